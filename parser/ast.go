@@ -30,6 +30,20 @@ type Node interface {
 	token() token
 }
 
+// Line returns the line (counting from 1) of the source a node came from.
+func Line(n Node) int {
+	switch s := n.(type) {
+	case *ExpressionStatement:
+		// these have no token of their own
+		return Line(s.X)
+	case *CaseStatement:
+		if s.X != nil {
+			return Line(s.X)
+		}
+	}
+	return n.token().line + 1
+}
+
 type Program struct {
 	Node
 	tok  token
@@ -35,6 +35,12 @@ type NumericLiteral struct {
 	tok token
 }
 
+// NewNumericLiteral returns a literal for v, for building trees by hand. v
+// can't be negative, as -1 is a unary minus on 1.
+func NewNumericLiteral(v float64) *NumericLiteral {
+	return &NumericLiteral{tok: token{tokenType: NUMERIC_LITERAL, value: strconv.FormatFloat(v, 'f', -1, 64)}}
+}
+
 func (this *NumericLiteral) token() token {
 	return this.tok
 }
@@ -61,6 +67,11 @@ type IdentifierLiteral struct {
 	tok token
 }
 
+// NewIdentifierLiteral returns an identifier, for building trees by hand.
+func NewIdentifierLiteral(name string) *IdentifierLiteral {
+	return &IdentifierLiteral{tok: token{tokenType: IDENTIFIER, value: name}}
+}
+
 func (this *IdentifierLiteral) token() token {
 	return this.tok
 }
@@ -74,6 +85,11 @@ type StringLiteral struct {
 	tok token
 }
 
+// NewStringLiteral returns a literal for s, for building trees by hand.
+func NewStringLiteral(s string) *StringLiteral {
+	return &StringLiteral{tok: token{tokenType: STRING_LITERAL, value: s}}
+}
+
 func (this *StringLiteral) token() token {
 	return this.tok
 }
@@ -169,8 +185,8 @@ func (this RegExpFlag) String() string {
 }
 
 const (
-	NoFlagsRegExp RegExpFlag = iota
-	GlobalRegExp
+	NoFlagsRegExp RegExpFlag = 0
+	GlobalRegExp  RegExpFlag = 1 << (iota - 1)
 	IgnoreCaseRegExp
 	MultilineRegExp
 )
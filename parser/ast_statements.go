@@ -169,6 +169,22 @@ func (this *ThrowStatement) token() token {
 	return this.tok
 }
 
+type BreakStatement struct {
+	tok token
+}
+
+func (this *BreakStatement) token() token {
+	return this.tok
+}
+
+type ContinueStatement struct {
+	tok token
+}
+
+func (this *ContinueStatement) token() token {
+	return this.tok
+}
+
 type TryStatement struct {
 	Body    Node
 	Catch   *CatchStatement
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// A Comment is a comment in the source code.
+type Comment struct {
+	Text     string // without the // or /* */ around it
+	Block    bool   // written /* like this */, rather than // like this
+	Location Location
+}
+
+func (this *Comment) String() string {
+	if this.Block {
+		return "/*" + this.Text + "*/"
+	}
+	return "//" + this.Text
+}
+
+// directivePrefix starts a comment that's meant for tools to read.
+const directivePrefix = "v2-"
+
+// Directive returns the name and arguments of a comment that's a directive to
+// tools. "// v2-disable-next-line no-eval, no-with -- it's fine" has the name
+// "disable-next-line" and the arguments "no-eval" and "no-with"; anything
+// after "--" explains it. The name is "" if the comment isn't a directive.
+func (this *Comment) Directive() (name string, args []string) {
+	text := strings.TrimSpace(this.Text)
+	if !strings.HasPrefix(text, directivePrefix) {
+		return "", nil
+	}
+	text = text[len(directivePrefix):]
+	if i := strings.Index(text, "--"); i >= 0 {
+		text = text[:i]
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	for _, arg := range strings.Split(strings.Join(fields[1:], " "), ",") {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			args = append(args, arg)
+		}
+	}
+	return fields[0], args
+}
+
+// NodeComments are the comments attached to a node.
+type NodeComments struct {
+	Leading  []*Comment // before it
+	Trailing []*Comment // after it, on the line it ends on
+	Inner    []*Comment // inside it, after everything else, like in an empty block
+}
+
+// A CommentMap says which comments are attached to which node.
+type CommentMap map[Node]*NodeComments
+
+// All returns each comment in the map, in the order they're written.
+func (this CommentMap) All() []*Comment {
+	ret := []*Comment{}
+	for _, c := range this {
+		ret = append(ret, c.Leading...)
+		ret = append(ret, c.Trailing...)
+		ret = append(ret, c.Inner...)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Location.Start.Offset < ret[j].Location.Start.Offset
+	})
+	return ret
+}
+
+func (this CommentMap) get(n Node) *NodeComments {
+	c := this[n]
+	if c == nil {
+		c = &NodeComments{}
+		this[n] = c
+	}
+	return c
+}
+
+// ParseWithComments parses code like ParseWithLocations does, and also
+// returns its comments, each attached to the node it's nearest:
+//
+//	// leading the statement after it
+//	a = b // trailing the statement before it, on the same line
+//	f(/* leading b */ b)
+//	function f() {
+//		// inside the function's body, with nothing to lead
+//	}
+func ParseWithComments(code string) (Node, map[Node]Location, CommentMap) {
+	ret, locations, np := parseWithLocations(code, false)
+
+	position := positionFinder(code)
+	comments := []*Comment{}
+	for _, tok := range np.stream.comments {
+		c := &Comment{Text: tok.value, Block: tok.pos+1 < len(code) && code[tok.pos+1] == '*'}
+		end := tok.pos + 2 + len(tok.value)
+		if c.Block && end+2 <= len(code) {
+			end += 2
+		}
+		c.Location = Location{Start: position(tok.pos), End: position(end)}
+		comments = append(comments, c)
+	}
+	return ret, locations, attachComments(ret, locations, comments)
+}
+
+// a node of the tree, in the order Walk visits them
+type commentTarget struct {
+	node  Node
+	loc   Location
+	depth int
+}
+
+type commentTargetCollector struct {
+	targets   *[]commentTarget
+	locations map[Node]Location
+	depth     int
+}
+
+func (this commentTargetCollector) Visit(n Node) Visitor {
+	if n == nil {
+		return nil
+	}
+	if loc, ok := this.locations[n]; ok {
+		*this.targets = append(*this.targets, commentTarget{n, loc, this.depth})
+	}
+	return commentTargetCollector{this.targets, this.locations, this.depth + 1}
+}
+
+func attachComments(tree Node, locations map[Node]Location, comments []*Comment) CommentMap {
+	targets := []commentTarget{}
+	Walk(commentTargetCollector{&targets, locations, 0}, tree)
+
+	ret := CommentMap{}
+	for _, c := range comments {
+		start, end := c.Location.Start.Offset, c.Location.End.Offset
+
+		// the innermost node around the comment
+		enclosing := 0
+		for i, t := range targets {
+			if t.loc.Start.Offset <= start && end <= t.loc.End.Offset && t.depth >= targets[enclosing].depth {
+				enclosing = i
+			}
+		}
+
+		// the nodes inside that just before and after it, the outermost if
+		// several end or start there
+		prev, next := -1, -1
+		for i := enclosing + 1; i < len(targets) && targets[i].depth > targets[enclosing].depth; i++ {
+			t := targets[i]
+			if t.loc.End.Offset <= start && (prev < 0 || t.loc.End.Offset > targets[prev].loc.End.Offset) {
+				prev = i
+			}
+			if t.loc.Start.Offset >= end && (next < 0 || t.loc.Start.Offset < targets[next].loc.Start.Offset) {
+				next = i
+			}
+		}
+
+		switch {
+		case prev >= 0 && targets[prev].loc.End.Line == c.Location.Start.Line &&
+			(next < 0 || targets[next].loc.Start.Line != c.Location.End.Line):
+			nc := ret.get(targets[prev].node)
+			nc.Trailing = append(nc.Trailing, c)
+		case next >= 0:
+			nc := ret.get(targets[next].node)
+			nc.Leading = append(nc.Leading, c)
+		default:
+			nc := ret.get(targets[enclosing].node)
+			nc.Inner = append(nc.Inner, c)
+		}
+	}
+	return ret
+}
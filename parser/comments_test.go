@@ -0,0 +1,119 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+const commentedCode = `// leads a
+a = 1; // trails a
+/* leads f */
+function f(/* leads x */ x) {
+	// inside f
+}
+b = [1 /* trails 1 */]
+// the end`
+
+func TestParseWithComments(t *testing.T) {
+	tree, _, comments := ParseWithComments(commentedCode)
+	body := tree.(*Program).Body()
+	f := body[1].(*ExpressionStatement).X.(*FunctionExpression)
+	array := body[2].(*ExpressionStatement).X.(*AssignmentExpression).Right.(*ArrayLiteral)
+
+	texts := func(comments []*Comment) []string {
+		ret := []string{}
+		for _, c := range comments {
+			ret = append(ret, c.String())
+		}
+		return ret
+	}
+	assert.Equal(t, texts(comments[body[0]].Leading), []string{"// leads a"})
+	assert.Equal(t, texts(comments[body[0]].Trailing), []string{"// trails a"})
+	assert.Equal(t, texts(comments[body[1]].Leading), []string{"/* leads f */"})
+	assert.Equal(t, texts(comments[f.Parameters[0]].Leading), []string{"/* leads x */"})
+	assert.Equal(t, texts(comments[f.Body].Inner), []string{"// inside f"})
+	assert.Equal(t, texts(comments[array.Elements[0]].Trailing), []string{"/* trails 1 */"})
+	assert.Equal(t, texts(comments[tree].Inner), []string{"// the end"})
+
+	all := comments.All()
+	assert.Equal(t, len(all), 7)
+	assert.Equal(t, all[1].Text, " trails a")
+	assert.False(t, all[1].Block)
+	assert.Equal(t, all[1].Location, Location{Position{18, 2, 7}, Position{29, 2, 18}})
+	assert.True(t, all[2].Block)
+	assert.Equal(t, all[2].Location, Location{Position{30, 3, 0}, Position{43, 3, 13}})
+}
+
+func TestParseKeepsCommentsOutOfTheTree(t *testing.T) {
+	withComments := Parse(commentedCode, false)
+	withoutComments := Parse(commentedCode, true)
+	assert.Equal(t, DumpAST(withComments), DumpAST(withoutComments))
+}
+
+func TestCommentDirective(t *testing.T) {
+	_, _, comments := ParseWithComments("// v2-disable-next-line no-eval, no-with -- it's fine\neval(a) /* v2-enable */ // not v2-directive")
+	all := comments.All()
+
+	name, args := all[0].Directive()
+	assert.Equal(t, name, "disable-next-line")
+	assert.Equal(t, args, []string{"no-eval", "no-with"})
+
+	name, args = all[1].Directive()
+	assert.Equal(t, name, "enable")
+	assert.Nil(t, args)
+
+	name, _ = all[2].Directive()
+	assert.Equal(t, name, "")
+}
+
+func TestPrintComments(t *testing.T) {
+	tree, _, comments := ParseWithComments(commentedCode + "\nif (c) // hoisted\n\td(e /* kept */)")
+	assert.Equal(t, Print(tree, PrintOptions{Comments: comments}), `// leads a
+a = 1; // trails a
+/* leads f */
+function f(/* leads x */ x) {
+    // inside f
+}
+b = [1 /* trails 1 */];
+// the end
+if (c)
+    d(e /* kept */); // hoisted
+`)
+}
+
+// A comment after a var statement trails it, semicolon and all.
+func TestPrintVarComments(t *testing.T) {
+	tree, _, comments := ParseWithComments("var s = 1; // c\nfunction f() {\n\tvar t; // d\n}")
+	assert.Equal(t, Print(tree, PrintOptions{Comments: comments}), `var s = 1; // c
+function f() {
+    var t; // d
+}
+`)
+}
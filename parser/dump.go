@@ -0,0 +1,206 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DumpTokens returns the tokens of code, comments included, one a line with
+// where each starts, for debugging the tokenizer. Regular expressions aren't
+// told apart from division, as only the parser knows which a / starts.
+func DumpTokens(code string) string {
+	ts := tokenStream{stream: &byteStream{code: code}}
+	var sb strings.Builder
+	for {
+		tok := ts.next()
+		fmt.Fprintf(&sb, "%d:%d %s", tok.line+1, tok.col+1, tok.tokenType)
+		if tok.value != "" {
+			fmt.Fprintf(&sb, " %q", tok.value)
+		}
+		sb.WriteString("\n")
+		if tok.tokenType == EOF {
+			return sb.String()
+		}
+	}
+}
+
+// DumpAST returns the tree under n as indented text, a node a line, for
+// debugging the parser.
+func DumpAST(n Node) string {
+	var sb strings.Builder
+	writeASTText(&sb, "", "", astValue(reflect.ValueOf(n)))
+	return sb.String()
+}
+
+// DumpASTJSON returns the tree under n as JSON: each node is an object with
+// its type, line and exported fields, and those of its operator or value if it
+// has one.
+func DumpASTJSON(n Node) []byte {
+	var buf bytes.Buffer
+	writeASTJSON(&buf, astValue(reflect.ValueOf(n)))
+	var out bytes.Buffer
+	json.Indent(&out, buf.Bytes(), "", "  ")
+	out.WriteString("\n")
+	return out.Bytes()
+}
+
+// astObject is a node, as the dumps show it.
+type astObject struct {
+	kind   string
+	fields []astField
+}
+
+type astField struct {
+	name  string
+	value interface{} // nil, a scalar, *astObject or []interface{}
+}
+
+// astValue turns what a node's field holds into what the dumps show.
+func astValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			return astValue(v.Elem())
+		}
+		if n, ok := v.Interface().(Node); ok {
+			return astNode(n)
+		}
+		return astValue(v.Elem())
+	case reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for idx := range list {
+			list[idx] = astValue(v.Index(idx))
+		}
+		return list
+	case reflect.Struct:
+		o := &astObject{kind: v.Type().Name()}
+		o.addFields(v)
+		return o
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	return v.Interface()
+}
+
+func astNode(n Node) *astObject {
+	v := reflect.ValueOf(n).Elem()
+	o := &astObject{kind: v.Type().Name()}
+	o.fields = append(o.fields, astField{"Line", Line(n)})
+	switch n := n.(type) {
+	case *Program:
+		o.fields = append(o.fields, astField{"Body", astValue(reflect.ValueOf(n.Body()))})
+	case *UnaryExpression:
+		o.fields = append(o.fields, astField{"Operator", n.Operator().String()}, astField{"Prefix", n.IsPrefix()})
+	case *AssignmentExpression:
+		o.fields = append(o.fields, astField{"Operator", n.Operator().String()})
+	case *BinaryExpression:
+		o.fields = append(o.fields, astField{"Operator", n.Operator().String()})
+	case *NumericLiteral, *IdentifierLiteral, *StringLiteral:
+		o.fields = append(o.fields, astField{"Value", n.(fmt.Stringer).String()})
+	}
+	o.addFields(v)
+	return o
+}
+
+// addFields adds the exported fields of the struct v.
+func (this *astObject) addFields(v reflect.Value) {
+	for idx := 0; idx < v.NumField(); idx++ {
+		f := v.Type().Field(idx)
+		if f.Anonymous || f.PkgPath != "" {
+			continue
+		}
+		this.fields = append(this.fields, astField{f.Name, astValue(v.Field(idx))})
+	}
+}
+
+func writeASTText(sb *strings.Builder, indent string, label string, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		return
+	case []interface{}:
+		for idx, item := range v {
+			writeASTText(sb, indent, fmt.Sprintf("%s[%d]", label, idx), item)
+		}
+		return
+	case *astObject:
+		if label != "" {
+			label += ": "
+		}
+		sb.WriteString(indent + label + v.kind)
+		var children []astField
+		for _, f := range v.fields {
+			switch value := f.value.(type) {
+			case *astObject, []interface{}:
+				children = append(children, f)
+			case nil:
+			case string:
+				fmt.Fprintf(sb, " %s=%q", f.name, value)
+			default:
+				fmt.Fprintf(sb, " %s=%v", f.name, value)
+			}
+		}
+		sb.WriteString("\n")
+		for _, f := range children {
+			writeASTText(sb, indent+"  ", f.name, f.value)
+		}
+		return
+	}
+	fmt.Fprintf(sb, "%s%s: %v\n", indent, label, v)
+}
+
+func writeASTJSON(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		buf.WriteString("[")
+		for idx, item := range v {
+			if idx > 0 {
+				buf.WriteString(",")
+			}
+			writeASTJSON(buf, item)
+		}
+		buf.WriteString("]")
+	case *astObject:
+		fmt.Fprintf(buf, `{"type":%q`, v.kind)
+		for _, f := range v.fields {
+			fmt.Fprintf(buf, ",%q:", f.name)
+			writeASTJSON(buf, f.value)
+		}
+		buf.WriteString("}")
+	default:
+		data, _ := json.Marshal(v)
+		buf.Write(data)
+	}
+}
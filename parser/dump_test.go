@@ -0,0 +1,80 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestDumpTokens(t *testing.T) {
+	assert.Equal(t, DumpTokens("var a = 'x'; // done\nb++"), `1:1 VAR "var"
+1:5 IDENTIFIER "a"
+1:7 ASSIGNMENT
+1:9 STRING_LITERAL "x"
+1:12 SEMICOLON
+1:14 COMMENT " done"
+2:1 IDENTIFIER "b"
+2:2 INCREMENT
+2:4 EOF
+`)
+}
+
+func TestDumpAST(t *testing.T) {
+	assert.Equal(t, DumpAST(Parse("if (a) {\n  f(-1, 'x')\n}", true)), `Program Line=1
+  Body[0]: IfStatement Line=1
+    ConditionExpr: IdentifierLiteral Line=1 Value="a"
+    ThenStmt: BlockStatement Line=1
+      Body[0]: ExpressionStatement Line=2
+        X: CallExpression Line=2
+          X: IdentifierLiteral Line=2 Value="f"
+          Arguments[0]: UnaryExpression Line=2 Operator="MINUS" Prefix=true
+            X: NumericLiteral Line=2 Value="1"
+          Arguments[1]: StringLiteral Line=2 Value="x"
+`)
+}
+
+func TestDumpASTJSON(t *testing.T) {
+	var tree map[string]interface{}
+	assert.Nil(t, json.Unmarshal(DumpASTJSON(Parse("a = {b: 1}; if (a) x", true)), &tree))
+	assert.Equal(t, tree["type"], "Program")
+
+	body := tree["Body"].([]interface{})
+	assign := body[0].(map[string]interface{})["X"].(map[string]interface{})
+	assert.Equal(t, assign["type"], "AssignmentExpression")
+	assert.Equal(t, assign["Operator"], "ASSIGNMENT")
+	assert.Equal(t, assign["Left"].(map[string]interface{})["Value"], "a")
+
+	// fields that hold nothing are there, as null
+	ifStmt := body[1].(map[string]interface{})
+	assert.Equal(t, ifStmt["type"], "IfStatement")
+	elseStmt, ok := ifStmt["ElseStmt"]
+	assert.True(t, ok)
+	assert.Nil(t, elseStmt)
+}
@@ -0,0 +1,901 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// The text of each operator, as ESTree has it.
+var operatorText = map[TokenType]string{
+	PLUS:                    "+",
+	MINUS:                   "-",
+	MULTIPLY:                "*",
+	DIVIDE:                  "/",
+	MODULUS:                 "%",
+	EXPONENT:                "**",
+	LEFT_SHIFT:              "<<",
+	RIGHT_SHIFT:             ">>",
+	UNSIGNED_RIGHT_SHIFT:    ">>>",
+	LESS_THAN:               "<",
+	GREATER_THAN:            ">",
+	LESS_EQ:                 "<=",
+	GREATER_EQ:              ">=",
+	IN:                      "in",
+	INSTANCEOF:              "instanceof",
+	EQUALS:                  "==",
+	NOT_EQUALS:              "!=",
+	STRICT_EQUALS:           "===",
+	STRICT_NOT_EQUALS:       "!==",
+	BITWISE_AND:             "&",
+	BITWISE_XOR:             "^",
+	BITWISE_OR:              "|",
+	LOGICAL_AND:             "&&",
+	LOGICAL_OR:              "||",
+	BITWISE_NOT:             "~",
+	LOGICAL_NOT:             "!",
+	DELETE:                  "delete",
+	TYPEOF:                  "typeof",
+	VOID:                    "void",
+	INCREMENT:               "++",
+	DECREMENT:               "--",
+	ASSIGNMENT:              "=",
+	PLUS_EQ:                 "+=",
+	MINUS_EQ:                "-=",
+	MULTIPLY_EQ:             "*=",
+	DIVIDE_EQ:               "/=",
+	MODULUS_EQ:              "%=",
+	EXPONENT_EQ:             "**=",
+	LEFT_SHIFT_EQ:           "<<=",
+	RIGHT_SHIFT_EQ:          ">>=",
+	UNSIGNED_RIGHT_SHIFT_EQ: ">>>=",
+	AND_EQ:                  "&=",
+	XOR_EQ:                  "^=",
+	OR_EQ:                   "|=",
+}
+
+// esError is what a failed conversion panics with, so that it can be told
+// apart from a bug when recovering.
+type esError string
+
+// esNode is an ESTree node, keeping its properties in order so the JSON reads
+// like acorn's.
+type esNode []esProperty
+
+type esProperty struct {
+	key   string
+	value interface{}
+}
+
+func (this esNode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range this {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(p.key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(p.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalESTree returns the tree under n as ESTree JSON, the format acorn and
+// esprima produce. If the locations ParseWithLocations returned for code are
+// given, each node also gets its loc and range, and each literal its raw text
+// as written; otherwise both can be nil and "".
+//
+// The tree has no place for parentheses, so a parenthesized sequence
+// expression is flattened into the one around it, and the parameter of a
+// setter is lost.
+func MarshalESTree(n Node, code string, locations map[Node]Location) (ret []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(esError)
+			if !ok {
+				panic(r)
+			}
+			ret, err = nil, fmt.Errorf("%s", string(e))
+		}
+	}()
+
+	ex := esExporter{code: code, locations: locations}
+	return json.MarshalIndent(ex.export(n), "", "  ")
+}
+
+type esExporter struct {
+	code      string
+	locations map[Node]Location
+}
+
+// Returns an ESTree node of type typ, located where first starts and last
+// ends.
+func (this *esExporter) spanning(first Node, last Node, typ string, props ...esProperty) esNode {
+	ret := esNode{{"type", typ}}
+	ret = append(ret, props...)
+	start, ok1 := this.locations[first]
+	end, ok2 := this.locations[last]
+	if ok1 && ok2 {
+		pos := func(p Position) esNode {
+			return esNode{{"line", p.Line}, {"column", p.Column}}
+		}
+		ret = append(ret,
+			esProperty{"loc", esNode{{"start", pos(start.Start)}, {"end", pos(end.End)}}},
+			esProperty{"range", []int{start.Start.Offset, end.End.Offset}})
+	}
+	return ret
+}
+
+func (this *esExporter) node(n Node, typ string, props ...esProperty) esNode {
+	return this.spanning(n, n, typ, props...)
+}
+
+// Returns the code n was parsed from, if it's known.
+func (this *esExporter) raw(n Node) (string, bool) {
+	loc, ok := this.locations[n]
+	if !ok || loc.End.Offset > len(this.code) {
+		return "", false
+	}
+	return this.code[loc.Start.Offset:loc.End.Offset], true
+}
+
+func (this *esExporter) list(nodes []Node) []interface{} {
+	ret := []interface{}{}
+	for _, n := range nodes {
+		ret = append(ret, this.export(n))
+	}
+	return ret
+}
+
+func (this *esExporter) identifier(n *IdentifierLiteral) interface{} {
+	if n == nil {
+		return nil
+	}
+	return this.export(n)
+}
+
+func (this *esExporter) function(n *FunctionExpression, typ string) esNode {
+	params := []interface{}{}
+	for _, p := range n.Parameters {
+		params = append(params, this.export(p))
+	}
+	return this.node(n, typ,
+		esProperty{"id", this.identifier(n.Identifier)},
+		esProperty{"expression", false},
+		esProperty{"generator", false},
+		esProperty{"async", false},
+		esProperty{"params", params},
+		esProperty{"body", this.export(n.Body)})
+}
+
+// A function written as a statement is a declaration, unless it's
+// parenthesized.
+func (this *esExporter) isDeclaration(n *ExpressionStatement) bool {
+	f, ok := n.X.(*FunctionExpression)
+	if !ok || f.Identifier == nil {
+		return false
+	}
+	loc, ok := this.locations[n]
+	return !ok || loc.Start == this.locations[f].Start
+}
+
+func (this *esExporter) statements(body []Node) []interface{} {
+	ret := []interface{}{}
+	for _, s := range body {
+		if es, ok := s.(*ExpressionStatement); ok && this.isDeclaration(es) {
+			ret = append(ret, this.function(es.X.(*FunctionExpression), "FunctionDeclaration"))
+		} else {
+			ret = append(ret, this.export(s))
+		}
+	}
+	return ret
+}
+
+func (this *esExporter) literal(n Node, value interface{}, raw string) esNode {
+	if r, ok := this.raw(n); ok {
+		raw = r
+	}
+	return this.node(n, "Literal", esProperty{"value", value}, esProperty{"raw", raw})
+}
+
+func (this *esExporter) export(node Node) interface{} {
+	if node == nil {
+		return nil
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		body := this.statements(n.body)
+		// leading string statements are directives, like "use strict"
+		for i, s := range n.body {
+			es, ok := s.(*ExpressionStatement)
+			if !ok {
+				break
+			}
+			str, ok := es.X.(*StringLiteral)
+			if !ok {
+				break
+			}
+			directive := str.tok.value
+			if raw, ok := this.raw(str); ok {
+				directive = raw[1 : len(raw)-1]
+			}
+			stmt := body[i].(esNode)
+			body[i] = append(stmt[:2:2], append(esNode{{"directive", directive}}, stmt[2:]...)...)
+		}
+		return this.node(n, "Program", esProperty{"body", body}, esProperty{"sourceType", "script"})
+	case *ExpressionStatement:
+		return this.node(n, "ExpressionStatement", esProperty{"expression", this.export(n.X)})
+	case *NewExpression:
+		callee, args := n.X, []interface{}{}
+		if call, ok := n.X.(*CallExpression); ok {
+			callee, args = call.X, this.list(call.Arguments)
+		}
+		return this.node(n, "NewExpression", esProperty{"callee", this.export(callee)}, esProperty{"arguments", args})
+	case *DotMemberExpression:
+		return this.node(n, "MemberExpression",
+			esProperty{"object", this.export(n.X)},
+			esProperty{"property", this.export(n.Name)},
+			esProperty{"computed", false})
+	case *BracketMemberExpression:
+		return this.node(n, "MemberExpression",
+			esProperty{"object", this.export(n.X)},
+			esProperty{"property", this.export(n.Y)},
+			esProperty{"computed", true})
+	case *UnaryExpression:
+		typ := "UnaryExpression"
+		if n.tok.tokenType == INCREMENT || n.tok.tokenType == DECREMENT {
+			typ = "UpdateExpression"
+		}
+		return this.node(n, typ,
+			esProperty{"operator", operatorText[n.tok.tokenType]},
+			esProperty{"prefix", !n.postfix},
+			esProperty{"argument", this.export(n.X)})
+	case *AssignmentExpression:
+		return this.node(n, "AssignmentExpression",
+			esProperty{"operator", operatorText[n.tok.tokenType]},
+			esProperty{"left", this.export(n.Left)},
+			esProperty{"right", this.export(n.Right)})
+	case *BinaryExpression:
+		typ := "BinaryExpression"
+		if n.tok.tokenType == LOGICAL_AND || n.tok.tokenType == LOGICAL_OR {
+			typ = "LogicalExpression"
+		}
+		return this.node(n, typ,
+			esProperty{"left", this.export(n.Left)},
+			esProperty{"operator", operatorText[n.tok.tokenType]},
+			esProperty{"right", this.export(n.Right)})
+	case *ConditionalExpression:
+		return this.node(n, "ConditionalExpression",
+			esProperty{"test", this.export(n.X)},
+			esProperty{"consequent", this.export(n.Then)},
+			esProperty{"alternate", this.export(n.Else)})
+	case *FunctionExpression:
+		return this.function(n, "FunctionExpression")
+	case *CallExpression:
+		return this.node(n, "CallExpression",
+			esProperty{"callee", this.export(n.X)},
+			esProperty{"arguments", this.list(n.Arguments)})
+	case *SequenceExpression:
+		exprs := []Node{n.Y}
+		x := n.X
+		for {
+			s, ok := x.(*SequenceExpression)
+			if !ok {
+				break
+			}
+			exprs = append(exprs, s.Y)
+			x = s.X
+		}
+		exprs = append(exprs, x)
+		for i, j := 0, len(exprs)-1; i < j; i, j = i+1, j-1 {
+			exprs[i], exprs[j] = exprs[j], exprs[i]
+		}
+		return this.node(n, "SequenceExpression", esProperty{"expressions", this.list(exprs)})
+	case *NumericLiteral:
+		return this.literal(n, n.Float64Value(), n.tok.value)
+	case *StringLiteral:
+		return this.literal(n, n.tok.value, strconv.Quote(n.tok.value))
+	case *TrueLiteral:
+		return this.literal(n, true, "true")
+	case *FalseLiteral:
+		return this.literal(n, false, "false")
+	case *NullLiteral:
+		return this.literal(n, nil, "null")
+	case *RegExpLiteral:
+		raw := "/" + n.RegExp + "/" + n.Flags.String()
+		if r, ok := this.raw(n); ok {
+			raw = r
+		}
+		// a RegExp turns into {} in acorn's JSON too
+		return this.node(n, "Literal",
+			esProperty{"value", esNode{}},
+			esProperty{"raw", raw},
+			esProperty{"regex", esNode{{"pattern", n.RegExp}, {"flags", n.Flags.String()}}})
+	case *IdentifierLiteral:
+		return this.node(n, "Identifier", esProperty{"name", n.tok.value})
+	case *ThisLiteral:
+		return this.node(n, "ThisExpression")
+	case *ArrayLiteral:
+		return this.node(n, "ArrayExpression", esProperty{"elements", this.list(n.Elements)})
+	case *ObjectLiteral:
+		props := []interface{}{}
+		for _, p := range n.Properties {
+			kind, value := "init", this.export(p.X)
+			if p.Type != Normal {
+				kind = "get"
+				if p.Type == Set {
+					kind = "set"
+				}
+				value = this.spanning(p.X, p.X, "FunctionExpression",
+					esProperty{"id", nil},
+					esProperty{"expression", false},
+					esProperty{"generator", false},
+					esProperty{"async", false},
+					esProperty{"params", []interface{}{}},
+					esProperty{"body", value})
+			}
+			props = append(props, this.spanning(p.Key, p.X, "Property",
+				esProperty{"method", false},
+				esProperty{"shorthand", false},
+				esProperty{"computed", false},
+				esProperty{"key", this.export(p.Key)},
+				esProperty{"value", value},
+				esProperty{"kind", kind}))
+		}
+		return this.node(n, "ObjectExpression", esProperty{"properties", props})
+	case *IfStatement:
+		return this.node(n, "IfStatement",
+			esProperty{"test", this.export(n.ConditionExpr)},
+			esProperty{"consequent", this.export(n.ThenStmt)},
+			esProperty{"alternate", this.export(n.ElseStmt)})
+	case *ReturnStatement:
+		return this.node(n, "ReturnStatement", esProperty{"argument", this.export(n.X)})
+	case *BlockStatement:
+		return this.node(n, "BlockStatement", esProperty{"body", this.statements(n.Body)})
+	case *EmptyStatement:
+		return this.node(n, "EmptyStatement")
+	case *SwitchStatement:
+		cases := []interface{}{}
+		for _, c := range n.Cases {
+			cases = append(cases, this.export(c))
+		}
+		return this.node(n, "SwitchStatement", esProperty{"discriminant", this.export(n.X)}, esProperty{"cases", cases})
+	case *CaseStatement:
+		return this.node(n, "SwitchCase", esProperty{"consequent", this.statements(n.Body)}, esProperty{"test", this.export(n.X)})
+	case *VariableStatement:
+		decls := []interface{}{}
+		for i, id := range n.Vars {
+			var last Node = id
+			if n.Initializers[i] != nil {
+				last = n.Initializers[i]
+			}
+			decls = append(decls, this.spanning(id, last, "VariableDeclarator",
+				esProperty{"id", this.export(id)},
+				esProperty{"init", this.export(n.Initializers[i])}))
+		}
+		return this.node(n, "VariableDeclaration", esProperty{"declarations", decls}, esProperty{"kind", "var"})
+	case *DoWhileStatement:
+		return this.node(n, "DoWhileStatement", esProperty{"body", this.export(n.Body)}, esProperty{"test", this.export(n.X)})
+	case *WhileStatement:
+		return this.node(n, "WhileStatement", esProperty{"test", this.export(n.X)}, esProperty{"body", this.export(n.Body)})
+	case *ForStatement:
+		return this.node(n, "ForStatement",
+			esProperty{"init", this.export(n.Initializer)},
+			esProperty{"test", this.export(n.Test)},
+			esProperty{"update", this.export(n.Update)},
+			esProperty{"body", this.export(n.Body)})
+	case *ForInStatement:
+		return this.node(n, "ForInStatement",
+			esProperty{"left", this.export(n.X)},
+			esProperty{"right", this.export(n.Y)},
+			esProperty{"body", this.export(n.Body)})
+	case *ThrowStatement:
+		return this.node(n, "ThrowStatement", esProperty{"argument", this.export(n.X)})
+	case *BreakStatement:
+		return this.node(n, "BreakStatement", esProperty{"label", nil})
+	case *ContinueStatement:
+		return this.node(n, "ContinueStatement", esProperty{"label", nil})
+	case *TryStatement:
+		var handler, finalizer interface{}
+		if n.Catch != nil {
+			handler = this.node(n.Catch, "CatchClause",
+				esProperty{"param", this.export(n.Catch.Identifier)},
+				esProperty{"body", this.export(n.Catch.Body)})
+		}
+		if n.Finally != nil {
+			finalizer = this.export(n.Finally.Body)
+		}
+		return this.node(n, "TryStatement",
+			esProperty{"block", this.export(n.Body)},
+			esProperty{"handler", handler},
+			esProperty{"finalizer", finalizer})
+	}
+
+	panic(esError(fmt.Sprintf("can't convert %T to ESTree", node)))
+}
+
+// UnmarshalESTree reads an ESTree Program, as acorn or esprima produce, back
+// into a tree. Nodes get the position of their loc, so that errors about them
+// point at the right line.
+//
+// Only what the parser itself understands can be read: no let, const, labels,
+// computed property names or other later additions.
+func UnmarshalESTree(data []byte) (ret Node, err error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(esError)
+			if !ok {
+				panic(r)
+			}
+			ret, err = nil, fmt.Errorf("%s", string(e))
+		}
+	}()
+
+	if root["type"] != "Program" {
+		panic(esError(fmt.Sprintf("expected a Program, got %v", root["type"])))
+	}
+	var im esImporter
+	return &Program{body: im.statements(root, "body")}, nil
+}
+
+type esImporter struct{}
+
+// Returns a token of type tt for n, at its position.
+func (this *esImporter) tok(n map[string]interface{}, tt TokenType, value string) token {
+	tok := token{tokenType: tt, value: value}
+	if loc, ok := n["loc"].(map[string]interface{}); ok {
+		if start, ok := loc["start"].(map[string]interface{}); ok {
+			line, _ := start["line"].(float64)
+			col, _ := start["column"].(float64)
+			tok.line, tok.col = int(line)-1, int(col)
+		}
+	}
+	if r, ok := n["range"].([]interface{}); ok && len(r) == 2 {
+		pos, _ := r[0].(float64)
+		tok.pos = int(pos)
+	} else if pos, ok := n["start"].(float64); ok {
+		tok.pos = int(pos)
+	}
+	return tok
+}
+
+// Returns the token type ESTree's operator text stands for, among ops.
+func (this *esImporter) operator(n map[string]interface{}, ops ...TokenType) TokenType {
+	for _, tt := range ops {
+		if operatorText[tt] == n["operator"] {
+			return tt
+		}
+	}
+	panic(esError(fmt.Sprintf("unsupported %v operator %v", n["type"], n["operator"])))
+}
+
+func (this *esImporter) object(v interface{}, what string) map[string]interface{} {
+	n, ok := v.(map[string]interface{})
+	if !ok {
+		panic(esError(fmt.Sprintf("expected %s, got %v", what, v)))
+	}
+	return n
+}
+
+// Returns c, read from v, if it is an expression, or says that n's field
+// needs one.
+func (this *esImporter) checkExpression(n map[string]interface{}, field string, v interface{}, c Node) Node {
+	if c != nil && isStatement(c) {
+		panic(esError(fmt.Sprintf("expected an expression as the %s of %v, got %v", field, n["type"], this.object(v, "a node")["type"])))
+	}
+	return c
+}
+
+// Returns c, read from v, if it is a statement, or says that n's field needs
+// one.
+func (this *esImporter) checkStatement(n map[string]interface{}, field string, v interface{}, c Node) Node {
+	if c != nil && !isStatement(c) {
+		panic(esError(fmt.Sprintf("expected a statement as the %s of %v, got %v", field, n["type"], this.object(v, "a node")["type"])))
+	}
+	return c
+}
+
+// Returns the expression in a field of n, or nil if it is left out.
+func (this *esImporter) expression(n map[string]interface{}, field string) Node {
+	return this.checkExpression(n, field, n[field], this.node(n[field]))
+}
+
+// Returns the expression in a field of n that it can't be without.
+func (this *esImporter) child(n map[string]interface{}, field string) Node {
+	c := this.expression(n, field)
+	if c == nil {
+		panic(esError(fmt.Sprintf("%v is missing its %s", n["type"], field)))
+	}
+	return c
+}
+
+// Returns the statement in a field of n, or nil if it is left out.
+func (this *esImporter) statement(n map[string]interface{}, field string) Node {
+	return this.checkStatement(n, field, n[field], this.node(n[field]))
+}
+
+// Returns the statement in a field of n that it can't be without.
+func (this *esImporter) body(n map[string]interface{}, field string) Node {
+	c := this.statement(n, field)
+	if c == nil {
+		panic(esError(fmt.Sprintf("%v is missing its %s", n["type"], field)))
+	}
+	return c
+}
+
+// Returns what can be assigned to in a field of n.
+func (this *esImporter) target(n map[string]interface{}, field string) Node {
+	c := this.child(n, field)
+	switch c.(type) {
+	case *IdentifierLiteral, *DotMemberExpression, *BracketMemberExpression:
+		return c
+	}
+	panic(esError(fmt.Sprintf("%v can't assign to a %v", n["type"], this.object(n[field], "a node")["type"])))
+}
+
+// Returns the list in a field of n.
+func (this *esImporter) list(n map[string]interface{}, field string) []interface{} {
+	items, ok := n[field].([]interface{})
+	if !ok {
+		if n[field] == nil {
+			panic(esError(fmt.Sprintf("%v is missing its %s", n["type"], field)))
+		}
+		panic(esError(fmt.Sprintf("expected a list, got %v", n[field])))
+	}
+	return items
+}
+
+// Returns the list of expressions in a field of n. Only an array's elements
+// can be null, for holes; holes is set for those.
+func (this *esImporter) children(n map[string]interface{}, field string, holes bool) []Node {
+	ret := []Node{}
+	for _, item := range this.list(n, field) {
+		c := this.checkExpression(n, field, item, this.node(item))
+		if c == nil && !holes {
+			panic(esError(fmt.Sprintf("%v has a null in its %s", n["type"], field)))
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// Returns the list of statements in a field of n.
+func (this *esImporter) statements(n map[string]interface{}, field string) []Node {
+	ret := []Node{}
+	for _, item := range this.list(n, field) {
+		c := this.checkStatement(n, field, item, this.node(item))
+		if c == nil {
+			panic(esError(fmt.Sprintf("%v has a null in its %s", n["type"], field)))
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func (this *esImporter) identifier(v interface{}) *IdentifierLiteral {
+	if v == nil {
+		return nil
+	}
+	id, ok := this.node(v).(*IdentifierLiteral)
+	if !ok {
+		panic(esError(fmt.Sprintf("expected an Identifier, got %v", this.object(v, "an Identifier")["type"])))
+	}
+	return id
+}
+
+// Returns the identifier in a field of n that it can't be without.
+func (this *esImporter) requiredIdentifier(n map[string]interface{}, field string) *IdentifierLiteral {
+	id := this.identifier(n[field])
+	if id == nil {
+		panic(esError(fmt.Sprintf("%v is missing its %s", n["type"], field)))
+	}
+	return id
+}
+
+func (this *esImporter) block(n map[string]interface{}, field string) *BlockStatement {
+	b, ok := this.body(n, field).(*BlockStatement)
+	if !ok {
+		panic(esError("expected a BlockStatement"))
+	}
+	return b
+}
+
+func (this *esImporter) function(n map[string]interface{}) *FunctionExpression {
+	if n["generator"] == true || n["async"] == true {
+		panic(esError("unsupported generator or async function"))
+	}
+	f := &FunctionExpression{tok: this.tok(n, FUNCTION, ""), Identifier: this.identifier(n["id"]), Parameters: []*IdentifierLiteral{}}
+	params, _ := n["params"].([]interface{})
+	for _, p := range params {
+		id := this.identifier(p)
+		if id == nil {
+			panic(esError(fmt.Sprintf("%v has a null in its params", n["type"])))
+		}
+		f.Parameters = append(f.Parameters, id)
+	}
+	f.Body = this.block(n, "body")
+	return f
+}
+
+func (this *esImporter) literal(n map[string]interface{}) Node {
+	if re, ok := n["regex"].(map[string]interface{}); ok {
+		pattern, _ := re["pattern"].(string)
+		flags, _ := re["flags"].(string)
+		r := &RegExpLiteral{tok: this.tok(n, DIVIDE, ""), RegExp: pattern}
+		for i := 0; i < len(flags); i++ {
+			f := regExpFlagFromChar(flags[i])
+			if f == NoFlagsRegExp {
+				panic(esError(fmt.Sprintf("unsupported regular expression flag %c", flags[i])))
+			}
+			r.Flags |= f
+		}
+		return r
+	}
+
+	switch v := n["value"].(type) {
+	case nil:
+		return &NullLiteral{tok: this.tok(n, NULL, "")}
+	case bool:
+		if v {
+			return &TrueLiteral{tok: this.tok(n, TRUE, "")}
+		}
+		return &FalseLiteral{tok: this.tok(n, FALSE, "")}
+	case string:
+		return &StringLiteral{tok: this.tok(n, STRING_LITERAL, v)}
+	case float64:
+		// keep the number as written, if we read it the same way
+		num := &NumericLiteral{tok: this.tok(n, NUMERIC_LITERAL, "")}
+		if raw, ok := n["raw"].(string); ok {
+			num.tok.value = raw
+			if num.Float64Value() == v {
+				return num
+			}
+		}
+		if math.IsInf(v, 0) || math.IsNaN(v) || v < 0 {
+			panic(esError(fmt.Sprintf("unsupported numeric literal %v", v)))
+		}
+		num.tok.value = strconv.FormatFloat(v, 'f', -1, 64)
+		return num
+	}
+	panic(esError(fmt.Sprintf("unsupported literal %v", n["value"])))
+}
+
+func (this *esImporter) node(v interface{}) Node {
+	if v == nil {
+		return nil
+	}
+	n := this.object(v, "a node")
+
+	switch n["type"] {
+	case "ExpressionStatement":
+		return &ExpressionStatement{X: this.child(n, "expression")}
+	case "FunctionDeclaration":
+		return &ExpressionStatement{X: this.function(n)}
+	case "FunctionExpression":
+		return this.function(n)
+	case "NewExpression":
+		ne := &NewExpression{tok: this.tok(n, NEW, ""), X: this.child(n, "callee")}
+		// without arguments, a call would be read as the new's own
+		_, isCall := ne.X.(*CallExpression)
+		if args := this.children(n, "arguments", false); len(args) > 0 || isCall {
+			ne.X = &CallExpression{tok: this.tok(n, LPAREN, ""), X: ne.X, Arguments: args}
+		}
+		return ne
+	case "MemberExpression":
+		if n["computed"] == true {
+			return &BracketMemberExpression{tok: this.tok(n, LBRACKET, ""), X: this.child(n, "object"), Y: this.child(n, "property")}
+		}
+		return &DotMemberExpression{tok: this.tok(n, DOT, ""), X: this.child(n, "object"), Name: this.requiredIdentifier(n, "property")}
+	case "UnaryExpression":
+		tt := this.operator(n, PLUS, MINUS, BITWISE_NOT, LOGICAL_NOT, DELETE, TYPEOF, VOID)
+		return &UnaryExpression{tok: this.tok(n, tt, ""), X: this.child(n, "argument")}
+	case "UpdateExpression":
+		tt := this.operator(n, INCREMENT, DECREMENT)
+		return &UnaryExpression{tok: this.tok(n, tt, ""), postfix: n["prefix"] != true, X: this.target(n, "argument")}
+	case "AssignmentExpression":
+		tt := this.operator(n, ASSIGNMENT, PLUS_EQ, MINUS_EQ, MULTIPLY_EQ, DIVIDE_EQ, MODULUS_EQ, EXPONENT_EQ,
+			LEFT_SHIFT_EQ, RIGHT_SHIFT_EQ, UNSIGNED_RIGHT_SHIFT_EQ, AND_EQ, XOR_EQ, OR_EQ)
+		return &AssignmentExpression{tok: this.tok(n, tt, ""), Left: this.target(n, "left"), Right: this.child(n, "right")}
+	case "BinaryExpression", "LogicalExpression":
+		tt := this.operator(n, PLUS, MINUS, MULTIPLY, DIVIDE, MODULUS, EXPONENT, LEFT_SHIFT, RIGHT_SHIFT,
+			UNSIGNED_RIGHT_SHIFT, LESS_THAN, GREATER_THAN, LESS_EQ, GREATER_EQ, IN, INSTANCEOF, EQUALS,
+			NOT_EQUALS, STRICT_EQUALS, STRICT_NOT_EQUALS, BITWISE_AND, BITWISE_XOR, BITWISE_OR, LOGICAL_AND, LOGICAL_OR)
+		return &BinaryExpression{tok: this.tok(n, tt, ""), Left: this.child(n, "left"), Right: this.child(n, "right")}
+	case "ConditionalExpression":
+		return &ConditionalExpression{tok: this.tok(n, CONDITIONAL, ""), X: this.child(n, "test"), Then: this.child(n, "consequent"), Else: this.child(n, "alternate")}
+	case "CallExpression":
+		return &CallExpression{tok: this.tok(n, LPAREN, ""), X: this.child(n, "callee"), Arguments: this.children(n, "arguments", false)}
+	case "SequenceExpression":
+		exprs := this.children(n, "expressions", false)
+		if len(exprs) == 0 {
+			panic(esError("empty SequenceExpression"))
+		}
+		ret := exprs[0]
+		for _, e := range exprs[1:] {
+			ret = &SequenceExpression{tok: this.tok(n, COMMA, ""), X: ret, Y: e}
+		}
+		return ret
+	case "Literal":
+		return this.literal(n)
+	case "Identifier":
+		name, _ := n["name"].(string)
+		if name == "" {
+			panic(esError("Identifier is missing its name"))
+		}
+		if !isIdentifierName(name) {
+			panic(esError(fmt.Sprintf("unsupported identifier name %q", name)))
+		}
+		return &IdentifierLiteral{tok: this.tok(n, IDENTIFIER, name)}
+	case "ThisExpression":
+		return &ThisLiteral{tok: this.tok(n, THIS, "")}
+	case "ArrayExpression":
+		return &ArrayLiteral{tok: this.tok(n, LBRACKET, ""), Elements: this.children(n, "elements", true)}
+	case "ObjectExpression":
+		o := &ObjectLiteral{tok: this.tok(n, LBRACE, "")}
+		props, ok := n["properties"].([]interface{})
+		if !ok {
+			panic(esError("ObjectExpression is missing its properties"))
+		}
+		for _, p := range props {
+			o.Properties = append(o.Properties, this.property(this.object(p, "a Property")))
+		}
+		return o
+	case "IfStatement":
+		return &IfStatement{tok: this.tok(n, IF, ""), ConditionExpr: this.child(n, "test"), ThenStmt: this.body(n, "consequent"), ElseStmt: this.statement(n, "alternate")}
+	case "ReturnStatement":
+		return &ReturnStatement{tok: this.tok(n, RETURN, ""), X: this.expression(n, "argument")}
+	case "BlockStatement":
+		return &BlockStatement{tok: this.tok(n, LBRACE, ""), Body: this.statements(n, "body")}
+	case "EmptyStatement":
+		return &EmptyStatement{tok: this.tok(n, SEMICOLON, "")}
+	case "SwitchStatement":
+		s := &SwitchStatement{tok: this.tok(n, SWITCH, ""), X: this.child(n, "discriminant")}
+		for _, item := range this.list(n, "cases") {
+			c := this.object(item, "a SwitchCase")
+			if c["type"] != "SwitchCase" {
+				panic(esError("expected a SwitchCase"))
+			}
+			s.Cases = append(s.Cases, &CaseStatement{X: this.expression(c, "test"), Body: this.statements(c, "consequent"), IsDefault: c["test"] == nil})
+		}
+		return s
+	case "VariableDeclaration":
+		if n["kind"] != "var" {
+			panic(esError(fmt.Sprintf("unsupported %v declaration", n["kind"])))
+		}
+		vs := &VariableStatement{tok: this.tok(n, VAR, "")}
+		decls, _ := n["declarations"].([]interface{})
+		if len(decls) == 0 {
+			panic(esError("VariableDeclaration is missing its declarations"))
+		}
+		for _, d := range decls {
+			decl := this.object(d, "a VariableDeclarator")
+			vs.Vars = append(vs.Vars, this.requiredIdentifier(decl, "id"))
+			vs.Initializers = append(vs.Initializers, this.expression(decl, "init"))
+		}
+		return vs
+	case "DoWhileStatement":
+		return &DoWhileStatement{tok: this.tok(n, DO, ""), X: this.child(n, "test"), Body: this.body(n, "body")}
+	case "WhileStatement":
+		return &WhileStatement{tok: this.tok(n, WHILE, ""), X: this.child(n, "test"), Body: this.body(n, "body")}
+	case "ForStatement":
+		var init Node
+		if this.isDeclaration(n["init"]) {
+			init = this.node(n["init"])
+		} else {
+			init = this.expression(n, "init")
+		}
+		return &ForStatement{tok: this.tok(n, FOR, ""), Initializer: init, Test: this.expression(n, "test"), Update: this.expression(n, "update"), Body: this.body(n, "body")}
+	case "ForInStatement":
+		var left Node
+		if this.isDeclaration(n["left"]) {
+			left = this.node(n["left"])
+		} else {
+			left = this.target(n, "left")
+		}
+		return &ForInStatement{tok: this.tok(n, FOR, ""), X: left, Y: this.child(n, "right"), Body: this.body(n, "body")}
+	case "ThrowStatement":
+		return &ThrowStatement{tok: this.tok(n, THROW, ""), X: this.child(n, "argument")}
+	case "BreakStatement", "ContinueStatement":
+		if n["label"] != nil {
+			panic(esError("unsupported label"))
+		}
+		if n["type"] == "ContinueStatement" {
+			return &ContinueStatement{tok: this.tok(n, CONTINUE, "")}
+		}
+		return &BreakStatement{tok: this.tok(n, BREAK, "")}
+	case "TryStatement":
+		t := &TryStatement{tok: this.tok(n, TRY, ""), Body: this.block(n, "block")}
+		if n["handler"] != nil {
+			h := this.object(n["handler"], "a CatchClause")
+			t.Catch = &CatchStatement{tok: this.tok(h, CATCH, ""), Identifier: this.requiredIdentifier(h, "param"), Body: this.block(h, "body")}
+		}
+		if n["finalizer"] != nil {
+			f := this.object(n["finalizer"], "a BlockStatement")
+			t.Finally = &FinallyStatement{tok: this.tok(f, FINALLY, ""), Body: this.block(n, "finalizer")}
+		}
+		return t
+	}
+
+	panic(esError(fmt.Sprintf("unsupported ESTree node type %v", n["type"])))
+}
+
+// Whether v is a VariableDeclaration, which a for can start with.
+func (this *esImporter) isDeclaration(v interface{}) bool {
+	n, ok := v.(map[string]interface{})
+	return ok && n["type"] == "VariableDeclaration"
+}
+
+// Whether name is read by the lexer as an identifier, and not a keyword.
+func isIdentifierName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isIdentifier(name[i], i == 0) {
+			return false
+		}
+	}
+	tt, _ := classifyIdentifier(name)
+	return name != "" && tt == IDENTIFIER
+}
+
+func (this *esImporter) property(n map[string]interface{}) ObjectPropertyLiteral {
+	if n["computed"] == true {
+		panic(esError("unsupported computed property name"))
+	}
+	p := ObjectPropertyLiteral{Key: this.child(n, "key"), Type: Normal}
+	switch p.Key.(type) {
+	case *IdentifierLiteral, *StringLiteral, *NumericLiteral:
+	default:
+		panic(esError("unsupported property key"))
+	}
+	switch n["kind"] {
+	case "get", "set":
+		p.Type = Get
+		if n["kind"] == "set" {
+			p.Type = Set
+		}
+		p.X = this.block(this.object(n["value"], "a FunctionExpression"), "body")
+	default:
+		p.X = this.child(n, "value")
+	}
+	return p
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestParseWithLocations(t *testing.T) {
+	code := "var a = 1;\nf( (a), [b] )"
+	prog, locations := ParseWithLocations(code, true)
+	body := prog.(*Program).Body()
+	call := body[1].(*ExpressionStatement).X.(*CallExpression)
+
+	assert.Equal(t, locations[prog], Location{Position{0, 1, 0}, Position{24, 2, 13}})
+	// the statement has its semicolon
+	assert.Equal(t, locations[body[0]], Location{Position{0, 1, 0}, Position{10, 1, 10}})
+	assert.Equal(t, locations[call], Location{Position{11, 2, 0}, Position{24, 2, 13}})
+	// the parentheses aren't part of the argument
+	assert.Equal(t, locations[call.Arguments[0]], Location{Position{15, 2, 4}, Position{16, 2, 5}})
+	assert.Equal(t, locations[call.Arguments[1]], Location{Position{19, 2, 8}, Position{22, 2, 11}})
+}
+
+func TestMarshalESTree(t *testing.T) {
+	code := "a + (b * 2);"
+	prog, locations := ParseWithLocations(code, true)
+	out, err := MarshalESTree(prog, code, locations)
+	assert.Nil(t, err)
+
+	// as acorn has it, with locations and ranges
+	expected := `{
+	  "type": "Program",
+	  "body": [{
+	    "type": "ExpressionStatement",
+	    "expression": {
+	      "type": "BinaryExpression",
+	      "left": {"type": "Identifier", "name": "a", "loc": {"start": {"line": 1, "column": 0}, "end": {"line": 1, "column": 1}}, "range": [0, 1]},
+	      "operator": "+",
+	      "right": {
+	        "type": "BinaryExpression",
+	        "left": {"type": "Identifier", "name": "b", "loc": {"start": {"line": 1, "column": 5}, "end": {"line": 1, "column": 6}}, "range": [5, 6]},
+	        "operator": "*",
+	        "right": {"type": "Literal", "value": 2, "raw": "2", "loc": {"start": {"line": 1, "column": 9}, "end": {"line": 1, "column": 10}}, "range": [9, 10]},
+	        "loc": {"start": {"line": 1, "column": 5}, "end": {"line": 1, "column": 10}},
+	        "range": [5, 10]
+	      },
+	      "loc": {"start": {"line": 1, "column": 0}, "end": {"line": 1, "column": 11}},
+	      "range": [0, 11]
+	    },
+	    "loc": {"start": {"line": 1, "column": 0}, "end": {"line": 1, "column": 12}},
+	    "range": [0, 12]
+	  }],
+	  "sourceType": "script",
+	  "loc": {"start": {"line": 1, "column": 0}, "end": {"line": 1, "column": 12}},
+	  "range": [0, 12]
+	}`
+	var got, want interface{}
+	assert.Nil(t, json.Unmarshal(out, &got))
+	assert.Nil(t, json.Unmarshal([]byte(expected), &want))
+	assert.Equal(t, got, want)
+}
+
+func TestMarshalESTreeShapes(t *testing.T) {
+	out, err := MarshalESTree(Parse("'use strict'\nfunction f(x) { return new F(x--, typeof x) }\nvar o = {get a() { return 1 }, 'b': /x/g}", true), "", nil)
+	assert.Nil(t, err)
+
+	var tree map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out, &tree))
+	body := tree["body"].([]interface{})
+
+	directive := body[0].(map[string]interface{})
+	assert.Equal(t, directive["directive"], "use strict")
+	_, hasLoc := directive["loc"]
+	assert.False(t, hasLoc)
+
+	fn := body[1].(map[string]interface{})
+	assert.Equal(t, fn["type"], "FunctionDeclaration")
+	ret := fn["body"].(map[string]interface{})["body"].([]interface{})[0].(map[string]interface{})
+	newExpr := ret["argument"].(map[string]interface{})
+	assert.Equal(t, newExpr["type"], "NewExpression")
+	args := newExpr["arguments"].([]interface{})
+	assert.Equal(t, args[0].(map[string]interface{})["type"], "UpdateExpression")
+	assert.Equal(t, args[0].(map[string]interface{})["prefix"], false)
+	assert.Equal(t, args[1].(map[string]interface{})["operator"], "typeof")
+
+	decl := body[2].(map[string]interface{})["declarations"].([]interface{})[0].(map[string]interface{})
+	props := decl["init"].(map[string]interface{})["properties"].([]interface{})
+	assert.Equal(t, props[0].(map[string]interface{})["kind"], "get")
+	assert.Equal(t, props[0].(map[string]interface{})["value"].(map[string]interface{})["type"], "FunctionExpression")
+	re := props[1].(map[string]interface{})["value"].(map[string]interface{})
+	assert.Equal(t, re["raw"], "/x/g")
+	assert.Equal(t, re["regex"], map[string]interface{}{"pattern": "x", "flags": "g"})
+}
+
+// Each statement is one node, as in acorn: a var keeps its semicolon, and
+// break and continue are statements of their own.
+func TestMarshalESTreeStatements(t *testing.T) {
+	out, err := MarshalESTree(Parse("var x;\nwhile (x) { break; continue }", true), "", nil)
+	assert.Nil(t, err)
+
+	var tree map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out, &tree))
+	body := tree["body"].([]interface{})
+	assert.Equal(t, len(body), 2)
+	assert.Equal(t, body[0].(map[string]interface{})["type"], "VariableDeclaration")
+	loop := body[1].(map[string]interface{})["body"].(map[string]interface{})["body"].([]interface{})
+	assert.Equal(t, loop, []interface{}{
+		map[string]interface{}{"type": "BreakStatement", "label": nil},
+		map[string]interface{}{"type": "ContinueStatement", "label": nil},
+	})
+}
+
+func TestESTreeRoundTrip(t *testing.T) {
+	sources := []string{
+		"var a = 1, b; a += b ? -a : [1, , 'x'];",
+		"function f(a, b) { if (a) return b; else { throw a } }",
+		"for (var i = 0; i < 10; i++) { o[i] = o.x.y(i) }",
+		"for (var k in o) ; while (a && !b) a = a, b, c; do { } while (x)",
+		"try { f() } catch (e) { g(e) } finally { h() }",
+		"switch (x) { case 1: a(); default: b() }",
+		"x = {a: 1, 'b': function () { return this }, 2: null}; new X; new Y(1)",
+		"while (a) { if (b) break; continue }",
+	}
+	for _, code := range sources {
+		prog, locations := ParseWithLocations(code, true)
+		out, err := MarshalESTree(prog, code, locations)
+		assert.Nil(t, err)
+		back, err := UnmarshalESTree(out)
+		assert.Nil(t, err)
+		assert.Equal(t, DumpAST(back), DumpAST(prog))
+	}
+}
+
+// A call as the callee of a new keeps its parentheses.
+func TestUnmarshalESTreeNew(t *testing.T) {
+	f := `{"type": "CallExpression", "callee": {"type": "Identifier", "name": "f"}, "arguments": []}`
+	prog, err := UnmarshalESTree([]byte(`{"type": "Program", "body": [
+		{"type": "ExpressionStatement", "expression": {"type": "NewExpression", "callee": ` + f + `, "arguments": []}},
+		{"type": "ExpressionStatement", "expression": {"type": "NewExpression", "callee": {"type": "Identifier", "name": "F"}, "arguments": []}}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, Print(prog, PrintOptions{}), "new (f())();\nnew F;\n")
+}
+
+func TestUnmarshalESTreeErrors(t *testing.T) {
+	_, err := UnmarshalESTree([]byte(`{"type": "Program", "body": [{"type": "VariableDeclaration", "kind": "let", "declarations": []}]}`))
+	assert.Equal(t, err.Error(), "unsupported let declaration")
+
+	_, err = UnmarshalESTree([]byte(`{"type": "Program", "body": [{"type": "BreakStatement", "label": {"type": "Identifier", "name": "a"}}]}`))
+	assert.Equal(t, err.Error(), "unsupported label")
+
+	_, err = UnmarshalESTree([]byte(`{"type": "Identifier", "name": "a"}`))
+	assert.Equal(t, err.Error(), "expected a Program, got Identifier")
+}
+
+// Trees that Print couldn't print, or that say something the parser couldn't
+// read, are refused.
+func TestUnmarshalESTreeInvalid(t *testing.T) {
+	id := `{"type": "Identifier", "name": "a"}`
+	one := `{"type": "Literal", "value": 1, "raw": "1"}`
+	tests := []struct {
+		statement string
+		err       string
+	}{
+		{`{"type": "ExpressionStatement", "expression": {"type": "BinaryExpression", "operator": "+", "left": ` + id + `, "right": {"type": "ExpressionStatement", "expression": ` + id + `}}}`,
+			"expected an expression as the right of BinaryExpression, got ExpressionStatement"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "CallExpression", "callee": ` + id + `, "arguments": [{"type": "EmptyStatement"}]}}`,
+			"expected an expression as the arguments of CallExpression, got EmptyStatement"},
+		{`{"type": "BlockStatement", "body": [` + id + `]}`, "expected a statement as the body of BlockStatement, got Identifier"},
+		{`{"type": "WhileStatement", "test": ` + id + `, "body": ` + id + `}`, "expected a statement as the body of WhileStatement, got Identifier"},
+		{`{"type": "BlockStatement", "body": [{"type": "Program", "body": []}]}`, "unsupported ESTree node type Program"},
+		{`{"type": "BlockStatement", "body": [{"type": "SwitchCase", "test": null, "consequent": []}]}`, "unsupported ESTree node type SwitchCase"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "AssignmentExpression", "operator": "=", "left": ` + one + `, "right": ` + id + `}}`,
+			"AssignmentExpression can't assign to a Literal"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "UpdateExpression", "operator": "++", "prefix": true, "argument": ` + one + `}}`,
+			"UpdateExpression can't assign to a Literal"},
+		{`{"type": "ForInStatement", "left": ` + one + `, "right": ` + id + `, "body": {"type": "EmptyStatement"}}`, "ForInStatement can't assign to a Literal"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "Identifier", "name": "a b"}}`, `unsupported identifier name "a b"`},
+		{`{"type": "ExpressionStatement", "expression": {"type": "Identifier", "name": "if"}}`, `unsupported identifier name "if"`},
+		{`{"type": "ExpressionStatement", "expression": {"type": "Identifier", "name": "1a"}}`, `unsupported identifier name "1a"`},
+	}
+	for _, test := range tests {
+		_, err := UnmarshalESTree([]byte(`{"type": "Program", "body": [` + test.statement + `]}`))
+		assert.NotNil(t, err, test.statement)
+		if err != nil {
+			assert.Equal(t, err.Error(), test.err)
+		}
+	}
+
+	// a for can start with a var, or any expression
+	_, err := UnmarshalESTree([]byte(`{"type": "Program", "body": [
+		{"type": "ForStatement", "init": {"type": "VariableDeclaration", "kind": "var", "declarations": [{"type": "VariableDeclarator", "id": ` + id + `, "init": null}]}, "test": null, "update": null, "body": {"type": "EmptyStatement"}},
+		{"type": "ForStatement", "init": ` + one + `, "test": null, "update": null, "body": {"type": "EmptyStatement"}},
+		{"type": "ForInStatement", "left": {"type": "VariableDeclaration", "kind": "var", "declarations": [{"type": "VariableDeclarator", "id": ` + id + `, "init": null}]}, "right": ` + id + `, "body": {"type": "EmptyStatement"}}]}`))
+	assert.Nil(t, err)
+}
+
+// Trees missing what a node can't be without are refused, rather than left for
+// Print or the compiler to trip over.
+func TestUnmarshalESTreeMissing(t *testing.T) {
+	id := `{"type": "Identifier", "name": "a"}`
+	tests := []struct {
+		statement string
+		err       string
+	}{
+		{`{"type": "ExpressionStatement"}`, "ExpressionStatement is missing its expression"},
+		{`{"type": "ExpressionStatement", "expression": null}`, "ExpressionStatement is missing its expression"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "BinaryExpression", "operator": "+", "left": ` + id + `}}`, "BinaryExpression is missing its right"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "BinaryExpression", "operator": "+", "right": ` + id + `}}`, "BinaryExpression is missing its left"},
+		{`{"type": "IfStatement", "test": ` + id + `}`, "IfStatement is missing its consequent"},
+		{`{"type": "BlockStatement", "body": [null]}`, "BlockStatement has a null in its body"},
+		{`{"type": "BlockStatement"}`, "BlockStatement is missing its body"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "CallExpression", "callee": ` + id + `, "arguments": [null]}}`, "CallExpression has a null in its arguments"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "MemberExpression", "object": ` + id + `}}`, "MemberExpression is missing its property"},
+		{`{"type": "ExpressionStatement", "expression": {"type": "Identifier"}}`, "Identifier is missing its name"},
+		{`{"type": "VariableDeclaration", "kind": "var", "declarations": [{"type": "VariableDeclarator", "init": null}]}`, "VariableDeclarator is missing its id"},
+		{`{"type": "WhileStatement", "test": ` + id + `}`, "WhileStatement is missing its body"},
+		{`{"type": "ThrowStatement"}`, "ThrowStatement is missing its argument"},
+		{`{"type": "TryStatement", "block": {"type": "BlockStatement", "body": []}, "handler": {"type": "CatchClause", "body": {"type": "BlockStatement", "body": []}}}`, "CatchClause is missing its param"},
+		{`{"type": "FunctionDeclaration", "id": ` + id + `, "params": []}`, "FunctionDeclaration is missing its body"},
+	}
+	for _, test := range tests {
+		_, err := UnmarshalESTree([]byte(`{"type": "Program", "body": [` + test.statement + `]}`))
+		assert.NotNil(t, err)
+		assert.Equal(t, err.Error(), test.err)
+	}
+
+	_, err := UnmarshalESTree([]byte(`{"type": "Program", "body": [null]}`))
+	assert.Equal(t, err.Error(), "Program has a null in its body")
+
+	// but a hole in an array is fine, as are the parts that can be left out
+	_, err = UnmarshalESTree([]byte(`{"type": "Program", "body": [
+		{"type": "ExpressionStatement", "expression": {"type": "ArrayExpression", "elements": [null]}},
+		{"type": "IfStatement", "test": ` + id + `, "consequent": {"type": "EmptyStatement"}, "alternate": null},
+		{"type": "ForStatement", "init": null, "test": null, "update": null, "body": {"type": "EmptyStatement"}}]}`))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import "fmt"
+
+// A Token is a piece of source code as a Lexer reads it.
+type Token struct {
+	Type TokenType
+
+	// identifiers' names, strings with their escapes decoded, numbers and
+	// comments as written, and regular expressions' patterns; keywords and
+	// punctuation have none
+	Value string
+
+	Text     string // the source code of the token, quotes and all
+	Location Location
+}
+
+// A LexerState is how far a Lexer has got, for another to carry on from, as
+// an editor might after a change later on in the code.
+type LexerState struct {
+	pos, line, col int
+
+	// whether the last token other than a comment ends an operand, after
+	// which a / divides rather than starting a regular expression
+	afterOperand bool
+}
+
+// Position returns where the state is in the code.
+func (this LexerState) Position() Position {
+	return Position{Offset: this.pos, Line: this.line + 1, Column: this.col}
+}
+
+// A Lexer splits code into tokens, comments included, without parsing it.
+type Lexer struct {
+	ts           tokenStream
+	afterOperand bool
+}
+
+// NewLexer returns a Lexer reading code from the start.
+func NewLexer(code string) *Lexer {
+	return ResumeLexer(code, LexerState{})
+}
+
+// ResumeLexer returns a Lexer carrying on reading code from state. The code
+// before state need not be what it was read from, as long as it ends there.
+func ResumeLexer(code string, state LexerState) *Lexer {
+	stream := &byteStream{code: code, pos: state.pos, line: state.line, col: state.col}
+	return &Lexer{ts: tokenStream{stream: stream}, afterOperand: state.afterOperand}
+}
+
+// State returns where the lexer has got to.
+func (this *Lexer) State() LexerState {
+	s := this.ts.stream
+	return LexerState{pos: s.pos, line: s.line, col: s.col, afterOperand: this.afterOperand}
+}
+
+// Next returns the next token, or one of type EOF at the end of the code. A /
+// is read as division after an operand, and as starting a regular expression
+// anywhere else, which is right but for code such as "if (x) /y/.exec(z)".
+func (this *Lexer) Next() (Token, error) {
+	return this.NextWithHint(!this.afterOperand)
+}
+
+// NextWithHint returns the next token like Next does, reading a / as starting
+// a regular expression if regExp is set, and as division if not.
+//
+// If the code there isn't a token, it returns an ILLEGAL token of its first
+// byte along with the error, so that the rest can still be read.
+func (this *Lexer) NextWithHint(regExp bool) (tok Token, err error) {
+	this.ts.consumeWhitespace()
+	start := *this.ts.stream
+	defer func() {
+		if r := recover(); r != nil {
+			*this.ts.stream = start
+			this.ts.stream.next()
+			tok = this.token(ILLEGAL, "", start)
+			err = fmt.Errorf("%d:%d: %v", start.line+1, start.col+1, r)
+		}
+	}()
+
+	if regExp && this.startsRegExp() {
+		this.ts.stream.next()
+		pattern, _ := this.ts.scanRegExp(false)
+		tok = this.token(REGEXP_LITERAL, pattern, start)
+	} else {
+		this.ts.readNext()
+		tok = this.token(this.ts.current.tokenType, this.ts.current.value, start)
+	}
+
+	if tok.Type != COMMENT {
+		this.afterOperand = endsOperand(tok.Type)
+	}
+	return tok, nil
+}
+
+// startsRegExp returns whether the code at the stream is a / that isn't the
+// start of a comment.
+func (this *Lexer) startsRegExp() bool {
+	s := this.ts.stream
+	if s.eof() || s.peek() != '/' {
+		return false
+	}
+	return s.pos+1 >= len(s.code) || (s.code[s.pos+1] != '/' && s.code[s.pos+1] != '*')
+}
+
+// token returns a token of the code from start to where the stream is now.
+func (this *Lexer) token(tokenType TokenType, value string, start byteStream) Token {
+	end := this.ts.stream
+	return Token{
+		Type:  tokenType,
+		Value: value,
+		Text:  end.code[start.pos:end.pos],
+		Location: Location{
+			Start: Position{Offset: start.pos, Line: start.line + 1, Column: start.col},
+			End:   Position{Offset: end.pos, Line: end.line + 1, Column: end.col},
+		},
+	}
+}
+
+// endsOperand returns whether a token of type t can be the last of an
+// operand, so that a / after it must be division.
+func endsOperand(t TokenType) bool {
+	switch t {
+	case IDENTIFIER, STRING_LITERAL, NUMERIC_LITERAL, REGEXP_LITERAL,
+		THIS, NULL, TRUE, FALSE, GET, SET,
+		RPAREN, RBRACKET, INCREMENT, DECREMENT:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+// lexAll returns the type and text of each token l reads, up to EOF, as
+// "TYPE text" strings.
+func lexAll(t *testing.T, l *Lexer) []string {
+	ret := []string{}
+	for {
+		tok, err := l.Next()
+		assert.Nil(t, err)
+		if tok.Type == EOF {
+			return ret
+		}
+		ret = append(ret, tok.Type.String()+" "+tok.Text)
+	}
+}
+
+func TestLexer(t *testing.T) {
+	tests := []struct {
+		input  string
+		output []string
+	}{
+		{"", []string{}},
+		{"var x = 'a\\n'; // hi", []string{"VAR var", "IDENTIFIER x", "ASSIGNMENT =", "STRING_LITERAL 'a\\n'", "SEMICOLON ;", "COMMENT // hi"}},
+		{"a /* b */ / 2", []string{"IDENTIFIER a", "COMMENT /* b */", "DIVIDE /", "NUMERIC_LITERAL 2"}},
+		{"a = /[/]x/g.test(b)", []string{"IDENTIFIER a", "ASSIGNMENT =", "REGEXP_LITERAL /[/]x/g", "DOT .", "IDENTIFIER test", "LPAREN (", "IDENTIFIER b", "RPAREN )"}},
+		{"/=/", []string{"REGEXP_LITERAL /=/"}},
+		{"(a) /= b / c", []string{"LPAREN (", "IDENTIFIER a", "RPAREN )", "DIVIDE_EQ /=", "IDENTIFIER b", "DIVIDE /", "IDENTIFIER c"}},
+		{"return /x/", []string{"RETURN return", "REGEXP_LITERAL /x/"}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, lexAll(t, NewLexer(test.input)), test.output)
+		t.Logf("Pass %s", escapeStringToPrint(test.input))
+	}
+}
+
+func TestLexerToken(t *testing.T) {
+	l := NewLexer("x\n  \"\\u2665\" + /a/i")
+	_, err := l.Next()
+	assert.Nil(t, err)
+
+	tok, err := l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, tok, Token{
+		Type:  STRING_LITERAL,
+		Value: "♥",
+		Text:  "\"\\u2665\"",
+		Location: Location{
+			Start: Position{Offset: 4, Line: 2, Column: 2},
+			End:   Position{Offset: 12, Line: 2, Column: 10},
+		},
+	})
+
+	l.Next()
+	tok, err = l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, tok.Type, REGEXP_LITERAL)
+	assert.Equal(t, tok.Value, "a")
+	assert.Equal(t, tok.Location.Start, Position{Offset: 15, Line: 2, Column: 13})
+
+	tok, err = l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, tok.Type, EOF)
+	assert.Equal(t, tok.Location.Start, Position{Offset: 19, Line: 2, Column: 17})
+}
+
+func TestLexerHint(t *testing.T) {
+	l := NewLexer("if (x) /y/.exec(z)")
+	for i := 0; i < 4; i++ {
+		l.Next()
+	}
+	tok, err := l.NextWithHint(true)
+	assert.Nil(t, err)
+	assert.Equal(t, tok.Text, "/y/")
+
+	l = NewLexer("/ 2")
+	tok, err = l.NextWithHint(false)
+	assert.Nil(t, err)
+	assert.Equal(t, tok.Type, DIVIDE)
+}
+
+func TestLexerError(t *testing.T) {
+	l := NewLexer("a\n#b; /c")
+	l.Next()
+
+	tok, err := l.Next()
+	assert.Equal(t, err.Error(), "2:1: unknown token: #")
+	assert.Equal(t, tok.Type, ILLEGAL)
+	assert.Equal(t, tok.Text, "#")
+
+	tok, err = l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, tok.Text, "b")
+
+	l.Next()
+	tok, err = l.Next()
+	assert.Equal(t, err.Error(), "2:5: Unterminated regular expression")
+	assert.Equal(t, tok.Text, "/")
+
+	tok, err = l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, tok.Text, "c")
+}
+
+// What a string or comment can't be without is an error too, not a token
+// running on to the end of the code.
+func TestLexerUnterminated(t *testing.T) {
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{"'abc\nx = 1", "1:1: unterminated string literal"},
+		{"'abc\r\nx = 1", "1:1: unterminated string literal"},
+		{`"abc`, "1:1: unterminated string literal"},
+		{"/* abc\n", "1:1: unterminated comment"},
+		{`"\x4"`, `1:1: malformed hex sequence: expected a hex digit, got '"'`},
+		{`"\u12g4"`, `1:1: malformed hex sequence: expected a hex digit, got 'g'`},
+	}
+	for _, test := range tests {
+		tok, err := NewLexer(test.code).Next()
+		assert.NotNil(t, err, test.code)
+		if err != nil {
+			assert.Equal(t, err.Error(), test.err)
+		}
+		assert.Equal(t, tok.Type, ILLEGAL)
+	}
+
+	// the rest can still be read
+	l := NewLexer("'abc\nx")
+	l.Next()
+	tok, err := l.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, tok.Text, "abc")
+
+	// but a line can be continued, and a string can hold an escaped quote
+	for _, code := range []string{"'a\\\nb'", `"a\"b"`, "/* a\n*/"} {
+		_, err := NewLexer(code).Next()
+		assert.Nil(t, err, code)
+	}
+}
+
+func TestLexerRegExp(t *testing.T) {
+	for _, code := range []string{`/é[♥]\é/`, `/[/]/`, `/[\]/]/`} {
+		tok, err := NewLexer(code).Next()
+		assert.Nil(t, err, code)
+		assert.Equal(t, tok.Text, code)
+	}
+
+	_, err := NewLexer("/[a/\n]/").Next()
+	assert.Equal(t, err.Error(), "1:1: Unterminated regular expression class")
+}
+
+func TestLexerResume(t *testing.T) {
+	code := "a = 1;\nb = c / 2;\n"
+	l := NewLexer(code)
+	var state LexerState
+	for {
+		tok, _ := l.Next()
+		if tok.Text == "c" {
+			state = l.State()
+			break
+		}
+	}
+	assert.Equal(t, state.Position(), Position{Offset: 12, Line: 2, Column: 5})
+
+	// the code before the state was changed, but what follows is read as it
+	// would have been, / and all
+	edited := strings.Repeat(" ", 12) + code[12:]
+	assert.Equal(t, lexAll(t, ResumeLexer(edited, state)), []string{"DIVIDE /", "NUMERIC_LITERAL 2", "SEMICOLON ;"})
+	assert.Equal(t, lexAll(t, ResumeLexer(code, LexerState{})), lexAll(t, NewLexer(code)))
+}
@@ -29,13 +29,39 @@ package parser
 import (
 	"fmt"
 	"log"
+	"sort"
 )
 
 type parser struct {
 	stream tokenStream
+
+	// where each node starts and ends, as indexes into the code. only kept
+	// for ParseWithLocations.
+	spans map[Node][2]int
+}
+
+// Returns where the next token starts.
+func (this *parser) start() int {
+	return this.stream.peek().pos
+}
+
+// Records that n runs from start to the end of the last token read, if we are
+// keeping track.
+func (this *parser) locate(n Node, start int) {
+	if this.spans != nil && n != nil {
+		this.spans[n] = [2]int{start, this.stream.lastEnd}
+	}
+}
+
+func (this *parser) parseIdentifier() *IdentifierLiteral {
+	start := this.start()
+	n := &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
+	this.locate(n, start)
+	return n
 }
 
 func (this *parser) parseArrayLiteral() *ArrayLiteral {
+	start := this.start()
 	tok := this.expect(LBRACKET)
 	n := &ArrayLiteral{tok: tok}
 
@@ -61,6 +87,7 @@ func (this *parser) parseArrayLiteral() *ArrayLiteral {
 
 	}
 
+	this.locate(n, start)
 	return n
 }
 
@@ -94,6 +121,7 @@ func (this *parser) parseObjectProperty(currentObject *ObjectLiteral, propertyNa
 }
 
 func (this *parser) parseObjectLiteral() *ObjectLiteral {
+	start := this.start()
 	tok := this.expect(LBRACE)
 	n := &ObjectLiteral{tok: tok}
 
@@ -109,17 +137,21 @@ func (this *parser) parseObjectLiteral() *ObjectLiteral {
 			wantsSet = true
 			this.expect(SET)
 		case IDENTIFIER:
-			propertyName := &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
+			propertyName := this.parseIdentifier()
 			this.parseObjectProperty(n, propertyName, wantsGet, wantsSet)
 			wantsGet = false
 			wantsSet = false
 		case STRING_LITERAL:
+			start := this.start()
 			propertyName := &StringLiteral{tok: this.expect(STRING_LITERAL)}
+			this.locate(propertyName, start)
 			this.parseObjectProperty(n, propertyName, wantsGet, wantsSet)
 			wantsGet = false
 			wantsSet = false
 		case NUMERIC_LITERAL:
+			start := this.start()
 			propertyName := &NumericLiteral{tok: this.expect(NUMERIC_LITERAL)}
+			this.locate(propertyName, start)
 			this.parseObjectProperty(n, propertyName, wantsGet, wantsSet)
 			wantsGet = false
 			wantsSet = false
@@ -129,23 +161,27 @@ func (this *parser) parseObjectLiteral() *ObjectLiteral {
 		}
 	}
 
+	this.locate(n, start)
 	return n
 }
 
-func (this *parser) parseMemberExpression() Node {
+// Calls are only read if calls is set, as a new takes the first arguments
+// after its callee as its own.
+func (this *parser) parseMemberExpression(calls bool) Node {
+	start := this.start()
 	if this.stream.peek().tokenType == FUNCTION {
 		funcTok := this.expect(FUNCTION)
 		var id *IdentifierLiteral
 		switch this.stream.peek().tokenType {
 		case IDENTIFIER:
-			id = &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
+			id = this.parseIdentifier()
 		}
 
 		this.expect(LPAREN)
 
 		params := []*IdentifierLiteral{}
 		for this.stream.peek().tokenType == IDENTIFIER {
-			params = append(params, &IdentifierLiteral{tok: this.expect(IDENTIFIER)})
+			params = append(params, this.parseIdentifier())
 			if this.stream.peek().tokenType == COMMA {
 				this.expect(COMMA)
 			}
@@ -154,77 +190,98 @@ func (this *parser) parseMemberExpression() Node {
 		this.expect(RPAREN)
 
 		body := this.parseBlockStatement()
-		return &FunctionExpression{tok: funcTok, Identifier: id, Parameters: params, Body: body}
+		n := &FunctionExpression{tok: funcTok, Identifier: id, Parameters: params, Body: body}
+		this.locate(n, start)
+		return n
 	}
 
 	left := this.parsePrimaryExpression()
-	return this.parseMemberOrCall(left)
+	return this.parseMemberOrCall(start, left, calls)
 }
 
-func (this *parser) parseMemberOrCall(left Node) Node {
+// start is where left starts, including any parentheses around it.
+func (this *parser) parseMemberOrCall(start int, left Node, calls bool) Node {
 	tok := this.stream.peek()
-	for tok.tokenType == LBRACKET || tok.tokenType == DOT || tok.tokenType == LPAREN {
+	for tok.tokenType == LBRACKET || tok.tokenType == DOT || (calls && tok.tokenType == LPAREN) {
 		if tok.tokenType == LBRACKET {
 			this.expect(LBRACKET)
 			right := this.parseExpression()
 			this.expect(RBRACKET)
 			left = &BracketMemberExpression{tok: tok, X: left, Y: right}
+			this.locate(left, start)
 		} else if tok.tokenType == DOT {
 			this.expect(DOT)
-			member := &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
+			member := this.parseIdentifier()
 			left = &DotMemberExpression{tok: tok, X: left, Name: member}
+			this.locate(left, start)
 		} else if tok.tokenType == LPAREN {
-			this.expect(LPAREN)
-			args := []Node{}
-			for this.stream.peek().tokenType != RPAREN {
-				arg := this.parseAssignmentExpression()
-				args = append(args, arg)
-				if this.stream.peek().tokenType == COMMA {
-					this.expect(COMMA)
-				}
-			}
-			this.expect(RPAREN)
-
-			left = &CallExpression{tok: tok, X: left, Arguments: args}
+			left = this.parseArguments(start, left)
 		}
 		tok = this.stream.peek()
 	}
 	return left
 }
 
+// Parses a call of left, which starts at start.
+func (this *parser) parseArguments(start int, left Node) Node {
+	tok := this.expect(LPAREN)
+	args := []Node{}
+	for this.stream.peek().tokenType != RPAREN {
+		arg := this.parseAssignmentExpression()
+		args = append(args, arg)
+		if this.stream.peek().tokenType == COMMA {
+			this.expect(COMMA)
+		}
+	}
+	this.expect(RPAREN)
+
+	n := &CallExpression{tok: tok, X: left, Arguments: args}
+	this.locate(n, start)
+	return n
+}
+
 func (this *parser) parseNewExpression() Node {
+	start := this.start()
 	tok := this.expect(NEW)
-	left := this.parseMemberExpression()
-	return &NewExpression{tok: tok, X: left}
+	calleeStart := this.start()
+	left := this.parseMemberExpression(false)
+	if this.stream.peek().tokenType == LPAREN {
+		left = this.parseArguments(calleeStart, left)
+	}
+	n := &NewExpression{tok: tok, X: left}
+	this.locate(n, start)
+	return n
 }
 
 func (this *parser) parseLeftHandSideExpression() Node {
+	start := this.start()
 	tok := this.stream.peek()
 	var left Node
 	if tok.tokenType == NEW {
 		left = this.parseNewExpression()
 	} else {
-		left = this.parseMemberExpression()
+		left = this.parseMemberExpression(true)
 	}
 
-	return this.parseMemberOrCall(left)
+	return this.parseMemberOrCall(start, left, true)
 }
 
 func (this *parser) parsePostfixExpression() Node {
+	start := this.start()
 	left := this.parseLeftHandSideExpression()
 	tok := this.stream.peek()
 	switch tok.tokenType {
-	case INCREMENT:
-		this.expect(INCREMENT)
-		return &UnaryExpression{tok: tok, postfix: true, X: left}
-	case DECREMENT:
-		this.expect(DECREMENT)
-		return &UnaryExpression{tok: tok, postfix: true, X: left}
+	case INCREMENT, DECREMENT:
+		this.expect(tok.tokenType)
+		n := &UnaryExpression{tok: tok, postfix: true, X: left}
+		this.locate(n, start)
+		return n
 	}
 	return left
 }
 
 func (this *parser) parseUnaryExpression() Node {
+	start := this.start()
 	tok := this.stream.peek()
 
 	tt := tok.tokenType
@@ -243,26 +300,56 @@ func (this *parser) parseUnaryExpression() Node {
 	case TYPEOF:
 		fallthrough
 	case VOID:
-		this.expect(tt)
-		return &UnaryExpression{tok: tok, postfix: false, X: this.parseUnaryExpression()}
+		fallthrough
 	case INCREMENT:
 		fallthrough
 	case DECREMENT:
 		this.expect(tt)
-		return &UnaryExpression{tok: tok, postfix: false, X: this.parseUnaryExpression()}
+		n := &UnaryExpression{tok: tok, postfix: false, X: this.parseUnaryExpression()}
+		this.locate(n, start)
+		return n
 	}
 
 	return this.parsePostfixExpression()
 }
 
-func (this *parser) parseMultiplicativeExpression() Node {
+func (this *parser) parseExponentiationExpression() Node {
+	// An unparenthesized unary operator can't be the base, as -2 ** 2 would be
+	// ambiguous. Parentheses aren't kept in the tree, so check before parsing.
+	start := this.start()
+	isUnary := false
+	switch this.stream.peek().tokenType {
+	case PLUS, MINUS, BITWISE_NOT, LOGICAL_NOT, DELETE, TYPEOF, VOID:
+		isUnary = true
+	}
+
 	left := this.parseUnaryExpression()
 	tok := this.stream.peek()
 
+	if tok.tokenType == EXPONENT {
+		if isUnary {
+			panic("unary operator used immediately before exponentiation expression")
+		}
+		this.expect(EXPONENT)
+		// right associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		right := this.parseExponentiationExpression()
+		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
+	}
+
+	return left
+}
+
+func (this *parser) parseMultiplicativeExpression() Node {
+	start := this.start()
+	left := this.parseExponentiationExpression()
+	tok := this.stream.peek()
+
 	for tok.tokenType == MULTIPLY || tok.tokenType == DIVIDE || tok.tokenType == MODULUS {
 		this.expect(tok.tokenType)
-		right := this.parseUnaryExpression()
+		right := this.parseExponentiationExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -270,6 +357,7 @@ func (this *parser) parseMultiplicativeExpression() Node {
 }
 
 func (this *parser) parseAdditiveExpression() Node {
+	start := this.start()
 	left := this.parseMultiplicativeExpression()
 	tok := this.stream.peek()
 
@@ -277,6 +365,7 @@ func (this *parser) parseAdditiveExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseMultiplicativeExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -284,6 +373,7 @@ func (this *parser) parseAdditiveExpression() Node {
 }
 
 func (this *parser) parseShiftExpression() Node {
+	start := this.start()
 	left := this.parseAdditiveExpression()
 	tok := this.stream.peek()
 
@@ -291,6 +381,7 @@ func (this *parser) parseShiftExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseAdditiveExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -298,6 +389,7 @@ func (this *parser) parseShiftExpression() Node {
 }
 
 func (this *parser) parseRelationalExpression() Node {
+	start := this.start()
 	left := this.parseShiftExpression()
 	tok := this.stream.peek()
 
@@ -315,13 +407,16 @@ func (this *parser) parseRelationalExpression() Node {
 	case INSTANCEOF:
 		this.expect(tok.tokenType)
 		right := this.parseShiftExpression()
-		return &BinaryExpression{tok: tok, Left: left, Right: right}
+		n := &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(n, start)
+		return n
 	}
 
 	return left
 }
 
 func (this *parser) parseEqualityExpression() Node {
+	start := this.start()
 	left := this.parseRelationalExpression()
 	tok := this.stream.peek()
 
@@ -329,6 +424,7 @@ func (this *parser) parseEqualityExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseRelationalExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -336,6 +432,7 @@ func (this *parser) parseEqualityExpression() Node {
 }
 
 func (this *parser) parseBitwiseAndExpression() Node {
+	start := this.start()
 	left := this.parseEqualityExpression()
 	tok := this.stream.peek()
 
@@ -343,6 +440,7 @@ func (this *parser) parseBitwiseAndExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseEqualityExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -350,6 +448,7 @@ func (this *parser) parseBitwiseAndExpression() Node {
 }
 
 func (this *parser) parseBitwiseXorExpression() Node {
+	start := this.start()
 	left := this.parseBitwiseAndExpression()
 	tok := this.stream.peek()
 
@@ -357,6 +456,7 @@ func (this *parser) parseBitwiseXorExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseBitwiseAndExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -364,6 +464,7 @@ func (this *parser) parseBitwiseXorExpression() Node {
 }
 
 func (this *parser) parseBitwiseOrExpression() Node {
+	start := this.start()
 	left := this.parseBitwiseXorExpression()
 	tok := this.stream.peek()
 
@@ -371,6 +472,7 @@ func (this *parser) parseBitwiseOrExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseBitwiseXorExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -378,6 +480,7 @@ func (this *parser) parseBitwiseOrExpression() Node {
 }
 
 func (this *parser) parseLogicalAndExpression() Node {
+	start := this.start()
 	left := this.parseBitwiseOrExpression()
 	tok := this.stream.peek()
 
@@ -385,6 +488,7 @@ func (this *parser) parseLogicalAndExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseBitwiseOrExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -392,6 +496,7 @@ func (this *parser) parseLogicalAndExpression() Node {
 }
 
 func (this *parser) parseLogicalOrExpression() Node {
+	start := this.start()
 	left := this.parseLogicalAndExpression()
 	tok := this.stream.peek()
 
@@ -399,6 +504,7 @@ func (this *parser) parseLogicalOrExpression() Node {
 		this.expect(tok.tokenType)
 		right := this.parseLogicalAndExpression()
 		left = &BinaryExpression{tok: tok, Left: left, Right: right}
+		this.locate(left, start)
 		tok = this.stream.peek()
 	}
 
@@ -406,6 +512,7 @@ func (this *parser) parseLogicalOrExpression() Node {
 }
 
 func (this *parser) parseConditionalExpression() Node {
+	start := this.start()
 	test := this.parseLogicalOrExpression()
 	tok := this.stream.peek()
 
@@ -415,13 +522,16 @@ func (this *parser) parseConditionalExpression() Node {
 		trueBranch := this.parseAssignmentExpression()
 		this.expect(COLON)
 		falseBranch := this.parseAssignmentExpression()
-		return &ConditionalExpression{tok: tok, X: test, Then: trueBranch, Else: falseBranch}
+		n := &ConditionalExpression{tok: tok, X: test, Then: trueBranch, Else: falseBranch}
+		this.locate(n, start)
+		return n
 	}
 
 	return test
 }
 
 func (this *parser) parseAssignmentExpression() Node {
+	start := this.start()
 	left := this.parseConditionalExpression()
 	tok := this.stream.peek()
 
@@ -436,6 +546,8 @@ func (this *parser) parseAssignmentExpression() Node {
 		fallthrough
 	case MODULUS_EQ:
 		fallthrough
+	case EXPONENT_EQ:
+		fallthrough
 	case LEFT_SHIFT_EQ:
 		fallthrough
 	case RIGHT_SHIFT_EQ:
@@ -451,39 +563,45 @@ func (this *parser) parseAssignmentExpression() Node {
 	case ASSIGNMENT:
 		this.expect(tok.tokenType)
 		right := this.parseAssignmentExpression()
-		return &AssignmentExpression{tok: tok, Left: left, Right: right}
+		n := &AssignmentExpression{tok: tok, Left: left, Right: right}
+		this.locate(n, start)
+		return n
 	}
 	return left
 }
 
 func (this *parser) parseExpression() Node {
+	start := this.start()
 	left := this.parseAssignmentExpression()
 
 	for this.stream.peek().tokenType == COMMA {
 		tok := this.expect(COMMA)
 		left = &SequenceExpression{tok: tok, X: left, Y: this.parseAssignmentExpression()}
+		this.locate(left, start)
 	}
 
 	return left
 }
 
 func (this *parser) parsePrimaryExpression() Node {
+	start := this.start()
 	tok := this.stream.peek()
+	var n Node
 	switch tok.tokenType {
 	case NUMERIC_LITERAL:
-		return &NumericLiteral{tok: this.expect(NUMERIC_LITERAL)}
+		n = &NumericLiteral{tok: this.expect(NUMERIC_LITERAL)}
 	case STRING_LITERAL:
-		return &StringLiteral{tok: this.expect(STRING_LITERAL)}
+		n = &StringLiteral{tok: this.expect(STRING_LITERAL)}
 	case THIS:
-		return &ThisLiteral{tok: this.expect(THIS)}
+		n = &ThisLiteral{tok: this.expect(THIS)}
 	case IDENTIFIER:
-		return &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
+		n = &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
 	case TRUE:
-		return &TrueLiteral{tok: this.expect(TRUE)}
+		n = &TrueLiteral{tok: this.expect(TRUE)}
 	case FALSE:
-		return &FalseLiteral{tok: this.expect(FALSE)}
+		n = &FalseLiteral{tok: this.expect(FALSE)}
 	case NULL:
-		return &NullLiteral{tok: this.expect(NULL)}
+		n = &NullLiteral{tok: this.expect(NULL)}
 	case LBRACKET:
 		return this.parseArrayLiteral()
 	case LBRACE:
@@ -502,13 +620,17 @@ func (this *parser) parsePrimaryExpression() Node {
 	default:
 		panic(fmt.Sprintf("unknown expression type %s %s", tok.tokenType, tok.value))
 	}
+	this.locate(n, start)
+	return n
 }
 
 func (this *parser) parseRegExpLiteral(eq bool) Node {
 	tok := this.stream.peek()
 	re, flags := this.stream.scanRegExp(eq)
 	this.expect(tok.tokenType) // we ate it
-	return &RegExpLiteral{tok: tok, RegExp: re, Flags: flags}
+	n := &RegExpLiteral{tok: tok, RegExp: re, Flags: flags}
+	this.locate(n, tok.pos)
+	return n
 }
 
 func (this *parser) parseIfStatement() *IfStatement {
@@ -523,6 +645,7 @@ func (this *parser) parseIfStatement() *IfStatement {
 		this.expect(ELSE)
 		n.ElseStmt = this.parseStatement()
 	}
+	this.locate(n, tok.pos)
 	return n
 }
 
@@ -531,12 +654,14 @@ func (this *parser) parseReturnStatement() *ReturnStatement {
 	n := &ReturnStatement{tok: tok}
 	if this.stream.peek().tokenType == SEMICOLON {
 		this.expect(SEMICOLON)
+		this.locate(n, tok.pos)
 		return n
 	}
 	n.X = this.parseExpression()
 	if this.stream.peek().tokenType == SEMICOLON {
 		this.expect(SEMICOLON)
 	}
+	this.locate(n, tok.pos)
 	return n
 }
 
@@ -554,6 +679,7 @@ func (this *parser) parseBlockStatement() *BlockStatement {
 	tok := this.expect(LBRACE)
 	n := &BlockStatement{tok: tok, Body: this.parseBlockStatementBody()}
 	this.expect(RBRACE)
+	this.locate(n, tok.pos)
 	return n
 }
 
@@ -562,7 +688,7 @@ func (this *parser) parseVariableStatement() Node {
 	n := &VariableStatement{tok: tok}
 
 	for this.stream.peek().tokenType == IDENTIFIER {
-		id := &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
+		id := this.parseIdentifier()
 		var initializer Node = nil
 		if this.stream.peek().tokenType == ASSIGNMENT {
 			this.expect(ASSIGNMENT)
@@ -577,6 +703,7 @@ func (this *parser) parseVariableStatement() Node {
 		}
 	}
 
+	this.locate(n, tok.pos)
 	return n
 }
 
@@ -587,7 +714,9 @@ func (this *parser) parseWhileStatement() Node {
 	this.expect(RPAREN)
 	body := this.parseStatement()
 
-	return &WhileStatement{tok: tok, X: expr, Body: body}
+	n := &WhileStatement{tok: tok, X: expr, Body: body}
+	this.locate(n, tok.pos)
+	return n
 }
 
 func (this *parser) parseDoWhileStatement() Node {
@@ -598,7 +727,9 @@ func (this *parser) parseDoWhileStatement() Node {
 	expr := this.parseExpression()
 	this.expect(RPAREN)
 
-	return &DoWhileStatement{tok: tok, X: expr, Body: body}
+	n := &DoWhileStatement{tok: tok, X: expr, Body: body}
+	this.locate(n, tok.pos)
+	return n
 }
 
 func (this *parser) parseForStatement() Node {
@@ -618,7 +749,9 @@ func (this *parser) parseForStatement() Node {
 		this.expect(IN)
 		Y := this.parseExpression()
 		this.expect(RPAREN)
-		return &ForInStatement{tok: tok, X: init, Y: Y, Body: this.parseStatement()}
+		n := &ForInStatement{tok: tok, X: init, Y: Y, Body: this.parseStatement()}
+		this.locate(n, tok.pos)
+		return n
 	} else {
 		this.expect(SEMICOLON)
 		var test Node
@@ -631,7 +764,9 @@ func (this *parser) parseForStatement() Node {
 			update = this.parseExpression()
 		}
 		this.expect(RPAREN)
-		return &ForStatement{tok: tok, Initializer: init, Test: test, Update: update, Body: this.parseStatement()}
+		n := &ForStatement{tok: tok, Initializer: init, Test: test, Update: update, Body: this.parseStatement()}
+		this.locate(n, tok.pos)
+		return n
 	}
 }
 
@@ -650,10 +785,12 @@ func (this *parser) parseIterationStatement() Node {
 }
 
 func (this *parser) parseExpressionStatement() Node {
+	start := this.start()
 	r := &ExpressionStatement{X: this.parseExpression()}
 	if this.stream.peek().tokenType == SEMICOLON {
 		this.expect(SEMICOLON)
 	}
+	this.locate(r, start)
 	return r
 }
 
@@ -666,6 +803,7 @@ func (this *parser) parseSwitchStatement() Node {
 
 	hasDefault := false
 	for this.stream.peek().tokenType != RBRACE {
+		start := this.start()
 		isDefault := false
 		var expr Node
 		switch this.stream.peek().tokenType {
@@ -686,13 +824,16 @@ func (this *parser) parseSwitchStatement() Node {
 
 		// this will stop at CASE, DEFAULT or }
 		body := this.parseBlockStatementBody()
-		r.Cases = append(r.Cases, &CaseStatement{X: expr, Body: body, IsDefault: isDefault})
+		c := &CaseStatement{X: expr, Body: body, IsDefault: isDefault}
+		this.locate(c, start)
+		r.Cases = append(r.Cases, c)
 		if isDefault {
 			hasDefault = true
 		}
 	}
 
 	this.expect(RBRACE)
+	this.locate(r, r.tok.pos)
 	return r
 }
 
@@ -702,7 +843,23 @@ func (this *parser) parseThrowStatement() Node {
 	if this.stream.peek().tokenType == SEMICOLON {
 		this.expect(SEMICOLON)
 	}
-	return &ThrowStatement{tok: tok, X: x}
+	n := &ThrowStatement{tok: tok, X: x}
+	this.locate(n, tok.pos)
+	return n
+}
+
+// Parses a break or continue, which may not have a label.
+func (this *parser) parseJumpStatement() Node {
+	tok := this.stream.next()
+	var n Node = &BreakStatement{tok: tok}
+	if tok.tokenType == CONTINUE {
+		n = &ContinueStatement{tok: tok}
+	}
+	if this.stream.peek().tokenType == SEMICOLON {
+		this.expect(SEMICOLON)
+	}
+	this.locate(n, tok.pos)
+	return n
 }
 
 func (this *parser) parseTryStatement() Node {
@@ -712,12 +869,14 @@ func (this *parser) parseTryStatement() Node {
 	case CATCH:
 		cb := &CatchStatement{tok: this.expect(CATCH)}
 		this.expect(LPAREN)
-		cb.Identifier = &IdentifierLiteral{tok: this.expect(IDENTIFIER)}
+		cb.Identifier = this.parseIdentifier()
 		this.expect(RPAREN)
 		cb.Body = this.parseBlockStatement()
+		this.locate(cb, cb.tok.pos)
 		tb.Catch = cb
 	case FINALLY:
 		fb := &FinallyStatement{tok: this.expect(FINALLY), Body: this.parseBlockStatement()}
+		this.locate(fb, fb.tok.pos)
 		tb.Finally = fb
 	default:
 		panic("expected catch or finally")
@@ -731,9 +890,11 @@ func (this *parser) parseTryStatement() Node {
 			panic("only one finally block expected")
 		}
 		fb := &FinallyStatement{tok: this.expect(FINALLY), Body: this.parseBlockStatement()}
+		this.locate(fb, fb.tok.pos)
 		tb.Finally = fb
 	}
 
+	this.locate(tb, tb.tok.pos)
 	return tb
 }
 
@@ -741,7 +902,14 @@ func (this *parser) parseStatement() Node {
 	tok := this.stream.peek()
 	switch tok.tokenType {
 	case VAR:
-		return this.parseVariableStatement()
+		n := this.parseVariableStatement()
+		// the ; is the statement's, as it is for an expression, but not
+		// when the var starts a for
+		if this.stream.peek().tokenType == SEMICOLON {
+			this.expect(SEMICOLON)
+			this.locate(n, tok.pos)
+		}
+		return n
 	case IF:
 		return this.parseIfStatement()
 	case RETURN:
@@ -758,10 +926,14 @@ func (this *parser) parseStatement() Node {
 		return this.parseTryStatement()
 	case THROW:
 		return this.parseThrowStatement()
+	case BREAK, CONTINUE:
+		return this.parseJumpStatement()
 	case SWITCH:
 		return this.parseSwitchStatement()
 	case SEMICOLON:
-		return &EmptyStatement{tok: this.expect(SEMICOLON)}
+		n := &EmptyStatement{tok: this.expect(SEMICOLON)}
+		this.locate(n, n.tok.pos)
+		return n
 	}
 
 	return this.parseExpressionStatement()
@@ -788,8 +960,15 @@ func (this *parser) parseProgram() *Program {
 
 const parseDebug = false
 
+func newParser(code string, ignoreComments bool) *parser {
+	// comments aren't part of the grammar, so the parser never sees them
+	return &parser{stream: tokenStream{stream: &byteStream{code: code}, ignoreComments: ignoreComments, collectComments: !ignoreComments}}
+}
+
+// Parse parses code into a tree. Comments are kept out of the way, and only
+// ParseWithComments says what they were.
 func Parse(code string, ignoreComments bool) Node {
-	np := parser{tokenStream{stream: &byteStream{code: code}, ignoreComments: ignoreComments}}
+	np := newParser(code, ignoreComments)
 	ret := np.parseProgram()
 	if parseDebug {
 		log.Printf("%s", RecursivelyPrint(ret))
@@ -797,6 +976,58 @@ func Parse(code string, ignoreComments bool) Node {
 	return ret
 }
 
+// A Position is a place in the source code.
+type Position struct {
+	Offset int // in bytes, from the start of the code
+	Line   int // counting from 1
+	Column int // in bytes, counting from 0
+}
+
+// A Location is the part of the source code a node was parsed from.
+type Location struct {
+	Start Position
+	End   Position // just past the node
+}
+
+// ParseWithLocations parses code like Parse does, and also returns where each
+// node of the tree came from. Parentheses around an expression aren't part of
+// its location, but are part of the location of any node containing it.
+func ParseWithLocations(code string, ignoreComments bool) (Node, map[Node]Location) {
+	ret, locations, _ := parseWithLocations(code, ignoreComments)
+	return ret, locations
+}
+
+// parseWithLocations is ParseWithLocations, also returning the parser, for the
+// comments it came across.
+func parseWithLocations(code string, ignoreComments bool) (Node, map[Node]Location, *parser) {
+	np := newParser(code, ignoreComments)
+	np.spans = map[Node][2]int{}
+	ret := np.parseProgram()
+	np.spans[ret] = [2]int{0, len(code)}
+
+	position := positionFinder(code)
+	locations := make(map[Node]Location, len(np.spans))
+	for n, span := range np.spans {
+		locations[n] = Location{Start: position(span[0]), End: position(span[1])}
+	}
+	return ret, locations, np
+}
+
+// positionFinder returns a function giving the position of an index into
+// code.
+func positionFinder(code string) func(offset int) Position {
+	lineStarts := []int{0}
+	for i := 0; i < len(code); i++ {
+		if code[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	return func(offset int) Position {
+		line := sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > offset }) - 1
+		return Position{Offset: offset, Line: line + 1, Column: offset - lineStarts[line]}
+	}
+}
+
 func RecursivelyPrint(node Node) string {
 	if node == nil {
 		return "(nil)"
@@ -921,6 +1152,8 @@ func RecursivelyPrint(node Node) string {
 			return fmt.Sprintf("%s /= %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
 		case MODULUS_EQ:
 			return fmt.Sprintf("%s %%= %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
+		case EXPONENT_EQ:
+			return fmt.Sprintf("%s **= %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
 		case LEFT_SHIFT_EQ:
 			return fmt.Sprintf("%s <<= %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
 		case RIGHT_SHIFT_EQ:
@@ -944,6 +1177,8 @@ func RecursivelyPrint(node Node) string {
 			return fmt.Sprintf("%s / %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
 		case MODULUS:
 			return fmt.Sprintf("%s %% %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
+		case EXPONENT:
+			return fmt.Sprintf("%s ** %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
 		case PLUS:
 			return fmt.Sprintf("%s + %s", RecursivelyPrint(n.Left), RecursivelyPrint(n.Right))
 		case MINUS:
@@ -1059,6 +1294,10 @@ func RecursivelyPrint(node Node) string {
 		return b
 	case *ThrowStatement:
 		return fmt.Sprintf("throw %s\n", n.X)
+	case *BreakStatement:
+		return "break\n"
+	case *ContinueStatement:
+		return "continue\n"
 	case *VariableStatement:
 		buf := "var "
 		for idx, _ := range n.Vars {
@@ -287,6 +287,12 @@ func TestAssignmentExpression(t *testing.T) {
 			ipos:        5,
 			icol:        5,
 		},
+		ut{
+			tokenString: "**=",
+			tokenType:   EXPONENT_EQ,
+			ipos:        6,
+			icol:        6,
+		},
 		ut{
 			tokenString: "<<=",
 			tokenType:   LEFT_SHIFT_EQ,
@@ -370,6 +376,12 @@ func TestSimpleBinaryExpression(t *testing.T) {
 			ipos:        4,
 			icol:        4,
 		},
+		ut{
+			tokenString: "**",
+			tokenType:   EXPONENT,
+			ipos:        5,
+			icol:        5,
+		},
 		ut{
 			tokenString: "+",
 			tokenType:   PLUS,
@@ -548,6 +560,54 @@ func TestSequenceExpression(t *testing.T) {
 	assert.Equal(t, Parse("a,b,c", false), ep1)
 }
 
+func TestExponentiationExpression(t *testing.T) {
+	ep1 := &Program{body: []Node{
+		&ExpressionStatement{X: &BinaryExpression{
+			tok: token{tokenType: EXPONENT, value: "", pos: 1, col: 1},
+			Left: &IdentifierLiteral{
+				tok: token{tokenType: IDENTIFIER, value: "a", pos: 0, col: 0},
+			},
+			Right: &BinaryExpression{
+				tok: token{tokenType: EXPONENT, value: "", pos: 4, col: 4},
+				Left: &IdentifierLiteral{
+					tok: token{tokenType: IDENTIFIER, value: "b", pos: 3, col: 3},
+				},
+				Right: &IdentifierLiteral{
+					tok: token{tokenType: IDENTIFIER, value: "c", pos: 6, col: 6},
+				},
+			},
+		},
+		}}}
+	assert.Equal(t, Parse("a**b**c", false), ep1)
+
+	ep2 := &Program{body: []Node{
+		&ExpressionStatement{X: &BinaryExpression{
+			tok: token{tokenType: MULTIPLY, value: "", pos: 1, col: 1},
+			Left: &IdentifierLiteral{
+				tok: token{tokenType: IDENTIFIER, value: "a", pos: 0, col: 0},
+			},
+			Right: &BinaryExpression{
+				tok: token{tokenType: EXPONENT, value: "", pos: 3, col: 3},
+				Left: &IdentifierLiteral{
+					tok: token{tokenType: IDENTIFIER, value: "b", pos: 2, col: 2},
+				},
+				Right: &IdentifierLiteral{
+					tok: token{tokenType: IDENTIFIER, value: "c", pos: 5, col: 5},
+				},
+			},
+		},
+		}}}
+	assert.Equal(t, Parse("a*b**c", false), ep2)
+
+	func() {
+		defer func() {
+			assert.NotNil(t, recover())
+		}()
+		Parse("-a**b", false)
+		t.Fatalf("unary operand to ** should not parse")
+	}()
+}
+
 func TestRegExpLiterals(t *testing.T) {
 	ep1 := &Program{body: []Node{
 		&VariableStatement{
@@ -573,9 +633,11 @@ func TestRegExpLiterals(t *testing.T) {
 				&RegExpLiteral{tok: token{tokenType: DIVIDE, pos: 11, col: 11}, RegExp: `(?:^|\s+)ba(?:\s+|$)`, Flags: NoFlagsRegExp},
 			},
 		},
-		&EmptyStatement{tok: token{tokenType: SEMICOLON, pos: 33, col: 33}},
 	},
 	}
 	assert.Equal(t, Parse(`var re19 = /(?:^|\s+)ba(?:\s+|$)/;`, false), ep2)
 
+	ep3 := &Program{body: []Node{&ExpressionStatement{X: &RegExpLiteral{tok: token{tokenType: DIVIDE}, RegExp: `[a-z/\]]+`, Flags: GlobalRegExp}}}}
+	assert.Equal(t, Parse(`/[a-z/\]]+/g`, false), ep3)
+
 }
@@ -249,6 +249,15 @@ func TestDotExpression(t *testing.T) {
 	assert.Equal(t, RecursivelyPrint(Parse("a.b()", false)), RecursivelyPrint(ep2))
 }
 
+func TestLine(t *testing.T) {
+	p := Parse("a\n\nvar b\nswitch (a) {\ncase\n 1: b }", false).(*Program)
+	assert.Equal(t, Line(p), 1)
+	assert.Equal(t, Line(p.body[0]), 1)
+	assert.Equal(t, Line(p.body[1]), 3)
+	assert.Equal(t, Line(p.body[2]), 4)
+	assert.Equal(t, Line(p.body[2].(*SwitchStatement).Cases[0]), 6)
+}
+
 func TestBreakage(t *testing.T) {
 	t.Skipf("Broken!")
 	// this is endlessly looping...
@@ -0,0 +1,709 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// PrintOptions says how Print lays out code.
+type PrintOptions struct {
+	// Indent is a level of indentation. It's four spaces if empty.
+	Indent string
+
+	// Comments are printed with the nodes they're attached to, if given, as
+	// ParseWithComments returns them. A // comment on an expression moves to
+	// its statement, as nothing else could follow it on its line.
+	Comments CommentMap
+}
+
+// Print returns the tree under n as JavaScript source. Parentheses are put in
+// where precedence needs them, strings are double quoted, and each statement
+// is on its own line. Parsing the result gives the same tree back, except
+// positions.
+func Print(n Node, options PrintOptions) string {
+	p := printer{indent: options.Indent, comments: options.Comments}
+	if p.indent == "" {
+		p.indent = "    "
+	}
+	if prog, ok := n.(*Program); ok {
+		s := p.statements(prog.body, p.comments.inner(prog))
+		if s != "" {
+			s += "\n"
+		}
+		return s
+	}
+	if isStatement(n) {
+		return p.statement(n)
+	}
+	s, h := p.hoisting(func() string { return p.expr(n, precSequence) })
+	return p.commented(nil, s, h)
+}
+
+type printer struct {
+	indent   string
+	comments CommentMap
+
+	// the // comments of expressions in the statement being printed
+	hoisted *hoistedComments
+
+	// set while printing a for's initializer, where an in would make it a
+	// for-in
+	noIn bool
+}
+
+type hoistedComments struct {
+	leading, trailing []*Comment
+}
+
+// How tightly expressions bind, loosest first.
+const (
+	precSequence = iota
+	precAssignment
+	precConditional
+	precLogicalOr
+	precLogicalAnd
+	precBitwiseOr
+	precBitwiseXor
+	precBitwiseAnd
+	precEquality
+	precRelational
+	precShift
+	precAdditive
+	precMultiplicative
+	precExponent
+	precUnary
+	precPostfix
+	precCall // and members, and new
+	precPrimary
+)
+
+var binaryPrecedence = map[TokenType]int{
+	LOGICAL_OR:           precLogicalOr,
+	LOGICAL_AND:          precLogicalAnd,
+	BITWISE_OR:           precBitwiseOr,
+	BITWISE_XOR:          precBitwiseXor,
+	BITWISE_AND:          precBitwiseAnd,
+	EQUALS:               precEquality,
+	NOT_EQUALS:           precEquality,
+	STRICT_EQUALS:        precEquality,
+	STRICT_NOT_EQUALS:    precEquality,
+	LESS_THAN:            precRelational,
+	GREATER_THAN:         precRelational,
+	LESS_EQ:              precRelational,
+	GREATER_EQ:           precRelational,
+	IN:                   precRelational,
+	INSTANCEOF:           precRelational,
+	LEFT_SHIFT:           precShift,
+	RIGHT_SHIFT:          precShift,
+	UNSIGNED_RIGHT_SHIFT: precShift,
+	PLUS:                 precAdditive,
+	MINUS:                precAdditive,
+	MULTIPLY:             precMultiplicative,
+	DIVIDE:               precMultiplicative,
+	MODULUS:              precMultiplicative,
+	EXPONENT:             precExponent,
+}
+
+func precedence(n Node) int {
+	switch n := n.(type) {
+	case *SequenceExpression:
+		return precSequence
+	case *AssignmentExpression:
+		return precAssignment
+	case *ConditionalExpression:
+		return precConditional
+	case *BinaryExpression:
+		return binaryPrecedence[n.tok.tokenType]
+	case *UnaryExpression:
+		if n.postfix {
+			return precPostfix
+		}
+		return precUnary
+	case *CallExpression, *NewExpression, *DotMemberExpression, *BracketMemberExpression:
+		return precCall
+	}
+	return precPrimary
+}
+
+func isStatement(n Node) bool {
+	switch n.(type) {
+	case *ExpressionStatement, *VariableStatement, *IfStatement, *ReturnStatement, *BlockStatement,
+		*EmptyStatement, *SwitchStatement, *CaseStatement, *DoWhileStatement, *WhileStatement,
+		*ForStatement, *ForInStatement, *ThrowStatement, *BreakStatement, *ContinueStatement, *TryStatement, *CatchStatement,
+		*FinallyStatement:
+		return true
+	}
+	return false
+}
+
+// Returns s with each line indented by a level.
+func (this *printer) indented(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = this.indent + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// How a statement ends, when it's printed without a semicolon.
+const (
+	endsClosed        = iota // with a } or ;
+	endsOpen                 // the parser leaves a following ; as an empty statement
+	endsOpenSemicolon        // the parser would take a following ; as part of it
+)
+
+func statementEnd(n Node) int {
+	switch n := n.(type) {
+	case *DoWhileStatement:
+		return endsOpen
+	case *ExpressionStatement:
+		if isFunctionDeclaration(n) {
+			return endsOpenSemicolon
+		}
+	case *IfStatement:
+		if n.ElseStmt != nil {
+			return statementEnd(n.ElseStmt)
+		}
+		return statementEnd(n.ThenStmt)
+	case *WhileStatement:
+		return statementEnd(n.Body)
+	case *ForStatement:
+		return statementEnd(n.Body)
+	case *ForInStatement:
+		return statementEnd(n.Body)
+	}
+	return endsClosed
+}
+
+func isFunctionDeclaration(n *ExpressionStatement) bool {
+	f, ok := n.X.(*FunctionExpression)
+	return ok && f.Identifier != nil
+}
+
+// Returns whether s would carry on the statement before it, if that wasn't
+// ended by a semicolon.
+func continuesStatement(s string) bool {
+	return s != "" && strings.IndexByte("([+-/", s[0]) >= 0
+}
+
+// Returns the statements of a body, a line each, followed by the comments
+// inside it after them.
+func (this *printer) statements(body []Node, inner []*Comment) string {
+	lines := []string{}
+	for i := 0; i < len(body); i++ {
+		stmt := body[i]
+		s, h := this.bareStatement(stmt)
+		if i+1 < len(body) {
+			switch statementEnd(stmt) {
+			case endsOpen:
+				if _, ok := body[i+1].(*EmptyStatement); ok && this.comments[body[i+1]] == nil {
+					s += ";"
+					i++
+				} else if next, _ := this.bareStatement(body[i+1]); continuesStatement(next) {
+					s += ";"
+				}
+			case endsOpenSemicolon:
+				if next, _ := this.bareStatement(body[i+1]); continuesStatement(next) {
+					s += ";"
+				}
+			}
+		}
+		lines = append(lines, this.commented(stmt, s, h))
+	}
+	for _, c := range inner {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (this *printer) block(n *BlockStatement) string {
+	inner := this.comments.inner(n)
+	if len(n.Body) == 0 && len(inner) == 0 {
+		return "{}"
+	}
+	return "{\n" + this.indented(this.statements(n.Body, inner)) + "\n}"
+}
+
+// Returns the body of an if or a loop, following its head.
+func (this *printer) body(n Node) string {
+	if _, ok := n.(*BlockStatement); ok {
+		return " " + this.statement(n)
+	}
+	return "\n" + this.indented(this.statement(n))
+}
+
+// Returns the comments inside n, after everything else in it.
+func (this CommentMap) inner(n Node) []*Comment {
+	if c := this[n]; c != nil {
+		return c.Inner
+	}
+	return nil
+}
+
+// Returns what print returns, and the // comments of the expressions in it.
+func (this *printer) hoisting(print func() string) (string, hoistedComments) {
+	saved := this.hoisted
+	this.hoisted = &hoistedComments{}
+	s := print()
+	h := *this.hoisted
+	this.hoisted = saved
+	return s, h
+}
+
+// Returns the statement n without its comments, and those of its expressions
+// that have to be written around it.
+func (this *printer) bareStatement(n Node) (string, hoistedComments) {
+	return this.hoisting(func() string { return this.statementText(n) })
+}
+
+// Returns the statement n, with its comments.
+func (this *printer) statement(n Node) string {
+	s, h := this.bareStatement(n)
+	return this.commented(n, s, h)
+}
+
+// Returns s, the statement n, with its comments, and those hoisted out of it,
+// around it.
+func (this *printer) commented(n Node, s string, h hoistedComments) string {
+	leading, trailing := h.leading, h.trailing
+	if c := this.comments[n]; c != nil {
+		leading = append(append([]*Comment{}, c.Leading...), leading...)
+		trailing = append(append([]*Comment{}, c.Trailing...), trailing...)
+		switch n.(type) {
+		case *BlockStatement, *SwitchStatement, *CaseStatement:
+			// these have a place for them
+		default:
+			trailing = append(trailing, c.Inner...)
+		}
+	}
+
+	var sb strings.Builder
+	for _, c := range leading {
+		sb.WriteString(c.String() + "\n")
+	}
+	sb.WriteString(s)
+	afterLineComment := false
+	for _, c := range trailing {
+		if afterLineComment {
+			sb.WriteString("\n")
+		} else {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(c.String())
+		afterLineComment = !c.Block
+	}
+	return sb.String()
+}
+
+// Returns whether an else following n would be taken as belonging to an if
+// inside it.
+func endsInIfWithoutElse(n Node) bool {
+	switch n := n.(type) {
+	case *IfStatement:
+		if n.ElseStmt == nil {
+			return true
+		}
+		return endsInIfWithoutElse(n.ElseStmt)
+	case *WhileStatement:
+		return endsInIfWithoutElse(n.Body)
+	case *ForStatement:
+		return endsInIfWithoutElse(n.Body)
+	case *ForInStatement:
+		return endsInIfWithoutElse(n.Body)
+	}
+	return false
+}
+
+func (this *printer) variables(n *VariableStatement) string {
+	decls := []string{}
+	for i, id := range n.Vars {
+		d := this.expr(id, precPrimary)
+		if n.Initializers[i] != nil {
+			d += " = " + this.expr(n.Initializers[i], precAssignment)
+		}
+		decls = append(decls, d)
+	}
+	return "var " + strings.Join(decls, ", ")
+}
+
+func (this *printer) statementText(node Node) string {
+	switch n := node.(type) {
+	case *ExpressionStatement:
+		if isFunctionDeclaration(n) {
+			return this.expr(n.X, precSequence)
+		}
+		s := this.expr(n.X, precSequence)
+		// these would be read as a block, or a function declaration
+		if strings.HasPrefix(s, "{") || strings.HasPrefix(s, "function") {
+			s = "(" + s + ")"
+		}
+		return s + ";"
+	case *VariableStatement:
+		return this.variables(n) + ";"
+	case *IfStatement:
+		then := n.ThenStmt
+		if n.ElseStmt != nil && endsInIfWithoutElse(then) {
+			then = &BlockStatement{Body: []Node{then}}
+		}
+		s := "if (" + this.expr(n.ConditionExpr, precSequence) + ")" + this.body(then)
+		if n.ElseStmt == nil {
+			return s
+		}
+		if _, ok := then.(*BlockStatement); ok {
+			s += " else"
+		} else {
+			s += "\nelse"
+		}
+		if _, ok := n.ElseStmt.(*IfStatement); ok {
+			return s + " " + this.statement(n.ElseStmt)
+		}
+		return s + this.body(n.ElseStmt)
+	case *ReturnStatement:
+		if n.X == nil {
+			return "return;"
+		}
+		return "return " + this.expr(n.X, precSequence) + ";"
+	case *BlockStatement:
+		return this.block(n)
+	case *EmptyStatement:
+		return ";"
+	case *SwitchStatement:
+		cases := []string{}
+		for _, c := range n.Cases {
+			cases = append(cases, this.statement(c))
+		}
+		for _, c := range this.comments.inner(n) {
+			cases = append(cases, c.String())
+		}
+		s := "switch (" + this.expr(n.X, precSequence) + ") {"
+		if len(cases) > 0 {
+			s += "\n" + this.indented(strings.Join(cases, "\n")) + "\n"
+		}
+		return s + "}"
+	case *CaseStatement:
+		s := "default:"
+		if !n.IsDefault {
+			s = "case " + this.expr(n.X, precSequence) + ":"
+		}
+		if body := this.statements(n.Body, this.comments.inner(n)); body != "" {
+			s += "\n" + this.indented(body)
+		}
+		return s
+	case *DoWhileStatement:
+		s := "do" + this.body(n.Body)
+		if _, ok := n.Body.(*BlockStatement); ok {
+			s += " "
+		} else {
+			s += "\n"
+		}
+		return s + "while (" + this.expr(n.X, precSequence) + ")"
+	case *WhileStatement:
+		return "while (" + this.expr(n.X, precSequence) + ")" + this.body(n.Body)
+	case *ForStatement:
+		s := "for ("
+		this.noIn = true
+		if vs, ok := n.Initializer.(*VariableStatement); ok {
+			s += this.variables(vs)
+		} else if n.Initializer != nil {
+			s += this.expr(n.Initializer, precSequence)
+		}
+		this.noIn = false
+		s += ";"
+		if n.Test != nil {
+			s += " " + this.expr(n.Test, precSequence)
+		}
+		s += ";"
+		if n.Update != nil {
+			s += " " + this.expr(n.Update, precSequence)
+		}
+		return s + ")" + this.body(n.Body)
+	case *ForInStatement:
+		s := "for ("
+		if vs, ok := n.X.(*VariableStatement); ok {
+			s += this.variables(vs)
+		} else {
+			s += this.expr(n.X, precCall)
+		}
+		return s + " in " + this.expr(n.Y, precSequence) + ")" + this.body(n.Body)
+	case *ThrowStatement:
+		return "throw " + this.expr(n.X, precSequence) + ";"
+	case *BreakStatement:
+		return "break;"
+	case *ContinueStatement:
+		return "continue;"
+	case *TryStatement:
+		s := "try " + this.statement(n.Body)
+		if n.Catch != nil {
+			s += " " + this.statement(n.Catch)
+		}
+		if n.Finally != nil {
+			s += " " + this.statement(n.Finally)
+		}
+		return s
+	case *CatchStatement:
+		return "catch (" + this.expr(n.Identifier, precPrimary) + ") " + this.statement(n.Body)
+	case *FinallyStatement:
+		return "finally " + this.statement(n.Body)
+	}
+
+	panic(fmt.Sprintf("can't print %T", node))
+}
+
+// Returns n, parenthesized unless it binds at least as tightly as prec.
+func (this *printer) expr(n Node, prec int) string {
+	var s string
+	if b, ok := n.(*BinaryExpression); ok && b.tok.tokenType == IN && this.noIn {
+		this.noIn = false
+		s = "(" + this.expression(n) + ")"
+		this.noIn = true
+	} else if s = this.expression(n); precedence(n) < prec {
+		s = "(" + s + ")"
+	}
+
+	c := this.comments[n]
+	if c == nil {
+		return s
+	}
+	for i := len(c.Leading) - 1; i >= 0; i-- {
+		if c.Leading[i].Block {
+			s = c.Leading[i].String() + " " + s
+		} else if this.hoisted != nil {
+			this.hoisted.leading = append(this.hoisted.leading, c.Leading[i])
+		}
+	}
+	for _, t := range append(append([]*Comment{}, c.Trailing...), c.Inner...) {
+		if t.Block {
+			s += " " + t.String()
+		} else if this.hoisted != nil {
+			this.hoisted.trailing = append(this.hoisted.trailing, t)
+		}
+	}
+	return s
+}
+
+// Returns what a member or call is on.
+func (this *printer) callee(n Node) string {
+	switch n := n.(type) {
+	case *NewExpression:
+		// without arguments, new would take the member or call as its own
+		if _, ok := n.X.(*CallExpression); !ok {
+			return "(" + this.expression(n) + ")"
+		}
+	case *NumericLiteral:
+		// 1.toString reads as a number
+		return "(" + this.expression(n) + ")"
+	}
+	return this.expr(n, precCall)
+}
+
+// Whether n is a call or new, or a member of one.
+func hasCall(n Node) bool {
+	for {
+		switch x := n.(type) {
+		case *CallExpression, *NewExpression:
+			return true
+		case *DotMemberExpression:
+			n = x.X
+		case *BracketMemberExpression:
+			n = x.X
+		default:
+			return false
+		}
+	}
+}
+
+func (this *printer) list(nodes []Node) string {
+	items := []string{}
+	for _, n := range nodes {
+		if n == nil {
+			items = append(items, "")
+		} else {
+			items = append(items, this.expr(n, precAssignment))
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+func (this *printer) function(id *IdentifierLiteral, params []string, body *BlockStatement) string {
+	s := "function"
+	if id != nil {
+		s += " " + this.expr(id, precPrimary)
+	}
+	// the body may be in a for's initializer, but an in there is fine
+	noIn := this.noIn
+	this.noIn = false
+	s += "(" + strings.Join(params, ", ") + ") " + this.statement(body)
+	this.noIn = noIn
+	return s
+}
+
+func (this *printer) expression(node Node) string {
+	switch n := node.(type) {
+	case *SequenceExpression:
+		return this.expr(n.X, precSequence) + ", " + this.expr(n.Y, precAssignment)
+	case *AssignmentExpression:
+		return this.expr(n.Left, precCall) + " " + operatorText[n.tok.tokenType] + " " + this.expr(n.Right, precAssignment)
+	case *ConditionalExpression:
+		return this.expr(n.X, precLogicalOr) + " ? " + this.expr(n.Then, precAssignment) + " : " + this.expr(n.Else, precAssignment)
+	case *BinaryExpression:
+		prec := binaryPrecedence[n.tok.tokenType]
+		left, right := prec, prec+1
+		switch prec {
+		case precExponent:
+			// right associative, and a unary operator can't be the base
+			left, right = precPostfix, prec
+		case precRelational:
+			// the parser doesn't chain these
+			left = prec + 1
+		}
+		return this.expr(n.Left, left) + " " + operatorText[n.tok.tokenType] + " " + this.expr(n.Right, right)
+	case *UnaryExpression:
+		op := operatorText[n.tok.tokenType]
+		if n.postfix {
+			return this.expr(n.X, precCall) + op
+		}
+		x := this.expr(n.X, precUnary)
+		if op[0] >= 'a' && op[0] <= 'z' || (op[0] == '+' || op[0] == '-') && x[0] == op[0] {
+			op += " "
+		}
+		return op + x
+	case *NewExpression:
+		// the callee ends where a call starts, so new (f())() and new (a().b)
+		// need their parentheses
+		if call, ok := n.X.(*CallExpression); ok && hasCall(call.X) {
+			return "new (" + this.expr(call.X, precSequence) + ")(" + this.list(call.Arguments) + ")"
+		} else if !ok && hasCall(n.X) {
+			return "new (" + this.expr(n.X, precSequence) + ")"
+		}
+		return "new " + this.expr(n.X, precCall)
+	case *CallExpression:
+		return this.callee(n.X) + "(" + this.list(n.Arguments) + ")"
+	case *DotMemberExpression:
+		return this.callee(n.X) + "." + this.expr(n.Name, precPrimary)
+	case *BracketMemberExpression:
+		return this.callee(n.X) + "[" + this.expr(n.Y, precSequence) + "]"
+	case *FunctionExpression:
+		params := []string{}
+		for _, p := range n.Parameters {
+			params = append(params, this.expr(p, precPrimary))
+		}
+		return this.function(n.Identifier, params, n.Body)
+	case *ArrayLiteral:
+		s := this.list(n.Elements)
+		if len(n.Elements) > 0 && n.Elements[len(n.Elements)-1] == nil {
+			// a trailing comma doesn't make a hole
+			s += ","
+		}
+		return "[" + s + "]"
+	case *ObjectLiteral:
+		props := []string{}
+		for _, p := range n.Properties {
+			key := this.expr(p.Key, precPrimary)
+			switch p.Type {
+			case Get:
+				props = append(props, "get "+key+"() "+this.statement(p.X))
+			case Set:
+				// the tree doesn't keep the parameter's name
+				props = append(props, "set "+key+"(value) "+this.statement(p.X))
+			default:
+				props = append(props, key+": "+this.expr(p.X, precAssignment))
+			}
+		}
+		return "{" + strings.Join(props, ", ") + "}"
+	case *NumericLiteral:
+		return n.tok.value
+	case *StringLiteral:
+		return quoteJS(n.tok.value)
+	case *IdentifierLiteral:
+		return n.tok.value
+	case *TrueLiteral:
+		return "true"
+	case *FalseLiteral:
+		return "false"
+	case *NullLiteral:
+		return "null"
+	case *ThisLiteral:
+		return "this"
+	case *RegExpLiteral:
+		return "/" + n.RegExp + "/" + n.Flags.String()
+	}
+
+	panic(fmt.Sprintf("can't print %T", node))
+}
+
+// Returns s as a double quoted JavaScript string, escaping what can't be
+// written as itself, and anything not ASCII.
+func quoteJS(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			r = rune(s[i]) // not UTF-8: keep the byte
+		}
+		i += size
+
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\v':
+			sb.WriteString(`\v`)
+		default:
+			switch {
+			case r < 0x20 || r == 0x7f:
+				fmt.Fprintf(&sb, `\x%02x`, r)
+			case r < 0x7f:
+				sb.WriteRune(r)
+			case r > 0xffff:
+				r1, r2 := utf16.EncodeRune(r)
+				fmt.Fprintf(&sb, `\u%04x\u%04x`, r1, r2)
+			default:
+				fmt.Fprintf(&sb, `\u%04x`, r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"a = b + c * d", "a = b + c * d;\n"},
+		{"(a + b) * c", "(a + b) * c;\n"},
+		{"a - (b - c); (a - b) - c", "a - (b - c);\na - b - c;\n"},
+		{"a ** (b ** c); (a ** b) ** c; (-a) ** b", "a ** b ** c;\n(a ** b) ** c;\n(-a) ** b;\n"},
+		{"a = (b, c); f((a, b), c)", "a = (b, c);\nf((a, b), c);\n"},
+		{"(a ? b : c) ? d : e", "(a ? b : c) ? d : e;\n"},
+		{"- -a; - (-a); typeof !a; a++ + ++b", "- -a;\n- -a;\ntypeof !a;\na++ + ++b;\n"},
+		{"(new X).y; new X().y; (1).toString()", "(new X).y;\nnew X().y;\n(1).toString();\n"},
+		{"new (a().b); new (f())(); new (a.b().c)(1); new f()()", "new (a().b);\nnew (f())();\nnew (a.b().c)(1);\nnew f()();\n"},
+		{"for (var i = ('a' in b), f = function () { return c in d }; i;) { }", "for (var i = (\"a\" in b), f = function() {\n    return c in d;\n}; i;) {}\n"},
+		{"for (x = (a in b) ? 1 : 2; ;) { }", "for (x = (a in b) ? 1 : 2;;) {}\n"},
+		{"[, 1, , 2, , ]", "[, 1, , 2, ,];\n"},
+		{`x = {a: 1, "b c": [2], 3: {}, get d() { return 1 }}`, "x = {a: 1, \"b c\": [2], 3: {}, get d() {\n    return 1;\n}};\n"},
+		{"({}).x; (function () {})()", "({}.x);\n(function() {}());\n"},
+		{"function f(a, b) { return a }; [f]", "function f(a, b) {\n    return a;\n};\n[f];\n"},
+		{"var a = 1, b; var c\n[1]", "var a = 1, b;\nvar c;\n[1];\n"},
+		{"if (a) var b; else var c", "if (a)\n    var b;\nelse\n    var c;\n"},
+		{"if (a) b; else if (c) { d } else e", "if (a)\n    b;\nelse if (c) {\n    d;\n} else\n    e;\n"},
+		{"if (a) { if (b) c } else d", "if (a) {\n    if (b)\n        c;\n} else\n    d;\n"},
+		{"for (var i = 0; i < 3; i++) ; for (;;) {}", "for (var i = 0; i < 3; i++)\n    ;\nfor (;;) {}\n"},
+		{"do x(); while (a); while (b) {}", "do\n    x();\nwhile (a);\nwhile (b) {}\n"},
+		{"switch (a) { case 1: b(); default: }", "switch (a) {\n    case 1:\n        b();\n    default:\n}\n"},
+		{"try { a() } catch (e) { throw e } finally { }", "try {\n    a();\n} catch (e) {\n    throw e;\n} finally {}\n"},
+		{"while (a) { if (b) break\n continue }", "while (a) {\n    if (b)\n        break;\n    continue;\n}\n"},
+		{`s = "a\"b\\c\n♥"; r = /[a-z]+/gi`, `s = "a\"b\\c\n\u2665";` + "\n" + `r = /[a-z]+/gi;` + "\n"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, Print(Parse(test.in, true), PrintOptions{}), test.out, test.in)
+	}
+}
+
+func TestPrintOptions(t *testing.T) {
+	assert.Equal(t, Print(Parse("if (a) { b() }", true), PrintOptions{Indent: "\t"}), "if (a) {\n\tb();\n}\n")
+	assert.Equal(t, Print(&BinaryExpression{
+		tok:   token{tokenType: MULTIPLY},
+		Left:  &BinaryExpression{tok: token{tokenType: PLUS}, Left: NewNumericLiteral(1), Right: NewIdentifierLiteral("a")},
+		Right: NewStringLiteral("b"),
+	}, PrintOptions{}), `(1 + a) * "b"`)
+}
+
+var positions = regexp.MustCompile(` Line=\d+`)
+
+// Printing each program the parser is tested with, and parsing it again, gives
+// the same tree.
+func TestPrintRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("parser_*_test.go")
+	assert.Nil(t, err)
+	assert.NotEqual(t, len(files), 0)
+
+	fset := gotoken.NewFileSet()
+	count := 0
+	for _, file := range files {
+		f, err := goparser.ParseFile(fset, file, nil, 0)
+		assert.Nil(t, err)
+		goast.Inspect(f, func(n goast.Node) bool {
+			call, ok := n.(*goast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			if id, ok := call.Fun.(*goast.Ident); !ok || id.Name != "Parse" {
+				return true
+			}
+			lit, ok := call.Args[0].(*goast.BasicLit)
+			if !ok {
+				return true
+			}
+			code, err := strconv.Unquote(lit.Value)
+			assert.Nil(t, err)
+			checkPrintRoundTrip(t, code)
+			count++
+			return true
+		})
+	}
+	assert.True(t, count > 40)
+}
+
+func checkPrintRoundTrip(t *testing.T, code string) {
+	var tree Node
+	func() {
+		defer func() { recover() }() // some are there to fail
+		tree = Parse(code, true)
+	}()
+	if tree == nil {
+		return
+	}
+
+	printed := Print(tree, PrintOptions{})
+	again := Parse(printed, true)
+	assert.Equal(t, positions.ReplaceAllString(DumpAST(again), ""), positions.ReplaceAllString(DumpAST(tree), ""), code+" printed as "+printed)
+}
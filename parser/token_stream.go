@@ -37,6 +37,13 @@ type tokenStream struct {
 	current        *token
 	hasStarted     bool
 	ignoreComments bool
+
+	// if set, comments are kept here rather than read as tokens
+	collectComments bool
+	comments        []token
+
+	// where the token last returned by next ends, as an index into the code
+	lastEnd int
 }
 
 type TokenType int
@@ -56,6 +63,7 @@ const (
 	MULTIPLY_EQ             // *=
 	DIVIDE_EQ               // /=
 	MODULUS_EQ              // %=
+	EXPONENT_EQ             // **=
 	LEFT_SHIFT_EQ           // <<=
 	RIGHT_SHIFT_EQ          // >>=
 	UNSIGNED_RIGHT_SHIFT_EQ // >>>=
@@ -70,6 +78,7 @@ const (
 	MULTIPLY             // *
 	DIVIDE               // /
 	MODULUS              // %
+	EXPONENT             // **
 	EQUALS               // ==
 	STRICT_EQUALS        // ===
 	BITWISE_AND          // &
@@ -134,6 +143,12 @@ const (
 	TRY
 	CATCH
 	FINALLY
+	BREAK
+	CONTINUE
+
+	// only read by a Lexer; the parser scans regular expressions itself
+	REGEXP_LITERAL
+	ILLEGAL
 )
 
 type token struct {
@@ -163,6 +178,9 @@ func (this *tokenStream) peek() token {
 // Returns the current token and advances the stream
 func (this *tokenStream) next() token {
 	cur := this.peek()
+	// nothing is read past the current token until now, except by scanRegExp,
+	// which makes its text part of the token.
+	this.lastEnd = this.stream.pos
 	this.readNext()
 	return cur
 }
@@ -189,7 +207,7 @@ func (this *tokenStream) consumeSingleLineComment() *token {
 	c.col -= 1
 	this.stream.next()
 	for !this.stream.eof() && this.stream.peek() != '\n' {
-		c.value += string(this.stream.next())
+		c.value += byteString(this.stream.next())
 	}
 	return c
 }
@@ -202,16 +220,16 @@ func (this *tokenStream) consumeMultiLineComment() *token {
 	this.stream.next()
 	for !this.stream.eof() {
 		if this.stream.peek() == '*' {
-			c.value += string(this.stream.next())
+			c.value += byteString(this.stream.next())
 			if !this.stream.eof() && this.stream.peek() == '/' {
 				this.stream.next()                    // eat /
 				c.value = c.value[0 : len(c.value)-1] // strip * from text
 				return c
 			}
 		}
-		c.value += string(this.stream.next())
+		c.value += byteString(this.stream.next())
 	}
-	return c
+	panic("unterminated comment")
 }
 
 func (this *tokenStream) consumeComment() *token {
@@ -235,7 +253,7 @@ func hex2dec(chr byte) rune {
 	case 'A' <= chr && chr <= 'F':
 		return rune(chr - 'A' + 10)
 	default:
-		panic("wtf")
+		panic(fmt.Sprintf("not a hex digit: %q", chr))
 	}
 }
 
@@ -246,8 +264,10 @@ func (this *tokenStream) decodeHexSequence(len int) rune {
 			panic("malformed hex sequence")
 		}
 		nextChar := this.stream.next()
-		val := hex2dec(nextChar) // maybe this shouldn't panic itself..
-		chr = chr<<4 | val
+		if !isHexDigit(nextChar) {
+			panic(fmt.Sprintf("malformed hex sequence: expected a hex digit, got %q", nextChar))
+		}
+		chr = chr<<4 | hex2dec(nextChar)
 	}
 	return chr
 }
@@ -260,6 +280,9 @@ func (this *tokenStream) consumeString(char byte) *token {
 	c.col -= 1
 	for !this.stream.eof() && this.stream.peek() != char {
 		nc := this.stream.next()
+		if nc == '\n' || nc == '\r' {
+			panic("unterminated string literal")
+		}
 
 		if nc == '\\' {
 			if this.stream.eof() {
@@ -280,9 +303,9 @@ func (this *tokenStream) consumeString(char byte) *token {
 			case '\'':
 				c.value += "'"
 			case 'r':
-				c.value += "\n"
-			case 'n':
 				c.value += "\r"
+			case 'n':
+				c.value += "\n"
 			case 'f':
 				c.value += "\f"
 			case 't':
@@ -295,19 +318,26 @@ func (this *tokenStream) consumeString(char byte) *token {
 				c.value += "\v"
 
 			default:
-				c.value += string(nc)
+				c.value += byteString(nc)
 			}
 		} else {
-			c.value += string(nc)
+			c.value += byteString(nc)
 		}
 
 	}
-	if !this.stream.eof() {
-		this.stream.next() // consume ending "
+	if this.stream.eof() {
+		panic("unterminated string literal")
 	}
+	this.stream.next() // consume ending "
 	return c
 }
 
+// Returns b as a string of that one byte. string(b) would be the character
+// b is the code point of, which differs for the bytes of UTF-8 sequences.
+func byteString(b byte) string {
+	return string([]byte{b})
+}
+
 func isIdentifier(c byte, isFirstChar bool) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c == '_') || (!isFirstChar && c >= '0' && c <= '9')
 }
@@ -332,6 +362,10 @@ func classifyIdentifier(id string) (TokenType, bool) {
 		return CATCH, false
 	case "finally":
 		return FINALLY, false
+	case "break":
+		return BREAK, false
+	case "continue":
+		return CONTINUE, false
 	case "return":
 		return RETURN, false
 	case "this":
@@ -510,7 +544,14 @@ func (this *tokenStream) consumeOperator(firstDigit byte) *token {
 		}
 	}
 	if c.tokenType == MULTIPLY {
-		if !this.stream.eof() && this.stream.peek() == '=' {
+		if !this.stream.eof() && this.stream.peek() == '*' {
+			this.stream.next()
+			c.tokenType = EXPONENT
+			if !this.stream.eof() && this.stream.peek() == '=' {
+				this.stream.next()
+				c.tokenType = EXPONENT_EQ
+			}
+		} else if !this.stream.eof() && this.stream.peek() == '=' {
 			this.stream.next()
 			c.tokenType = MULTIPLY_EQ
 		}
@@ -719,7 +760,10 @@ func (this *tokenStream) readNext() {
 
 	if c == '/' && (n == '/' || n == '*') {
 		this.current = this.consumeComment()
-		if this.ignoreComments {
+		if this.collectComments {
+			this.comments = append(this.comments, *this.current)
+		}
+		if this.ignoreComments || this.collectComments {
 			this.readNext() // recurse until we hit EOF or something not a comment
 		}
 		return
@@ -793,6 +837,9 @@ func (this *tokenStream) scanRegExp(eq bool) (tokenText string, patternFlags Reg
 	}
 
 	for { // this will terminate when we find the following /
+		if this.stream.eof() {
+			panic("Unterminated regular expression")
+		}
 		currChar := this.stream.peek()
 		switch currChar {
 		case '\\':
@@ -809,35 +856,24 @@ func (this *tokenStream) scanRegExp(eq bool) (tokenText string, patternFlags Reg
 			break
 
 		case '[':
-			tokenText += string(currChar)
-			currChar = this.stream.next()
-
-			for !this.stream.eof() && this.stream.peek() != '\n' {
+			// a class runs to the first unescaped ], and can hold a /
+			for {
+				if this.stream.eof() || this.stream.peek() == '\n' {
+					panic("Unterminated regular expression class")
+				}
+				currChar = this.stream.next()
+				tokenText += string(currChar)
 				if currChar == ']' {
 					break
-				} else if currChar == '\\' {
-					tokenText += string(currChar)
-					currChar = this.stream.next()
-
+				}
+				if currChar == '\\' {
 					if this.stream.eof() || this.stream.peek() == '\n' {
 						panic("Unterminated regular expression")
 					}
-
-					tokenText += string(currChar)
-					currChar = this.stream.next()
-				} else {
-					tokenText += string(currChar)
-					currChar = this.stream.next()
+					tokenText += byteString(this.stream.next())
 				}
 			}
 
-			if currChar != ']' {
-				panic("Unterminated regular expression class")
-			}
-
-			tokenText += string(currChar)
-			break
-
 		case '/': // terminating the regexp...
 			patternFlags = NoFlagsRegExp
 			currChar = this.stream.next() // skip /
@@ -865,7 +901,7 @@ func (this *tokenStream) scanRegExp(eq bool) (tokenText string, patternFlags Reg
 			if this.stream.eof() || this.stream.peek() == '\n' {
 				panic("Unterminated regular expression")
 			} else {
-				tokenText += string(currChar)
+				tokenText += byteString(currChar)
 				this.stream.next()
 			}
 		} // switch
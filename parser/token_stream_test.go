@@ -103,6 +103,15 @@ func TestSingleLineComments(t *testing.T) {
 				},
 			},
 		},
+		tokenStreamTest{
+			input: "// ♥",
+			output: []token{
+				token{
+					tokenType: COMMENT,
+					value:     " ♥",
+				},
+			},
+		},
 	}
 	runTokenStreamTests(t, tests)
 }
@@ -133,6 +142,15 @@ func TestMultiLineComments(t *testing.T) {
 				},
 			},
 		},
+		tokenStreamTest{
+			input: "/* ♥ */",
+			output: []token{
+				token{
+					tokenType: COMMENT,
+					value:     " ♥ ",
+				},
+			},
+		},
 	}
 	runTokenStreamTests(t, tests)
 }
@@ -232,6 +250,33 @@ func TestStringLiterals(t *testing.T) {
 				},
 			},
 		},
+		tokenStreamTest{
+			input: `"♥"`,
+			output: []token{
+				token{
+					tokenType: STRING_LITERAL,
+					value:     "♥",
+				},
+			},
+		},
+		tokenStreamTest{
+			input: `"a\nb\rc"`,
+			output: []token{
+				token{
+					tokenType: STRING_LITERAL,
+					value:     "a\nb\rc",
+				},
+			},
+		},
+		tokenStreamTest{
+			input: `"\é"`,
+			output: []token{
+				token{
+					tokenType: STRING_LITERAL,
+					value:     "é",
+				},
+			},
+		},
 	}
 	runTokenStreamTests(t, tests)
 }
@@ -478,6 +523,22 @@ func TestOperators(t *testing.T) {
 				},
 			},
 		},
+		tokenStreamTest{
+			input: "**",
+			output: []token{
+				token{
+					tokenType: EXPONENT,
+				},
+			},
+		},
+		tokenStreamTest{
+			input: "**=",
+			output: []token{
+				token{
+					tokenType: EXPONENT_EQ,
+				},
+			},
+		},
 		tokenStreamTest{
 			input: "/",
 			output: []token{
@@ -4,9 +4,9 @@ package parser
 
 import "strconv"
 
-const _TokenType_name = "EOFCOMMENTSTRING_LITERALNUMERIC_LITERALIDENTIFIERASSIGNMENTPLUS_EQMINUS_EQMULTIPLY_EQDIVIDE_EQMODULUS_EQLEFT_SHIFT_EQRIGHT_SHIFT_EQUNSIGNED_RIGHT_SHIFT_EQAND_EQXOR_EQOR_EQPLUSINCREMENTMINUSDECREMENTMULTIPLYDIVIDEMODULUSEQUALSSTRICT_EQUALSBITWISE_ANDLOGICAL_ANDBITWISE_ORLOGICAL_ORLESS_THANLESS_EQLEFT_SHIFTGREATER_THANGREATER_EQRIGHT_SHIFTUNSIGNED_RIGHT_SHIFTBITWISE_XORINSTANCEOFINNEWCONDITIONALLOGICAL_NOTNOT_EQUALSSTRICT_NOT_EQUALSBITWISE_NOTDELETETYPEOFVOIDDOTCOMMACOLONSEMICOLONLPARENRPARENLBRACKETRBRACKETLBRACERBRACETHISNULLTRUEFALSEVARRETURNFUNCTIONDOWHILEFORGETSETIFELSESWITCHCASEDEFAULTTHROWTRYCATCHFINALLY"
+const _TokenType_name = "EOFCOMMENTSTRING_LITERALNUMERIC_LITERALIDENTIFIERASSIGNMENTPLUS_EQMINUS_EQMULTIPLY_EQDIVIDE_EQMODULUS_EQEXPONENT_EQLEFT_SHIFT_EQRIGHT_SHIFT_EQUNSIGNED_RIGHT_SHIFT_EQAND_EQXOR_EQOR_EQPLUSINCREMENTMINUSDECREMENTMULTIPLYDIVIDEMODULUSEXPONENTEQUALSSTRICT_EQUALSBITWISE_ANDLOGICAL_ANDBITWISE_ORLOGICAL_ORLESS_THANLESS_EQLEFT_SHIFTGREATER_THANGREATER_EQRIGHT_SHIFTUNSIGNED_RIGHT_SHIFTBITWISE_XORINSTANCEOFINNEWCONDITIONALLOGICAL_NOTNOT_EQUALSSTRICT_NOT_EQUALSBITWISE_NOTDELETETYPEOFVOIDDOTCOMMACOLONSEMICOLONLPARENRPARENLBRACKETRBRACKETLBRACERBRACETHISNULLTRUEFALSEVARRETURNFUNCTIONDOWHILEFORGETSETIFELSESWITCHCASEDEFAULTTHROWTRYCATCHFINALLYBREAKCONTINUEREGEXP_LITERALILLEGAL"
 
-var _TokenType_index = [...]uint16{0, 3, 10, 24, 39, 49, 59, 66, 74, 85, 94, 104, 117, 131, 154, 160, 166, 171, 175, 184, 189, 198, 206, 212, 219, 225, 238, 249, 260, 270, 280, 289, 296, 306, 318, 328, 339, 359, 370, 380, 382, 385, 396, 407, 417, 434, 445, 451, 457, 461, 464, 469, 474, 483, 489, 495, 503, 511, 517, 523, 527, 531, 535, 540, 543, 549, 557, 559, 564, 567, 570, 573, 575, 579, 585, 589, 596, 601, 604, 609, 616}
+var _TokenType_index = [...]uint16{0, 3, 10, 24, 39, 49, 59, 66, 74, 85, 94, 104, 115, 128, 142, 165, 171, 177, 182, 186, 195, 200, 209, 217, 223, 230, 238, 244, 257, 268, 279, 289, 299, 308, 315, 325, 337, 347, 358, 378, 389, 399, 401, 404, 415, 426, 436, 453, 464, 470, 476, 480, 483, 488, 493, 502, 508, 514, 522, 530, 536, 542, 546, 550, 554, 559, 562, 568, 576, 578, 583, 586, 589, 592, 594, 598, 604, 608, 615, 620, 623, 628, 635, 640, 648, 662, 669}
 
 func (i TokenType) String() string {
 	if i < 0 || i >= TokenType(len(_TokenType_index)-1) {
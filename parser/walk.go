@@ -0,0 +1,364 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"fmt"
+)
+
+// A Visitor's Visit is called by Walk for each node. If it returns a visitor
+// w, Walk visits each of the node's children with w, then calls
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk visits the tree under node in source order, as go/ast.Walk does: it
+// starts by calling v.Visit(node). Children that are missing, like an if's
+// absent else, aren't visited.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	walkList := func(nodes []Node) {
+		for _, n := range nodes {
+			if n != nil {
+				Walk(v, n)
+			}
+		}
+	}
+	walk := func(n Node) {
+		if n != nil {
+			Walk(v, n)
+		}
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkList(n.body)
+	case *ExpressionStatement:
+		walk(n.X)
+	case *NewExpression:
+		walk(n.X)
+	case *DotMemberExpression:
+		walk(n.X)
+		Walk(v, n.Name)
+	case *BracketMemberExpression:
+		walk(n.X)
+		walk(n.Y)
+	case *UnaryExpression:
+		walk(n.X)
+	case *AssignmentExpression:
+		walk(n.Left)
+		walk(n.Right)
+	case *BinaryExpression:
+		walk(n.Left)
+		walk(n.Right)
+	case *ConditionalExpression:
+		walk(n.X)
+		walk(n.Then)
+		walk(n.Else)
+	case *FunctionExpression:
+		if n.Identifier != nil {
+			Walk(v, n.Identifier)
+		}
+		for _, p := range n.Parameters {
+			Walk(v, p)
+		}
+		Walk(v, n.Body)
+	case *CallExpression:
+		walk(n.X)
+		walkList(n.Arguments)
+	case *SequenceExpression:
+		walk(n.X)
+		walk(n.Y)
+	case *ArrayLiteral:
+		walkList(n.Elements)
+	case *ObjectLiteral:
+		for _, p := range n.Properties {
+			walk(p.Key)
+			walk(p.X)
+		}
+	case *IfStatement:
+		walk(n.ConditionExpr)
+		walk(n.ThenStmt)
+		walk(n.ElseStmt)
+	case *ReturnStatement:
+		walk(n.X)
+	case *BlockStatement:
+		walkList(n.Body)
+	case *CaseStatement:
+		walk(n.X)
+		walkList(n.Body)
+	case *SwitchStatement:
+		walk(n.X)
+		for _, c := range n.Cases {
+			Walk(v, c)
+		}
+	case *VariableStatement:
+		for i, id := range n.Vars {
+			Walk(v, id)
+			walk(n.Initializers[i])
+		}
+	case *DoWhileStatement:
+		walk(n.Body)
+		walk(n.X)
+	case *WhileStatement:
+		walk(n.X)
+		walk(n.Body)
+	case *ForStatement:
+		walk(n.Initializer)
+		walk(n.Test)
+		walk(n.Update)
+		walk(n.Body)
+	case *ForInStatement:
+		walk(n.X)
+		walk(n.Y)
+		walk(n.Body)
+	case *ThrowStatement:
+		walk(n.X)
+	case *TryStatement:
+		Walk(v, n.Body)
+		if n.Catch != nil {
+			Walk(v, n.Catch)
+		}
+		if n.Finally != nil {
+			Walk(v, n.Finally)
+		}
+	case *CatchStatement:
+		Walk(v, n.Identifier)
+		Walk(v, n.Body)
+	case *FinallyStatement:
+		Walk(v, n.Body)
+	case *NumericLiteral, *StringLiteral, *IdentifierLiteral, *TrueLiteral, *FalseLiteral,
+		*NullLiteral, *ThisLiteral, *RegExpLiteral, *EmptyStatement, *BreakStatement, *ContinueStatement:
+		// nothing inside
+	default:
+		panic(fmt.Sprintf("Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect walks the tree under node, calling f for each node, and then for
+// nil after a node's children. Returning false from f skips a node's
+// children.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite changes the tree under node, bottom up: f is called with each node
+// after its children have been rewritten, and what it returns takes the
+// node's place. Returning the node itself leaves it be. Returning nil removes
+// a statement from the body it is in, and elsewhere leaves nothing, like an
+// if without an else.
+//
+// Where the tree needs a particular type of node, like a function's body or a
+// member's name, f must return that type, or Rewrite panics.
+//
+// Rewrite returns what f returned for node, so a whole tree can be replaced.
+func Rewrite(node Node, f func(Node) Node) Node {
+	if node == nil {
+		return nil
+	}
+
+	rewriteList := func(nodes []Node, statements bool) []Node {
+		ret := nodes[:0]
+		for _, n := range nodes {
+			if n == nil {
+				ret = append(ret, nil) // an array hole
+				continue
+			}
+			n = Rewrite(n, f)
+			if n == nil && statements {
+				continue
+			}
+			ret = append(ret, n)
+		}
+		return ret
+	}
+	rewrite := func(n Node) Node {
+		return Rewrite(n, f)
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		n.body = rewriteList(n.body, true)
+	case *ExpressionStatement:
+		n.X = rewrite(n.X)
+	case *NewExpression:
+		n.X = rewrite(n.X)
+	case *DotMemberExpression:
+		n.X = rewrite(n.X)
+		n.Name = rewriteIdentifier(n.Name, f)
+	case *BracketMemberExpression:
+		n.X = rewrite(n.X)
+		n.Y = rewrite(n.Y)
+	case *UnaryExpression:
+		n.X = rewrite(n.X)
+	case *AssignmentExpression:
+		n.Left = rewrite(n.Left)
+		n.Right = rewrite(n.Right)
+	case *BinaryExpression:
+		n.Left = rewrite(n.Left)
+		n.Right = rewrite(n.Right)
+	case *ConditionalExpression:
+		n.X = rewrite(n.X)
+		n.Then = rewrite(n.Then)
+		n.Else = rewrite(n.Else)
+	case *FunctionExpression:
+		n.Identifier = rewriteIdentifier(n.Identifier, f)
+		for i, p := range n.Parameters {
+			n.Parameters[i] = rewriteIdentifier(p, f)
+		}
+		n.Body = rewriteBlock(n.Body, f)
+	case *CallExpression:
+		n.X = rewrite(n.X)
+		n.Arguments = rewriteList(n.Arguments, false)
+	case *SequenceExpression:
+		n.X = rewrite(n.X)
+		n.Y = rewrite(n.Y)
+	case *ArrayLiteral:
+		n.Elements = rewriteList(n.Elements, false)
+	case *ObjectLiteral:
+		for i := range n.Properties {
+			n.Properties[i].Key = rewrite(n.Properties[i].Key)
+			n.Properties[i].X = rewrite(n.Properties[i].X)
+		}
+	case *IfStatement:
+		n.ConditionExpr = rewrite(n.ConditionExpr)
+		n.ThenStmt = rewrite(n.ThenStmt)
+		n.ElseStmt = rewrite(n.ElseStmt)
+	case *ReturnStatement:
+		n.X = rewrite(n.X)
+	case *BlockStatement:
+		n.Body = rewriteList(n.Body, true)
+	case *CaseStatement:
+		n.X = rewrite(n.X)
+		n.Body = rewriteList(n.Body, true)
+	case *SwitchStatement:
+		n.X = rewrite(n.X)
+		cases := n.Cases[:0]
+		for _, c := range n.Cases {
+			if r := rewrite(c); r != nil {
+				c, ok := r.(*CaseStatement)
+				if !ok {
+					badRewrite("a switch case", r)
+				}
+				cases = append(cases, c)
+			}
+		}
+		n.Cases = cases
+	case *VariableStatement:
+		for i := range n.Vars {
+			n.Vars[i] = rewriteIdentifier(n.Vars[i], f)
+			n.Initializers[i] = rewrite(n.Initializers[i])
+		}
+	case *DoWhileStatement:
+		n.Body = rewrite(n.Body)
+		n.X = rewrite(n.X)
+	case *WhileStatement:
+		n.X = rewrite(n.X)
+		n.Body = rewrite(n.Body)
+	case *ForStatement:
+		n.Initializer = rewrite(n.Initializer)
+		n.Test = rewrite(n.Test)
+		n.Update = rewrite(n.Update)
+		n.Body = rewrite(n.Body)
+	case *ForInStatement:
+		n.X = rewrite(n.X)
+		n.Y = rewrite(n.Y)
+		n.Body = rewrite(n.Body)
+	case *ThrowStatement:
+		n.X = rewrite(n.X)
+	case *TryStatement:
+		n.Body = rewrite(n.Body)
+		if n.Catch != nil {
+			r := rewrite(n.Catch)
+			c, ok := r.(*CatchStatement)
+			if r != nil && !ok {
+				badRewrite("a catch", r)
+			}
+			n.Catch = c
+		}
+		if n.Finally != nil {
+			r := rewrite(n.Finally)
+			fs, ok := r.(*FinallyStatement)
+			if r != nil && !ok {
+				badRewrite("a finally", r)
+			}
+			n.Finally = fs
+		}
+	case *CatchStatement:
+		n.Identifier = rewriteIdentifier(n.Identifier, f)
+		n.Body = rewrite(n.Body)
+	case *FinallyStatement:
+		n.Body = rewrite(n.Body)
+	case *NumericLiteral, *StringLiteral, *IdentifierLiteral, *TrueLiteral, *FalseLiteral,
+		*NullLiteral, *ThisLiteral, *RegExpLiteral, *EmptyStatement, *BreakStatement, *ContinueStatement:
+		// nothing inside
+	default:
+		panic(fmt.Sprintf("Rewrite: unexpected node type %T", n))
+	}
+
+	return f(node)
+}
+
+func badRewrite(what string, r Node) {
+	panic(fmt.Sprintf("Rewrite: %s can't be replaced with %T", what, r))
+}
+
+func rewriteIdentifier(n *IdentifierLiteral, f func(Node) Node) *IdentifierLiteral {
+	if n == nil {
+		return nil
+	}
+	r := Rewrite(n, f)
+	id, ok := r.(*IdentifierLiteral)
+	if !ok {
+		badRewrite("an identifier", r)
+	}
+	return id
+}
+
+func rewriteBlock(n *BlockStatement, f func(Node) Node) *BlockStatement {
+	r := Rewrite(n, f)
+	b, ok := r.(*BlockStatement)
+	if !ok {
+		badRewrite("a block", r)
+	}
+	return b
+}
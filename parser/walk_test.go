@@ -0,0 +1,125 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stvp/assert"
+)
+
+func TestInspect(t *testing.T) {
+	ast := Parse("var a = b + c[d]; function f(x) { return {k: x.y} }", true)
+
+	names := []string{}
+	Inspect(ast, func(n Node) bool {
+		if id, ok := n.(*IdentifierLiteral); ok {
+			names = append(names, id.tok.value)
+		}
+		return true
+	})
+	assert.Equal(t, names, []string{"a", "b", "c", "d", "f", "x", "k", "x", "y"})
+
+	// skipping functions
+	names = []string{}
+	Inspect(ast, func(n Node) bool {
+		if id, ok := n.(*IdentifierLiteral); ok {
+			names = append(names, id.tok.value)
+		}
+		_, isFunction := n.(*FunctionExpression)
+		return !isFunction
+	})
+	assert.Equal(t, names, []string{"a", "b", "c", "d"})
+}
+
+type depthVisitor struct {
+	depth    int
+	maxDepth *int
+	types    *[]string
+}
+
+func (this depthVisitor) Visit(n Node) Visitor {
+	if n == nil {
+		*this.types = append(*this.types, "end")
+		return nil
+	}
+	*this.types = append(*this.types, fmt.Sprintf("%T", n))
+	if this.depth > *this.maxDepth {
+		*this.maxDepth = this.depth
+	}
+	return depthVisitor{this.depth + 1, this.maxDepth, this.types}
+}
+
+func TestWalk(t *testing.T) {
+	maxDepth, types := 0, []string{}
+	Walk(depthVisitor{0, &maxDepth, &types}, Parse("if (a) b()", true))
+	assert.Equal(t, maxDepth, 4)
+	assert.Equal(t, strings.Join(types, " "), "*parser.Program *parser.IfStatement *parser.IdentifierLiteral end "+
+		"*parser.ExpressionStatement *parser.CallExpression *parser.IdentifierLiteral end end end end end")
+}
+
+func TestRewrite(t *testing.T) {
+	ast := Parse("console.log(1); var a = 2 * 3; if (a) { console.log(a) } else console.log(0)", true)
+
+	// fold constants, and drop logging
+	ast = Rewrite(ast, func(n Node) Node {
+		switch n := n.(type) {
+		case *BinaryExpression:
+			l, lok := n.Left.(*NumericLiteral)
+			r, rok := n.Right.(*NumericLiteral)
+			if lok && rok && n.tok.tokenType == MULTIPLY {
+				return NewNumericLiteral(l.Float64Value() * r.Float64Value())
+			}
+		case *IdentifierLiteral:
+			if n.String() == "a" {
+				return NewIdentifierLiteral("b")
+			}
+		case *ExpressionStatement:
+			if call, ok := n.X.(*CallExpression); ok {
+				if m, ok := call.X.(*DotMemberExpression); ok && m.Name.tok.value == "log" {
+					return nil
+				}
+			}
+		}
+		return n
+	})
+	assert.Equal(t, RecursivelyPrint(ast), "program:\nvar b = 6If b then {:\n}\n")
+}
+
+func TestRewriteWrongType(t *testing.T) {
+	defer func() {
+		assert.Equal(t, recover(), "Rewrite: a block can't be replaced with *parser.EmptyStatement")
+	}()
+	Rewrite(Parse("function f() { }", true), func(n Node) Node {
+		if _, ok := n.(*BlockStatement); ok {
+			return &EmptyStatement{}
+		}
+		return n
+	})
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/CrimsonAS/v2/parser"
+	"github.com/CrimsonAS/v2/vm"
+	"io"
+	"strings"
+)
+
+// the stages of compiling a script that --dump can show, in order.
+var dumpStages = []string{"tokens", "ast", "ast-json", "estree", "tac", "tac-opt", "bytecode"}
+
+// checkDumpStages returns an error if stages, as given to --dump, names any
+// that there aren't.
+func checkDumpStages(stages string) error {
+	for _, stage := range strings.Split(stages, ",") {
+		known := false
+		for _, s := range dumpStages {
+			known = known || s == stage
+		}
+		if !known {
+			return fmt.Errorf("can't dump %q: choose from %s", stage, strings.Join(dumpStages, ", "))
+		}
+	}
+	return nil
+}
+
+// dump writes out each of the stages of compiling code, which is source
+// unless compiled is given.
+func dump(w io.Writer, stages string, code string, name string, compiled *vm.Program) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", name, r)
+		}
+	}()
+
+	for _, stage := range strings.Split(stages, ",") {
+		if compiled != nil && stage != "bytecode" {
+			return fmt.Errorf("%s: can't dump %s of a compiled script", name, stage)
+		}
+		switch stage {
+		case "tokens":
+			io.WriteString(w, parser.DumpTokens(code))
+		case "ast":
+			io.WriteString(w, parser.DumpAST(parser.Parse(code, true)))
+		case "ast-json":
+			w.Write(parser.DumpASTJSON(parser.Parse(code, true)))
+		case "estree":
+			ast, locations := parser.ParseWithLocations(code, true)
+			tree, err := parser.MarshalESTree(ast, code, locations)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			w.Write(append(tree, '\n'))
+		case "tac", "tac-opt":
+			tac, err := vm.DumpTAC(code, stage == "tac-opt")
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			io.WriteString(w, tac)
+		case "bytecode":
+			p := compiled
+			if p == nil {
+				if p, err = vm.Compile(code, name); err != nil {
+					return err
+				}
+			}
+			p.Disassemble(w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"github.com/CrimsonAS/v2/vm"
+	"github.com/stvp/assert"
+	"strings"
+	"testing"
+)
+
+func TestCheckDumpStages(t *testing.T) {
+	assert.Nil(t, checkDumpStages("ast"))
+	assert.Nil(t, checkDumpStages("tokens,tac-opt,bytecode"))
+	assert.NotNil(t, checkDumpStages("ast,opcodes"))
+	assert.NotNil(t, checkDumpStages(""))
+}
+
+func TestDump(t *testing.T) {
+	var out bytes.Buffer
+	assert.Nil(t, dump(&out, "tokens,ast,estree,tac,bytecode", "x = 1", "test.js", nil))
+	for _, s := range []string{"IDENTIFIER", "AssignmentExpression", `"range": [`, "function(%main)", "String table:"} {
+		assert.True(t, strings.Contains(out.String(), s), s)
+	}
+
+	p, err := vm.Compile("x = 1", "test.js")
+	assert.Nil(t, err)
+	out.Reset()
+	assert.Nil(t, dump(&out, "bytecode", "", "test.v2c", p))
+	assert.True(t, strings.Contains(out.String(), "STORE_GLOBAL"))
+	assert.NotNil(t, dump(&out, "ast", "", "test.v2c", p))
+
+	assert.NotNil(t, dump(&out, "ast", "1 }", "broken.js", nil))
+}
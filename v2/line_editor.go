@@ -0,0 +1,191 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errCanceled is what reading a line returns when Ctrl+C is pressed.
+var errCanceled = errors.New("canceled")
+
+// keys that have no character of their own
+const (
+	keyNone rune = -1 - iota
+	keyDelete
+)
+
+func ctrl(c rune) rune {
+	return c & 0x1f
+}
+
+// A lineEditor reads lines from a terminal in raw mode (see makeRaw), doing
+// the echoing and editing itself. Up and Down go back and forth through the
+// history; an entry of more than one line comes back as one.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// history returns the entries to go through, oldest first
+	history func() []string
+}
+
+// readLine shows prompt, and reads a line. Ctrl+D on an empty line gives
+// io.EOF, and Ctrl+C gives errCanceled.
+func (this *lineEditor) readLine(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	history := this.history()
+	entry := len(history) // being shown, or len(history) for the line being entered
+	entered := ""         // the line being entered, while an entry is shown
+
+	show := func(idx int) {
+		if entry == len(history) {
+			entered = string(line)
+		}
+		entry = idx
+		if idx == len(history) {
+			line = []rune(entered)
+		} else {
+			line = []rune(strings.Replace(history[idx], "\n", " ", -1))
+		}
+		pos = len(line)
+	}
+
+	fmt.Fprint(this.out, prompt)
+	for {
+		key, err := this.readKey()
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case '\r', '\n':
+			fmt.Fprint(this.out, "\r\n")
+			return string(line), nil
+		case ctrl('C'):
+			fmt.Fprint(this.out, "^C")
+			return "", errCanceled
+		case ctrl('D'):
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+			fallthrough
+		case keyDelete:
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case 0x7f, ctrl('H'):
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case ctrl('A'):
+			pos = 0
+		case ctrl('E'):
+			pos = len(line)
+		case ctrl('B'):
+			if pos > 0 {
+				pos--
+			}
+		case ctrl('F'):
+			if pos < len(line) {
+				pos++
+			}
+		case ctrl('K'):
+			line = line[:pos]
+		case ctrl('U'):
+			line = line[pos:]
+			pos = 0
+		case ctrl('P'):
+			if entry > 0 {
+				show(entry - 1)
+			}
+		case ctrl('N'):
+			if entry < len(history) {
+				show(entry + 1)
+			}
+		default:
+			if key < ' ' {
+				continue
+			}
+			line = append(line, 0)
+			copy(line[pos+1:], line[pos:])
+			line[pos] = key
+			pos++
+		}
+
+		fmt.Fprintf(this.out, "\r%s%s\x1b[K", prompt, string(line))
+		if n := len(line) - pos; n > 0 {
+			fmt.Fprintf(this.out, "\x1b[%dD", n)
+		}
+	}
+}
+
+// readKey reads a key press, turning the escape sequences that the arrow, Home,
+// End and Delete keys send into the control keys that do the same.
+func (this *lineEditor) readKey() (rune, error) {
+	r, _, err := this.in.ReadRune()
+	if err != nil || r != 0x1b {
+		return r, err
+	}
+	if r, _, err = this.in.ReadRune(); err != nil {
+		return r, err
+	}
+	if r != '[' && r != 'O' {
+		return keyNone, nil
+	}
+	seq := ""
+	for {
+		if r, _, err = this.in.ReadRune(); err != nil {
+			return r, err
+		}
+		if (r < '0' || r > '9') && r != ';' {
+			break
+		}
+		seq += string(r)
+	}
+	switch seq + string(r) {
+	case "A":
+		return ctrl('P'), nil
+	case "B":
+		return ctrl('N'), nil
+	case "C":
+		return ctrl('F'), nil
+	case "D":
+		return ctrl('B'), nil
+	case "H", "1~", "7~":
+		return ctrl('A'), nil
+	case "F", "4~", "8~":
+		return ctrl('E'), nil
+	case "3~":
+		return keyDelete, nil
+	}
+	return keyNone, nil
+}
@@ -35,12 +35,105 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+const usage = `Usage: v2 [flags] [script.js | -e code | -] [arguments...]
+       v2 compile [-o output] script.js
+
+Runs a script, compiled or not, from a file, from -e, or from stdin (-),
+and with none of those, starts a REPL. The arguments after the script are
+the script's, in process.argv. v2 exits with the code given to exit(), 1 if
+the script threw an error it didn't catch or was stopped, or 0.
+
+Flags:
+`
+
+// fatal reports an error that stops v2 from running anything.
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "v2: %s\n", err)
+	os.Exit(1)
+}
+
+// compile writes a script out compiled, so that running it later skips
+// parsing and compiling it.
+func compile(args []string) {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	out := flags.String("o", "", "where to write the compiled script (default: the script's name, ending in .v2c)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: v2 compile [-o output] script.js\n")
+		os.Exit(2)
+	}
+	f := flags.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(f, filepath.Ext(f)) + ".v2c"
+	}
+
+	code, err := ioutil.ReadFile(f)
+	if err != nil {
+		fatal(err)
+	}
+	p, err := vm.CompileScript(string(code), f)
+	if err != nil {
+		fatal(err)
+	}
+	data, err := p.MarshalBinary()
+	if err != nil {
+		fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+// exitStatus reports how a run ended, and returns what v2 should exit with.
+func exitStatus(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *vm.ExitError:
+		return e.Code
+	case *vm.Exception:
+		fmt.Fprintf(os.Stderr, "Uncaught %s\n", e.Message)
+		for _, frame := range e.Stack {
+			fmt.Fprintf(os.Stderr, "    at %s\n", frame)
+		}
+		return 1
+	default:
+		fmt.Fprintf(os.Stderr, "v2: %s\n", err)
+		return 1
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compile" {
+		compile(os.Args[2:])
+		return
+	}
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	eval := flag.String("e", "", "run `code` given here, rather than a script")
+	printResult := flag.Bool("p", false, "print the value of the script's last statement (or what it returned)")
 	profile := flag.Bool("profile", false, "enable profiling")
 	showBytecode := flag.Bool("show-bytecode", false, "show bytecode after code generation")
+	dumps := flag.String("dump", "", "print these `stages` of compiling the script, separated by commas, instead of running it: "+strings.Join(dumpStages, ", "))
+	timeout := flag.Duration("timeout", 0, "stop the script if it runs for longer than this")
+	maxSteps := flag.Int64("max-steps", 0, "stop the script if it runs more than this many opcodes")
+	maxMemory := flag.Int64("max-memory", 0, "stop the script if it uses more than this many bytes")
 	flag.Parse()
+	if *dumps != "" {
+		if err := checkDumpStages(*dumps); err != nil {
+			fmt.Fprintf(os.Stderr, "v2: %s\n", err)
+			os.Exit(2)
+		}
+	}
 
 	if *profile {
 		log.Printf("Enabling profiling")
@@ -49,21 +142,74 @@ func main() {
 		}()
 	}
 
-	f := ""
-	if flag.NArg() > 0 {
-		f = flag.Args()[0]
-		log.Printf("Running %s", f)
-	} else {
-		fmt.Printf("Need a file to run\n")
-		os.Exit(0)
+	// where the script comes from, and what it's called in errors
+	var code []byte
+	var err error
+	name := ""
+	args := flag.Args()
+	isSet := func(name string) (set bool) {
+		flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+		return
+	}
+	switch {
+	case isSet("e"):
+		code, name = []byte(*eval), "[eval]"
+	case len(args) == 0:
+		runREPL(*maxSteps, *maxMemory)
+		return
+	case args[0] == "-":
+		code, err = ioutil.ReadAll(os.Stdin)
+		name, args = "[stdin]", args[1:]
+	default:
+		name, args = args[0], args[1:]
+		code, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	compiled := vm.IsCompiledProgram(code)
+	if *dumps != "" {
+		var p *vm.Program
+		if compiled {
+			if p, err = vm.LoadProgram(code); err != nil {
+				fatal(err)
+			}
+		}
+		if err := dump(os.Stdout, *dumps, string(code), name, p); err != nil {
+			fatal(err)
+		}
+		return
 	}
-	code, _ := ioutil.ReadFile(f)
-	vm := vm.New(string(code))
 
+	// Scripts can be run either as source, or compiled by v2 compile.
+	var p *vm.Program
+	if compiled {
+		p, err = vm.LoadProgram(code)
+	} else {
+		p, err = vm.CompileScript(string(code), name)
+	}
+	if err != nil {
+		fatal(err)
+	}
 	if *showBytecode {
-		vm.DumpCode()
+		p.Disassemble(os.Stderr)
+	}
+
+	rt := vm.NewFromProgram(p)
+	rt.DefineProcess(append([]string{os.Args[0], name}, args...))
+
+	if *timeout > 0 {
+		rt.SetDeadline(time.Now().Add(*timeout))
 	}
+	rt.SetStepLimit(*maxSteps)
+	rt.SetMemoryLimit(*maxMemory)
 
-	ret := vm.Run()
-	log.Printf("Code returned %s", ret)
+	result, err := rt.Run()
+	if err != nil {
+		os.Exit(exitStatus(err))
+	}
+	if *printResult {
+		fmt.Println(rt.Inspect(result))
+	}
 }
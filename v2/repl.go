@@ -0,0 +1,444 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/CrimsonAS/v2/parser"
+	"github.com/CrimsonAS/v2/vm"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// the most entries the REPL's history keeps.
+const historySize = 1000
+
+const replHelp = `.ast [code]       Show the syntax tree of code, or of the last input
+.bytecode [code]  Show the bytecode of code, or of the last input
+.exit             Leave the REPL (as does Ctrl+D)
+.help             Show this
+.history          Show what was entered before
+.load file        Run a file, as if it had been typed in
+
+Ctrl+C stops the code that is running, or discards a partly entered input.
+Up and Down go back and forth through the history, which is kept in %s.`
+
+// A repl reads code a statement at a time, and runs each in the same vm.
+type repl struct {
+	out         io.Writer
+	interactive bool // whether to prompt, see isTerminal
+
+	// eval runs code, and returns its result formatted for printing
+	eval func(code string) (string, error)
+	// interrupt stops the code eval is running
+	interrupt func()
+	running   int32 // whether eval is running
+
+	lastInput   string
+	history     []string
+	historyFile string // or "", if history isn't saved
+}
+
+// runREPL starts a REPL on stdin and stdout, with each input limited to
+// maxSteps and maxMemory (see vm.SetStepLimit and vm.SetMemoryLimit).
+func runREPL(maxSteps int64, maxMemory int64) {
+	r := newVMREPL(maxSteps, maxMemory)
+	r.interactive = isTerminal(os.Stdin)
+	if home, err := os.UserHomeDir(); err == nil {
+		r.historyFile = filepath.Join(home, ".v2_repl_history")
+		r.loadHistory()
+	}
+	r.run(os.Stdin)
+}
+
+// newVMREPL returns a REPL that runs input in a vm, printing to stdout.
+func newVMREPL(maxSteps int64, maxMemory int64) *repl {
+	p, err := vm.Compile("", "repl")
+	if err != nil {
+		fatal(err)
+	}
+	rt := vm.NewFromProgram(p)
+	rt.DefineProcess([]string{os.Args[0]})
+	rt.SetStepLimit(maxSteps)
+	rt.SetMemoryLimit(maxMemory)
+
+	return &repl{
+		out: os.Stdout,
+		eval: func(code string) (string, error) {
+			// a Ctrl+C that came as the last input finished is for that
+			rt.ClearInterrupt()
+			ret, err := rt.Eval(code)
+			if e, ok := err.(*vm.ExitError); ok {
+				os.Exit(e.Code)
+			}
+			if err != nil {
+				return "", err
+			}
+			return rt.Inspect(ret), nil
+		},
+		interrupt: func() { rt.Interrupt("Ctrl+C") },
+	}
+}
+
+// run reads and runs input until it ends, or .exit.
+func (this *repl) run(in io.Reader) {
+	// Ctrl+C stops a running eval here; otherwise the loop below hears of it
+	interrupts := make(chan os.Signal, 1)
+	cancels := make(chan bool, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			if atomic.LoadInt32(&this.running) != 0 {
+				this.interrupt()
+			} else {
+				select {
+				case cancels <- true:
+				default:
+				}
+			}
+		}
+	}()
+	readLine := this.lineReader(in, cancels)
+
+	if this.interactive {
+		fmt.Fprintf(this.out, "Welcome to v2. Type \".help\" for more information.\n")
+	}
+	input := ""
+	for {
+		prompt := "> "
+		if input != "" {
+			prompt = "... "
+		}
+
+		line, err := readLine(prompt)
+		if err == errCanceled {
+			if input == "" {
+				fmt.Fprintf(this.out, "\n(To exit, press Ctrl+D or type .exit)\n")
+			} else {
+				fmt.Fprintf(this.out, "\n")
+			}
+			input = ""
+			continue
+		} else if err != nil {
+			if this.interactive {
+				fmt.Fprintf(this.out, "\n")
+			}
+			return
+		}
+
+		if input == "" && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			if !this.command(strings.TrimSpace(line)) {
+				return
+			}
+			continue
+		}
+
+		input += line + "\n"
+		if incomplete(input) {
+			continue
+		}
+		if strings.TrimSpace(input) != "" {
+			this.addHistory(strings.TrimSuffix(input, "\n"))
+			this.runInput(input)
+		}
+		input = ""
+	}
+}
+
+// lineReader returns what run reads lines with: a lineEditor, if in is a
+// terminal that can be put in raw mode, or else something that reads lines as
+// they come, prompting if the REPL is interactive. Either gives errCanceled
+// for Ctrl+C, which arrives on cancels if the terminal isn't in raw mode.
+func (this *repl) lineReader(in io.Reader, cancels chan bool) func(prompt string) (string, error) {
+	if f, ok := in.(*os.File); ok && this.interactive {
+		if restore, err := makeRaw(f); err == nil {
+			restore()
+			editor := &lineEditor{
+				in:      bufio.NewReader(f),
+				out:     this.out,
+				history: func() []string { return this.history },
+			}
+			return func(prompt string) (string, error) {
+				// only while reading, so Ctrl+C can still stop an eval
+				restore, err := makeRaw(f)
+				if err != nil {
+					return "", err
+				}
+				defer restore()
+				return editor.readLine(prompt)
+			}
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return func(prompt string) (string, error) {
+		if this.interactive {
+			fmt.Fprint(this.out, prompt)
+		}
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return "", io.EOF
+			}
+			return line, nil
+		case <-cancels:
+			return "", errCanceled
+		}
+	}
+}
+
+// runInput runs code, and prints what came of it.
+func (this *repl) runInput(code string) {
+	this.lastInput = code
+	atomic.StoreInt32(&this.running, 1)
+	result, err := this.eval(code)
+	atomic.StoreInt32(&this.running, 0)
+	if e, ok := err.(*vm.Exception); ok {
+		fmt.Fprintf(this.out, "Uncaught %s\n", e.Message)
+		for _, frame := range e.Stack {
+			fmt.Fprintf(this.out, "    at %s\n", frame)
+		}
+		return
+	} else if err != nil {
+		fmt.Fprintf(this.out, "%s\n", err)
+		return
+	}
+	fmt.Fprintf(this.out, "%s\n", result)
+}
+
+// command carries out a meta command, and returns false if the REPL should
+// stop.
+func (this *repl) command(line string) bool {
+	cmd, arg := line, ""
+	if idx := strings.IndexAny(line, " \t"); idx >= 0 {
+		cmd, arg = line[:idx], strings.TrimSpace(line[idx+1:])
+	}
+	switch cmd {
+	case ".exit":
+		return false
+	case ".help":
+		fmt.Fprintf(this.out, replHelp+"\n", this.historyFile)
+	case ".history":
+		for idx, entry := range this.history {
+			fmt.Fprintf(this.out, "%4d  %s\n", idx+1, strings.Replace(entry, "\n", "\n      ", -1))
+		}
+	case ".load":
+		if arg == "" {
+			fmt.Fprintf(this.out, "Usage: .load file\n")
+			break
+		}
+		code, err := ioutil.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintf(this.out, "%s\n", err)
+			break
+		}
+		this.runInput(string(code))
+	case ".ast":
+		if arg == "" {
+			arg = this.lastInput
+		}
+		this.showAST(arg)
+	case ".bytecode":
+		if arg == "" {
+			arg = this.lastInput
+		}
+		p, err := vm.Compile(arg, "repl")
+		if err != nil {
+			fmt.Fprintf(this.out, "%s\n", err)
+			break
+		}
+		p.Disassemble(this.out)
+	default:
+		fmt.Fprintf(this.out, "Unknown command %s. Type \".help\" for a list.\n", cmd)
+	}
+	return true
+}
+
+func (this *repl) showAST(code string) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(this.out, "SyntaxError: %v\n", r)
+		}
+	}()
+	fmt.Fprintf(this.out, "%s\n", parser.RecursivelyPrint(parser.Parse(code, true)))
+}
+
+// addHistory adds an entry to the history, and saves it.
+func (this *repl) addHistory(entry string) {
+	if n := len(this.history); n > 0 && this.history[n-1] == entry {
+		return
+	}
+	this.history = append(this.history, entry)
+	if len(this.history) > historySize {
+		this.history = this.history[len(this.history)-historySize:]
+	}
+	if this.historyFile == "" {
+		return
+	}
+	var data []string
+	for _, e := range this.history {
+		data = append(data, escapeHistory(e))
+	}
+	ioutil.WriteFile(this.historyFile, []byte(strings.Join(data, "\n")+"\n"), 0600)
+}
+
+func (this *repl) loadHistory() {
+	data, err := ioutil.ReadFile(this.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line != "" {
+			this.history = append(this.history, unescapeHistory(line))
+		}
+	}
+}
+
+// escapeHistory turns an entry, which may have newlines in it, into a line
+// of the history file.
+func escapeHistory(entry string) string {
+	return strings.Replace(strings.Replace(entry, `\`, `\\`, -1), "\n", `\n`, -1)
+}
+
+func unescapeHistory(line string) string {
+	var sb strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) {
+			i++
+			if line[i] == 'n' {
+				sb.WriteByte('\n')
+				continue
+			}
+		}
+		sb.WriteByte(line[i])
+	}
+	return sb.String()
+}
+
+// incomplete reports whether input stops partway through, inside brackets, a
+// string or a comment, so that the REPL should read more before running it.
+func incomplete(input string) bool {
+	depth := 0
+	for i := 0; i < len(input); i++ {
+		switch c := input[i]; c {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '\'', '"':
+			for i++; i < len(input) && input[i] != c; i++ {
+				if input[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(input) {
+				return true
+			}
+		case '/':
+			if strings.HasPrefix(input[i:], "//") {
+				for i < len(input) && input[i] != '\n' {
+					i++
+				}
+			} else if strings.HasPrefix(input[i:], "/*") {
+				end := strings.Index(input[i+2:], "*/")
+				if end < 0 {
+					return true
+				}
+				i += end + 3
+			} else if regExpAllowed(input[:i]) {
+				i = skipRegExp(input, i)
+			}
+		}
+	}
+	return depth > 0
+}
+
+// regExpAllowed reports whether a / after before starts a regular expression
+// literal, rather than being a division.
+func regExpAllowed(before string) bool {
+	before = strings.TrimRight(before, " \t\r\n")
+	if before == "" {
+		return true
+	}
+	switch c := before[len(before)-1]; {
+	case c == ')' || c == ']' || c == '\'' || c == '"':
+		return false
+	case c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		word := before[strings.LastIndexFunc(before, func(r rune) bool {
+			return !(r == '_' || r == '$' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+		})+1:]
+		switch word {
+		case "return", "typeof", "instanceof", "in", "new", "delete", "void", "case", "do", "else":
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// skipRegExp returns the index of the / that ends the regular expression
+// literal starting at input[start], or of the end of its line, if it has none.
+func skipRegExp(input string, start int) int {
+	inClass := false
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if !inClass {
+				return i
+			}
+		case '\n':
+			return i
+		}
+	}
+	return len(input)
+}
+
+// isTerminal reports whether f is a terminal, rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/CrimsonAS/v2/vm"
+	"github.com/stvp/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	for _, code := range []string{
+		"function f() {",
+		"f(1,",
+		"[1, [2]",
+		"'abc",
+		`"it\"s`,
+		"/* comment",
+		"if (x) {\n  y()\n",
+		"x = /'/.test(s) + (",
+	} {
+		assert.True(t, incomplete(code), code)
+	}
+	for _, code := range []string{
+		"",
+		"1 + 1",
+		"function f() { return '{' }",
+		"f('(', \")\")",
+		"// {",
+		"/* { */ x",
+		"'\\''",
+		"1 }",
+		"/'/.test(s)",
+		`x = /"[/]"/`,
+		"f(/\\/'/)",
+		"return /(/",
+		"a / b / 'c'",
+		"(a) / 2 / '('",
+	} {
+		assert.False(t, incomplete(code), code)
+	}
+}
+
+// runREPLWith runs input through a REPL whose eval echoes what it was given.
+func runREPLWith(input string) (string, []string) {
+	var out bytes.Buffer
+	var evaluated []string
+	r := &repl{out: &out, eval: func(code string) (string, error) {
+		evaluated = append(evaluated, code)
+		if strings.Contains(code, "throw") {
+			return "", &vm.Exception{Message: "Error: thrown", Stack: []string{"<main> (repl:1)"}}
+		}
+		return "ok", nil
+	}}
+	r.run(strings.NewReader(input))
+	return out.String(), evaluated
+}
+
+func TestREPL(t *testing.T) {
+	out, evaluated := runREPLWith("1\nfunction f() {\n  return 1\n}\n\nthrow\n2\n.history\n.exit\n3\n")
+	assert.Equal(t, evaluated, []string{"1\n", "function f() {\n  return 1\n}\n", "throw\n", "2\n"})
+	assert.Equal(t, out, strings.Join([]string{
+		"ok",
+		"ok",
+		"Uncaught Error: thrown",
+		"    at <main> (repl:1)",
+		"ok",
+		"   1  1",
+		"   2  function f() {",
+		"        return 1",
+		"      }",
+		"   3  throw",
+		"   4  2",
+		"",
+	}, "\n"))
+}
+
+// A Ctrl+C that comes as an eval finishes doesn't stop the next one.
+func TestREPLLateInterrupt(t *testing.T) {
+	var out bytes.Buffer
+	r := newVMREPL(0, 0)
+	r.out = &out
+	r.interrupt()
+	r.run(strings.NewReader("1 + 1\n"))
+	assert.Equal(t, out.String(), "2\n")
+}
+
+func TestHistoryEscaping(t *testing.T) {
+	for _, entry := range []string{"a", "a\nb", `"\n"`, `\`} {
+		assert.False(t, strings.Contains(escapeHistory(entry), "\n"))
+		assert.Equal(t, unescapeHistory(escapeHistory(entry)), entry)
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	history := []string{"1", "2\n3"}
+	for _, test := range []struct {
+		keys string
+		line string
+	}{
+		{"abc\r", "abc"},
+		{"abd\x1b[Dc\r", "abcd"},
+		{"abc\x7f\x7f\r", "a"},
+		{"abc\x01\x1b[3~\x05d\r", "bcd"},
+		{"abcd\x02\x02\x0b\r", "ab"},
+		{"abcd\x02\x15\r", "d"},
+		{"a\x1b[1;5Db\r", "ab"},
+		{"\x1b[A\r", "2 3"},
+		{"\x1b[A\x1b[A\x1b[A\r", "1"},
+		{"x\x1b[A\x1b[B\r", "x"},
+		{"x\x10\x10\x0ey\r", "2 3y"},
+		{"é\r", "é"},
+	} {
+		var out bytes.Buffer
+		editor := &lineEditor{
+			in:      bufio.NewReader(strings.NewReader(test.keys)),
+			out:     &out,
+			history: func() []string { return history },
+		}
+		line, err := editor.readLine("> ")
+		assert.Nil(t, err, test.keys)
+		assert.Equal(t, line, test.line, test.keys)
+		assert.True(t, strings.HasPrefix(out.String(), "> "), out.String())
+	}
+
+	for _, test := range []struct {
+		keys string
+		err  error
+	}{
+		{"ab\x03", errCanceled},
+		{"\x04", io.EOF},
+		{"ab", io.EOF},
+	} {
+		editor := &lineEditor{
+			in:      bufio.NewReader(strings.NewReader(test.keys)),
+			out:     &bytes.Buffer{},
+			history: func() []string { return nil },
+		}
+		_, err := editor.readLine("> ")
+		assert.Equal(t, err, test.err, test.keys)
+	}
+}
@@ -30,6 +30,10 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
 )
 
 type arrayObject struct {
@@ -37,8 +41,8 @@ type arrayObject struct {
 	primitiveData *valueArrayData
 }
 
-func (this *arrayObject) Prototype() *valueBasicObject {
-	return &arrayProto
+func (this *arrayObject) Prototype(vm *vm) *valueBasicObject {
+	return &vm.arrayProto
 }
 
 //////////////////////////////////////
@@ -57,7 +61,7 @@ func (this arrayObject) ToBoolean() bool {
 	return true
 }
 
-func (this arrayObject) ToString() valueString {
+func (this arrayObject) ToString() value {
 	return this.primitiveData.ToString()
 }
 
@@ -70,76 +74,251 @@ func (this arrayObject) hasPrimitiveBase() bool {
 }
 
 func (this arrayObject) String() string {
-	return string(this.primitiveData.ToString())
+	return this.primitiveData.ToString().String()
 }
 
 //////////////////////////////////////
 // object methods
 //////////////////////////////////////
 
+// ES5 15.4: an array index is the canonical name of a uint32 other than 2^32-1.
+func arrayIndex(prop value) (uint32, bool) {
+	switch prop.kind {
+	case kindNumber:
+		if prop.num >= 0 && prop.num < math.MaxUint32 && prop.num == math.Trunc(prop.num) {
+			return uint32(prop.num), true
+		}
+	case kindString:
+		n, err := strconv.ParseUint(prop.str, 10, 32)
+		if err == nil && n != math.MaxUint32 && strconv.FormatUint(n, 10) == prop.str {
+			return uint32(n), true
+		}
+	}
+	return 0, false
+}
+
+var lengthKey = newString("length")
+
+// ES5 15.4.5.1
 func (this arrayObject) defineOwnProperty(vm *vm, prop value, desc *propertyDescriptor, throw bool) bool {
-	return true
+	// ### attributes other than the value aren't kept for elements or length
+	if idx, ok := arrayIndex(prop); ok {
+		if desc.hasValue {
+			vm.setElement(this.primitiveData, idx, desc.value)
+		}
+		return true
+	}
+	if prop == lengthKey {
+		if desc.hasValue {
+			this.setLength(vm, desc.value)
+		}
+		return true
+	}
+	return this.valueBasicObject.defineOwnProperty(vm, prop, desc, throw)
 }
 
 func (this arrayObject) getOwnProperty(vm *vm, prop value) *propertyDescriptor {
-	return nil
+	if idx, ok := arrayIndex(prop); ok {
+		v, ok := this.primitiveData.Get(idx)
+		if !ok {
+			return nil
+		}
+		return &propertyDescriptor{name: prop.String(), value: v, hasValue: true, writable: true, hasWritable: true, enumerable: true, hasEnumerable: true, configurable: true, hasConfigurable: true}
+	}
+	if prop == lengthKey {
+		return &propertyDescriptor{name: "length", value: newNumber(float64(this.primitiveData.length)), hasValue: true, writable: true, hasWritable: true, hasEnumerable: true, hasConfigurable: true}
+	}
+	return this.valueBasicObject.getOwnProperty(vm, prop)
 }
 
 func (this arrayObject) hasInstance(vm *vm, instance value) bool {
-	log.Printf("arrayObject hasInstance: %+v STUB", instance)
+	if objectDebug {
+		log.Printf("arrayObject hasInstance: %+v STUB", instance)
+	}
 	return false
 }
 
 func (this arrayObject) put(vm *vm, prop value, v value, throw bool) {
-	if numIdx, ok := prop.(valueNumber); ok {
-		idx := numIdx.ToInteger()
-		if float64(idx) == float64(numIdx) {
-			this.primitiveData.Set(idx, v)
-		}
+	if idx, ok := arrayIndex(prop); ok {
+		vm.setElement(this.primitiveData, idx, v)
+		return
+	}
+	if prop == lengthKey {
+		this.setLength(vm, v)
+		return
 	}
 
 	this.valueBasicObject.put(vm, prop, v, throw)
 }
 
 func (this arrayObject) get(vm *vm, prop value) value {
-	// ### belongs in getOwnProperty perhaps?
-	if numIdx, ok := prop.(valueNumber); ok {
-		idx := numIdx.ToInteger()
-		if float64(idx) == float64(numIdx) && idx >= 0 && idx < len(this.primitiveData.values) {
-			return this.primitiveData.values[idx]
+	if idx, ok := arrayIndex(prop); ok {
+		if v, ok := this.primitiveData.Get(idx); ok {
+			return v
 		}
+		return vm.arrayProto.get(vm, prop)
+	}
+	if prop == lengthKey {
+		return newNumber(float64(this.primitiveData.length))
 	}
 
 	if this.valueBasicObject.getOwnProperty(vm, prop) != nil {
 		return this.valueBasicObject.get(vm, prop)
 	} else {
-		return arrayProto.get(vm, prop)
+		return vm.arrayProto.get(vm, prop)
 	}
 }
 
+// setLength sets the length of the array to v, removing any elements past it.
+func (this arrayObject) setLength(vm *vm, v value) {
+	n := v.ToNumber()
+	newLen := toUint32(n)
+	if float64(newLen) != n {
+		vm.ThrowRangeError("Invalid array length")
+	}
+	this.primitiveData.SetLength(newLen)
+}
+
 //////////////////////////////////////
 // array data
 //////////////////////////////////////
 
+// how many holes setting an element past the end of a dense array may leave
+// before the array is made sparse instead.
+const maxArrayHoles = 1024
+
+// arrayHole marks a missing element in a dense array. It never leaves the
+// array: reading it gives undefined, or whatever the prototype has instead.
+var arrayHole = value{kind: kindHole}
+
+// valueArrayData holds the elements of an array.
+//
+// An array starts out dense, with its elements in a slice, and holes marked
+// by arrayHole. Setting an element so far past the end that it would leave a
+// lot of holes makes it sparse instead, and from then on it keeps its
+// elements in a map. Either way, the length is kept apart from the elements,
+// as nothing needs to be stored for the holes at the end of an array.
+type valueArrayData struct {
+	dense  []value
+	sparse map[uint32]value // nil while the array is dense
+	length uint32
+}
+
 // Copying is necessary, otherwise we'll end up with stack data, which is bad
 func newArrayData(v []value) *valueArrayData {
-	ad := valueArrayData{values: make([]value, len(v))}
-	for idx, _ := range v {
-		ad.values[idx] = v[idx]
-	}
+	ad := valueArrayData{dense: make([]value, len(v)), length: uint32(len(v))}
+	copy(ad.dense, v)
 	return &ad
 }
 
-func (this valueArrayData) Get(idx int) value {
-	return this.values[idx]
+// Get returns the element at idx, and whether there is one.
+func (this *valueArrayData) Get(idx uint32) (value, bool) {
+	if this.sparse != nil {
+		v, ok := this.sparse[idx]
+		return v, ok
+	}
+	if idx < uint32(len(this.dense)) && this.dense[idx].kind != kindHole {
+		return this.dense[idx], true
+	}
+	return newUndefined(), false
+}
+
+// Set sets the element at idx, making the array longer if it needs to be.
+func (this *valueArrayData) Set(idx uint32, v value) {
+	if this.sparse != nil {
+		this.sparse[idx] = v
+	} else if n := uint32(len(this.dense)); idx < n {
+		this.dense[idx] = v
+	} else if idx-n <= maxArrayHoles {
+		for ; n < idx; n++ {
+			this.dense = append(this.dense, arrayHole)
+		}
+		this.dense = append(this.dense, v)
+	} else {
+		this.makeSparse()
+		this.sparse[idx] = v
+	}
+
+	if idx >= this.length {
+		this.length = idx + 1
+	}
 }
 
-func (this valueArrayData) Set(idx int, v value) {
-	this.values[idx] = v
+// Delete removes the element at idx, leaving a hole.
+func (this *valueArrayData) Delete(idx uint32) {
+	if this.sparse != nil {
+		delete(this.sparse, idx)
+	} else if idx < uint32(len(this.dense)) {
+		this.dense[idx] = arrayHole
+	}
 }
 
-type valueArrayData struct {
-	values []value
+// SetLength changes the length of the array, removing any elements past it.
+func (this *valueArrayData) SetLength(length uint32) {
+	if length < this.length {
+		if this.sparse != nil {
+			for idx := range this.sparse {
+				if idx >= length {
+					delete(this.sparse, idx)
+				}
+			}
+		} else if length < uint32(len(this.dense)) {
+			for idx := length; idx < uint32(len(this.dense)); idx++ {
+				this.dense[idx] = value{} // let the values be collected
+			}
+			this.dense = this.dense[:length]
+		}
+	}
+	this.length = length
+}
+
+// indices returns the indices of the elements from from up to to, in order.
+// Going through these, rather than every index up to the length, means
+// walking an array takes as long as it has elements, however long it is.
+func (this *valueArrayData) indices(from uint32, to uint32) []uint32 {
+	var idxs []uint32
+	if this.sparse != nil {
+		for idx := range this.sparse {
+			if idx >= from && idx < to {
+				idxs = append(idxs, idx)
+			}
+		}
+		sort.Slice(idxs, func(i, j int) bool { return idxs[i] < idxs[j] })
+		return idxs
+	}
+	if to > uint32(len(this.dense)) {
+		to = uint32(len(this.dense))
+	}
+	for idx := from; idx < to; idx++ {
+		if this.dense[idx].kind != kindHole {
+			idxs = append(idxs, idx)
+		}
+	}
+	return idxs
+}
+
+// moveElements moves the elements from from to the end of the array by
+// offset places, leaving holes where they were.
+func (this *valueArrayData) moveElements(vm *vm, from uint32, offset int64) {
+	idxs := this.indices(from, this.length)
+	values := make([]value, len(idxs))
+	for i, idx := range idxs {
+		values[i], _ = this.Get(idx)
+		this.Delete(idx)
+	}
+	for i, idx := range idxs {
+		vm.setElement(this, uint32(int64(idx)+offset), values[i])
+	}
+}
+
+func (this *valueArrayData) makeSparse() {
+	this.sparse = make(map[uint32]value)
+	for idx, v := range this.dense {
+		if v.kind != kindHole {
+			this.sparse[uint32(idx)] = v
+		}
+	}
+	this.dense = nil
 }
 
 func (this valueArrayData) ToInteger() int {
@@ -154,8 +333,19 @@ func (this valueArrayData) ToBoolean() bool {
 	panic("Should never happen")
 }
 
-func (this valueArrayData) ToString() valueString {
-	return newString(fmt.Sprintf("ARRAY[%s]", this.values))
+func (this valueArrayData) ToString() value {
+	if this.sparse != nil {
+		return newString(fmt.Sprintf("ARRAY(%d)%v", this.length, this.sparse))
+	}
+	elems := make([]string, len(this.dense))
+	for idx, v := range this.dense {
+		if v.kind == kindHole {
+			elems[idx] = "<hole>"
+		} else {
+			elems[idx] = v.String()
+		}
+	}
+	return newString(fmt.Sprintf("ARRAY(%d)[%s]", this.length, strings.Join(elems, " ")))
 }
 
 func (this valueArrayData) ToObject() valueObject {
@@ -176,39 +366,49 @@ func newArrayObject(s []value) valueObject {
 	return arrayObject{valueBasicObject: newBasicObject(), primitiveData: newArrayData(s)}
 }
 
-var arrayProto valueBasicObject
-
 func defineArrayCtor(vm *vm) value {
-	arrayProto = valueBasicObject{&rootObjectData{&valueBasicObjectData{extensible: true}}}
-	arrayProto.defineDefaultProperty(vm, "toString", newFunctionObject(array_prototype_toString, nil), 0)
-	arrayProto.defineDefaultProperty(vm, "concat", newFunctionObject(array_prototype_concat, nil), 1)
-	arrayProto.defineDefaultProperty(vm, "join", newFunctionObject(array_prototype_join, nil), 1)
-	arrayProto.defineDefaultProperty(vm, "pop", newFunctionObject(array_prototype_pop, nil), 0)
-	arrayProto.defineDefaultProperty(vm, "push", newFunctionObject(array_prototype_push, nil), 1)
-	arrayProto.defineDefaultProperty(vm, "reverse", newFunctionObject(array_prototype_reverse, nil), 1)
-	arrayProto.defineDefaultProperty(vm, "shift", newFunctionObject(array_prototype_shift, nil), 1)
-	arrayProto.defineDefaultProperty(vm, "slice", newFunctionObject(array_prototype_slice, nil), 2)
-	arrayProto.defineDefaultProperty(vm, "unshift", newFunctionObject(array_prototype_unshift, nil), 1)
-	arrayProto.defineDefaultProperty(vm, "indexOf", newFunctionObject(array_prototype_indexOf, nil), 1)
-	arrayProto.defineDefaultProperty(vm, "lastIndexOf", newFunctionObject(array_prototype_lastIndexOf, nil), 1)
+	vm.arrayProto = valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	vm.arrayProto.defineDefaultProperty(vm, "toString", objectValue(newFunctionObject(array_prototype_toString, nil)), 0)
+	vm.arrayProto.defineDefaultProperty(vm, "concat", objectValue(newFunctionObject(array_prototype_concat, nil)), 1)
+	vm.arrayProto.defineDefaultProperty(vm, "join", objectValue(newFunctionObject(array_prototype_join, nil)), 1)
+	vm.arrayProto.defineDefaultProperty(vm, "pop", objectValue(newFunctionObject(array_prototype_pop, nil)), 0)
+	vm.arrayProto.defineDefaultProperty(vm, "push", objectValue(newFunctionObject(array_prototype_push, nil)), 1)
+	vm.arrayProto.defineDefaultProperty(vm, "reverse", objectValue(newFunctionObject(array_prototype_reverse, nil)), 1)
+	vm.arrayProto.defineDefaultProperty(vm, "shift", objectValue(newFunctionObject(array_prototype_shift, nil)), 1)
+	vm.arrayProto.defineDefaultProperty(vm, "slice", objectValue(newFunctionObject(array_prototype_slice, nil)), 2)
+	vm.arrayProto.defineDefaultProperty(vm, "unshift", objectValue(newFunctionObject(array_prototype_unshift, nil)), 1)
+	vm.arrayProto.defineDefaultProperty(vm, "indexOf", objectValue(newFunctionObject(array_prototype_indexOf, nil)), 1)
+	vm.arrayProto.defineDefaultProperty(vm, "lastIndexOf", objectValue(newFunctionObject(array_prototype_lastIndexOf, nil)), 1)
 
 	arrayO := newFunctionObject(array_call, array_ctor)
-	arrayProto.defineDefaultProperty(vm, "constructor", arrayO, 0)
-	arrayO.defineDefaultProperty(vm, "isArray", newFunctionObject(array_isArray, nil), 0)
+	vm.arrayProto.defineDefaultProperty(vm, "constructor", objectValue(arrayO), 0)
+	arrayO.defineDefaultProperty(vm, "isArray", objectValue(newFunctionObject(array_isArray, nil)), 0)
 
-	return arrayO
+	return objectValue(arrayO)
 }
 
 func array_call(vm *vm, f value, args []value) value {
 	return array_ctor(vm, f, args)
 }
 
+// ES5 15.4.2
 func array_ctor(vm *vm, f value, args []value) value {
-	return newArrayObject(args)
+	if len(args) == 1 && args[0].isNumber() {
+		n := args[0].num
+		if float64(toUint32(n)) != n {
+			vm.ThrowRangeError("Invalid array length")
+		}
+		vm.allocate(objectSize)
+		ad := newArrayData(nil)
+		ad.length = uint32(n)
+		return objectValue(arrayObject{valueBasicObject: newBasicObject(), primitiveData: ad})
+	}
+	vm.allocate(objectSize + int64(len(args))*valueSize)
+	return objectValue(newArrayObject(args))
 }
 
 func array_isArray(vm *vm, f value, args []value) value {
-	switch f.(type) {
+	switch f.obj.(type) {
 	case arrayObject:
 		return newBool(true)
 	}
@@ -216,241 +416,251 @@ func array_isArray(vm *vm, f value, args []value) value {
 	return newBool(false)
 }
 
+// thisArrayData returns the elements of f, and throws a TypeError if f isn't
+// an array.
+func thisArrayData(vm *vm, f value) *valueArrayData {
+	a, ok := f.obj.(arrayObject)
+	if !ok {
+		vm.ThrowTypeError("this is not an Array")
+	}
+	return a.primitiveData
+}
+
 func array_prototype_toString(vm *vm, f value, args []value) value {
 	array := f.ToObject()
 	funcJ := array.get(vm, newString("join"))
-	switch typedJ := funcJ.(type) {
+	switch typedJ := funcJ.obj.(type) {
 	case functionObject:
-		return typedJ.call(vm, array, []value{newUndefined()})
+		return typedJ.call(vm, objectValue(array), []value{newUndefined()})
 	default:
-		return object_prototype_toString(vm, array, []value{})
+		return object_prototype_toString(vm, objectValue(array), []value{})
 	}
 }
 
 // ### toLocaleString
 
+// ES5 15.4.4.4
 func array_prototype_concat(vm *vm, f value, args []value) value {
-	other := args[0].(arrayObject)
-	switch typedJ := f.(type) {
-	case arrayObject:
-		ad := valueArrayData{values: make([]value, len(typedJ.primitiveData.values)+len(other.primitiveData.values))}
-		for idx, v := range typedJ.primitiveData.values {
-			ad.values[idx] = v
-		}
-		for idx, v := range other.primitiveData.values {
-			ad.values[len(typedJ.primitiveData.values)+idx] = v
+	vm.allocate(objectSize)
+	ad := newArrayData(nil)
+	n := uint32(0)
+	for _, E := range append([]value{f}, args...) {
+		if other, ok := E.obj.(arrayObject); ok {
+			od := other.primitiveData
+			if float64(n)+float64(od.length) > math.MaxUint32 {
+				vm.ThrowRangeError("Invalid array length")
+			}
+			for _, k := range od.indices(0, od.length) {
+				v, _ := od.Get(k)
+				vm.setElement(ad, n+k, v)
+			}
+			n += od.length
+		} else {
+			vm.setElement(ad, n, E)
+			n++
 		}
-
-		return arrayObject{valueBasicObject: newBasicObject(), primitiveData: &ad}
-	default:
-		panic("TypeError")
 	}
+	ad.SetLength(n)
+
+	return objectValue(arrayObject{valueBasicObject: newBasicObject(), primitiveData: ad})
 }
 
+// ES5 15.4.4.5
 func array_prototype_join(vm *vm, f value, args []value) value {
-	var sep valueString
-	if args[0] == newUndefined() {
-		sep = ","
-	} else {
-		sep = args[0].ToString()
+	ad := thisArrayData(vm, f)
+	sep := ","
+	if s := argument(args, 0); !s.isUndefined() {
+		sep = s.ToString().str
 	}
-	switch typedJ := f.(type) {
-	case arrayObject:
-		if len(typedJ.primitiveData.values) == 0 {
-			return newString("")
-		}
 
-		element0 := typedJ.primitiveData.values[0]
-		var R valueString
-		if element0 == newUndefined() || element0 == newNull() {
-			R = newString("")
-		} else {
-			R = element0.ToString()
-		}
+	if ad.length == 0 {
+		return newString("")
+	}
+	length := float64(len(sep)) * float64(ad.length-1)
+	if length > maxStringLength {
+		vm.ThrowRangeError("Invalid string length")
+	}
 
-		k := 1
-		for ; k < len(typedJ.primitiveData.values); k += 1 {
-			S := R + sep
-			element := typedJ.primitiveData.values[k]
-			var next valueString
-			if element == newUndefined() || element == newNull() {
-				next = newString("")
-			} else {
-				next = element.ToString()
-			}
-			R = S + next
+	idxs := ad.indices(0, ad.length)
+	vm.allocate(int64(len(idxs)) * int64(unsafe.Sizeof("")))
+	elems := make([]string, len(idxs))
+	for i, k := range idxs {
+		if v, _ := ad.Get(k); !v.isUndefined() && !v.isNull() {
+			elems[i] = v.ToString().str
+			length += float64(len(elems[i]))
 		}
+	}
+	if length > maxStringLength {
+		vm.ThrowRangeError("Invalid string length")
+	}
 
-		return R
-	default:
-		panic("TypeError")
+	// the holes between elements still get their separators
+	vm.allocateString(int(length))
+	var sb strings.Builder
+	sb.Grow(int(length))
+	last := uint32(0)
+	for i, k := range idxs {
+		sb.WriteString(strings.Repeat(sep, int(k-last)))
+		sb.WriteString(elems[i])
+		last = k
 	}
+	sb.WriteString(strings.Repeat(sep, int(ad.length-1-last)))
+	return newString(sb.String())
 }
 
+// ES5 15.4.4.6
 func array_prototype_pop(vm *vm, f value, args []value) value {
-	switch typedJ := f.(type) {
-	case arrayObject:
-		if len(typedJ.primitiveData.values) == 0 {
-			return newUndefined()
-		}
-
-		element := typedJ.primitiveData.values[len(typedJ.primitiveData.values)-1]
-		typedJ.primitiveData.values = typedJ.primitiveData.values[:len(typedJ.primitiveData.values)-1]
-		return element
-	default:
-		panic("TypeError")
+	ad := thisArrayData(vm, f)
+	if ad.length == 0 {
+		return newUndefined()
 	}
+
+	element, _ := ad.Get(ad.length - 1)
+	ad.SetLength(ad.length - 1)
+	return element
 }
 
+// ES5 15.4.4.7
 func array_prototype_push(vm *vm, f value, args []value) value {
-	switch typedJ := f.(type) {
-	case arrayObject:
-		for _, v := range args {
-			typedJ.primitiveData.values = append(typedJ.primitiveData.values, v)
-		}
-		return newNumber(float64(len(typedJ.primitiveData.values)))
-	default:
-		panic("TypeError")
+	ad := thisArrayData(vm, f)
+	if float64(ad.length)+float64(len(args)) > math.MaxUint32 {
+		vm.ThrowRangeError("Invalid array length")
+	}
+	for _, v := range args {
+		vm.setElement(ad, ad.length, v)
 	}
+	return newNumber(float64(ad.length))
 }
 
+// ES5 15.4.4.8
 func array_prototype_reverse(vm *vm, f value, args []value) value {
-	switch typedJ := f.(type) {
-	case arrayObject:
-		for i, j := 0, len(typedJ.primitiveData.values)-1; i < j; i, j = i+1, j-1 {
-			typedJ.primitiveData.values[i], typedJ.primitiveData.values[j] = typedJ.primitiveData.values[j], typedJ.primitiveData.values[i]
-		}
-		return typedJ
-	default:
-		panic("TypeError")
+	ad := thisArrayData(vm, f)
+	idxs := ad.indices(0, ad.length)
+	values := make([]value, len(idxs))
+	for i, k := range idxs {
+		values[i], _ = ad.Get(k)
+		ad.Delete(k)
 	}
+	for i, k := range idxs {
+		vm.setElement(ad, ad.length-1-k, values[i])
+	}
+	return f
 }
 
+// ES5 15.4.4.9
 func array_prototype_shift(vm *vm, f value, args []value) value {
-	switch typedJ := f.(type) {
-	case arrayObject:
-		if len(typedJ.primitiveData.values) == 0 {
-			return newUndefined()
-		}
+	ad := thisArrayData(vm, f)
+	if ad.length == 0 {
+		return newUndefined()
+	}
 
-		element := typedJ.primitiveData.values[0]
-		typedJ.primitiveData.values = typedJ.primitiveData.values[1:]
-		return element
-	default:
-		panic("TypeError")
+	first, _ := ad.Get(0)
+	ad.Delete(0)
+	ad.moveElements(vm, 1, -1)
+	ad.SetLength(ad.length - 1)
+	return first
+}
+
+// relativeIndex resolves a start or end argument of slice, which counts from
+// the end of the array if it is negative.
+func relativeIndex(arg value, length uint32, dflt float64) uint32 {
+	relative := dflt
+	if !arg.isUndefined() {
+		relative = toInteger(arg.ToNumber())
+	}
+	if relative < 0 {
+		return uint32(math.Max(float64(length)+relative, 0))
 	}
+	return uint32(math.Min(relative, float64(length)))
 }
 
+// ES5 15.4.4.10
 func array_prototype_slice(vm *vm, f value, args []value) value {
-	switch typedJ := f.(type) {
-	case arrayObject:
-		lenVal := len(typedJ.primitiveData.values)
-		ulen := uint32(lenVal)
-
-		relativeStart := args[0].ToInteger()
-		k := 0
-		if relativeStart < 0 {
-			k = int(math.Max(float64(int(ulen)+relativeStart), 0))
-		} else {
-			k = int(math.Min(float64(relativeStart), float64(ulen)))
-		}
-
-		relativeEnd := 0
-		switch args[1].(type) {
-		case valueUndefined:
-			relativeEnd = int(ulen)
-		default:
-			relativeEnd = args[1].ToInteger()
-		}
-
-		final := 0
-		if relativeEnd < 0 {
-			final = int(math.Max(float64(int(ulen)+relativeEnd), 0))
-		} else {
-			final = int(math.Min(float64(relativeEnd), float64(ulen)))
-		}
-
-		n := 0
-		newValues := []value{}
-		for ; k < final; k, n = k+1, n+1 {
-			if k >= 0 && k < len(typedJ.primitiveData.values) {
-				kValue := typedJ.primitiveData.values[k]
-				newValues = append(newValues, kValue)
-			}
-		}
-
-		return newArrayObject(newValues)
-	default:
-		panic("TypeError")
+	ad := thisArrayData(vm, f)
+	k := relativeIndex(argument(args, 0), ad.length, 0)
+	final := relativeIndex(argument(args, 1), ad.length, float64(ad.length))
+
+	vm.allocate(objectSize)
+	A := newArrayData(nil)
+	for _, idx := range ad.indices(k, final) {
+		v, _ := ad.Get(idx)
+		vm.setElement(A, idx-k, v)
+	}
+	if final > k {
+		A.SetLength(final - k)
 	}
+
+	return objectValue(arrayObject{valueBasicObject: newBasicObject(), primitiveData: A})
 }
 
 // ### sort
 // ### splice
 
+// ES5 15.4.4.13
 func array_prototype_unshift(vm *vm, f value, args []value) value {
-	switch typedJ := f.(type) {
-	case arrayObject:
-		newData := make([]value, len(args)+len(typedJ.primitiveData.values))
-		for idx, val := range args {
-			newData[idx] = val
-		}
-		for idx, val := range typedJ.primitiveData.values {
-			newData[len(args)+idx] = val
+	ad := thisArrayData(vm, f)
+	argCount := uint32(len(args))
+	if float64(ad.length)+float64(argCount) > math.MaxUint32 {
+		vm.ThrowRangeError("Invalid array length")
+	}
+	if argCount > 0 {
+		length := ad.length
+		ad.moveElements(vm, 0, int64(argCount))
+		ad.SetLength(length + argCount)
+		for j, v := range args {
+			vm.setElement(ad, uint32(j), v)
 		}
-		typedJ.primitiveData.values = newData
-		return newNumber(float64(len(typedJ.primitiveData.values)))
-	default:
-		panic("TypeError")
 	}
+	return newNumber(float64(ad.length))
 }
 
+// ES5 15.4.4.14
 func array_prototype_indexOf(vm *vm, f value, args []value) value {
-	fromIndex := 0
-
+	ad := thisArrayData(vm, f)
+	length := float64(ad.length)
+	n := 0.0
 	if len(args) > 1 {
-		fromIndex = int(args[1].ToInteger())
+		n = toInteger(args[1].ToNumber())
+	}
+	if n >= length {
+		return newNumber(-1)
 	}
 
-	switch typedJ := f.(type) {
-	case arrayObject:
-		for ; fromIndex < len(typedJ.primitiveData.values); fromIndex++ {
-			if strictEqualityComparison(typedJ.primitiveData.values[fromIndex], args[0]) {
-				return newNumber(float64(fromIndex))
-			}
+	k := n
+	if n < 0 {
+		k = math.Max(length+n, 0)
+	}
+	for _, idx := range ad.indices(uint32(k), ad.length) {
+		if v, _ := ad.Get(idx); strictEqualityComparison(v, argument(args, 0)) {
+			return newNumber(float64(idx))
 		}
-
-		return newNumber(-1)
-	default:
-		panic("TypeError")
 	}
+	return newNumber(-1)
 }
 
+// ES5 15.4.4.15
 func array_prototype_lastIndexOf(vm *vm, f value, args []value) value {
-	fromIndex := -1
-	fromIndexSet := false
-
+	ad := thisArrayData(vm, f)
+	length := float64(ad.length)
+	n := length - 1
 	if len(args) > 1 {
-		fromIndex = int(args[1].ToInteger())
-		fromIndexSet = true
+		n = toInteger(args[1].ToNumber())
 	}
 
-	switch typedJ := f.(type) {
-	case arrayObject:
-		if fromIndexSet == false {
-			fromIndex = len(typedJ.primitiveData.values) - 1
-		}
-		for idx := fromIndex; idx >= 0; idx-- {
-			val := typedJ.primitiveData.values[idx]
-			if strictEqualityComparison(val, args[0]) {
-				return newNumber(float64(idx))
-			}
-		}
-
+	k := math.Min(n, length-1)
+	if n < 0 {
+		k = length + n
+	}
+	if k < 0 {
 		return newNumber(-1)
-	default:
-		panic("TypeError")
 	}
+	idxs := ad.indices(0, uint32(k)+1)
+	for i := len(idxs) - 1; i >= 0; i-- {
+		if v, _ := ad.Get(idxs[i]); strictEqualityComparison(v, argument(args, 0)) {
+			return newNumber(float64(idxs[i]))
+		}
+	}
+	return newNumber(-1)
 }
 
 // ### every
@@ -27,6 +27,7 @@
 package vm
 
 import (
+	"github.com/stvp/assert"
 	"testing"
 )
 
@@ -34,23 +35,23 @@ func TestArrayObject(t *testing.T) {
 	tests := []simpleVMTest{
 		simpleVMTest{
 			in:  "var b = []; return b",
-			out: newArrayObject(nil),
+			out: objectValue(newArrayObject(nil)),
 		},
 		simpleVMTest{
 			in:  "var b = new Array(); return b",
-			out: newArrayObject(nil),
+			out: objectValue(newArrayObject(nil)),
 		},
 		simpleVMTest{
 			in:  "var b = [1, 2, 3, 4, 5]; return b",
-			out: newArrayObject([]value{newNumber(1), newNumber(2), newNumber(3), newNumber(4), newNumber(5)}),
+			out: objectValue(newArrayObject([]value{newNumber(1), newNumber(2), newNumber(3), newNumber(4), newNumber(5)})),
 		},
 		simpleVMTest{
 			in:  "var b = new Array(1, 2, 3, 4, 5); return b",
-			out: newArrayObject([]value{newNumber(1), newNumber(2), newNumber(3), newNumber(4), newNumber(5)}),
+			out: objectValue(newArrayObject([]value{newNumber(1), newNumber(2), newNumber(3), newNumber(4), newNumber(5)})),
 		},
 		simpleVMTest{
 			in:  "var b = Array(1, 2, 3, 4, 5); return b",
-			out: newArrayObject([]value{newNumber(1), newNumber(2), newNumber(3), newNumber(4), newNumber(5)}),
+			out: objectValue(newArrayObject([]value{newNumber(1), newNumber(2), newNumber(3), newNumber(4), newNumber(5)})),
 		},
 	}
 	runSimpleVMTestHelper(t, tests)
@@ -266,3 +267,276 @@ func TestArrayLastIndexOf(t *testing.T) {
 	}
 	runSimpleVMTestHelper(t, tests)
 }
+
+func TestArrayLength(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var a = [1, 2, 3]; return a.length",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[1000] = 1; return a.length",
+			out: newNumber(1001),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[4294967294] = 1; return a.length",
+			out: newNumber(4294967295),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2, 3]; a[1] = 5; return a.length",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2, 3]; a.length = 1; return a.length",
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2, 3]; a.length = 1; return typeof a[2]",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2, 3]; a.length = 1; a.length = 3; return typeof a[2]",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2, 3]; a.length = 5; return a.length",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[1000000] = 1; a.length = 10; return typeof a[1000000]",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "var a = new Array(5); return a.length",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "var a = new Array(5); return typeof a[0]",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "var a = new Array('5'); return a[0]",
+			out: newString("5"),
+		},
+		simpleVMTest{
+			in:  "var a = [1, , 3]; return a.length",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "var a = [, ]; return a.length",
+			out: newNumber(1),
+		},
+	}
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestArrayInvalidLength(t *testing.T) {
+	tests := []string{
+		"var a = []; a.length = -1",
+		"var a = []; a.length = 1.5",
+		"var a = []; a.length = 4294967296",
+		"var a = new Array(-1)",
+	}
+
+	for _, in := range tests {
+		t.Logf("Testing: %s", in)
+		_, err := New(in).Run()
+		assert.Equal(t, err.Error(), "RangeError: Invalid array length")
+	}
+}
+
+func TestArrayHoles(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var a = [1, , 3]; return '1' in a",
+			out: newBool(false),
+		},
+		simpleVMTest{
+			in:  "var a = [1, undefined, 3]; return '1' in a",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[5] = 1; return ('4' in a) + ',' + ('5' in a)",
+			out: newString("false,true"),
+		},
+		simpleVMTest{
+			in:  "var a = ['a', , 'c']; return a.join('-')",
+			out: newString("a--c"),
+		},
+		simpleVMTest{
+			in:  "var a = [, 'x']; return a.indexOf(undefined)",
+			out: newNumber(-1),
+		},
+		simpleVMTest{
+			in:  "var a = ['a', , 'c']; a.reverse(); return ('1' in a) + a.join('')",
+			out: newString("falseca"),
+		},
+		simpleVMTest{
+			in:  "var a = ['a', , 'c']; var b = a.concat(['d']); return (b.length == 4) + b.join('')",
+			out: newString("trueacd"),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[2000] = 'x'; a[3] = 'y'; return a.indexOf('x') + a.lastIndexOf('y')",
+			out: newNumber(2003),
+		},
+	}
+	runSimpleVMTestHelper(t, tests)
+}
+
+// Sparse arrays take as long as they have elements, not as their length.
+func TestArraySparse(t *testing.T) {
+	huge := "var a = []; a[4294967294] = 1; a[3] = 'y'; "
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  huge + "var b = a.slice(0); return b.length + ',' + b[3] + b[4294967294]",
+			out: newString("4294967295,y1"),
+		},
+		simpleVMTest{
+			in:  huge + "var b = a.slice(4294967290); return b.length + ',' + b.indexOf(1)",
+			out: newString("5,4"),
+		},
+		simpleVMTest{
+			in:  huge + "var b = a.concat(); return b.length + ',' + b[3] + b[4294967294]",
+			out: newString("4294967295,y1"),
+		},
+		simpleVMTest{
+			in:  huge + "return a.indexOf(1) + ',' + a.lastIndexOf('y') + ',' + a.indexOf('y', 4)",
+			out: newString("4294967294,3,-1"),
+		},
+		simpleVMTest{
+			in:  huge + "a.reverse(); return a[0] + a[4294967291] + ('3' in a)",
+			out: newString("1yfalse"),
+		},
+		simpleVMTest{
+			in:  huge + "var first = a.shift(); return first + ',' + a.length + ',' + a[4294967293] + a[2]",
+			out: newString("undefined,4294967294,1y"),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[4294967290] = 1; return a.unshift('x') + ',' + a[0] + a[4294967291] + ('1' in a)",
+			out: newString("4294967292,x1false"),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[5000] = 'x'; a[2] = 'y'; return a.join('') + a.join('-').length",
+			out: newString("yx5002"),
+		},
+	}
+	runSimpleVMTestHelper(t, tests)
+
+	for _, test := range []struct {
+		in  string
+		err string
+	}{
+		{huge + "a.join(',')", "RangeError: Invalid string length"},
+		{huge + "a.concat(['z'])", "RangeError: Invalid array length"},
+	} {
+		t.Logf("Testing: %s", test.in)
+		_, err := New(test.in).Run()
+		assert.Equal(t, err.Error(), test.err)
+	}
+}
+
+func TestArrayProperties(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var a = [1, 2]; a.foo = 'bar'; return a.foo",
+			out: newString("bar"),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2]; a.foo = 'bar'; return a.length",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2]; a['1'] = 5; return a[1]",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "var a = []; a['01'] = 5; return a.length",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "var a = ['x']; return ('0' in a) + ',' + ('length' in a) + ',' + ('1' in a)",
+			out: newString("true,true,false"),
+		},
+		// numbers that aren't array indices are ordinary property names
+		simpleVMTest{
+			in:  "var a = []; a[1.5] = 1; return a.length + ',' + a['1.5'] + ',' + a[1]",
+			out: newString("0,1,undefined"),
+		},
+		simpleVMTest{
+			in:  "var a = [7]; a[-0.5] = 1; return a.length + ',' + a['-0.5'] + ',' + a[0]",
+			out: newString("1,1,7"),
+		},
+		simpleVMTest{
+			in:  "var a = [7]; a[-1] = 2; return a.length + ',' + a['-1'] + ',' + a[-1]",
+			out: newString("1,2,2"),
+		},
+		simpleVMTest{
+			in:  "var a = []; a[-0] = 3; return a.length + ',' + a[0]",
+			out: newString("1,3"),
+		},
+		simpleVMTest{
+			in:  "var o = {}; o[2.5] = 1; o[true] = 2; return o['2.5'] + o['true']",
+			out: newNumber(3),
+		},
+	}
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestArrayIndexOfFromIndex(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var a = ['a', 'b', 'a']; return a.indexOf('a', -1)",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "var a = ['a', 'b', 'a']; return a.indexOf('a', 5)",
+			out: newNumber(-1),
+		},
+		simpleVMTest{
+			in:  "var a = ['a', 'b', 'a']; return a.lastIndexOf('a', -2)",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "var a = ['a', 'b', 'c', 'd']; return a.slice(-2).join('')",
+			out: newString("cd"),
+		},
+		simpleVMTest{
+			in:  "var a = ['a', 'b', 'c', 'd']; return a.slice(1, -1).join('')",
+			out: newString("bc"),
+		},
+	}
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestArrayData(t *testing.T) {
+	ad := newArrayData([]value{newNumber(0), newNumber(1)})
+	ad.Set(5, newNumber(5))
+	assert.Equal(t, ad.length, uint32(6))
+	assert.Nil(t, ad.sparse)
+	_, ok := ad.Get(3)
+	assert.False(t, ok)
+	v, ok := ad.Get(5)
+	assert.True(t, ok)
+	assert.Equal(t, v, newNumber(5))
+
+	// far past the end, it becomes sparse
+	ad.Set(5+maxArrayHoles*2, newNumber(7))
+	assert.NotNil(t, ad.sparse)
+	assert.Equal(t, ad.length, uint32(5+maxArrayHoles*2+1))
+	v, ok = ad.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, v, newNumber(1))
+	_, ok = ad.Get(3)
+	assert.False(t, ok)
+
+	ad.SetLength(2)
+	assert.Equal(t, ad.length, uint32(2))
+	assert.Equal(t, len(ad.sparse), 2)
+
+	dense := newArrayData([]value{newNumber(0), newNumber(1), newNumber(2)})
+	dense.SetLength(1)
+	assert.Equal(t, len(dense.dense), 1)
+	dense.SetLength(10)
+	assert.Equal(t, len(dense.dense), 1)
+	assert.Equal(t, dense.length, uint32(10))
+}
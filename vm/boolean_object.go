@@ -30,30 +30,28 @@ import (
 	"fmt"
 )
 
-var booleanProto valueBasicObject
-
 type booleanObjectData struct {
 	*valueBasicObjectData
 	primitiveData bool
 }
 
-func (this *booleanObjectData) Prototype() *valueBasicObject {
-	return &booleanProto
+func (this *booleanObjectData) Prototype(vm *vm) *valueBasicObject {
+	return &vm.booleanProto
 }
 
 func newBooleanObject(b bool) valueBasicObject {
-	return valueBasicObject{&booleanObjectData{&valueBasicObjectData{extensible: true}, b}}
+	return valueBasicObject{&booleanObjectData{newValueBasicObjectData(), b}}
 }
 
 func defineBooleanCtor(vm *vm) functionObject {
-	booleanProto = valueBasicObject{&rootObjectData{&valueBasicObjectData{extensible: true}}}
-	booleanProto.defineDefaultProperty(vm, "toString", newFunctionObject(boolean_prototype_toString, nil), 0)
-	booleanProto.defineDefaultProperty(vm, "valueOf", newFunctionObject(boolean_prototype_valueOf, nil), 0)
+	vm.booleanProto = valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	vm.booleanProto.defineDefaultProperty(vm, "toString", objectValue(newFunctionObject(boolean_prototype_toString, nil)), 0)
+	vm.booleanProto.defineDefaultProperty(vm, "valueOf", objectValue(newFunctionObject(boolean_prototype_valueOf, nil)), 0)
 
 	boolO := newFunctionObject(boolean_call, boolean_ctor)
-	boolO.prototype = &booleanProto
+	boolO.prototype = &vm.booleanProto
 
-	booleanProto.defineDefaultProperty(vm, "constructor", boolO, 0)
+	vm.booleanProto.defineDefaultProperty(vm, "constructor", objectValue(boolO), 0)
 	return boolO
 }
 
@@ -62,16 +60,16 @@ func boolean_call(vm *vm, f value, args []value) value {
 }
 
 func boolean_ctor(vm *vm, f value, args []value) value {
-	return newBooleanObject(args[0].ToBoolean())
+	return objectValue(newBooleanObject(args[0].ToBoolean()))
 }
 
 func boolean_prototype_toString(vm *vm, f value, args []value) value {
 	b := false
-	switch o := f.(type) {
-	case valueBool:
-		b = bool(o)
-	case valueBasicObject:
-		b = o.odata.(*booleanObjectData).primitiveData
+	switch {
+	case f.isBool():
+		b = f.num != 0
+	case f.isObject():
+		b = f.obj.objectData().(*booleanObjectData).primitiveData
 	default:
 		panic(fmt.Sprintf("Not a boolean! %s", f)) // ### throw
 	}
@@ -85,11 +83,11 @@ func boolean_prototype_toString(vm *vm, f value, args []value) value {
 
 func boolean_prototype_valueOf(vm *vm, f value, args []value) value {
 	b := false
-	switch o := f.(type) {
-	case valueBool:
-		b = bool(o)
-	case valueBasicObject:
-		b = o.odata.(*booleanObjectData).primitiveData
+	switch {
+	case f.isBool():
+		b = f.num != 0
+	case f.isObject():
+		b = f.obj.objectData().(*booleanObjectData).primitiveData
 	default:
 		panic(fmt.Sprintf("Not a boolean! %s", f)) // ### throw
 	}
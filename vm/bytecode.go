@@ -0,0 +1,477 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strings"
+)
+
+// A Program can be saved, and loaded again to be run without compiling it
+// again. A saved program is:
+//
+//	"v2bc"
+//	the format version, as a little endian uint16
+//	the name, constants, strings, functions, number of inline caches, code
+//	and line table, as varints and length prefixed strings
+//	a CRC-32 (IEEE) of everything before it, as a little endian uint32
+//
+// Opcodes are saved as their number, so bytecodeVersion has to change when
+// they do, as well as when the format does.
+//
+// As the code may have come from anywhere, it is checked when it is loaded:
+// every operand has to be in bounds of whatever it refers to (a register of
+// the frame, a constant, a string, an inline cache, a function or the code).
+
+var bytecodeMagic = []byte("v2bc")
+
+const bytecodeVersion = 1
+
+// IsCompiledProgram reports whether data looks like a saved Program.
+func IsCompiledProgram(data []byte) bool {
+	return bytes.HasPrefix(data, bytecodeMagic)
+}
+
+// MarshalBinary saves the program, for LoadProgram to load.
+func (this *Program) MarshalBinary() ([]byte, error) {
+	w := &bytecodeWriter{}
+	w.buf.Write(bytecodeMagic)
+	binary.Write(&w.buf, binary.LittleEndian, uint16(bytecodeVersion))
+
+	w.string(this.name)
+	w.uvarint(len(this.constants))
+	for _, c := range this.constants {
+		if err := w.constant(c); err != nil {
+			return nil, err
+		}
+	}
+	w.uvarint(len(this.strings))
+	for _, s := range this.strings {
+		w.string(s)
+	}
+	w.uvarint(len(this.functions))
+	for _, fn := range this.functions {
+		w.uvarint(fn.entry)
+		w.uvarint(fn.frameSize)
+		w.uvarint(fn.params)
+	}
+	w.uvarint(this.caches)
+	w.uvarint(len(this.code))
+	for _, op := range this.code {
+		w.uvarint(int(op.otype))
+		w.operand(op.a)
+		w.operand(op.b)
+		w.operand(op.c)
+		w.operand(op.d)
+	}
+	w.uvarint(len(this.lines))
+	for _, l := range this.lines {
+		w.uvarint(l.pc)
+		w.uvarint(l.line)
+	}
+
+	binary.Write(&w.buf, binary.LittleEndian, crc32.ChecksumIEEE(w.buf.Bytes()))
+	return w.buf.Bytes(), nil
+}
+
+// LoadProgram loads a program saved by MarshalBinary, after checking that it
+// is intact, of the right version, and safe to run.
+func LoadProgram(data []byte) (*Program, error) {
+	header := len(bytecodeMagic) + 2
+	if !IsCompiledProgram(data) {
+		return nil, errors.New("not a compiled program")
+	}
+	if len(data) < header+crc32.Size {
+		return nil, errors.New("compiled program is truncated")
+	}
+	if version := binary.LittleEndian.Uint16(data[len(bytecodeMagic):]); version != bytecodeVersion {
+		return nil, fmt.Errorf("compiled program is version %d, not %d", version, bytecodeVersion)
+	}
+	body := data[:len(data)-crc32.Size]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, errors.New("compiled program is corrupt: checksum mismatch")
+	}
+
+	r := &bytecodeReader{data: body[header:]}
+	p := &Program{name: r.string()}
+	p.constants = make([]value, r.count())
+	for idx := range p.constants {
+		p.constants[idx] = r.constant()
+	}
+	p.strings = make([]string, r.count())
+	for idx := range p.strings {
+		p.strings[idx] = r.string()
+	}
+	p.functions = make([]compiledFunction, r.count())
+	for idx := range p.functions {
+		p.functions[idx] = compiledFunction{entry: r.uvarint(), frameSize: r.uvarint(), params: r.uvarint()}
+	}
+	p.caches = r.uvarint()
+	p.code = make([]opcode, r.count())
+	for idx := range p.code {
+		otype := r.uvarint()
+		if otype >= int(opcodeCount) {
+			r.fail("unknown opcode %d at %d", otype, idx)
+		}
+		p.code[idx] = opcode{otype: opcode_type(otype), a: r.operand(), b: r.operand(), c: r.operand(), d: r.operand()}
+	}
+	p.lines = make([]lineEntry, r.count())
+	for idx := range p.lines {
+		p.lines[idx] = lineEntry{pc: r.uvarint(), line: r.uvarint()}
+	}
+	if r.err == nil && len(r.data) > 0 {
+		r.fail("%d bytes of trailing data", len(r.data))
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("compiled program is corrupt: %s", r.err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("compiled program is invalid: %s", err)
+	}
+	return p, nil
+}
+
+// what an opcode's operand refers to, see operandKinds.
+type operandKind int
+
+const (
+	operandUnused   operandKind = iota
+	operandValue                // a register or a constant
+	operandRegister             // a register only, to store to
+	operandString
+	operandJump
+	operandCache
+	operandFunction
+	operandCount
+)
+
+// operandKinds returns what each of an opcode's operands refer to.
+func operandKinds(t opcode_type) [4]operandKind {
+	switch t {
+	case ADD, SUB, MULTIPLY, DIVIDE, LEFT_SHIFT, RIGHT_SHIFT,
+		UNSIGNED_RIGHT_SHIFT, BITWISE_AND, BITWISE_XOR, BITWISE_OR, MODULUS,
+		EXPONENT, LESS_THAN, LESS_THAN_EQ, GREATER_THAN, GREATER_THAN_EQ,
+		EQUALS, NOT_EQUALS, STRICT_EQUALS, STRICT_NOT_EQUALS, LOGICAL_AND,
+		LOGICAL_OR, IN, INSTANCEOF, ADD_NUMBER, SUB_NUMBER, LESS_THAN_NUMBER,
+		LESS_THAN_EQ_NUMBER, GREATER_THAN_NUMBER, GREATER_THAN_EQ_NUMBER,
+		LOAD_INDEXED:
+		return [4]operandKind{operandRegister, operandValue, operandValue}
+	case UPLUS, UMINUS, UNOT, TYPEOF, BITWISE_NOT, MOVE, INCREMENT, DECREMENT:
+		return [4]operandKind{operandRegister, operandValue}
+	case TYPEOF_VAR, LOAD_GLOBAL:
+		return [4]operandKind{operandRegister, operandString}
+	case STORE_GLOBAL:
+		return [4]operandKind{operandString, operandValue}
+	case LOAD_MEMBER:
+		return [4]operandKind{operandRegister, operandValue, operandString, operandCache}
+	case STORE_MEMBER:
+		return [4]operandKind{operandValue, operandString, operandValue, operandCache}
+	case STORE_INDEXED, DEFINE_PROPERTY:
+		return [4]operandKind{operandValue, operandValue, operandValue}
+	case LOAD_THIS, NEW_OBJECT:
+		return [4]operandKind{operandRegister}
+	case LOAD_FUNCTION:
+		return [4]operandKind{operandRegister, operandFunction}
+	case JMP:
+		return [4]operandKind{operandJump}
+	case JNE:
+		return [4]operandKind{operandValue, operandJump}
+	case PUSH_ARG, RETURN:
+		return [4]operandKind{operandValue}
+	case CALL, NEW:
+		return [4]operandKind{operandRegister, operandValue, operandValue, operandCount}
+	case IN_FUNCTION:
+		return [4]operandKind{operandString, operandCount}
+	case DECLARE:
+		return [4]operandKind{operandString}
+	case NEW_ARRAY:
+		return [4]operandKind{operandRegister, operandCount}
+	}
+	return [4]operandKind{}
+}
+
+// the most registers a function may have in a loaded program, so that a
+// corrupt one can't make the vm allocate without bound.
+const maxFrameSize = 65535
+
+// validate checks that running the program won't go out of bounds of its
+// code, or any of its tables.
+func (this *Program) validate() error {
+	if len(this.code) == 0 || this.code[0].otype != IN_FUNCTION {
+		return errors.New("code doesn't start with a function")
+	}
+	for idx, fn := range this.functions {
+		if fn.entry >= len(this.code) || this.code[fn.entry].otype != IN_FUNCTION || int(this.code[fn.entry].b) != fn.frameSize {
+			return fmt.Errorf("function %d has a bad entry point %d", idx, fn.entry)
+		}
+		if fn.params > fn.frameSize {
+			return fmt.Errorf("function %d has more parameters than registers", idx)
+		}
+	}
+	if this.caches > len(this.code) {
+		return fmt.Errorf("%d inline caches for %d opcodes", this.caches, len(this.code))
+	}
+
+	// the code of each function runs from its IN_FUNCTION to the next one
+	ends := make([]int, len(this.code))
+	end := len(this.code)
+	for pc := len(this.code) - 1; pc >= 0; pc-- {
+		ends[pc] = end
+		if this.code[pc].otype == IN_FUNCTION {
+			end = pc
+		}
+	}
+
+	// the arguments a function has pushed before each instruction, if the
+	// code just runs on, a byte each: 'h' for an array hole, which only
+	// NEW_ARRAY can take, and 'v' for anything else. A jump has to keep to
+	// that, so that what is pushed is always what is taken.
+	pushed := make([]string, len(this.code)+1)
+
+	frameSize, start := 0, 0
+	for pc, op := range this.code {
+		if op.otype == IN_FUNCTION {
+			frameSize, start = int(op.b), pc
+			pushed[pc] = ""
+			if frameSize > maxFrameSize {
+				return fmt.Errorf("%d: %s has more than %d registers", pc, op.format(this.strings), maxFrameSize)
+			}
+		}
+		operands := [4]operand{op.a, op.b, op.c, op.d}
+		for idx, kind := range operandKinds(op.otype) {
+			o := operands[idx]
+			ok := true
+			switch kind {
+			case operandValue:
+				if o.isConstant() {
+					ok = o.constantIndex() < len(this.constants)
+					// holes are only pushed, for arrays to take
+					if ok && this.constants[o.constantIndex()].kind == kindHole {
+						ok = op.otype == PUSH_ARG
+					}
+				} else {
+					ok = int(o) < frameSize
+				}
+			case operandRegister:
+				ok = o >= 0 && int(o) < frameSize
+			case operandString:
+				ok = o >= 0 && int(o) < len(this.strings)
+			case operandJump:
+				ok = int(o) >= start && int(o) < ends[pc]
+			case operandCache:
+				ok = o >= 0 && int(o) < this.caches
+			case operandFunction:
+				ok = o >= 0 && int(o) < len(this.functions)
+			case operandCount:
+				ok = o >= 0
+			}
+			if !ok {
+				return fmt.Errorf("%d: operand %d of %s is out of bounds", pc, idx, op.format(this.strings))
+			}
+		}
+
+		args := pushed[pc]
+		taken := 0
+		switch op.otype {
+		case PUSH_ARG:
+			if op.a.isConstant() && this.constants[op.a.constantIndex()].kind == kindHole {
+				args += "h"
+			} else {
+				args += "v"
+			}
+		case NEW_ARRAY:
+			taken = int(op.b)
+		case CALL, NEW:
+			taken = int(op.d)
+		}
+		if taken > len(args) {
+			return fmt.Errorf("%d: %s takes more arguments than were pushed", pc, op.format(this.strings))
+		}
+		args = args[:len(args)-taken]
+		if (op.otype == CALL || op.otype == NEW) && strings.Contains(pushed[pc][len(args):], "h") {
+			return fmt.Errorf("%d: %s is passed an array hole", pc, op.format(this.strings))
+		}
+		pushed[pc+1] = args
+	}
+
+	// jumps are only checked now, as they can go forwards
+	for pc, op := range this.code {
+		target := -1
+		switch op.otype {
+		case JMP:
+			target = int(op.a)
+		case JNE:
+			target = int(op.b)
+		}
+		if target != -1 && pushed[target] != pushed[pc] {
+			return fmt.Errorf("%d: %s jumps with %d arguments pushed to where there are %d", pc, op.format(this.strings), len(pushed[pc]), len(pushed[target]))
+		}
+	}
+
+	for idx, l := range this.lines {
+		if l.pc >= len(this.code) || (idx > 0 && l.pc <= this.lines[idx-1].pc) {
+			return fmt.Errorf("line table entry %d is out of order", idx)
+		}
+	}
+	return nil
+}
+
+type bytecodeWriter struct {
+	buf bytes.Buffer
+}
+
+func (this *bytecodeWriter) uvarint(v int) {
+	var b [binary.MaxVarintLen64]byte
+	this.buf.Write(b[:binary.PutUvarint(b[:], uint64(v))])
+}
+
+func (this *bytecodeWriter) operand(o operand) {
+	var b [binary.MaxVarintLen64]byte
+	this.buf.Write(b[:binary.PutVarint(b[:], int64(o))])
+}
+
+func (this *bytecodeWriter) string(s string) {
+	this.uvarint(len(s))
+	this.buf.WriteString(s)
+}
+
+func (this *bytecodeWriter) constant(v value) error {
+	this.buf.WriteByte(byte(v.kind))
+	switch v.kind {
+	case kindUndefined, kindNull, kindHole:
+	case kindBool:
+		this.buf.WriteByte(byte(v.num))
+	case kindNumber:
+		binary.Write(&this.buf, binary.LittleEndian, math.Float64bits(v.num))
+	case kindString:
+		this.string(v.str)
+	default:
+		return fmt.Errorf("can't save constant %s", v)
+	}
+	return nil
+}
+
+// bytecodeReader reads what bytecodeWriter wrote. Once something goes wrong,
+// it only gives zeroes, and err says what it was.
+type bytecodeReader struct {
+	data []byte
+	err  error
+}
+
+func (this *bytecodeReader) fail(format string, args ...interface{}) {
+	if this.err == nil {
+		this.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (this *bytecodeReader) bytes(n int) []byte {
+	if this.err != nil {
+		return nil
+	}
+	if n > len(this.data) {
+		this.fail("unexpected end of data")
+		return nil
+	}
+	b := this.data[:n]
+	this.data = this.data[n:]
+	return b
+}
+
+func (this *bytecodeReader) uvarint() int {
+	if this.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(this.data)
+	if n <= 0 || v > math.MaxInt32 {
+		this.fail("bad number")
+		return 0
+	}
+	this.data = this.data[n:]
+	return int(v)
+}
+
+// count reads the length of a table, every entry of which takes at least a
+// byte, so that a bad length can't make for a huge allocation.
+func (this *bytecodeReader) count() int {
+	n := this.uvarint()
+	if n > len(this.data) {
+		this.fail("table of %d entries in %d bytes", n, len(this.data))
+		return 0
+	}
+	return n
+}
+
+func (this *bytecodeReader) operand() operand {
+	if this.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(this.data)
+	if n <= 0 || v < math.MinInt32 || v > math.MaxInt32 {
+		this.fail("bad operand")
+		return 0
+	}
+	this.data = this.data[n:]
+	return operand(v)
+}
+
+func (this *bytecodeReader) string() string {
+	return string(this.bytes(this.count()))
+}
+
+func (this *bytecodeReader) constant() value {
+	kind := this.bytes(1)
+	if kind == nil {
+		return newUndefined()
+	}
+	switch valueKind(kind[0]) {
+	case kindUndefined:
+		return newUndefined()
+	case kindNull:
+		return newNull()
+	case kindHole:
+		return arrayHole
+	case kindBool:
+		if b := this.bytes(1); b != nil {
+			return newBool(b[0] != 0)
+		}
+	case kindNumber:
+		if b := this.bytes(8); b != nil {
+			return newNumber(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+	case kindString:
+		return newString(this.string())
+	default:
+		this.fail("unknown constant kind %d", kind[0])
+	}
+	return newUndefined()
+}
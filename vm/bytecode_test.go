@@ -0,0 +1,229 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/stvp/assert"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func saveProgram(t *testing.T, p *Program) []byte {
+	data, err := p.MarshalBinary()
+	assert.Nil(t, err)
+	return data
+}
+
+func TestLoadProgram(t *testing.T) {
+	p, err := Compile(`
+	function greet(name) {
+		return "hello " + name
+	}
+	var o = {n: 1.5, u: undefined, z: null, b: true}
+	var a = [1, , 3]
+	return greet("world") + (o.n * 2) + a.length`, "greet.js")
+	assert.Nil(t, err)
+	data := saveProgram(t, p)
+	assert.True(t, IsCompiledProgram(data))
+
+	loaded, err := LoadProgram(data)
+	assert.Nil(t, err)
+	assert.Equal(t, loaded.Name(), "greet.js")
+	assert.Equal(t, loaded.lines, p.lines)
+	assert.Equal(t, mustRun(t, NewFromProgram(loaded)), mustRun(t, NewFromProgram(p)))
+
+	// and it saves the same way again
+	assert.Equal(t, saveProgram(t, loaded), data)
+}
+
+func TestLoadProgramErrors(t *testing.T) {
+	p, err := Compile(`var a = 1; if (a) { a = 2 }; return a`, "test.js")
+	assert.Nil(t, err)
+	data := saveProgram(t, p)
+
+	load := func(data []byte) string {
+		p, err := LoadProgram(data)
+		assert.Nil(t, p)
+		if err == nil {
+			return ""
+		}
+		return err.Error()
+	}
+
+	assert.Equal(t, load([]byte("var a = 1")), "not a compiled program")
+	assert.Equal(t, load(data[:6]), "compiled program is truncated")
+
+	wrongVersion := append([]byte{}, data...)
+	wrongVersion[4] = 99
+	assert.Equal(t, load(wrongVersion), "compiled program is version 99, not 1")
+
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 0xff
+	assert.Equal(t, load(flipped), "compiled program is corrupt: checksum mismatch")
+}
+
+const validationSource = `var a = 1; while (a) { a = 0 }; function f(x) { return [x, 2] }; return f(a)`
+
+// validationPC returns where the first otype is in validationSource's code.
+func validationPC(otype opcode_type) operand {
+	p, err := Compile(validationSource, "test.js")
+	if err != nil {
+		panic(err)
+	}
+	for pc, op := range p.code {
+		if op.otype == otype {
+			return operand(pc)
+		}
+	}
+	return -1
+}
+
+// Well formed code that would go out of bounds is refused.
+func TestLoadProgramValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     opcode_type
+		change func(op *opcode)
+	}{
+		{"jump past the end", JMP, func(op *opcode) { op.a = 1000 }},
+		{"jump before the start", JNE, func(op *opcode) { op.b = -1 }},
+		{"string index", STORE_GLOBAL, func(op *opcode) { op.a = 1000 }},
+		{"constant index", STORE_GLOBAL, func(op *opcode) { op.b = constantOperand(1000) }},
+		{"register", STORE_GLOBAL, func(op *opcode) { op.b = 1000 }},
+		{"jump into another function", JMP, func(op *opcode) { op.a = validationPC(NEW_ARRAY) }},
+		{"too many arguments", CALL, func(op *opcode) { op.d = 5 }},
+		{"jump past pushed arguments", JNE, func(op *opcode) { op.b = validationPC(CALL) }},
+		{"frame size", IN_FUNCTION, func(op *opcode) { op.b = 1 << 30 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Compile(validationSource, "test.js")
+			assert.Nil(t, err)
+			found := false
+			for idx := range p.code {
+				if p.code[idx].otype == test.op {
+					test.change(&p.code[idx])
+					found = true
+					break
+				}
+			}
+			assert.True(t, found)
+
+			_, err = LoadProgram(saveProgram(t, p))
+			assert.NotNil(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), "compiled program is invalid: "))
+		})
+	}
+}
+
+// A function's frame size can't be so big that calling it runs out of memory.
+func TestLoadProgramFunctionFrameSize(t *testing.T) {
+	p, err := Compile(validationSource, "test.js")
+	assert.Nil(t, err)
+	fn := &p.functions[0]
+	fn.frameSize = 1 << 30
+	p.code[fn.entry].b = operand(fn.frameSize)
+
+	_, err = LoadProgram(saveProgram(t, p))
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "has more than 65535 registers"), err.Error())
+}
+
+// Whatever the operands of a program that loads, running it doesn't go wrong
+// in the vm.
+func TestLoadProgramFuzz(t *testing.T) {
+	sources := []string{
+		validationSource,
+		`function f(a, b) { return a + b }; var o = {x: f(1, 2), y: [1, , "s"]}; o.x = o.y[2]; return f(o.x, new f(3))`,
+		`var s = 0; for (var i = 0; i < 3; i++) { s += Math.max(i, 2) }; if (s > 1) { s = -s } else { s = typeof s }; return s`,
+		`function g(n) { if (n < 1) return []; return [n, g(n - 1)] }; var a = g(3); a[5] = a.length; return a[1][0]`,
+	}
+	rng := rand.New(rand.NewSource(1))
+
+	for _, src := range sources {
+		p, err := Compile(src, "fuzz.js")
+		assert.Nil(t, err)
+
+		for i := 0; i < 2000; i++ {
+			code := append([]opcode{}, p.code...)
+			pc := rng.Intn(len(code))
+			kinds := operandKinds(code[pc].otype)
+			n := 0
+			for n < len(kinds) && kinds[n] != operandUnused {
+				n++
+			}
+			if n == 0 {
+				continue
+			}
+			o := operand(rng.Intn(len(code)+4) - 2)
+			if rng.Intn(4) == 0 {
+				o = constantOperand(rng.Intn(len(p.constants) + 2))
+			}
+			switch rng.Intn(n) {
+			case 0:
+				code[pc].a = o
+			case 1:
+				code[pc].b = o
+			case 2:
+				code[pc].c = o
+			case 3:
+				code[pc].d = o
+			}
+
+			mutated := *p
+			mutated.code = code
+			loaded, err := LoadProgram(saveProgram(t, &mutated))
+			if err != nil {
+				continue
+			}
+			v := NewFromProgram(loaded)
+			v.SetStepLimit(10000)
+			if _, err := v.Run(); err != nil {
+				if _, ok := err.(*InternalError); ok {
+					t.Fatalf("%s: with %s: %s", src, code[pc].format(p.strings), err)
+				}
+			}
+		}
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	v := New("var a = 1\n\nvar b = a +\n  Math.PI\nreturn b")
+	lineOf := func(otype opcode_type) int {
+		for pc, op := range v.code {
+			if op.otype == otype {
+				return v.lineAt(pc)
+			}
+		}
+		return -1
+	}
+	assert.Equal(t, lineOf(DECLARE), 1)
+	assert.Equal(t, lineOf(ADD), 3)
+	assert.Equal(t, lineOf(RETURN), 5)
+}
@@ -30,42 +30,68 @@ import (
 	"fmt"
 	"github.com/CrimsonAS/v2/parser"
 	"log"
+	"math"
+	"sort"
 )
 
 // ### this really needs some cleanup
+//
+// A member address is a variable or temporary address (the base object) with
+// a reference to the member's key: a string constant for a named member, or
+// any other address for a computed one.
+//
+// A variable address has a slot if it is local to the function (see scope),
+// or a slot of -1 if it has to be looked up by name.
 type tac_address struct {
 	valid     bool
 	constant  value
 	varname   string
 	reference *tac_address
 	temporary int
+	slot      int
 }
 
 func (this tac_address) isConstant() bool {
-	return !this.isTemp() && !this.isVar()
+	return !this.isTemp() && !this.isVar() && !this.isMember()
 }
 
 func (this tac_address) isVar() bool {
-	return this.varname != "" && this.valid
+	return this.varname != "" && this.valid && this.reference == nil
 }
 
 func (this tac_address) isMember() bool {
-	return this.isVar() && this.reference != nil
+	return this.valid && this.reference != nil
 }
 
 func (this tac_address) isTemp() bool {
-	return this.temporary != -1 && this.valid
+	return this.temporary != -1 && this.valid && this.reference == nil
+}
+
+// base returns the address of the object a member address refers into.
+func (this tac_address) base() tac_address {
+	this.reference = nil
+	return this
+}
+
+// memberName returns the name of a member address whose key is known at
+// codegen time.
+func (this tac_address) memberName() (string, bool) {
+	if this.reference.isConstant() {
+		if c := this.reference.constant; c.isString() {
+			return c.str, true
+		}
+	}
+	return "", false
 }
 
 func (this tac_address) String() string {
 	if !this.valid {
 		return "(invalid)"
 	}
+	if this.reference != nil {
+		return fmt.Sprintf("%s.%s", this.base(), this.reference)
+	}
 	if this.varname != "" {
-		if this.reference != nil {
-			return fmt.Sprintf("%s.%s", this.varname, this.reference)
-		}
-
 		return this.varname
 	}
 
@@ -81,6 +107,7 @@ type tac struct {
 	arg1   tac_address
 	op     tac_op_type
 	arg2   tac_address
+	line   int // of the source it came from, or 0 if not known
 }
 
 func (this tac) String() string {
@@ -114,12 +141,12 @@ func (this tac) String() string {
 	if this.op == TAC_NEW {
 		return fmt.Sprintf("%s = NEW(%s)", this.result, this.arg1)
 	}
-	if this.op == TAC_FUNCTION_PARAMETER {
-		return fmt.Sprintf("function_param(%s)", this.arg1)
-	}
 	if this.op == TAC_FUNCTION {
 		return fmt.Sprintf("function(%s)", this.arg1)
 	}
+	if this.op == TAC_LOAD_FUNCTION {
+		return fmt.Sprintf("%s = LOAD_FUNCTION(%s)", this.result, this.arg1)
+	}
 	if this.op == TAC_END_FUNCTION {
 		return fmt.Sprintf("end function(%s)", this.arg1)
 	}
@@ -140,19 +167,35 @@ func (this tac) String() string {
 
 func (this *vm) newTemporary() tac_address {
 	this.temporaryIndex += 1
-	return tac_address{true, newUndefined(), "", nil, this.temporaryIndex}
+	return tac_address{true, newUndefined(), "", nil, this.temporaryIndex, -1}
 }
 
 func newConstant(v value) tac_address {
-	return tac_address{true, v, "", nil, -1}
+	return tac_address{true, v, "", nil, -1, -1}
 }
 
 func newVar(n string) tac_address {
-	return tac_address{true, newUndefined(), n, nil, -1}
+	return tac_address{true, newUndefined(), n, nil, -1, -1}
 }
 
-func newReference(n string, m tac_address) tac_address {
-	return tac_address{true, newUndefined(), n, &m, -1}
+func newLocal(n string, slot int) tac_address {
+	return tac_address{true, newUndefined(), n, nil, -1, slot}
+}
+
+func newReference(base tac_address, m tac_address) tac_address {
+	base.reference = &m
+	return base
+}
+
+// Member addresses can only be based on a variable or a temporary, so any
+// other base (a constant, or another member) is copied to a temporary first.
+func (this *vm) newMemberBase(base tac_address, codebuf *[]tac) tac_address {
+	if base.isVar() || base.isTemp() {
+		return base
+	}
+	tmp := this.newTemporary()
+	*codebuf = append(*codebuf, tac{result: tmp, arg1: base, op: TAC_ASSIGN})
+	return tmp
 }
 
 type tac_op_type int
@@ -165,6 +208,7 @@ const (
 	TAC_MULTIPLY
 	TAC_DIVIDE
 	TAC_MODULUS
+	TAC_EXPONENT // a ** b
 	TAC_LEFT_SHIFT
 	TAC_RIGHT_SHIFT
 	TAC_UNSIGNED_RIGHT_SHIFT
@@ -208,9 +252,10 @@ const (
 	TAC_INSTANCEOF
 	TAC_DELETE
 
-	TAC_FUNCTION_PARAMETER
 	TAC_FUNCTION
 	TAC_END_FUNCTION
+	TAC_USE_STRICT
+	TAC_LOAD_FUNCTION
 	TAC_RETURN
 
 	TAC_JNE
@@ -218,360 +263,495 @@ const (
 	TAC_JMP
 )
 
-func pushConstant(addr tac_address) []opcode {
-	codebuf := []opcode{}
-	switch c := addr.constant.(type) {
-	case valueNumber:
-		codebuf = append(codebuf, newOpcode(PUSH_NUMBER, float64(c)))
-	case valueUndefined:
-		codebuf = append(codebuf, simpleOp(PUSH_UNDEFINED))
-	case valueNull:
-		codebuf = append(codebuf, simpleOp(PUSH_NULL))
-	case valueString:
-		id := appendStringtable(c.String())
-		codebuf = append(codebuf, newOpcode(PUSH_STRING, float64(id)))
-	case valueBool:
-		if c == true {
-			codebuf = append(codebuf, newOpcode(PUSH_BOOL, float64(1)))
-		} else {
-			codebuf = append(codebuf, newOpcode(PUSH_BOOL, float64(0)))
+// callJsFunction returns the builtin that enters a JavaScript function, whose
+// code starts after addr, and sets up its frame.
+func callJsFunction(addr int, frameSize int, params int) func(vm *vm, f value, args []value) value {
+	return func(vm *vm, f value, args []value) value {
+		if execDebug {
+			log.Printf("Calling func! IP %d going to %d, %s", vm.ip, addr, args)
 		}
-	default:
-		panic(fmt.Sprintf("Unknown constant type %T %s", addr, addr.String()))
+		// alter the IP of the new stack frame the CALL set up to be in
+		// the function's code.
+		vm.ip = addr
+
+		// bit of a dirty hack here. we tell the VM to ignore the return
+		// value of the builtin function, and instead, wait for the
+		// return instruction to pop the stack.
+		vm.ignoreReturn = true
+
+		// parameters come first, and everything else starts out undefined
+		registers := make([]value, frameSize)
+		for idx := range registers {
+			if idx < params && idx < len(args) {
+				registers[idx] = args[idx]
+			} else {
+				registers[idx] = newUndefined()
+			}
+		}
+		vm.currentFrame.registers = registers
+
+		return newUndefined()
 	}
-	return codebuf
 }
 
-func pushVarOrConstant(addr tac_address) []opcode {
-	codebuf := []opcode{}
+const codegenDebug = false
 
-	if addr.isMember() {
-		if addr.reference.isVar() {
-			memberIdx := appendStringtable(addr.reference.varname)
-			codebuf = append(codebuf, pushVarOrConstant(newVar(addr.varname))...)
-			codebuf = append(codebuf, newOpcode(LOAD_MEMBER, float64(memberIdx)))
-		} else {
-			codebuf = append(codebuf, pushVarOrConstant(*addr.reference)...)
-			codebuf = append(codebuf, pushVarOrConstant(newVar(addr.varname))...)
-			codebuf = append(codebuf, simpleOp(LOAD_INDEXED))
+// A lineEntry says that the code from pc on, up to the next entry, came from
+// a line of the source.
+type lineEntry struct {
+	pc   int
+	line int
+}
+
+// noteLine records the line that the opcode at pc came from.
+func (this *vm) noteLine(pc int, line int) {
+	if line == 0 || (len(this.lines) > 0 && this.lines[len(this.lines)-1].line == line) {
+		return
+	}
+	this.lines = append(this.lines, lineEntry{pc, line})
+}
+
+// lineAt returns the line of the source that the opcode at pc came from, or 0
+// if that isn't known.
+func (this *vm) lineAt(pc int) int {
+	return findLine(this.lines, pc)
+}
+
+func findLine(lines []lineEntry, pc int) int {
+	idx := sort.Search(len(lines), func(i int) bool { return lines[i].pc > pc })
+	if idx == 0 {
+		return 0
+	}
+	return lines[idx-1].line
+}
+
+// newInlineCache returns the operand for a new inline cache.
+func (this *vm) newInlineCache() operand {
+	this.caches = append(this.caches, inlineCache{})
+	return operand(len(this.caches) - 1)
+}
+
+// constant returns the operand for a constant, adding it to the constant table
+// if it isn't there yet.
+func (this *vm) constant(v value) operand {
+	key := constantKey(v)
+	idx, ok := this.constantIndexes[key]
+	if !ok {
+		idx = len(this.constants)
+		this.constants = append(this.constants, v)
+		this.constantIndexes[key] = idx
+	}
+	return constantOperand(idx)
+}
+
+// constantKey returns what the constant table is indexed by for v. Numbers
+// are keyed by their bits, so that 0 and -0 (and NaNs) are kept apart.
+func constantKey(v value) interface{} {
+	if v.isNumber() {
+		return math.Float64bits(v.num)
+	}
+	return v
+}
+
+var binaryOpcodes = map[tac_op_type]opcode_type{
+	TAC_ADD:                  ADD,
+	TAC_SUB:                  SUB,
+	TAC_MULTIPLY:             MULTIPLY,
+	TAC_DIVIDE:               DIVIDE,
+	TAC_MODULUS:              MODULUS,
+	TAC_EXPONENT:             EXPONENT,
+	TAC_LEFT_SHIFT:           LEFT_SHIFT,
+	TAC_RIGHT_SHIFT:          RIGHT_SHIFT,
+	TAC_UNSIGNED_RIGHT_SHIFT: UNSIGNED_RIGHT_SHIFT,
+	TAC_BITWISE_AND:          BITWISE_AND,
+	TAC_BITWISE_XOR:          BITWISE_XOR,
+	TAC_BITWISE_OR:           BITWISE_OR,
+	TAC_LESS_THAN:            LESS_THAN,
+	TAC_LESS_THAN_EQ:         LESS_THAN_EQ,
+	TAC_GREATER_THAN:         GREATER_THAN,
+	TAC_GREATER_THAN_EQ:      GREATER_THAN_EQ,
+	TAC_EQUALS:               EQUALS,
+	TAC_NOT_EQUALS:           NOT_EQUALS,
+	TAC_STRICT_EQUALS:        STRICT_EQUALS,
+	TAC_STRICT_NOT_EQUALS:    STRICT_NOT_EQUALS,
+	TAC_LOGICAL_AND:          LOGICAL_AND,
+	TAC_LOGICAL_OR:           LOGICAL_OR,
+	TAC_IN:                   IN,
+	TAC_INSTANCEOF:           INSTANCEOF,
+}
+
+var unaryOpcodes = map[tac_op_type]opcode_type{
+	TAC_UPLUS:       UPLUS,
+	TAC_UMINUS:      UMINUS,
+	TAC_LOGICAL_NOT: UNOT,
+	TAC_BITWISE_NOT: BITWISE_NOT,
+}
+
+// A bytecodeGenerator lowers the TAC of a single function to bytecode.
+//
+// Every temporary the function uses gets a register of its own, after the
+// function's locals. Scratch registers come after those, and only live for as
+// long as the TAC instruction they were needed for.
+type bytecodeGenerator struct {
+	vm          *vm
+	code        *[]opcode
+	registers   map[int]int  // temporary -> register
+	numbers     map[int]bool // see inferNumbers
+	scratch     int          // the first scratch register
+	nextScratch int
+	frameSize   int
+	line        int // of the tac being generated
+}
+
+// allocateRegisters assigns registers to the temporaries used in code.
+// Temporaries that are only used as labels don't need one.
+func (this *bytecodeGenerator) allocateRegisters(locals int, code []tac) {
+	this.registers = make(map[int]int)
+	next := locals
+
+	var note func(addr tac_address)
+	note = func(addr tac_address) {
+		if addr.isMember() {
+			note(addr.base())
+			note(*addr.reference)
+		} else if addr.isTemp() {
+			if _, ok := this.registers[addr.temporary]; !ok {
+				this.registers[addr.temporary] = next
+				next++
+			}
 		}
+	}
 
+	for _, op := range code {
+		switch op.op {
+		case TAC_LABEL, TAC_JMP:
+			continue
+		case TAC_JNE:
+			note(op.arg1)
+			continue
+		}
+		note(op.result)
+		note(op.arg1)
+		note(op.arg2)
+	}
+
+	this.scratch = next
+	this.frameSize = next
+}
+
+func (this *bytecodeGenerator) emit(o opcode_type, operands ...operand) {
+	this.vm.noteLine(len(*this.code), this.line)
+	*this.code = append(*this.code, newOpcode(o, operands...))
+}
+
+func (this *bytecodeGenerator) newScratch() operand {
+	reg := this.nextScratch
+	this.nextScratch++
+	if this.nextScratch > this.frameSize {
+		this.frameSize = this.nextScratch
+	}
+	return registerOperand(reg)
+}
+
+func (this *bytecodeGenerator) isScratch(o operand) bool {
+	return !o.isConstant() && int(o) >= this.scratch
+}
+
+// register returns the register for an address that has one.
+func (this *bytecodeGenerator) register(addr tac_address) (operand, bool) {
+	if addr.isTemp() {
+		return registerOperand(this.registers[addr.temporary]), true
+	}
+	if addr.isVar() && addr.slot != -1 {
+		return registerOperand(addr.slot), true
+	}
+	return 0, false
+}
+
+// operand returns an operand holding the value of addr, loading it into a
+// scratch register first if it isn't a register or a constant.
+func (this *bytecodeGenerator) operand(addr tac_address) operand {
+	if reg, ok := this.register(addr); ok {
+		return reg
+	}
+
+	if addr.isMember() {
+		return this.loadMember(this.operand(addr.base()), addr)
 	} else if addr.isVar() {
 		if addr.varname == "undefined" {
-			codebuf = append(codebuf, pushConstant(newConstant(newUndefined()))...)
-		} else if addr.varname == "this" {
-			codebuf = append(codebuf, simpleOp(LOAD_THIS))
+			return this.vm.constant(newUndefined())
+		}
+		dst := this.newScratch()
+		if addr.varname == "this" {
+			this.emit(LOAD_THIS, dst)
 		} else {
-			rhsIdx := float64(appendStringtable(addr.varname))
-			codebuf = append(codebuf, newOpcode(LOAD, rhsIdx))
+			this.emit(LOAD_GLOBAL, dst, operand(this.vm.appendStringtable(addr.varname)))
 		}
+		return dst
 	} else if addr.isConstant() {
-		codebuf = append(codebuf, pushConstant(addr)...)
-	} else if addr.isTemp() {
-		codebuf = append(codebuf, newOpcode(LOAD_TEMPORARY, float64(addr.temporary)))
-	} else {
-		panic(fmt.Sprintf("Unknown address type %+v", addr))
+		return this.vm.constant(addr.constant)
 	}
 
-	return codebuf
+	panic(fmt.Sprintf("Unknown address type %+v", addr))
 }
 
-func maybePushStore(result tac_address) []opcode {
-	codebuf := []opcode{}
+// loadMember loads the member addr refers to from base.
+func (this *bytecodeGenerator) loadMember(base operand, addr tac_address) operand {
+	if name, ok := addr.memberName(); ok {
+		dst := this.newScratch()
+		this.emit(LOAD_MEMBER, dst, base, operand(this.vm.appendStringtable(name)), this.vm.newInlineCache())
+		return dst
+	}
+	key := this.operand(*addr.reference)
+	dst := this.newScratch()
+	this.emit(LOAD_INDEXED, dst, base, key)
+	return dst
+}
+
+// destination returns the register to compute the value of result into, and
+// a function to store it to result afterwards, if result isn't a register.
+func (this *bytecodeGenerator) destination(result tac_address) (operand, func()) {
+	if reg, ok := this.register(result); ok {
+		return reg, func() {}
+	}
+
+	dst := this.newScratch()
 	if result.isMember() {
-		if result.reference.isVar() {
-			codebuf = append(codebuf, pushVarOrConstant(newVar(result.varname))...)
-			memberIdx := appendStringtable(result.reference.varname)
-			codebuf = append(codebuf, newOpcode(STORE_MEMBER, float64(memberIdx)))
-		} else {
-			codebuf = append(codebuf, pushVarOrConstant(*result.reference)...)
-			codebuf = append(codebuf, pushVarOrConstant(newVar(result.varname))...)
-			codebuf = append(codebuf, simpleOp(STORE_INDEXED))
+		return dst, func() {
+			base := this.operand(result.base())
+			if name, ok := result.memberName(); ok {
+				this.emit(STORE_MEMBER, base, operand(this.vm.appendStringtable(name)), dst, this.vm.newInlineCache())
+			} else {
+				this.emit(STORE_INDEXED, base, this.operand(*result.reference), dst)
+			}
 		}
 	} else if result.isVar() {
-		varIdx := appendStringtable(result.varname)
-		codebuf = append(codebuf, newOpcode(STORE, float64(varIdx)))
-	} else if result.isTemp() {
-		codebuf = append(codebuf, newOpcode(STORE_TEMPORARY, float64(result.temporary)))
-	} else {
-		panic(fmt.Sprintf("Unknown address type %+v", result))
+		return dst, func() {
+			this.emit(STORE_GLOBAL, operand(this.vm.appendStringtable(result.varname)), dst)
+		}
 	}
 
-	return codebuf
+	panic(fmt.Sprintf("Unknown address type %+v", result))
 }
 
-func callJsFunction(this *vm, params []valueString, addr int) func(vm *vm, f value, args []value) value {
-	// Small optimisation: intern strings at codegen time, so we don't have to
-	// hash at runtime.
-	intArgs := []int{}
-	for _, arg := range params {
-		intArgs = append(intArgs, appendStringtable(arg.String()))
-	}
+// emitTo emits an instruction computing into result, followed by whatever it
+// takes to store it there.
+func (this *bytecodeGenerator) emitTo(result tac_address, o opcode_type, operands ...operand) {
+	dst, store := this.destination(result)
+	this.emit(o, append([]operand{dst}, operands...)...)
+	store()
+}
 
-	return func(vm *vm, f value, args []value) value {
-		if execDebug {
-			log.Printf("Calling func! IP %d going to %d, %s", vm.ip, addr, args)
-		}
-		// alter the IP of the new stack frame the CALL set up to be in
-		// the function's code.
-		vm.ip = addr
+// callee returns the function to call for a CALL or NEW of addr, and the
+// 'this' to call it with.
+func (this *bytecodeGenerator) callee(addr tac_address) (operand, operand) {
+	if addr.isMember() {
+		base := this.operand(addr.base())
+		return this.loadMember(base, addr), base
+	}
 
-		// bit of a dirty hack here. we tell the VM to ignore the return
-		// value of the builtin function, and instead, wait for the
-		// return instruction to pop the stack.
-		vm.ignoreReturn = true
+	// ### 'this' for a plain call should be undefined
+	fn := this.operand(addr)
+	return fn, fn
+}
 
-		for idx, arg := range intArgs {
-			v := args[idx]
-			vm.defineVar(arg, v)
+func (this *vm) generateBytecode(in []tac) []opcode {
+	// after any code that is already there, see Eval
+	codebuf := this.code
+	for start := 0; start < len(in); {
+		end := start + 1
+		for end < len(in) && in[end-1].op != TAC_END_FUNCTION {
+			end++
 		}
-
-		return newUndefined()
+		this.generateFunctionBytecode(in[start:end], &codebuf)
+		start = end
 	}
+	return codebuf
 }
 
-const codegenDebug = false
+// generateFunctionBytecode generates the code for one function, from its
+// TAC_FUNCTION to its TAC_END_FUNCTION.
+func (this *vm) generateFunctionBytecode(in []tac, codebuf *[]opcode) {
+	fnIdx := in[0].arg2.constant.ToInteger()
+	s := this.programScope
+	if fnIdx != -1 {
+		s = this.scopes[this.funcsToDefine[fnIdx]]
+	}
 
-func (this *vm) generateBytecode(in []tac) []opcode {
-	codebuf := []opcode{}
+	g := &bytecodeGenerator{vm: this, code: codebuf, numbers: inferNumbers(in)}
+	g.allocateRegisters(len(s.slots), in)
 
-	type labelInfo struct {
-		bytecodeOffset int
-	}
-	labels := make(map[tac_address]labelInfo)
-	type jumpInfo struct {
-		label          tac_address
-		bytecodeOffset int
-	}
-	jumps := []jumpInfo{}
-	paramNames := []valueString{}
+	entry := len(*codebuf)
+	labels := make(map[int]int)
+	jumps := []int{}
+	objects := []operand{}
 	paramCount := 0
 
 	for idx, op := range in {
 		if codegenDebug {
 			log.Printf("Generating bytecode for %d: %s", idx, op)
 		}
+		g.nextScratch = g.scratch
+		if op.line != 0 {
+			g.line = op.line
+		}
+
+		if o, ok := binaryOpcodes[op.op]; ok {
+			rhs := g.operand(op.arg2)
+			lhs := g.operand(op.arg1)
+			g.emitTo(op.result, g.specialize(o, op), lhs, rhs)
+			continue
+		}
+		if o, ok := unaryOpcodes[op.op]; ok {
+			g.emitTo(op.result, o, g.operand(op.arg1))
+			continue
+		}
+
 		switch op.op {
 		case TAC_PUSH_PARAM:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
+			g.emit(PUSH_ARG, g.operand(op.arg1))
 			paramCount++
-		case TAC_CALL:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, newOpcode(CALL, float64(paramCount)))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-			paramCount = 0
-		case TAC_NEW:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, newOpcode(NEW, float64(paramCount)))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-			paramCount = 0
-		case TAC_FUNCTION_PARAMETER:
-			if !op.arg1.isConstant() {
-				panic("Not a constant???")
+		case TAC_CALL, TAC_NEW:
+			fn, thisArg := g.callee(op.arg1)
+			o := CALL
+			if op.op == TAC_NEW {
+				o = NEW
 			}
-			paramNames = append(paramNames, op.arg1.constant.(valueString))
+			g.emitTo(op.result, o, fn, thisArg, operand(paramCount))
+			paramCount = 0
 		case TAC_FUNCTION:
-			funcIdx := appendStringtable(op.arg1.constant.String())
-
-			runBuiltin := callJsFunction(this, paramNames, len(codebuf)-1)
-			callFn := newFunctionObject(runBuiltin, runBuiltin)
-			this.defineVar(funcIdx, callFn)
-			paramNames = []valueString{}
-
-			codebuf = append(codebuf, newOpcode(IN_FUNCTION, float64(funcIdx)))
-
-			// Gather all local declarations
-			declaredVars := make(map[int]bool)
-			for _, nop := range in[idx:] {
-				if nop.op == TAC_END_FUNCTION && nop.arg1 == op.arg1 {
-					break
-				}
-
-				if nop.result.isVar() {
-					varIdx := appendStringtable(nop.result.varname)
-					if _, ok := declaredVars[varIdx]; !ok {
-						declaredVars[varIdx] = true
-						codebuf = append(codebuf, newOpcode(DECLARE, float64(varIdx)))
-					}
-				}
-			}
-
+			g.emit(IN_FUNCTION, operand(this.appendStringtable(op.arg1.constant.String())), 0)
 		case TAC_END_FUNCTION:
 			// ignore for now
+		case TAC_USE_STRICT:
+			g.emit(USE_STRICT)
+		case TAC_LOAD_FUNCTION:
+			g.emitTo(op.result, LOAD_FUNCTION, operand(op.arg1.constant.ToInteger()))
 		case TAC_RETURN:
 			if op.arg1.valid {
-				codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
+				g.emit(RETURN, g.operand(op.arg1))
+			} else {
+				g.emit(RETURN, this.constant(newUndefined()))
 			}
-			codebuf = append(codebuf, simpleOp(RETURN))
-		case TAC_LOAD:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, maybePushStore(op.result)...)
 		case TAC_LABEL:
-			labels[op.arg1] = labelInfo{bytecodeOffset: len(codebuf)}
+			labels[op.arg1.temporary] = len(*codebuf)
 		case TAC_DECLARE:
-			// used only to ensure the var is declared. if it had an
-			// initializer, it would be TAC_ASSIGN, so we can ignore it here.
-		case TAC_ASSIGN:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_LESS_THAN:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(LESS_THAN))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_LESS_THAN_EQ:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(LESS_THAN_EQ))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_GREATER_THAN:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(GREATER_THAN))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_GREATER_THAN_EQ:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(GREATER_THAN_EQ))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
+			g.emit(DECLARE, operand(this.appendStringtable(op.result.varname)))
+		case TAC_LOAD, TAC_ASSIGN:
+			src := g.operand(op.arg1)
+			dst, store := g.destination(op.result)
+			if g.isScratch(src) {
+				// load straight into the destination
+				(*codebuf)[len(*codebuf)-1].a = dst
+			} else {
+				g.emit(MOVE, dst, src)
+			}
+			store()
 		case TAC_JNE:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			jumps = append(jumps, jumpInfo{label: op.arg2, bytecodeOffset: len(codebuf)})
-			codebuf = append(codebuf, newOpcode(JNE, 0))
+			cond := g.operand(op.arg1)
+			jumps = append(jumps, len(*codebuf))
+			g.emit(JNE, cond, operand(op.arg2.temporary))
 		case TAC_JMP:
-			jumps = append(jumps, jumpInfo{label: op.arg1, bytecodeOffset: len(codebuf)})
-			codebuf = append(codebuf, newOpcode(JMP, 0))
+			jumps = append(jumps, len(*codebuf))
+			g.emit(JMP, operand(op.arg1.temporary))
 		case TAC_TYPEOF:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(TYPEOF))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_SUB:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(SUB))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_ADD:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(ADD))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_MULTIPLY:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(MULTIPLY))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_DIVIDE:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(DIVIDE))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_MODULUS:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(MODULUS))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_LEFT_SHIFT:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(LEFT_SHIFT))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_RIGHT_SHIFT:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(RIGHT_SHIFT))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_UNSIGNED_RIGHT_SHIFT:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(UNSIGNED_RIGHT_SHIFT))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_BITWISE_AND:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(BITWISE_AND))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_BITWISE_XOR:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(BITWISE_XOR))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_BITWISE_OR:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(BITWISE_OR))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_BITWISE_NOT:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(BITWISE_NOT))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_NOT_EQUALS:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(NOT_EQUALS))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_EQUALS:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(EQUALS))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_STRICT_NOT_EQUALS:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(STRICT_NOT_EQUALS))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_STRICT_EQUALS:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(STRICT_EQUALS))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_LOGICAL_AND:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(LOGICAL_AND))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_LOGICAL_OR:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(LOGICAL_OR))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_LOGICAL_NOT:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(UNOT))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_IN:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(IN))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_INSTANCEOF:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, simpleOp(INSTANCEOF))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
-		case TAC_PUSH_OBJECT_MEMBER:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
-			codebuf = append(codebuf, pushVarOrConstant(op.arg2)...)
-			codebuf = append(codebuf, simpleOp(DEFINE_PROPERTY))
+			if op.arg1.isVar() && op.arg1.slot == -1 && op.arg1.varname != "this" && op.arg1.varname != "undefined" {
+				g.emitTo(op.result, TYPEOF_VAR, operand(this.appendStringtable(op.arg1.varname)))
+			} else {
+				g.emitTo(op.result, TYPEOF, g.operand(op.arg1))
+			}
 		case TAC_NEW_OBJECT:
-			codebuf = append(codebuf, simpleOp(NEW_OBJECT))
+			obj, ok := g.register(op.result)
+			if !ok {
+				panic(fmt.Sprintf("object literal stored to %s", op.result))
+			}
+			objects = append(objects, obj)
+			g.emit(NEW_OBJECT, obj)
+		case TAC_PUSH_OBJECT_MEMBER:
+			key := g.operand(op.arg1)
+			val := g.operand(op.arg2)
+			g.emit(DEFINE_PROPERTY, objects[len(objects)-1], key, val)
 		case TAC_END_OBJECT:
-			codebuf = append(codebuf, maybePushStore(op.result)...)
+			objects = objects[:len(objects)-1]
 		case TAC_PUSH_ARRAY_MEMBER:
-			codebuf = append(codebuf, pushVarOrConstant(op.arg1)...)
+			g.emit(PUSH_ARG, g.operand(op.arg1))
 		case TAC_NEW_ARRAY:
-			codebuf = append(codebuf, newOpcode(PUSH_ARRAY, float64(op.arg1.constant.(valueNumber).ToNumber())))
-			codebuf = append(codebuf, maybePushStore(op.result)...)
+			g.emitTo(op.result, NEW_ARRAY, operand(op.arg1.constant.ToInteger()))
 		default:
 			panic(fmt.Sprintf("unknown tac %s", op))
 		}
 	}
 
 	for _, jmp := range jumps {
-		codebuf[jmp.bytecodeOffset].opdata = opdata(labels[jmp.label].bytecodeOffset - jmp.bytecodeOffset - 1)
+		op := &(*codebuf)[jmp]
+		if op.otype == JMP {
+			op.a = operand(labels[int(op.a)])
+		} else {
+			op.b = operand(labels[int(op.b)])
+		}
 	}
 
-	return codebuf
+	(*codebuf)[entry].b = operand(g.frameSize)
+	if fnIdx != -1 {
+		this.compiled[fnIdx] = compiledFunction{entry: entry, frameSize: g.frameSize, params: len(s.params)}
+	}
+}
+
+// isStrictCode reports whether a directive prologue (the string literal
+// statements a program or function body starts with) contains "use strict".
+func isStrictCode(body []parser.Node) bool {
+	for _, s := range body {
+		es, ok := s.(*parser.ExpressionStatement)
+		if !ok {
+			return false
+		}
+		sl, ok := es.X.(*parser.StringLiteral)
+		if !ok {
+			return false
+		}
+		if sl.String() == "use strict" {
+			return true
+		}
+	}
+	return false
+}
+
+// generateFunctionTAC generates the code for a function (or the program),
+// starting with its hoisted declarations.
+func (this *vm) generateFunctionTAC(s *scope, body []parser.Node, codebuf *[]tac) {
+	this.currentScope = s
+	name := newConstant(newString(s.name()))
+
+	*codebuf = append(*codebuf, tac{arg1: name, op: TAC_FUNCTION, arg2: newConstant(newNumber(float64(s.index)))})
+	if s.strict {
+		*codebuf = append(*codebuf, tac{op: TAC_USE_STRICT})
+	}
+	if s.isProgram() {
+		for _, v := range s.vars {
+			*codebuf = append(*codebuf, tac{result: newVar(v), op: TAC_DECLARE})
+		}
+	}
+	if s.self != "" {
+		fnIdx := newConstant(newNumber(float64(s.index)))
+		*codebuf = append(*codebuf, tac{result: this.resolveVar(s.self), arg1: fnIdx, op: TAC_LOAD_FUNCTION})
+	}
+	for _, fn := range s.functions {
+		fnIdx := newConstant(newNumber(float64(this.scopes[fn].index)))
+		*codebuf = append(*codebuf, tac{result: this.resolveVar(fn.Identifier.String()), arg1: fnIdx, op: TAC_LOAD_FUNCTION})
+	}
+
+	var completion tac_address
+	for _, stmt := range body {
+		completion = this.generateCodeTAC(stmt, codebuf)
+		if _, ok := stmt.(*parser.ExpressionStatement); !ok {
+			completion = tac_address{}
+		}
+	}
+	if s.isProgram() && this.returnCompletion && completion.valid {
+		*codebuf = append(*codebuf, tac{arg1: completion, op: TAC_RETURN})
+	}
+	*codebuf = append(*codebuf, tac{op: TAC_RETURN})
+	*codebuf = append(*codebuf, tac{arg1: name, op: TAC_END_FUNCTION})
 }
 
 func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address {
@@ -580,35 +760,31 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 
 	switch n := node.(type) {
 	case *parser.Program:
-		codebuf = append(codebuf, tac{arg1: newConstant(newString("%main")), op: TAC_FUNCTION})
-		for _, s := range n.Body() {
-			this.generateCodeTAC(s, &codebuf)
-		}
-		codebuf = append(codebuf, tac{op: TAC_RETURN})
-		codebuf = append(codebuf, tac{arg1: newConstant(newString("%main")), op: TAC_END_FUNCTION})
-
+		this.generateFunctionTAC(this.programScope, n.Body(), &codebuf)
 		for _, afunc := range this.funcsToDefine {
-			for _, p := range afunc.Parameters {
-				codebuf = append(codebuf, tac{arg1: newConstant(newString(p.String())), op: TAC_FUNCTION_PARAMETER})
+			if afunc == nil {
+				// compiled already, see Eval
+				continue
 			}
-			codebuf = append(codebuf, tac{arg1: newConstant(newString(afunc.Identifier.String())), op: TAC_FUNCTION})
-			this.generateCodeTAC(afunc.Body, &codebuf)
-			codebuf = append(codebuf, tac{op: TAC_RETURN})
-			codebuf = append(codebuf, tac{arg1: newConstant(newString(afunc.Identifier.String())), op: TAC_END_FUNCTION})
+			this.generateFunctionTAC(this.scopes[afunc], afunc.Body.Body, &codebuf)
 		}
 	case *parser.VariableStatement:
+		// the vars themselves were declared on entering the function
 		for idx, _ := range n.Vars {
 			v := n.Vars[idx]
 			i := n.Initializers[idx]
 
 			if i != nil {
 				exp := this.generateCodeTAC(i, &codebuf)
-				codebuf = append(codebuf, tac{result: newVar(v.String()), arg1: exp, op: TAC_ASSIGN})
-			} else {
-				codebuf = append(codebuf, tac{result: newVar(v.String()), op: TAC_DECLARE})
+				codebuf = append(codebuf, tac{result: this.resolveVar(v.String()), arg1: exp, op: TAC_ASSIGN})
 			}
 		}
 	case *parser.ExpressionStatement:
+		if _, ok := isFunctionDeclaration(n); ok {
+			// hoisted, see generateFunctionTAC
+			break
+		}
+
 		// We generate an assignment here for the case of: var a = 5; a
 		// such that 'a' is loaded back onto the stack for returning.
 		// This might not be correct?
@@ -697,7 +873,10 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 
 	case *parser.ArrayLiteral:
 		for _, elem := range n.Elements {
-			param := this.generateCodeTAC(elem, &codebuf)
+			param := newConstant(arrayHole) // elided, as in [1,,3]
+			if elem != nil {
+				param = this.generateCodeTAC(elem, &codebuf)
+			}
 			codebuf = append(codebuf, tac{op: TAC_PUSH_ARRAY_MEMBER, arg1: param})
 		}
 		retaddr = this.newTemporary()
@@ -729,7 +908,7 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 		retaddr = this.newTemporary()
 		codebuf = append(codebuf, tac{result: retaddr, arg1: newConstant(newString(n.String())), op: TAC_ASSIGN})
 	case *parser.IdentifierLiteral:
-		return newVar(n.String())
+		return this.resolveVar(n.String())
 	case *parser.NumericLiteral:
 		retaddr = this.newTemporary()
 		codebuf = append(codebuf, tac{result: retaddr, arg1: newConstant(newNumber(n.Float64Value())), op: TAC_ASSIGN})
@@ -750,22 +929,17 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 		rref := this.generateCodeTAC(n.Y, &codebuf)
 		codebuf = append(codebuf, tac{result: retaddr, arg1: rref, op: TAC_ASSIGN})
 	case *parser.FunctionExpression:
-		this.funcsToDefine = append(this.funcsToDefine, n)
+		retaddr = this.newTemporary()
+		codebuf = append(codebuf, tac{result: retaddr, arg1: newConstant(newNumber(float64(this.scopes[n].index))), op: TAC_LOAD_FUNCTION})
 	case *parser.NewExpression:
 		c := n.X.(*parser.CallExpression)
-		for _, arg := range c.Arguments {
-			param := this.generateCodeTAC(arg, &codebuf)
-			codebuf = append(codebuf, tac{op: TAC_PUSH_PARAM, arg1: param})
-		}
 		fid := this.generateCodeTAC(c.X, &codebuf)
+		this.generateArgumentsTAC(c.Arguments, &codebuf)
 		retaddr = this.newTemporary()
 		codebuf = append(codebuf, tac{result: retaddr, op: TAC_NEW, arg1: fid})
 	case *parser.CallExpression:
-		for _, arg := range n.Arguments {
-			param := this.generateCodeTAC(arg, &codebuf)
-			codebuf = append(codebuf, tac{op: TAC_PUSH_PARAM, arg1: param})
-		}
 		fid := this.generateCodeTAC(n.X, &codebuf)
+		this.generateArgumentsTAC(n.Arguments, &codebuf)
 		retaddr = this.newTemporary()
 		codebuf = append(codebuf, tac{result: retaddr, op: TAC_CALL, arg1: fid})
 	case *parser.UnaryExpression:
@@ -777,7 +951,7 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 				codebuf = append(codebuf, tac{result: retaddr, arg1: uref, op: TAC_ASSIGN})
 			case parser.MINUS:
 				retaddr = this.newTemporary()
-				codebuf = append(codebuf, tac{result: retaddr, arg1: newConstant(newNumber(0)), op: TAC_SUB, arg2: uref})
+				codebuf = append(codebuf, tac{result: retaddr, arg1: uref, op: TAC_UMINUS})
 			case parser.LOGICAL_NOT:
 				retaddr = this.newTemporary()
 				codebuf = append(codebuf, tac{result: retaddr, arg1: uref, op: TAC_LOGICAL_NOT, arg2: uref})
@@ -836,6 +1010,8 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 			realOp = TAC_DIVIDE
 		case parser.MODULUS_EQ:
 			realOp = TAC_MODULUS
+		case parser.EXPONENT_EQ:
+			realOp = TAC_EXPONENT
 		case parser.LEFT_SHIFT_EQ:
 			realOp = TAC_LEFT_SHIFT
 		case parser.RIGHT_SHIFT_EQ:
@@ -888,6 +1064,8 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 			realOp = TAC_BITWISE_OR
 		case parser.MODULUS:
 			realOp = TAC_MODULUS
+		case parser.EXPONENT:
+			realOp = TAC_EXPONENT
 		case parser.LESS_THAN:
 			realOp = TAC_LESS_THAN
 		case parser.LESS_EQ:
@@ -919,163 +1097,36 @@ func (this *vm) generateCodeTAC(node parser.Node, retcodebuf *[]tac) tac_address
 		codebuf = append(codebuf, tac{result: retaddr, arg1: leftRef, op: realOp, arg2: rightRef})
 
 	case *parser.DotMemberExpression:
-		base := this.generateCodeTAC(n.X, &codebuf)
-		retaddr = newReference(base.varname, newVar(n.Name.String()))
+		base := this.newMemberBase(this.generateCodeTAC(n.X, &codebuf), &codebuf)
+		retaddr = newReference(base, newConstant(newString(n.Name.String())))
 	case *parser.BracketMemberExpression:
-		base := this.generateCodeTAC(n.X, &codebuf)
-		retaddr = newReference(base.varname, this.generateCodeTAC(n.Y, &codebuf))
+		base := this.newMemberBase(this.generateCodeTAC(n.X, &codebuf), &codebuf)
+		retaddr = newReference(base, this.generateCodeTAC(n.Y, &codebuf))
 
 	default:
 		panic(fmt.Sprintf("unknown node %T", node))
 	}
 
-	*retcodebuf = append(*retcodebuf, codebuf...)
-	return retaddr
-}
-
-func optimizeTAC(codebuf *[]tac) {
-	return
-	for i := 0; i < 50; i++ {
-		removeDeadCode(codebuf)
-		simplifyExpression(codebuf)
-		copyPropagation(codebuf)
-	}
-}
-
-func removeDeadCode(retcodebuf *[]tac) {
-	codebuf := *retcodebuf
-
-	// look for temporaries that aren't subsequently used as an RHS
-	// and remove them.
-	for idx, op := range codebuf {
-		if op.result.isTemp() {
-			// Don't eliminate temps that have side effects (function calls)
-			if op.op == TAC_CALL || op.op == TAC_NEW {
-				continue
-			}
-
-			found := false
-			for _, nop := range codebuf[idx:] {
-				if nop.arg1 == op.result || nop.arg2 == op.result {
-					found = true
-				}
-			}
-			if !found {
-				codebuf = append(codebuf[0:idx], codebuf[idx+1:]...)
-			}
+	// the code for nested nodes already has their lines
+	line := parser.Line(node)
+	for idx := range codebuf {
+		if codebuf[idx].line == 0 {
+			codebuf[idx].line = line
 		}
 	}
 
-	// optimize:
-	// return; return -> return
-	// this probably won't crop up in user code, but it does crop up in our own
-	// code generation, as we surround function expressions with a begin/end
-	// function instruction.
-	remove := false
-	for idx, op := range codebuf {
-		if op.op == TAC_RETURN {
-			if remove {
-				codebuf = append(codebuf[0:idx], codebuf[idx+1:]...)
-				remove = false
-			} else {
-				if idx < len(codebuf)-1 && codebuf[idx+1].op == TAC_RETURN {
-					remove = true
-				}
-			}
-		}
-	}
-
-	// optimize:
-	// t_N = a * 5
-	// t_N+1 = t_N
-	//
-	// to:
-	// t_N+1 = a * 5
-	for idx, op := range codebuf {
-		if op.result.isTemp() {
-			// Don't perform this optimization right now, as it breaks with dead code
-			// elimination. If we optimize t_N = NEW, a = t_N to a = NEW, then
-			// we also need to remove the old new instruction below...
-			if op.op == TAC_CALL || op.op == TAC_NEW {
-				continue
-			}
-
-			foundIdx := -1
-			foundCount := 0
-			for nidx, nop := range codebuf[idx:] {
-				if nop.op == TAC_ASSIGN && nop.arg1 == op.result {
-					foundIdx = idx + nidx
-					foundCount++
-				}
-			}
-
-			if foundCount == 1 {
-				codebuf[foundIdx].arg1 = op.arg1
-				codebuf[foundIdx].arg2 = op.arg2
-				codebuf[foundIdx].op = op.op
-				// We could remove the original instruction here, but dead code
-				// elimination will take it for us.
-			}
-		}
-	}
-
-	*retcodebuf = codebuf
+	*retcodebuf = append(*retcodebuf, codebuf...)
+	return retaddr
 }
 
-func simplifyExpression(retcodebuf *[]tac) {
-	codebuf := *retcodebuf
-	zero := newConstant(newNumber(0))
-	for idx, op := range codebuf {
-		// lhs = rhs * 0 -> lhs = 0
-		if op.op == TAC_MULTIPLY {
-			if op.arg1 == zero {
-				op.op = TAC_ASSIGN
-				op.arg1 = zero
-				op.arg2 = tac_address{}
-				codebuf[idx] = op
-			} else if op.arg2 == zero {
-				op.op = TAC_ASSIGN
-				op.arg1 = zero
-				op.arg2 = tac_address{}
-				codebuf[idx] = op
-			}
-		}
-
-		// lhs = rhs + 0 -> lhs = rhs
-		if op.op == TAC_ADD || op.op == TAC_SUB {
-			if op.arg1 == zero {
-				op.op = TAC_ASSIGN
-				op.arg1 = op.arg2
-				op.arg2 = tac_address{}
-				codebuf[idx] = op
-			}
-			if op.arg2 == zero {
-				op.op = TAC_ASSIGN
-				op.arg2 = tac_address{}
-				codebuf[idx] = op
-			}
-		}
+// The PUSH_PARAMs for a call are counted up to the next CALL or NEW, so they
+// must all come after any calls made while evaluating the arguments.
+func (this *vm) generateArgumentsTAC(args []parser.Node, codebuf *[]tac) {
+	params := []tac_address{}
+	for _, arg := range args {
+		params = append(params, this.generateCodeTAC(arg, codebuf))
 	}
-	*retcodebuf = codebuf
-}
-
-func copyPropagation(retcodebuf *[]tac) {
-	codebuf := *retcodebuf
-	for idx, op := range codebuf {
-		// t_1 = 5, t_2 = t_1 ADD 0 => t_2 = 5 ADD 0
-		if op.op == TAC_ASSIGN && op.result.isTemp() {
-			for nidx := 0; nidx < len(codebuf)-idx; nidx++ {
-				nop := codebuf[idx+nidx]
-				if nop.arg1 == op.result {
-					nop.arg1 = op.arg1
-					codebuf[idx+nidx] = nop
-				}
-				if nop.arg2 == op.result {
-					nop.arg2 = op.arg1
-					codebuf[idx+nidx] = nop
-				}
-			}
-		}
+	for _, param := range params {
+		*codebuf = append(*codebuf, tac{op: TAC_PUSH_PARAM, arg1: param})
 	}
-	*retcodebuf = codebuf
 }
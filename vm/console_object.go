@@ -27,16 +27,634 @@
 package vm
 
 import (
-	"log"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// A ConsoleLevel says which of the console methods a message came from.
+type ConsoleLevel int
+
+const (
+	ConsoleLog ConsoleLevel = iota
+	ConsoleInfo
+	ConsoleDebug
+	ConsoleWarn
+	ConsoleError
+)
+
+func (this ConsoleLevel) String() string {
+	switch this {
+	case ConsoleLog:
+		return "log"
+	case ConsoleInfo:
+		return "info"
+	case ConsoleDebug:
+		return "debug"
+	case ConsoleWarn:
+		return "warn"
+	case ConsoleError:
+		return "error"
+	}
+	return fmt.Sprintf("ConsoleLevel(%d)", int(this))
+}
+
+// A Console is where the console object's output goes. Each message is
+// formatted and indented for the current group already, and has no trailing
+// newline, though it may span several lines (console.table, console.trace).
+// The default writes to os.Stdout and os.Stderr; an embedder can replace it
+// with SetConsole.
+type Console interface {
+	Print(level ConsoleLevel, message string)
+}
+
+type writerConsole struct {
+	out    io.Writer
+	errOut io.Writer
+}
+
+// NewConsole returns a Console that writes log, info and debug messages to
+// out, and warnings and errors to errOut.
+func NewConsole(out io.Writer, errOut io.Writer) Console {
+	return writerConsole{out: out, errOut: errOut}
+}
+
+func (this writerConsole) Print(level ConsoleLevel, message string) {
+	w := this.out
+	if level >= ConsoleWarn {
+		w = this.errOut
+	}
+	io.WriteString(w, message+"\n")
+}
+
+// SetConsole replaces where the console object's output goes.
+func (this *vm) SetConsole(c Console) {
+	this.console.out = c
+}
+
+// consoleState is what the console object keeps between calls.
+type consoleState struct {
+	out    Console
+	indent int // for console.group
+	counts map[string]int
+	timers map[string]time.Time
+}
+
+func newConsoleState() consoleState {
+	return consoleState{out: NewConsole(os.Stdout, os.Stderr), counts: make(map[string]int), timers: make(map[string]time.Time)}
+}
+
+// consolePrint hands message to the console, indented for the current group.
+func (this *vm) consolePrint(level ConsoleLevel, message string) {
+	if this.console.indent > 0 {
+		pad := strings.Repeat(" ", this.console.indent)
+		message = pad + strings.Replace(message, "\n", "\n"+pad, -1)
+	}
+	this.console.out.Print(level, message)
+}
+
 func defineConsoleObject(vm *vm) value {
-	consoleO := valueBasicObject{&rootObjectData{&valueBasicObjectData{extensible: true}}}
-	consoleO.defineDefaultProperty(vm, "log", newFunctionObject(console_log, nil), 0)
-	return consoleO
+	consoleO := valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	consoleO.defineDefaultProperty(vm, "log", objectValue(newFunctionObject(console_log, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "info", objectValue(newFunctionObject(console_info, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "debug", objectValue(newFunctionObject(console_debug, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "warn", objectValue(newFunctionObject(console_warn, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "error", objectValue(newFunctionObject(console_error, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "assert", objectValue(newFunctionObject(console_assert, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "count", objectValue(newFunctionObject(console_count, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "countReset", objectValue(newFunctionObject(console_countReset, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "time", objectValue(newFunctionObject(console_time, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "timeLog", objectValue(newFunctionObject(console_timeLog, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "timeEnd", objectValue(newFunctionObject(console_timeEnd, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "group", objectValue(newFunctionObject(console_group, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "groupCollapsed", objectValue(newFunctionObject(console_group, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "groupEnd", objectValue(newFunctionObject(console_groupEnd, nil)), 0)
+	consoleO.defineDefaultProperty(vm, "table", objectValue(newFunctionObject(console_table, nil)), 1)
+	consoleO.defineDefaultProperty(vm, "trace", objectValue(newFunctionObject(console_trace, nil)), 0)
+	return objectValue(consoleO)
 }
 
 func console_log(vm *vm, f value, args []value) value {
-	log.Printf("console.log: %+v", args)
+	vm.consolePrint(ConsoleLog, formatLog(vm, args))
+	return newUndefined()
+}
+
+func console_info(vm *vm, f value, args []value) value {
+	vm.consolePrint(ConsoleInfo, formatLog(vm, args))
+	return newUndefined()
+}
+
+func console_debug(vm *vm, f value, args []value) value {
+	vm.consolePrint(ConsoleDebug, formatLog(vm, args))
 	return newUndefined()
 }
+
+func console_warn(vm *vm, f value, args []value) value {
+	vm.consolePrint(ConsoleWarn, formatLog(vm, args))
+	return newUndefined()
+}
+
+func console_error(vm *vm, f value, args []value) value {
+	vm.consolePrint(ConsoleError, formatLog(vm, args))
+	return newUndefined()
+}
+
+func console_assert(vm *vm, f value, args []value) value {
+	if argument(args, 0).ToBoolean() {
+		return newUndefined()
+	}
+	if len(args) < 2 {
+		vm.consolePrint(ConsoleError, "Assertion failed")
+	} else {
+		vm.consolePrint(ConsoleError, "Assertion failed: "+formatLog(vm, args[1:]))
+	}
+	return newUndefined()
+}
+
+// consoleLabel returns the label that count and time take as their argument.
+func consoleLabel(args []value) string {
+	if l := argument(args, 0); l.kind != kindUndefined {
+		return l.String()
+	}
+	return "default"
+}
+
+func console_count(vm *vm, f value, args []value) value {
+	label := consoleLabel(args)
+	vm.console.counts[label]++
+	vm.consolePrint(ConsoleLog, fmt.Sprintf("%s: %d", label, vm.console.counts[label]))
+	return newUndefined()
+}
+
+func console_countReset(vm *vm, f value, args []value) value {
+	label := consoleLabel(args)
+	if _, ok := vm.console.counts[label]; !ok {
+		vm.consolePrint(ConsoleWarn, fmt.Sprintf("Count for '%s' does not exist", label))
+		return newUndefined()
+	}
+	vm.console.counts[label] = 0
+	return newUndefined()
+}
+
+func console_time(vm *vm, f value, args []value) value {
+	label := consoleLabel(args)
+	if _, ok := vm.console.timers[label]; ok {
+		vm.consolePrint(ConsoleWarn, fmt.Sprintf("Label '%s' already exists for console.time()", label))
+		return newUndefined()
+	}
+	vm.console.timers[label] = vm.clock.Now()
+	return newUndefined()
+}
+
+// consoleElapsed prints how long the timer label has been running, followed
+// by extra, and reports whether there was such a timer.
+func consoleElapsed(vm *vm, label string, extra []value) bool {
+	start, ok := vm.console.timers[label]
+	if !ok {
+		vm.consolePrint(ConsoleWarn, fmt.Sprintf("No such label '%s' for console.timeEnd()", label))
+		return false
+	}
+	ms := float64(vm.clock.Now().Sub(start)) / float64(time.Millisecond)
+	msg := fmt.Sprintf("%s: %.3fms", label, ms)
+	if len(extra) > 0 {
+		msg += " " + formatLog(vm, extra)
+	}
+	vm.consolePrint(ConsoleLog, msg)
+	return true
+}
+
+func console_timeLog(vm *vm, f value, args []value) value {
+	var extra []value
+	if len(args) > 1 {
+		extra = args[1:]
+	}
+	consoleElapsed(vm, consoleLabel(args), extra)
+	return newUndefined()
+}
+
+func console_timeEnd(vm *vm, f value, args []value) value {
+	label := consoleLabel(args)
+	if consoleElapsed(vm, label, nil) {
+		delete(vm.console.timers, label)
+	}
+	return newUndefined()
+}
+
+func console_group(vm *vm, f value, args []value) value {
+	if len(args) > 0 {
+		vm.consolePrint(ConsoleLog, formatLog(vm, args))
+	}
+	vm.console.indent += 2
+	return newUndefined()
+}
+
+func console_groupEnd(vm *vm, f value, args []value) value {
+	if vm.console.indent > 0 {
+		vm.console.indent -= 2
+	}
+	return newUndefined()
+}
+
+func console_trace(vm *vm, f value, args []value) value {
+	msg := "Trace"
+	if len(args) > 0 {
+		msg += ": " + formatLog(vm, args)
+	}
+	for _, frame := range vm.stackTrace() {
+		msg += "\n    at " + frame
+	}
+	vm.consolePrint(ConsoleError, msg)
+	return newUndefined()
+}
+
+func console_table(vm *vm, f value, args []value) value {
+	data := argument(args, 0)
+	if data.kind != kindObject {
+		return console_log(vm, f, args)
+	}
+
+	// rows are the data's own properties (or elements); the columns are the
+	// properties of those that are objects, and a Values column for those
+	// that aren't.
+	var rows []string
+	var columns []string
+	hasColumn := make(map[string]bool)
+	hasValues := false
+	cells := make(map[string]map[string]string)
+	for _, row := range inspectKeys(vm, data.obj) {
+		rows = append(rows, row.name)
+		cells[row.name] = make(map[string]string)
+		if row.accessor || row.value.kind != kindObject {
+			hasValues = true
+			cells[row.name]["\x00values"] = inspectValue(vm, row, 1, nil)
+			continue
+		}
+		for _, col := range inspectKeys(vm, row.value.obj) {
+			if !hasColumn[col.name] {
+				hasColumn[col.name] = true
+				columns = append(columns, col.name)
+			}
+			cells[row.name][col.name] = inspectValue(vm, col, 1, nil)
+		}
+	}
+
+	header := append([]string{"(index)"}, columns...)
+	keys := append([]string{""}, columns...)
+	if hasValues {
+		header = append(header, "Values")
+		keys = append(keys, "\x00values")
+	}
+	table := make([][]string, len(rows))
+	for i, row := range rows {
+		table[i] = make([]string, len(keys))
+		table[i][0] = row
+		for j := 1; j < len(keys); j++ {
+			table[i][j] = cells[row][keys[j]]
+		}
+	}
+	vm.consolePrint(ConsoleLog, renderTable(header, table))
+	return newUndefined()
+}
+
+// renderTable draws a box around header and rows, with each cell centered.
+func renderTable(header []string, rows [][]string) string {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = utf8.RuneCountInString(h) + 2
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if w := utf8.RuneCountInString(cell) + 2; w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	line := func(left, mid, right string) string {
+		parts := make([]string, len(widths))
+		for i, w := range widths {
+			parts[i] = strings.Repeat("─", w)
+		}
+		return left + strings.Join(parts, mid) + right
+	}
+	renderRow := func(cells []string) string {
+		parts := make([]string, len(widths))
+		for i, w := range widths {
+			pad := w - utf8.RuneCountInString(cells[i])
+			parts[i] = strings.Repeat(" ", pad/2) + cells[i] + strings.Repeat(" ", pad-pad/2)
+		}
+		return "│" + strings.Join(parts, "│") + "│"
+	}
+
+	out := []string{line("┌", "┬", "┐"), renderRow(header), line("├", "┼", "┤")}
+	for _, row := range rows {
+		out = append(out, renderRow(row))
+	}
+	out = append(out, line("└", "┴", "┘"))
+	return strings.Join(out, "\n")
+}
+
+//////////////////////////////////////
+// formatting
+//////////////////////////////////////
+
+// formatLog turns the arguments to console.log and friends into a message.
+// If the first is a string, it may have printf-style substitutions in it for
+// the arguments that follow; any left over are appended, like the rest.
+func formatLog(vm *vm, args []value) string {
+	var parts []string
+	if len(args) > 0 && args[0].kind == kindString {
+		format := args[0].String()
+		var sb strings.Builder
+		next := 1
+		for i := 0; i < len(format); i++ {
+			if format[i] != '%' || i+1 == len(format) {
+				sb.WriteByte(format[i])
+				continue
+			}
+			verb := format[i+1]
+			if verb == '%' {
+				sb.WriteByte('%')
+				i++
+				continue
+			}
+			if next >= len(args) || !strings.ContainsRune("sdifoOc", rune(verb)) {
+				sb.WriteByte('%')
+				continue
+			}
+			arg := args[next]
+			next++
+			i++
+			switch verb {
+			case 's':
+				if arg.kind == kindObject {
+					sb.WriteString(inspect(vm, arg, 1, nil))
+				} else {
+					sb.WriteString(displayValue(vm, arg))
+				}
+			case 'd', 'i':
+				n := math.NaN()
+				if arg.kind != kindObject {
+					n = arg.ToNumber()
+				}
+				if verb == 'i' {
+					n = math.Trunc(n)
+				}
+				sb.WriteString(formatNumber(n))
+			case 'f':
+				n := math.NaN()
+				if arg.kind != kindObject {
+					n = arg.ToNumber()
+				}
+				sb.WriteString(formatNumber(n))
+			case 'o', 'O':
+				sb.WriteString(inspect(vm, arg, 0, nil))
+			case 'c':
+				// CSS, which has nowhere to go
+			}
+		}
+		parts = append(parts, sb.String())
+		args = args[next:]
+	}
+	for _, arg := range args {
+		parts = append(parts, displayValue(vm, arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// displayValue formats v as a top level argument to console.log: strings are
+// printed as they are, and everything else is inspected.
+func displayValue(vm *vm, v value) string {
+	if v.kind == kindString {
+		return v.String()
+	}
+	return inspect(vm, v, 0, nil)
+}
+
+// Inspect formats v the way console.log shows it inside an object or array,
+// with strings quoted.
+func (this *vm) Inspect(v value) string {
+	return inspect(this, v, 0, nil)
+}
+
+// how deep inspect goes into nested objects before abbreviating them.
+const inspectDepth = 2
+
+// how many elements of an array inspect prints.
+const inspectMaxElements = 100
+
+// inspect formats v the way console.log shows a value nested inside another:
+// strings are quoted, and objects and arrays are shown with their contents,
+// down to inspectDepth. seen holds the objects being printed further out, to
+// find cycles.
+func inspect(vm *vm, v value, depth int, seen []valueObject) string {
+	switch v.kind {
+	case kindUndefined:
+		return "undefined"
+	case kindNull:
+		return "null"
+	case kindBool:
+		if v.ToBoolean() {
+			return "true"
+		}
+		return "false"
+	case kindNumber:
+		return formatNumber(v.ToNumber())
+	case kindString:
+		return quoteString(v.String())
+	case kindObject:
+	default:
+		return v.String()
+	}
+
+	for _, s := range seen {
+		if sameObject(s, v.obj) {
+			return "[Circular]"
+		}
+	}
+
+	switch o := v.obj.(type) {
+	case functionObject:
+		return "[Function]"
+	case stringObject:
+		return "[String: " + quoteString(o.primitiveData) + "]"
+	case valueBasicObject:
+		switch d := o.odata.(type) {
+		case *numberObjectData:
+			return "[Number: " + formatNumber(d.primitiveData) + "]"
+		case *booleanObjectData:
+			return "[Boolean: " + strconv.FormatBool(d.primitiveData) + "]"
+		case *dateObjectData:
+			if math.IsNaN(d.primitiveData) {
+				return "Invalid Date"
+			}
+			return formatISOString(d.primitiveData)
+		}
+	}
+
+	a, isArray := v.obj.(arrayObject)
+	if depth > inspectDepth {
+		if isArray {
+			return "[Array]"
+		}
+		return "[Object]"
+	}
+	seen = append(seen, v.obj)
+
+	var parts []string
+	if isArray {
+		parts = inspectElements(vm, a.primitiveData, depth, seen)
+	}
+	for _, prop := range inspectKeys(vm, v.obj) {
+		if isArray {
+			if _, isIndex := arrayIndex(newString(prop.name)); isIndex {
+				continue
+			}
+		}
+		parts = append(parts, formatKey(prop.name)+": "+inspectValue(vm, prop, depth+1, seen))
+	}
+
+	if isArray {
+		if len(parts) == 0 {
+			return "[]"
+		}
+		return "[ " + strings.Join(parts, ", ") + " ]"
+	}
+	if len(parts) == 0 {
+		return "{}"
+	}
+	return "{ " + strings.Join(parts, ", ") + " }"
+}
+
+// inspectElements formats the elements of an array, with runs of holes
+// counted rather than shown one by one.
+func inspectElements(vm *vm, ad *valueArrayData, depth int, seen []valueObject) []string {
+	var parts []string
+	holes := 0
+	flushHoles := func() {
+		if holes == 1 {
+			parts = append(parts, "<1 empty item>")
+		} else if holes > 1 {
+			parts = append(parts, fmt.Sprintf("<%d empty items>", holes))
+		}
+		holes = 0
+	}
+	for idx := uint32(0); idx < ad.length; idx++ {
+		if len(parts) == inspectMaxElements {
+			flushHoles()
+			parts = append(parts, fmt.Sprintf("... %d more items", ad.length-idx))
+			return parts
+		}
+		v, ok := ad.Get(idx)
+		if !ok {
+			if ad.sparse != nil {
+				// skip straight to the next element
+				next := ad.length
+				for i := range ad.sparse {
+					if i > idx && i < next {
+						next = i
+					}
+				}
+				holes += int(next - idx)
+				idx = next - 1
+				continue
+			}
+			holes++
+			continue
+		}
+		flushHoles()
+		parts = append(parts, inspect(vm, v, depth+1, seen))
+	}
+	flushHoles()
+	return parts
+}
+
+type inspectKey struct {
+	name     string
+	value    value
+	accessor bool // value is meaningless, as it has a getter or setter
+}
+
+// inspectValue formats the value of the property k.
+func inspectValue(vm *vm, k inspectKey, depth int, seen []valueObject) string {
+	if k.accessor {
+		return "[Getter/Setter]"
+	}
+	return inspect(vm, k.value, depth, seen)
+}
+
+// inspectKeys returns o's own enumerable properties, with an array's
+// elements first.
+func inspectKeys(vm *vm, o valueObject) []inspectKey {
+	var keys []inspectKey
+	if a, ok := o.(arrayObject); ok {
+		for _, idx := range a.primitiveData.indices(0, a.primitiveData.length) {
+			v, _ := a.primitiveData.Get(idx)
+			keys = append(keys, inspectKey{name: strconv.FormatUint(uint64(idx), 10), value: v})
+		}
+	}
+	for _, pd := range o.objectData().Properties() {
+		if !pd.enumerable {
+			continue
+		}
+		keys = append(keys, inspectKey{name: pd.name, value: pd.value, accessor: pd.hasGet || pd.hasSet})
+	}
+	return keys
+}
+
+// sameObject reports whether a and b are the same object.
+func sameObject(a valueObject, b valueObject) bool {
+	return a.objectData().Storage() == b.objectData().Storage()
+}
+
+// formatKey formats a property name, quoting it if it isn't an identifier.
+func formatKey(name string) string {
+	for i, r := range name {
+		if r == '_' || r == '$' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return quoteString(name)
+	}
+	if name == "" {
+		return "''"
+	}
+	return name
+}
+
+// quoteString quotes s in single quotes, escaping what needs to be.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'':
+			sb.WriteString("\\'")
+		case '\\':
+			sb.WriteString("\\\\")
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+// formatNumber formats n as JavaScript would print it, which is as ToString
+// would, but for showing -0 as it is.
+func formatNumber(n float64) string {
+	if n == 0 && math.Signbit(n) {
+		return "-0"
+	}
+	return numberToString(n)
+}
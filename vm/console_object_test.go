@@ -0,0 +1,223 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stvp/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingConsole struct {
+	lines []string
+}
+
+func (this *recordingConsole) Print(level ConsoleLevel, message string) {
+	this.lines = append(this.lines, fmt.Sprintf("%s: %s", level, message))
+}
+
+// runConsole runs code, and returns what it printed to the console.
+func runConsole(t *testing.T, code string) []string {
+	c := &recordingConsole{}
+	v := New(code)
+	v.SetConsole(c)
+	mustRun(t, v)
+	return c.lines
+}
+
+func TestConsoleFormatting(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`console.log("hello", "world")`, "hello world"},
+		{`console.log(1, 1.5, -0, 0 / 0, Math.pow(10, 21), 1 / 10000000)`, "1 1.5 -0 NaN 1e+21 1e-7"},
+		{`console.log(true, null, undefined)`, "true null undefined"},
+		{`console.log([1, "two", [3]])`, "[ 1, 'two', [ 3 ] ]"},
+		{`console.log([])`, "[]"},
+		{`var a = [1]; a[3] = 4; console.log(a)`, "[ 1, <2 empty items>, 4 ]"},
+		{`var a = []; a[100000] = 1; console.log(a)`, "[ <100000 empty items>, 1 ]"},
+		{`console.log({a: 1, b: "it's", "c-d": {e: [true]}})`, "{ a: 1, b: 'it\\'s', 'c-d': { e: [ true ] } }"},
+		{`console.log({})`, "{}"},
+		{`console.log({a: {b: {c: {d: 1}}, x: [[[1]]]}})`, "{ a: { b: { c: [Object] }, x: [ [Array] ] } }"},
+		{`var o = {a: 1}; o.self = o; console.log(o)`, "{ a: 1, self: [Circular] }"},
+		{`var a = [1]; a.push(a); console.log(a)`, "[ 1, [Circular] ]"},
+		{`var o = {}; console.log([o, o])`, "[ {}, {} ]"},
+		{`console.log(function() {}, [Math.max])`, "[Function] [ [Function] ]"},
+		{`console.log(new Number(3), new String("s"), new Boolean(false))`, "[Number: 3] [String: 's'] [Boolean: false]"},
+		{`console.log(new Date(0))`, "1970-01-01T00:00:00.000Z"},
+		{`console.log(new Date(0 / 0))`, "Invalid Date"},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			assert.Equal(t, runConsole(t, test.code), []string{"log: " + test.expected})
+		})
+	}
+}
+
+func TestConsoleSubstitution(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`console.log("%s is %d years", "Bob", 42)`, "Bob is 42 years"},
+		{`console.log("%i %f", 4.7, "4.7")`, "4 4.7"},
+		{`console.log("%d", {})`, "NaN"},
+		{`console.log("%o and %O", [1], {a: "b"})`, "[ 1 ] and { a: 'b' }"},
+		{`console.log("%s", {a: "b"})`, "{ a: 'b' }"},
+		{`console.log("%c styled", "color: red")`, " styled"},
+		{`console.log("100%% %s")`, "100% %s"},
+		{`console.log("%x %s", 1, 2)`, "%x 1 2"},
+		{`console.log("a", "b", 3)`, "a b 3"},
+		{`console.log(1, "%s", 2)`, "1 %s 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			assert.Equal(t, runConsole(t, test.code), []string{"log: " + test.expected})
+		})
+	}
+}
+
+func TestConsoleLevels(t *testing.T) {
+	lines := runConsole(t, `
+		console.log("l"); console.info("i"); console.debug("d")
+		console.warn("w"); console.error("e")
+		console.assert(true, "not printed")
+		console.assert(false)
+		console.assert(0, "%s failed", "this")
+	`)
+	assert.Equal(t, lines, []string{
+		"log: l", "info: i", "debug: d", "warn: w", "error: e",
+		"error: Assertion failed",
+		"error: Assertion failed: this failed",
+	})
+}
+
+func TestConsoleCount(t *testing.T) {
+	lines := runConsole(t, `
+		console.count(); console.count(); console.count("x")
+		console.countReset(); console.count()
+		console.countReset("nope")
+	`)
+	assert.Equal(t, lines, []string{
+		"log: default: 1", "log: default: 2", "log: x: 1", "log: default: 1",
+		"warn: Count for 'nope' does not exist",
+	})
+}
+
+type steppingClock struct {
+	now time.Time
+}
+
+func (this *steppingClock) Now() time.Time {
+	this.now = this.now.Add(1500 * time.Microsecond)
+	return this.now
+}
+
+func (this *steppingClock) Location() *time.Location {
+	return time.UTC
+}
+
+func TestConsoleTime(t *testing.T) {
+	c := &recordingConsole{}
+	v := New(`
+		console.time("t")
+		console.timeLog("t", "so far")
+		console.timeEnd("t")
+		console.timeEnd("t")
+	`)
+	v.SetConsole(c)
+	v.SetClock(&steppingClock{})
+	mustRun(t, v)
+	assert.Equal(t, c.lines, []string{
+		"log: t: 1.500ms so far",
+		"log: t: 3.000ms",
+		"warn: No such label 't' for console.timeEnd()",
+	})
+}
+
+func TestConsoleGroup(t *testing.T) {
+	lines := runConsole(t, `
+		console.group("outer")
+		console.log("a")
+		console.group()
+		console.log({b: 1})
+		console.groupEnd()
+		console.groupEnd()
+		console.groupEnd()
+		console.log("c")
+	`)
+	assert.Equal(t, lines, []string{"log: outer", "log:   a", "log:     { b: 1 }", "log: c"})
+}
+
+func TestConsoleTable(t *testing.T) {
+	lines := runConsole(t, `console.table([{a: 1, b: "Y"}, {a: "Z", c: 2}, 3])`)
+	assert.Equal(t, lines, []string{"log: " + strings.Join([]string{
+		"┌─────────┬─────┬─────┬───┬────────┐",
+		"│ (index) │  a  │  b  │ c │ Values │",
+		"├─────────┼─────┼─────┼───┼────────┤",
+		"│    0    │  1  │ 'Y' │   │        │",
+		"│    1    │ 'Z' │     │ 2 │        │",
+		"│    2    │     │     │   │   3    │",
+		"└─────────┴─────┴─────┴───┴────────┘",
+	}, "\n")})
+
+	lines = runConsole(t, `console.table("not tabular")`)
+	assert.Equal(t, lines, []string{"log: not tabular"})
+}
+
+func TestConsoleTrace(t *testing.T) {
+	p, err := Compile(`function inner() {
+		console.trace("here")
+	}
+	function outer() {
+		inner()
+	}
+	outer()
+	`, "test")
+	assert.Nil(t, err)
+	c := &recordingConsole{}
+	v := NewFromProgram(p)
+	v.SetConsole(c)
+	mustRun(t, v)
+	assert.Equal(t, c.lines, []string{"error: Trace: here\n" +
+		"    at inner (test:2)\n" +
+		"    at outer (test:5)\n" +
+		"    at <main> (test:7)"})
+}
+
+func TestNewConsole(t *testing.T) {
+	var out, errOut bytes.Buffer
+	v := New(`console.log("out"); console.error("err"); console.info("info")`)
+	v.SetConsole(NewConsole(&out, &errOut))
+	mustRun(t, v)
+	assert.Equal(t, out.String(), "out\ninfo\n")
+	assert.Equal(t, errOut.String(), "err\n")
+}
@@ -0,0 +1,932 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// A Clock supplies Date with the current time and the local time zone. The
+// default reads both from the host; an embedder can replace it with SetClock,
+// for instance to pin them in tests.
+type Clock interface {
+	Now() time.Time
+	Location() *time.Location
+}
+
+type hostClock struct{}
+
+func (this hostClock) Now() time.Time {
+	return time.Now()
+}
+
+func (this hostClock) Location() *time.Location {
+	return time.Local
+}
+
+// SetClock replaces the clock and time zone used by Date.
+func (this *vm) SetClock(c Clock) {
+	this.clock = c
+}
+
+type dateObjectData struct {
+	*valueBasicObjectData
+	primitiveData float64 // [[PrimitiveValue]], the time value in ms since the epoch
+}
+
+func (this *dateObjectData) Prototype(vm *vm) *valueBasicObject {
+	return &vm.dateProto
+}
+
+func newDateObject(t float64) valueBasicObject {
+	return valueBasicObject{&dateObjectData{newValueBasicObjectData(), t}}
+}
+
+func defineDateCtor(vm *vm) functionObject {
+	vm.dateProto = valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	vm.dateProto.defineDefaultProperty(vm, "toString", objectValue(newFunctionObject(date_prototype_toString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toDateString", objectValue(newFunctionObject(date_prototype_toDateString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toTimeString", objectValue(newFunctionObject(date_prototype_toTimeString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toLocaleString", objectValue(newFunctionObject(date_prototype_toString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toLocaleDateString", objectValue(newFunctionObject(date_prototype_toDateString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toLocaleTimeString", objectValue(newFunctionObject(date_prototype_toTimeString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toUTCString", objectValue(newFunctionObject(date_prototype_toUTCString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toGMTString", objectValue(newFunctionObject(date_prototype_toUTCString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toISOString", objectValue(newFunctionObject(date_prototype_toISOString, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "toJSON", objectValue(newFunctionObject(date_prototype_toJSON, nil)), 1)
+	vm.dateProto.defineDefaultProperty(vm, "valueOf", objectValue(newFunctionObject(date_prototype_getTime, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getTime", objectValue(newFunctionObject(date_prototype_getTime, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getTimezoneOffset", objectValue(newFunctionObject(date_prototype_getTimezoneOffset, nil)), 0)
+
+	vm.dateProto.defineDefaultProperty(vm, "getFullYear", objectValue(newFunctionObject(date_prototype_getFullYear, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getYear", objectValue(newFunctionObject(date_prototype_getYear, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getMonth", objectValue(newFunctionObject(date_prototype_getMonth, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getDate", objectValue(newFunctionObject(date_prototype_getDate, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getDay", objectValue(newFunctionObject(date_prototype_getDay, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getHours", objectValue(newFunctionObject(date_prototype_getHours, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getMinutes", objectValue(newFunctionObject(date_prototype_getMinutes, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getSeconds", objectValue(newFunctionObject(date_prototype_getSeconds, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getMilliseconds", objectValue(newFunctionObject(date_prototype_getMilliseconds, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCFullYear", objectValue(newFunctionObject(date_prototype_getUTCFullYear, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCMonth", objectValue(newFunctionObject(date_prototype_getUTCMonth, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCDate", objectValue(newFunctionObject(date_prototype_getUTCDate, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCDay", objectValue(newFunctionObject(date_prototype_getUTCDay, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCHours", objectValue(newFunctionObject(date_prototype_getUTCHours, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCMinutes", objectValue(newFunctionObject(date_prototype_getUTCMinutes, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCSeconds", objectValue(newFunctionObject(date_prototype_getUTCSeconds, nil)), 0)
+	vm.dateProto.defineDefaultProperty(vm, "getUTCMilliseconds", objectValue(newFunctionObject(date_prototype_getUTCMilliseconds, nil)), 0)
+
+	vm.dateProto.defineDefaultProperty(vm, "setTime", objectValue(newFunctionObject(date_prototype_setTime, nil)), 1)
+	vm.dateProto.defineDefaultProperty(vm, "setFullYear", objectValue(newFunctionObject(date_prototype_setFullYear, nil)), 3)
+	vm.dateProto.defineDefaultProperty(vm, "setYear", objectValue(newFunctionObject(date_prototype_setYear, nil)), 1)
+	vm.dateProto.defineDefaultProperty(vm, "setMonth", objectValue(newFunctionObject(date_prototype_setMonth, nil)), 2)
+	vm.dateProto.defineDefaultProperty(vm, "setDate", objectValue(newFunctionObject(date_prototype_setDate, nil)), 1)
+	vm.dateProto.defineDefaultProperty(vm, "setHours", objectValue(newFunctionObject(date_prototype_setHours, nil)), 4)
+	vm.dateProto.defineDefaultProperty(vm, "setMinutes", objectValue(newFunctionObject(date_prototype_setMinutes, nil)), 3)
+	vm.dateProto.defineDefaultProperty(vm, "setSeconds", objectValue(newFunctionObject(date_prototype_setSeconds, nil)), 2)
+	vm.dateProto.defineDefaultProperty(vm, "setMilliseconds", objectValue(newFunctionObject(date_prototype_setMilliseconds, nil)), 1)
+	vm.dateProto.defineDefaultProperty(vm, "setUTCFullYear", objectValue(newFunctionObject(date_prototype_setUTCFullYear, nil)), 3)
+	vm.dateProto.defineDefaultProperty(vm, "setUTCMonth", objectValue(newFunctionObject(date_prototype_setUTCMonth, nil)), 2)
+	vm.dateProto.defineDefaultProperty(vm, "setUTCDate", objectValue(newFunctionObject(date_prototype_setUTCDate, nil)), 1)
+	vm.dateProto.defineDefaultProperty(vm, "setUTCHours", objectValue(newFunctionObject(date_prototype_setUTCHours, nil)), 4)
+	vm.dateProto.defineDefaultProperty(vm, "setUTCMinutes", objectValue(newFunctionObject(date_prototype_setUTCMinutes, nil)), 3)
+	vm.dateProto.defineDefaultProperty(vm, "setUTCSeconds", objectValue(newFunctionObject(date_prototype_setUTCSeconds, nil)), 2)
+	vm.dateProto.defineDefaultProperty(vm, "setUTCMilliseconds", objectValue(newFunctionObject(date_prototype_setUTCMilliseconds, nil)), 1)
+
+	dateO := newFunctionObject(date_call, date_ctor)
+	dateO.prototype = &vm.dateProto
+	dateO.defineDefaultProperty(vm, "now", objectValue(newFunctionObject(date_now, nil)), 0)
+	dateO.defineDefaultProperty(vm, "parse", objectValue(newFunctionObject(date_parse, nil)), 1)
+	dateO.defineDefaultProperty(vm, "UTC", objectValue(newFunctionObject(date_UTC, nil)), 7)
+
+	vm.dateProto.defineDefaultProperty(vm, "constructor", objectValue(dateO), 0)
+	return dateO
+}
+
+//////////////////////////////////////
+// time value arithmetic (ES5 15.9.1)
+//////////////////////////////////////
+
+const (
+	msPerSecond = 1000.0
+	msPerMinute = 60000.0
+	msPerHour   = 3600000.0
+	msPerDay    = 86400000.0
+
+	// ES5 15.9.1.1: time values are within 100,000,000 days of the epoch.
+	maxTimeValue = 8.64e15
+)
+
+// Days before the first of each month in a common year.
+var daysBeforeMonth = [...]float64{0, 31, 59, 90, 120, 151, 181, 212, 243, 273, 304, 334, 365}
+
+var weekDayNames = [...]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+var monthNames = [...]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// positive remainder, as used throughout the spec's date algorithms
+func dateMod(a, b float64) float64 {
+	r := math.Mod(a, b)
+	if r < 0 {
+		r += b
+	}
+	return r
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+func day(t float64) float64 {
+	return math.Floor(t / msPerDay)
+}
+
+func timeWithinDay(t float64) float64 {
+	return dateMod(t, msPerDay)
+}
+
+func daysInYear(y float64) float64 {
+	if math.Mod(y, 4) != 0 {
+		return 365
+	}
+	if math.Mod(y, 100) != 0 {
+		return 366
+	}
+	if math.Mod(y, 400) != 0 {
+		return 365
+	}
+	return 366
+}
+
+func dayFromYear(y float64) float64 {
+	return 365*(y-1970) + math.Floor((y-1969)/4) - math.Floor((y-1901)/100) + math.Floor((y-1601)/400)
+}
+
+func timeFromYear(y float64) float64 {
+	return msPerDay * dayFromYear(y)
+}
+
+func yearFromTime(t float64) float64 {
+	y := math.Floor(t/(msPerDay*365.2425)) + 1970
+	for timeFromYear(y) > t {
+		y--
+	}
+	for timeFromYear(y+1) <= t {
+		y++
+	}
+	return y
+}
+
+func inLeapYear(t float64) bool {
+	return daysInYear(yearFromTime(t)) == 366
+}
+
+func dayWithinYear(t float64) float64 {
+	return day(t) - dayFromYear(yearFromTime(t))
+}
+
+// the number of days before the first of month m (0-11) in a year
+func daysBeforeMonthInYear(m int, leap bool) float64 {
+	d := daysBeforeMonth[m]
+	if leap && m >= 2 {
+		d++
+	}
+	return d
+}
+
+func monthFromTime(t float64) float64 {
+	d := dayWithinYear(t)
+	leap := inLeapYear(t)
+	m := 0
+	for m < 11 && d >= daysBeforeMonthInYear(m+1, leap) {
+		m++
+	}
+	return float64(m)
+}
+
+func dateFromTime(t float64) float64 {
+	m := int(monthFromTime(t))
+	return dayWithinYear(t) - daysBeforeMonthInYear(m, inLeapYear(t)) + 1
+}
+
+func weekDay(t float64) float64 {
+	return dateMod(day(t)+4, 7)
+}
+
+func hourFromTime(t float64) float64 {
+	return dateMod(math.Floor(t/msPerHour), 24)
+}
+
+func minFromTime(t float64) float64 {
+	return dateMod(math.Floor(t/msPerMinute), 60)
+}
+
+func secFromTime(t float64) float64 {
+	return dateMod(math.Floor(t/msPerSecond), 60)
+}
+
+func msFromTime(t float64) float64 {
+	return dateMod(t, msPerSecond)
+}
+
+// ES5 15.9.1.11
+func makeTime(hour, min, sec, ms float64) float64 {
+	if !isFinite(hour) || !isFinite(min) || !isFinite(sec) || !isFinite(ms) {
+		return math.NaN()
+	}
+	return toInteger(hour)*msPerHour + toInteger(min)*msPerMinute + toInteger(sec)*msPerSecond + toInteger(ms)
+}
+
+// ES5 15.9.1.12
+func makeDay(year, month, date float64) float64 {
+	if !isFinite(year) || !isFinite(month) || !isFinite(date) {
+		return math.NaN()
+	}
+	y := toInteger(year)
+	m := toInteger(month)
+	dt := toInteger(date)
+	ym := y + math.Floor(m/12)
+	mn := int(dateMod(m, 12))
+
+	// Anything this far out is going to be clipped anyway, and would only
+	// lose precision below.
+	if math.Abs(ym) > 400000 {
+		return math.NaN()
+	}
+
+	leap := daysInYear(ym) == 366
+	return dayFromYear(ym) + daysBeforeMonthInYear(mn, leap) + dt - 1
+}
+
+// ES5 15.9.1.13
+func makeDate(day, time float64) float64 {
+	if !isFinite(day) || !isFinite(time) {
+		return math.NaN()
+	}
+	return day*msPerDay + time
+}
+
+// ES5 15.9.1.14
+func timeClip(t float64) float64 {
+	if !isFinite(t) || math.Abs(t) > maxTimeValue {
+		return math.NaN()
+	}
+	return toInteger(t) + 0 // + 0 turns -0 into +0
+}
+
+// The offset of the local time zone from UTC, in ms, at the UTC time t. This
+// covers both LocalTZA and DaylightSavingTA (ES5 15.9.1.7, 15.9.1.8), since
+// the host's zone database already knows about both.
+func (this *vm) localOffset(t float64) float64 {
+	if !isFinite(t) || math.Abs(t) > 2*maxTimeValue {
+		return 0
+	}
+	_, offset := goTime(t).In(this.clock.Location()).Zone()
+	return float64(offset) * msPerSecond
+}
+
+// Converts a (finite) time value to a time.Time.
+func goTime(t float64) time.Time {
+	sec := math.Floor(t / msPerSecond)
+	return time.Unix(int64(sec), int64(t-sec*msPerSecond)*int64(time.Millisecond))
+}
+
+// The time value of a time.Time.
+func timeValue(t time.Time) float64 {
+	return float64(t.Unix())*msPerSecond + float64(t.Nanosecond()/int(time.Millisecond))
+}
+
+// ES5 15.9.1.9
+func (this *vm) localTime(t float64) float64 {
+	return t + this.localOffset(t)
+}
+
+// ES5 15.9.1.9
+func (this *vm) utc(t float64) float64 {
+	return t - this.localOffset(t-this.localOffset(t))
+}
+
+// The components of a time value, in the order the setters take them.
+const (
+	dateYear = iota
+	dateMonth
+	dateDate
+	dateHours
+	dateMinutes
+	dateSeconds
+	dateMilliseconds
+	dateComponentCount
+)
+
+func splitTime(t float64) [dateComponentCount]float64 {
+	return [dateComponentCount]float64{
+		yearFromTime(t),
+		monthFromTime(t),
+		dateFromTime(t),
+		hourFromTime(t),
+		minFromTime(t),
+		secFromTime(t),
+		msFromTime(t),
+	}
+}
+
+func joinTime(c [dateComponentCount]float64) float64 {
+	return makeDate(makeDay(c[dateYear], c[dateMonth], c[dateDate]), makeTime(c[dateHours], c[dateMinutes], c[dateSeconds], c[dateMilliseconds]))
+}
+
+// Builds a time value from the (year, month[, date[, hours...]]) arguments
+// taken by both the constructor and Date.UTC.
+func timeFromArguments(args []value) float64 {
+	c := [dateComponentCount]float64{math.NaN(), 0, 1, 0, 0, 0, 0}
+	for idx := 0; idx < len(args) && idx < dateComponentCount; idx++ {
+		c[idx] = args[idx].ToNumber()
+	}
+	if !math.IsNaN(c[dateYear]) {
+		if y := toInteger(c[dateYear]); y >= 0 && y <= 99 {
+			c[dateYear] = 1900 + y
+		}
+	}
+	return joinTime(c)
+}
+
+//////////////////////////////////////
+// formatting
+//////////////////////////////////////
+
+func formatDateYear(y float64) string {
+	if y < 0 {
+		return fmt.Sprintf("-%04d", int64(-y))
+	}
+	return fmt.Sprintf("%04d", int64(y))
+}
+
+// e.g. "Tue Jan 02 2018", in local time
+func formatDatePart(t float64) string {
+	return fmt.Sprintf("%s %s %02d %s", weekDayNames[int(weekDay(t))], monthNames[int(monthFromTime(t))], int(dateFromTime(t)), formatDateYear(yearFromTime(t)))
+}
+
+// e.g. "04:05:06 GMT+0100 (CET)", given the UTC time value.
+func (this *vm) formatTimePart(t float64) string {
+	lt := this.localTime(t)
+	offset := int((lt - t) / msPerMinute)
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	s := fmt.Sprintf("%02d:%02d:%02d GMT%c%02d%02d", int(hourFromTime(lt)), int(minFromTime(lt)), int(secFromTime(lt)), sign, offset/60, offset%60)
+	name, _ := goTime(t).In(this.clock.Location()).Zone()
+	if name != "" {
+		s += " (" + name + ")"
+	}
+	return s
+}
+
+func formatISOString(t float64) string {
+	y := yearFromTime(t)
+	var year string
+	if y >= 0 && y <= 9999 {
+		year = fmt.Sprintf("%04d", int64(y))
+	} else if y < 0 {
+		year = fmt.Sprintf("-%06d", int64(-y))
+	} else {
+		year = fmt.Sprintf("+%06d", int64(y))
+	}
+	return fmt.Sprintf("%s-%02d-%02dT%02d:%02d:%02d.%03dZ", year, int(monthFromTime(t))+1, int(dateFromTime(t)), int(hourFromTime(t)), int(minFromTime(t)), int(secFromTime(t)), int(msFromTime(t)))
+}
+
+func formatUTCString(t float64) string {
+	return fmt.Sprintf("%s, %02d %s %s %02d:%02d:%02d GMT", weekDayNames[int(weekDay(t))], int(dateFromTime(t)), monthNames[int(monthFromTime(t))], formatDateYear(yearFromTime(t)), int(hourFromTime(t)), int(minFromTime(t)), int(secFromTime(t)))
+}
+
+//////////////////////////////////////
+// parsing
+//////////////////////////////////////
+
+// Reads exactly n digits from s at *pos.
+func parseDateDigits(s string, pos *int, n int) (float64, bool) {
+	if *pos+n > len(s) {
+		return 0, false
+	}
+	v := 0
+	for i := 0; i < n; i++ {
+		c := s[*pos+i]
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + int(c-'0')
+	}
+	*pos += n
+	return float64(v), true
+}
+
+// Parses the Date Time String Format of ES5 15.9.1.15:
+// YYYY[-MM[-DD]][THH:mm[:ss[.sss]][Z|(+|-)HH:mm]], with optional six digit
+// signed years. As in ES5, an absent offset means UTC.
+func parseISODate(s string) (float64, bool) {
+	pos := 0
+	var year float64
+	var ok bool
+	if len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		sign := s[0]
+		pos++
+		if year, ok = parseDateDigits(s, &pos, 6); !ok {
+			return 0, false
+		}
+		if sign == '-' {
+			if year == 0 {
+				return 0, false // -000000 is not a valid year
+			}
+			year = -year
+		}
+	} else if year, ok = parseDateDigits(s, &pos, 4); !ok {
+		return 0, false
+	}
+
+	c := [dateComponentCount]float64{year, 0, 1, 0, 0, 0, 0}
+	if pos < len(s) && s[pos] == '-' {
+		pos++
+		if c[dateMonth], ok = parseDateDigits(s, &pos, 2); !ok || c[dateMonth] < 1 || c[dateMonth] > 12 {
+			return 0, false
+		}
+		c[dateMonth]--
+		if pos < len(s) && s[pos] == '-' {
+			pos++
+			if c[dateDate], ok = parseDateDigits(s, &pos, 2); !ok || c[dateDate] < 1 {
+				return 0, false
+			}
+			leap := daysInYear(year) == 366
+			m := int(c[dateMonth])
+			if c[dateDate] > daysBeforeMonthInYear(m+1, leap)-daysBeforeMonthInYear(m, leap) {
+				return 0, false
+			}
+		}
+	}
+
+	offset := 0.0
+	if pos < len(s) && s[pos] == 'T' {
+		pos++
+		if c[dateHours], ok = parseDateDigits(s, &pos, 2); !ok || pos >= len(s) || s[pos] != ':' {
+			return 0, false
+		}
+		pos++
+		if c[dateMinutes], ok = parseDateDigits(s, &pos, 2); !ok {
+			return 0, false
+		}
+		if pos < len(s) && s[pos] == ':' {
+			pos++
+			if c[dateSeconds], ok = parseDateDigits(s, &pos, 2); !ok {
+				return 0, false
+			}
+			if pos < len(s) && s[pos] == '.' {
+				pos++
+				start := pos
+				scale := 100.0
+				for pos < len(s) && s[pos] >= '0' && s[pos] <= '9' {
+					c[dateMilliseconds] += float64(s[pos]-'0') * scale
+					scale /= 10
+					pos++
+				}
+				if pos == start {
+					return 0, false
+				}
+				c[dateMilliseconds] = math.Floor(c[dateMilliseconds])
+			}
+		}
+		if c[dateHours] > 24 || c[dateMinutes] > 59 || c[dateSeconds] > 59 {
+			return 0, false
+		}
+		if c[dateHours] == 24 && (c[dateMinutes] != 0 || c[dateSeconds] != 0 || c[dateMilliseconds] != 0) {
+			return 0, false
+		}
+
+		if pos < len(s) && s[pos] == 'Z' {
+			pos++
+		} else if pos < len(s) && (s[pos] == '+' || s[pos] == '-') {
+			sign := 1.0
+			if s[pos] == '-' {
+				sign = -1
+			}
+			pos++
+			hh, ok := parseDateDigits(s, &pos, 2)
+			if !ok || pos >= len(s) || s[pos] != ':' {
+				return 0, false
+			}
+			pos++
+			mm, ok := parseDateDigits(s, &pos, 2)
+			if !ok || hh > 23 || mm > 59 {
+				return 0, false
+			}
+			offset = sign * (hh*msPerHour + mm*msPerMinute)
+		}
+	}
+
+	if pos != len(s) {
+		return 0, false
+	}
+
+	return joinTime(c) - offset, true
+}
+
+// Formats other than the ISO one that Date.parse understands. Those with a
+// zone are absolute, the rest are read as local time.
+var dateParseZonedLayouts = []string{
+	"Mon Jan 02 2006 15:04:05 GMT-0700", // toString
+	"Mon, 02 Jan 2006 15:04:05 GMT",     // toUTCString
+	"Mon, 2 Jan 2006 15:04:05 GMT",
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700", // RFC 2822
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC1123,
+	time.RFC850,
+	time.RFC822Z,
+	time.RFC822,
+	"Mon Jan 02 2006 15:04:05 MST",
+	"Jan 2, 2006 15:04:05 MST",
+}
+
+var dateParseLocalLayouts = []string{
+	"Mon Jan 02 2006 15:04:05", // toString, without a zone
+	"Mon Jan 02 2006",          // toDateString
+	"Jan 2 2006 15:04:05",
+	"Jan 2 2006",
+	"Jan 2, 2006 15:04:05",
+	"Jan 2, 2006",
+	"January 2, 2006 15:04:05",
+	"January 2, 2006",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	"01/02/2006 15:04:05",
+	"01/02/2006",
+}
+
+// ES5 15.9.4.2
+func (this *vm) parseDate(s string) float64 {
+	s = strings.TrimSpace(s)
+	if t, ok := parseISODate(s); ok {
+		return timeClip(t)
+	}
+
+	// toString appends the zone name in parentheses; it carries no information
+	// we don't already have from the numeric offset.
+	if idx := strings.LastIndex(s, " ("); idx != -1 && strings.HasSuffix(s, ")") {
+		s = s[:idx]
+	}
+
+	for _, layout := range dateParseZonedLayouts {
+		if pt, err := time.Parse(layout, s); err == nil {
+			return timeClip(timeValue(pt))
+		}
+	}
+	for _, layout := range dateParseLocalLayouts {
+		if pt, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return timeClip(this.utc(timeValue(pt)))
+		}
+	}
+
+	return math.NaN()
+}
+
+//////////////////////////////////////
+// constructor
+//////////////////////////////////////
+
+func (this *vm) currentTime() float64 {
+	return timeValue(this.clock.Now())
+}
+
+// ES5 15.9.2.1
+func date_call(vm *vm, f value, args []value) value {
+	return newString(vm.dateToString(vm.currentTime()))
+}
+
+// ES5 15.9.3
+func date_ctor(vm *vm, f value, args []value) value {
+	switch len(args) {
+	case 0:
+		return objectValue(newDateObject(vm.currentTime()))
+	case 1:
+		v := args[0]
+		if o, ok := v.obj.(valueBasicObject); ok {
+			if d, ok := o.odata.(*dateObjectData); ok {
+				return objectValue(newDateObject(d.primitiveData))
+			}
+		}
+		v = valueToPrimitive(v)
+		if v.isString() {
+			return objectValue(newDateObject(vm.parseDate(v.str)))
+		}
+		return objectValue(newDateObject(timeClip(v.ToNumber())))
+	default:
+		return objectValue(newDateObject(timeClip(vm.utc(timeFromArguments(args)))))
+	}
+}
+
+func date_now(vm *vm, f value, args []value) value {
+	return newNumber(vm.currentTime())
+}
+
+func date_parse(vm *vm, f value, args []value) value {
+	return newNumber(vm.parseDate(argument(args, 0).ToString().String()))
+}
+
+func date_UTC(vm *vm, f value, args []value) value {
+	return newNumber(timeClip(timeFromArguments(args)))
+}
+
+//////////////////////////////////////
+// prototype
+//////////////////////////////////////
+
+// thisTimeValue returns the time value of the Date object f, and throws a
+// TypeError if f is not a Date.
+func thisTimeValue(vm *vm, f value) *dateObjectData {
+	if o, ok := f.obj.(valueBasicObject); ok {
+		if d, ok := o.odata.(*dateObjectData); ok {
+			return d
+		}
+	}
+	vm.ThrowTypeError("this is not a Date object")
+	return nil
+}
+
+func (this *vm) dateToString(t float64) string {
+	if math.IsNaN(t) {
+		return "Invalid Date"
+	}
+	return formatDatePart(this.localTime(t)) + " " + this.formatTimePart(t)
+}
+
+func date_prototype_toString(vm *vm, f value, args []value) value {
+	return newString(vm.dateToString(thisTimeValue(vm, f).primitiveData))
+}
+
+func date_prototype_toDateString(vm *vm, f value, args []value) value {
+	t := thisTimeValue(vm, f).primitiveData
+	if math.IsNaN(t) {
+		return newString("Invalid Date")
+	}
+	return newString(formatDatePart(vm.localTime(t)))
+}
+
+func date_prototype_toTimeString(vm *vm, f value, args []value) value {
+	t := thisTimeValue(vm, f).primitiveData
+	if math.IsNaN(t) {
+		return newString("Invalid Date")
+	}
+	return newString(vm.formatTimePart(t))
+}
+
+func date_prototype_toUTCString(vm *vm, f value, args []value) value {
+	t := thisTimeValue(vm, f).primitiveData
+	if math.IsNaN(t) {
+		return newString("Invalid Date")
+	}
+	return newString(formatUTCString(t))
+}
+
+func date_prototype_toISOString(vm *vm, f value, args []value) value {
+	t := thisTimeValue(vm, f).primitiveData
+	if math.IsNaN(t) {
+		vm.ThrowRangeError("Invalid time value")
+	}
+	return newString(formatISOString(t))
+}
+
+// ES5 15.9.5.44
+func date_prototype_toJSON(vm *vm, f value, args []value) value {
+	O := f.ToObject()
+	if o, ok := O.(valueBasicObject); ok {
+		if d, ok := o.odata.(*dateObjectData); ok {
+			if !isFinite(d.primitiveData) {
+				return newNull()
+			}
+		}
+	}
+	toISO := O.get(vm, newString("toISOString"))
+	fn, ok := toISO.obj.(functionObject)
+	if !ok {
+		vm.ThrowTypeError("toISOString is not a function")
+	}
+	return fn.call(vm, objectValue(O), []value{})
+}
+
+func date_prototype_getTime(vm *vm, f value, args []value) value {
+	return newNumber(thisTimeValue(vm, f).primitiveData)
+}
+
+func date_prototype_getTimezoneOffset(vm *vm, f value, args []value) value {
+	t := thisTimeValue(vm, f).primitiveData
+	if math.IsNaN(t) {
+		return newNumber(t)
+	}
+	return newNumber((t - vm.localTime(t)) / msPerMinute)
+}
+
+// Shared by the getters: applies component to the local (or UTC) time.
+func getDateComponent(vm *vm, f value, local bool, component func(t float64) float64) value {
+	t := thisTimeValue(vm, f).primitiveData
+	if math.IsNaN(t) {
+		return newNumber(t)
+	}
+	if local {
+		t = vm.localTime(t)
+	}
+	return newNumber(component(t))
+}
+
+func date_prototype_getFullYear(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, yearFromTime)
+}
+
+// Annex B.2.4
+func date_prototype_getYear(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, func(t float64) float64 { return yearFromTime(t) - 1900 })
+}
+
+func date_prototype_getMonth(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, monthFromTime)
+}
+
+func date_prototype_getDate(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, dateFromTime)
+}
+
+func date_prototype_getDay(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, weekDay)
+}
+
+func date_prototype_getHours(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, hourFromTime)
+}
+
+func date_prototype_getMinutes(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, minFromTime)
+}
+
+func date_prototype_getSeconds(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, secFromTime)
+}
+
+func date_prototype_getMilliseconds(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, true, msFromTime)
+}
+
+func date_prototype_getUTCFullYear(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, yearFromTime)
+}
+
+func date_prototype_getUTCMonth(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, monthFromTime)
+}
+
+func date_prototype_getUTCDate(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, dateFromTime)
+}
+
+func date_prototype_getUTCDay(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, weekDay)
+}
+
+func date_prototype_getUTCHours(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, hourFromTime)
+}
+
+func date_prototype_getUTCMinutes(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, minFromTime)
+}
+
+func date_prototype_getUTCSeconds(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, secFromTime)
+}
+
+func date_prototype_getUTCMilliseconds(vm *vm, f value, args []value) value {
+	return getDateComponent(vm, f, false, msFromTime)
+}
+
+func date_prototype_setTime(vm *vm, f value, args []value) value {
+	d := thisTimeValue(vm, f)
+	d.primitiveData = timeClip(argument(args, 0).ToNumber())
+	return newNumber(d.primitiveData)
+}
+
+// Shared by the setters (ES5 15.9.5.28 - 15.9.5.41): replaces up to count
+// components of the time, starting at first, with the arguments given.
+func setDateComponents(vm *vm, f value, args []value, local bool, first int, count int) value {
+	d := thisTimeValue(vm, f)
+	t := d.primitiveData
+	if local {
+		t = vm.localTime(t)
+	}
+	if first == dateYear && math.IsNaN(t) {
+		t = +0
+	}
+
+	c := splitTime(t)
+	c[first] = argument(args, 0).ToNumber()
+	for idx := 1; idx < count && idx < len(args); idx++ {
+		c[first+idx] = args[idx].ToNumber()
+	}
+
+	u := joinTime(c)
+	if local {
+		u = vm.utc(u)
+	}
+	d.primitiveData = timeClip(u)
+	return newNumber(d.primitiveData)
+}
+
+func date_prototype_setFullYear(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, true, dateYear, 3)
+}
+
+// Annex B.2.5
+func date_prototype_setYear(vm *vm, f value, args []value) value {
+	y := argument(args, 0).ToNumber()
+	if !math.IsNaN(y) {
+		if yi := toInteger(y); yi >= 0 && yi <= 99 {
+			y = 1900 + yi
+		}
+	}
+	return setDateComponents(vm, f, []value{newNumber(y)}, true, dateYear, 1)
+}
+
+func date_prototype_setMonth(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, true, dateMonth, 2)
+}
+
+func date_prototype_setDate(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, true, dateDate, 1)
+}
+
+func date_prototype_setHours(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, true, dateHours, 4)
+}
+
+func date_prototype_setMinutes(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, true, dateMinutes, 3)
+}
+
+func date_prototype_setSeconds(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, true, dateSeconds, 2)
+}
+
+func date_prototype_setMilliseconds(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, true, dateMilliseconds, 1)
+}
+
+func date_prototype_setUTCFullYear(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, false, dateYear, 3)
+}
+
+func date_prototype_setUTCMonth(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, false, dateMonth, 2)
+}
+
+func date_prototype_setUTCDate(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, false, dateDate, 1)
+}
+
+func date_prototype_setUTCHours(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, false, dateHours, 4)
+}
+
+func date_prototype_setUTCMinutes(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, false, dateMinutes, 3)
+}
+
+func date_prototype_setUTCSeconds(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, false, dateSeconds, 2)
+}
+
+func date_prototype_setUTCMilliseconds(vm *vm, f value, args []value) value {
+	return setDateComponents(vm, f, args, false, dateMilliseconds, 1)
+}
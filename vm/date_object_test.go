@@ -0,0 +1,399 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/stvp/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+type fixedClock struct {
+	now time.Time
+	loc *time.Location
+}
+
+func (this fixedClock) Now() time.Time {
+	return this.now
+}
+
+func (this fixedClock) Location() *time.Location {
+	return this.loc
+}
+
+// 2018-01-02T03:04:05.678Z, in a zone an hour ahead of UTC.
+var testClock = fixedClock{time.Date(2018, 1, 2, 3, 4, 5, 678000000, time.UTC), time.FixedZone("CET", 3600)}
+
+func runDateVMTestHelper(t *testing.T, tests []simpleVMTest) {
+	for _, test := range tests {
+		t.Logf("Testing: %s", test.in)
+		vm := New(test.in)
+		vm.SetClock(testClock)
+		assertSameDateValue(t, mustRun(t, vm), test.out, test.in)
+		t.Logf("** Passed %s == %s", test.in, test.out)
+	}
+}
+
+// NaN never compares equal to itself, so invalid time values need checking
+// by hand.
+func assertSameDateValue(t *testing.T, got value, want value, msg string) {
+	if want.isNumber() && math.IsNaN(want.num) {
+		assert.True(t, got.isNumber() && math.IsNaN(got.num), msg)
+		return
+	}
+	assert.Equal(t, got, want, msg)
+}
+
+func TestDateConstructor(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "return Date.now()",
+			out: newNumber(1514862245678),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getTime()",
+			out: newNumber(1514862245678),
+		},
+		simpleVMTest{
+			in:  "return Date()",
+			out: newString("Tue Jan 02 2018 04:04:05 GMT+0100 (CET)"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); return d.valueOf()",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(8640000000000001); return d.getTime()",
+			out: newNumber(math.NaN()),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(2018, 0, 2); return d.toISOString()",
+			out: newString("2018-01-01T23:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(2018, 13, 1, 25, 61, 61, 1001); return d.toISOString()",
+			out: newString("2019-02-02T01:02:02.001Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(99, 11); return d.getFullYear()",
+			out: newNumber(1999),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(\"2018-01-02T03:04:05Z\"); return d.getTime()",
+			out: newNumber(1514862245000),
+		},
+		simpleVMTest{
+			in:  "var a = new Date(1000); var b = new Date(a); return b.getTime()",
+			out: newNumber(1000),
+		},
+		simpleVMTest{
+			in:  "return Date.UTC(2018, 0, 2, 3, 4, 5, 678)",
+			out: newNumber(1514862245678),
+		},
+		simpleVMTest{
+			in:  "return Date.UTC(1970, 0)",
+			out: newNumber(0),
+		},
+	}
+
+	runDateVMTestHelper(t, tests)
+
+	vm := New("")
+	assert.Equal(t, object_prototype_toString(vm, objectValue(newDateObject(0)), nil), newString("[object Date]"))
+}
+
+func TestDateParse(t *testing.T) {
+	tests := []struct {
+		in  string
+		out float64
+	}{
+		{"1970", 0},
+		{"1970-01", 0},
+		{"1970-01-02", 86400000},
+		{"2018-01-02T03:04", 1514862240000},
+		{"2018-01-02T03:04:05.678Z", 1514862245678},
+		{"2018-01-02T03:04:05.6Z", 1514862245600},
+		{"2018-01-02T04:04:05+01:00", 1514862245000},
+		{"2018-01-01T22:04:05-05:00", 1514862245000},
+		{"1970-01-01T24:00:00Z", 86400000},
+		{"+002018-01-02T03:04:05Z", 1514862245000},
+		{"-000001-01-01T00:00:00Z", -62198755200000},
+		{"Tue Jan 02 2018 04:04:05 GMT+0100 (CET)", 1514862245000},
+		{"Tue Jan 02 2018 04:04:05 GMT+0100", 1514862245000},
+		{"Tue, 02 Jan 2018 03:04:05 GMT", 1514862245000},
+		{"Tue, 2 Jan 2018 05:04:05 +0200", 1514862245000},
+		{"Tue Jan 02 2018", 1514847600000},
+		{"Jan 2, 2018 04:04:05", 1514862245000},
+
+		{"", math.NaN()},
+		{"2018-13-01", math.NaN()},
+		{"2018-02-29", math.NaN()},
+		{"2018-01-02T25:00Z", math.NaN()},
+		{"1970-01-01T24:00:01Z", math.NaN()},
+		{"-000000-01-01T00:00:00Z", math.NaN()},
+		{"2018-01-02T03:04:05+0100", math.NaN()},
+		{"not a date", math.NaN()},
+	}
+
+	vm := New("")
+	vm.SetClock(testClock)
+	for _, test := range tests {
+		assertSameDateValue(t, date_parse(vm, newUndefined(), []value{newString(test.in)}), newNumber(test.out), test.in)
+	}
+}
+
+func TestDateGetters(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getFullYear()",
+			out: newNumber(2018),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getYear()",
+			out: newNumber(118),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getMonth()",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getDate()",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getDay()",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getHours()",
+			out: newNumber(4),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getUTCHours()",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getMinutes()",
+			out: newNumber(4),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getSeconds()",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getMilliseconds()",
+			out: newNumber(678),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.getTimezoneOffset()",
+			out: newNumber(-60),
+		},
+		simpleVMTest{
+			// 23:30 UTC on new year's eve is already the next year locally
+			in:  "var d = new Date(1514763000000); return d.getFullYear()",
+			out: newNumber(2018),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(1514763000000); return d.getUTCFullYear()",
+			out: newNumber(2017),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(1514763000000); return d.getUTCMonth()",
+			out: newNumber(11),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(1514763000000); return d.getUTCDate()",
+			out: newNumber(31),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(1514763000000); return d.getUTCDay()",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(-1); return d.getUTCMilliseconds()",
+			out: newNumber(999),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(-1); return d.getUTCFullYear()",
+			out: newNumber(1969),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(951782400000); return d.getUTCDate()",
+			out: newNumber(29),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(\"invalid\"); return d.getFullYear()",
+			out: newNumber(math.NaN()),
+		},
+	}
+
+	runDateVMTestHelper(t, tests)
+}
+
+func TestDateSetters(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setTime(1000); return d.getTime()",
+			out: newNumber(1000),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); return d.setUTCFullYear(2000)",
+			out: newNumber(946684800000),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setUTCFullYear(2000, 1, 29); return d.toISOString()",
+			out: newString("2000-02-29T00:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setUTCMonth(12); return d.toISOString()",
+			out: newString("1971-01-01T00:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setUTCDate(0); return d.toISOString()",
+			out: newString("1969-12-31T00:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setUTCHours(1, 2, 3, 4); return d.toISOString()",
+			out: newString("1970-01-01T01:02:03.004Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setUTCMinutes(90); return d.toISOString()",
+			out: newString("1970-01-01T01:30:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setUTCSeconds(-1); return d.toISOString()",
+			out: newString("1969-12-31T23:59:59.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setUTCMilliseconds(1500); return d.toISOString()",
+			out: newString("1970-01-01T00:00:01.500Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setHours(0); return d.toISOString()",
+			out: newString("1969-12-31T23:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setDate(15); return d.getDate()",
+			out: newNumber(15),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setMonth(5, 6); return d.toISOString()",
+			out: newString("1970-06-06T00:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setFullYear(2018); return d.getFullYear()",
+			out: newNumber(2018),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setYear(95); return d.getFullYear()",
+			out: newNumber(1995),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setMinutes(5, 6, 7); return d.toISOString()",
+			out: newString("1970-01-01T00:05:06.007Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); d.setSeconds(30); d.setMilliseconds(5); return d.toISOString()",
+			out: newString("1970-01-01T00:00:30.005Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(0); return d.setHours()",
+			out: newNumber(math.NaN()),
+		},
+		simpleVMTest{
+			// setFullYear is the only setter that revives an invalid date
+			in:  "var d = new Date(\"invalid\"); d.setUTCFullYear(1970); return d.getTime()",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(\"invalid\"); return d.setUTCHours(1)",
+			out: newNumber(math.NaN()),
+		},
+	}
+
+	runDateVMTestHelper(t, tests)
+}
+
+func TestDateStrings(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var d = new Date(); return d.toString()",
+			out: newString("Tue Jan 02 2018 04:04:05 GMT+0100 (CET)"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.toDateString()",
+			out: newString("Tue Jan 02 2018"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.toTimeString()",
+			out: newString("04:04:05 GMT+0100 (CET)"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.toUTCString()",
+			out: newString("Tue, 02 Jan 2018 03:04:05 GMT"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.toISOString()",
+			out: newString("2018-01-02T03:04:05.678Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); return d.toJSON()",
+			out: newString("2018-01-02T03:04:05.678Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(\"invalid\"); return d.toJSON()",
+			out: newNull(),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(\"invalid\"); return d.toString()",
+			out: newString("Invalid Date"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(-8640000000000000); return d.toISOString()",
+			out: newString("-271821-04-20T00:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(8640000000000000); return d.toISOString()",
+			out: newString("+275760-09-13T00:00:00.000Z"),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); var e = new Date(d.toString()); return e.getTime()",
+			out: newNumber(1514862245000),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); var e = new Date(d.toUTCString()); return e.getTime()",
+			out: newNumber(1514862245000),
+		},
+		simpleVMTest{
+			in:  "var d = new Date(); var e = new Date(d.toISOString()); return e.getTime()",
+			out: newNumber(1514862245678),
+		},
+	}
+
+	runDateVMTestHelper(t, tests)
+}
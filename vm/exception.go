@@ -0,0 +1,131 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"fmt"
+)
+
+// An Exception is an error a script threw, and didn't catch.
+type Exception struct {
+	Message string   // such as "TypeError: Cannot read property"
+	Stack   []string // where it was thrown from, innermost first
+}
+
+func (this *Exception) Error() string {
+	return this.Message
+}
+
+// An ExitError is what Run returns when the script called exit.
+type ExitError struct {
+	Code int
+}
+
+func (this *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", this.Code)
+}
+
+// An InternalError is what Run returns if the vm itself failed running the
+// script, which is a bug in the vm rather than in the script.
+type InternalError struct {
+	Reason interface{} // what the vm panicked with
+	Stack  []string    // where the script was, as for an Exception
+}
+
+func (this *InternalError) Error() string {
+	return fmt.Sprintf("internal error: %v", this.Reason)
+}
+
+// catch turns what a run panicked with into the error it returns, leaving the
+// stacks empty. Anything that isn't an error from the script, or one of ours,
+// is a bug, and becomes an InternalError.
+func (this *vm) catch(r interface{}) error {
+	var err error
+	switch e := r.(type) {
+	case *TerminationError:
+		err = e
+	case *ExitError:
+		err = e
+	case string:
+		// see ThrowTypeError and friends
+		err = &Exception{Message: e, Stack: this.stackTrace()}
+	default:
+		err = &InternalError{Reason: r, Stack: this.stackTrace()}
+	}
+	this.resetStack()
+	return err
+}
+
+// the most places a stack trace lists.
+const stackTraceLimit = 10
+
+// stackTrace describes where the run is, and where each function on the call
+// stack was called from, innermost first.
+func (this *vm) stackTrace() []string {
+	if this.stack.depth == 0 {
+		return nil
+	}
+	// A builtin's frame has no registers, and has no place in the code: what
+	// is running in it, or what it calls, is where it was called from.
+	pcs := []int{}
+	if this.stack.top().registers != nil {
+		pcs = append(pcs, this.ip)
+	}
+	for idx := this.stack.depth - 1; idx > 0; idx-- {
+		if this.stack.at(idx-1).registers != nil {
+			pcs = append(pcs, this.stack.at(idx).retAddr)
+		}
+	}
+
+	if len(pcs) > stackTraceLimit {
+		pcs = pcs[:stackTraceLimit]
+	}
+
+	var trace []string
+	for _, pc := range pcs {
+		trace = append(trace, fmt.Sprintf("%s (%s:%d)", this.functionAt(pc), this.name, this.lineAt(pc)))
+	}
+	return trace
+}
+
+// functionAt returns the name of the function that the instruction at pc is
+// part of.
+func (this *vm) functionAt(pc int) string {
+	for ; pc >= 0; pc-- {
+		if this.code[pc].otype == IN_FUNCTION {
+			switch name := stringName(this.strings, this.code[pc].a); name {
+			case "%main":
+				return "<main>"
+			case "%anonymous":
+				return "<anonymous>"
+			default:
+				return name
+			}
+		}
+	}
+	return "<unknown>"
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/stvp/assert"
+	"testing"
+)
+
+func TestException(t *testing.T) {
+	tests := []struct {
+		code  string
+		stack []string
+	}{
+		{"undeclared", []string{"<main> (test.js:1)"}},
+		{`function f() {
+			return undeclared
+		}
+		f()`, []string{"f (test.js:2)", "<main> (test.js:4)"}},
+		// builtins aren't listed, but what they call is
+		{`function f() { return undeclared }
+		"a".replace("a", f)`, []string{"f (test.js:1)", "<main> (test.js:2)"}},
+		{`function f() {
+			return new Array(-1)
+		}
+		f()`, []string{"f (test.js:2)", "<main> (test.js:4)"}},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			p, err := Compile(test.code, "test.js")
+			assert.Nil(t, err)
+			_, err = NewFromProgram(p).Run()
+			e, ok := err.(*Exception)
+			assert.True(t, ok)
+			assert.Equal(t, e.Stack, test.stack)
+		})
+	}
+}
+
+func TestExceptionStackLimit(t *testing.T) {
+	_, err := New("function f() { return f() }; f()").Run()
+	e := err.(*Exception)
+	assert.Equal(t, e.Message, "RangeError: Maximum call stack size exceeded")
+	assert.Equal(t, len(e.Stack), stackTraceLimit)
+}
+
+func TestCallNotAFunction(t *testing.T) {
+	tests := []struct {
+		code    string
+		message string
+	}{
+		{"var o = {}; o.f()", "TypeError: undefined is not a function"},
+		{"var n = null; n()", "TypeError: null is not a function"},
+		{"var x = true; new x()", "TypeError: true is not a constructor"},
+		{"var s = 'f'; s()", "TypeError: \"f\" is not a function"},
+		{"var a = [1]; a[0] = a; a()", "TypeError: object is not a function"},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			_, err := New(test.code).Run()
+			e, ok := err.(*Exception)
+			assert.True(t, ok)
+			assert.Equal(t, e.Message, test.message)
+		})
+	}
+}
+
+func TestPropertyOfNullOrUndefined(t *testing.T) {
+	tests := []struct {
+		code    string
+		message string
+	}{
+		{"null.x", "TypeError: Cannot read property 'x' of null"},
+		{"var o; o.x", "TypeError: Cannot read property 'x' of undefined"},
+		{"var o; o.x = 1", "TypeError: Cannot set property 'x' of undefined"},
+		{"var n = null; n[1.5]", "TypeError: Cannot read property '1.5' of null"},
+		{"var o; o[0] = 1", "TypeError: Cannot set property '0' of undefined"},
+		{"var o = {}; o.a.b", "TypeError: Cannot read property 'b' of undefined"},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			_, err := New(test.code).Run()
+			e, ok := err.(*Exception)
+			assert.True(t, ok)
+			assert.Equal(t, e.Message, test.message)
+		})
+	}
+}
+
+// A bug in the vm fails the run, rather than the program running it.
+func TestInternalError(t *testing.T) {
+	v := New("function f() { return boom() }\nf()")
+	v.defineBuiltinGlobal("boom", objectValue(newFunctionObject(func(vm *vm, f value, args []value) value {
+		return args[1]
+	}, nil)))
+
+	_, err := v.Run()
+	e, ok := err.(*InternalError)
+	assert.True(t, ok)
+	assert.Equal(t, e.Error(), "internal error: runtime error: index out of range [1] with length 0")
+	assert.Equal(t, e.Stack, []string{"f (:1)", "<main> (:2)"})
+
+	rval, err := v.Eval("1 + 1")
+	assert.Nil(t, err)
+	assert.Equal(t, rval, newNumber(2))
+}
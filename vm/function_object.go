@@ -19,8 +19,17 @@ func (this *functionObject) construct(vm *vm, thisArg value, args []value) value
 	return this.constructPtr(vm, thisArg, args)
 }
 
+// argument returns args[idx], or undefined if the caller passed fewer
+// arguments than that.
+func argument(args []value, idx int) value {
+	if idx < len(args) {
+		return args[idx]
+	}
+	return newUndefined()
+}
+
 //////////////////////////////////////
 
-func (this *functionObject) Prototype() *valueBasicObject {
+func (this *functionObject) Prototype(vm *vm) *valueBasicObject {
 	return this.prototype
 }
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A run can be stopped part way through: by running out of steps (opcodes
+// executed), by passing a deadline, by its context being done, or by someone
+// calling Interrupt. Checking for all of that on every opcode would be slow,
+// so the run loop only counts steps, and looks at the rest every
+// limitCheckInterval steps.
+//
+// Stopping a run isn't a JavaScript exception: scripts can't catch it, and
+// it ends the run with a TerminationError, rather than a panic.
+
+// how many steps may run between checks on the deadline, context and
+// interrupts.
+const limitCheckInterval = 1024
+
+type limits struct {
+	steps     int64 // taken by this run
+	nextCheck int64 // when the run loop next calls checkLimits
+	stepLimit int64 // or 0 for none
+	deadline  time.Time
+	ctx       context.Context
+
+	interrupted int32 // set atomically, as Interrupt can come from anywhere
+	mutex       sync.Mutex
+	reason      string
+}
+
+// A TerminationError is what Run returns when the run was stopped, saying why
+// and where.
+type TerminationError struct {
+	Reason string
+	Name   string // of the program
+	Line   int    // or 0, if it isn't known
+	Err    error  // the context's error, if that is what stopped it
+}
+
+func (this *TerminationError) Error() string {
+	where := this.Name
+	if this.Line != 0 {
+		where = fmt.Sprintf("%s:%d", where, this.Line)
+	}
+	if where == "" {
+		return fmt.Sprintf("terminated: %s", this.Reason)
+	}
+	return fmt.Sprintf("terminated at %s: %s", where, this.Reason)
+}
+
+func (this *TerminationError) Unwrap() error {
+	return this.Err
+}
+
+// SetStepLimit sets the most steps a run can take, or 0 for no limit.
+func (this *vm) SetStepLimit(n int64) {
+	this.limits.stepLimit = n
+}
+
+// SetDeadline sets the time a run has to finish by, or the zero time for no
+// deadline.
+func (this *vm) SetDeadline(t time.Time) {
+	this.limits.deadline = t
+}
+
+// Interrupt stops the run that is going on, or the next one, as soon as it
+// can. It can be called from any goroutine.
+func (this *vm) Interrupt(reason string) {
+	this.limits.mutex.Lock()
+	this.limits.reason = reason
+	this.limits.mutex.Unlock()
+	atomic.StoreInt32(&this.limits.interrupted, 1)
+}
+
+// ClearInterrupt drops an Interrupt that no run has stopped for yet, so that
+// it doesn't stop the next one.
+func (this *vm) ClearInterrupt() {
+	atomic.StoreInt32(&this.limits.interrupted, 0)
+}
+
+// startLimits readies the limits for a run with ctx.
+func (this *vm) startLimits(ctx context.Context) {
+	this.limits.ctx = ctx
+	this.limits.steps = 0
+	this.limits.nextCheck = 0
+}
+
+// checkLimits stops the run if it has to, or works out when to check again.
+func (this *vm) checkLimits() {
+	l := &this.limits
+	if l.stepLimit > 0 && l.steps > l.stepLimit {
+		this.terminate(fmt.Sprintf("step limit of %d exceeded", l.stepLimit), nil)
+	}
+	if atomic.LoadInt32(&l.interrupted) != 0 {
+		l.mutex.Lock()
+		reason := l.reason
+		l.mutex.Unlock()
+		atomic.StoreInt32(&l.interrupted, 0)
+		this.terminate(fmt.Sprintf("interrupted: %s", reason), nil)
+	}
+	if l.ctx != nil && l.ctx.Err() != nil {
+		this.terminate(l.ctx.Err().Error(), l.ctx.Err())
+	}
+	if !l.deadline.IsZero() && time.Now().After(l.deadline) {
+		this.terminate("deadline exceeded", context.DeadlineExceeded)
+	}
+
+	l.nextCheck = l.steps + limitCheckInterval
+	if l.stepLimit > 0 && l.nextCheck > l.stepLimit+1 {
+		l.nextCheck = l.stepLimit + 1
+	}
+}
+
+// terminate stops the run, see RunContext.
+func (this *vm) terminate(reason string, err error) {
+	panic(&TerminationError{Reason: reason, Name: this.name, Line: this.lineAt(this.ip), Err: err})
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"context"
+	"errors"
+	"github.com/stvp/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+const infiniteLoop = "var a = 0\nwhile (true) {\n  a++\n}"
+
+func runTerminated(t *testing.T, run func() (value, error)) *TerminationError {
+	_, err := run()
+	te, ok := err.(*TerminationError)
+	if !ok {
+		t.Fatalf("run wasn't terminated: %v", err)
+	}
+	return te
+}
+
+func TestStepLimit(t *testing.T) {
+	p, err := Compile(infiniteLoop, "loop.js")
+	assert.Nil(t, err)
+	v := NewFromProgram(p)
+	v.SetStepLimit(10000)
+	te := runTerminated(t, v.Run)
+	assert.Equal(t, te.Reason, "step limit of 10000 exceeded")
+	assert.Equal(t, te.Name, "loop.js")
+	assert.True(t, te.Line == 2 || te.Line == 3)
+	assert.True(t, strings.HasPrefix(te.Error(), "terminated at loop.js:"))
+}
+
+// The limit is exact, rather than only being looked at now and then.
+func TestStepLimitIsExact(t *testing.T) {
+	code := "var a = 0; while (a < 3000) { a++ }; return a"
+	v := New(code)
+	assert.Equal(t, mustRun(t, v), newNumber(3000))
+	steps := v.limits.steps
+
+	v = New(code)
+	v.SetStepLimit(steps)
+	assert.Equal(t, mustRun(t, v), newNumber(3000))
+
+	v = New(code)
+	v.SetStepLimit(steps - 1)
+	runTerminated(t, v.Run)
+}
+
+func TestDeadline(t *testing.T) {
+	v := New(infiniteLoop)
+	v.SetDeadline(time.Now().Add(20 * time.Millisecond))
+	te := runTerminated(t, v.Run)
+	assert.Equal(t, te.Reason, "deadline exceeded")
+	assert.True(t, errors.Is(te, context.DeadlineExceeded))
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	v := New(infiniteLoop)
+	te := runTerminated(t, func() (value, error) { return v.RunContext(ctx) })
+	assert.True(t, errors.Is(te, context.Canceled))
+}
+
+func TestInterrupt(t *testing.T) {
+	v := New(infiniteLoop)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		v.Interrupt("shutting down")
+	}()
+	te := runTerminated(t, v.Run)
+	assert.Equal(t, te.Reason, "interrupted: shutting down")
+}
+
+func TestClearInterrupt(t *testing.T) {
+	v := New("for (var i = 0; i < 5000; i++) {}")
+	v.Interrupt("too late")
+	v.ClearInterrupt()
+	mustRun(t, v)
+}
+
+// Functions run from inside builtins are stopped too.
+func TestLimitInCallback(t *testing.T) {
+	v := New(`"abc".replace("b", function() { while (true) {} })`)
+	v.SetStepLimit(10000)
+	runTerminated(t, v.Run)
+}
@@ -28,76 +28,217 @@ package vm
 
 import (
 	"math"
+	"math/bits"
 	"math/rand"
 )
 
+// A RandomSource supplies Math.random with numbers in [0, 1). The default
+// draws from the shared math/rand source; an embedder can replace it with
+// SetRandomSource, for instance with rand.New(rand.NewSource(seed)) to make
+// a script's behaviour repeatable.
+type RandomSource interface {
+	Float64() float64
+}
+
+type hostRandomSource struct{}
+
+func (this hostRandomSource) Float64() float64 {
+	return rand.Float64()
+}
+
+// SetRandomSource replaces the source of numbers used by Math.random.
+func (this *vm) SetRandomSource(r RandomSource) {
+	this.random = r
+}
+
 func defineMathObject(vm *vm) valueBasicObject {
-	mathO := valueBasicObject{&rootObjectData{&valueBasicObjectData{extensible: true}}}
+	mathO := valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
 
 	mathO.defineReadonlyProperty(vm, "E", newNumber(2.7182818284590452354), 1)
 	mathO.defineReadonlyProperty(vm, "LN10", newNumber(2.302585092994046), 1)
 	mathO.defineReadonlyProperty(vm, "LN2", newNumber(0.6931471805599453), 1)
 	mathO.defineReadonlyProperty(vm, "LOG2E", newNumber(1.4426950408889634), 1)
-	mathO.defineReadonlyProperty(vm, "LO10E", newNumber(0.4342944819032518), 1)
+	mathO.defineReadonlyProperty(vm, "LOG10E", newNumber(0.4342944819032518), 1)
 	mathO.defineReadonlyProperty(vm, "PI", newNumber(3.1415926535897932), 1)
 	mathO.defineReadonlyProperty(vm, "SQRT1_2", newNumber(0.7071067811865476), 1)
 	mathO.defineReadonlyProperty(vm, "SQRT2", newNumber(1.4142135623730951), 1)
 
-	mathO.defineDefaultProperty(vm, "abs", newFunctionObject(math_abs, nil), 1)
-	mathO.defineDefaultProperty(vm, "acos", newFunctionObject(math_acos, nil), 1)
-	mathO.defineDefaultProperty(vm, "asin", newFunctionObject(math_asin, nil), 1)
-	mathO.defineDefaultProperty(vm, "atan", newFunctionObject(math_atan, nil), 1)
-	// atan2
-	mathO.defineDefaultProperty(vm, "ceil", newFunctionObject(math_ceil, nil), 1)
-	mathO.defineDefaultProperty(vm, "cos", newFunctionObject(math_cos, nil), 1)
-	mathO.defineDefaultProperty(vm, "exp", newFunctionObject(math_exp, nil), 1)
-	mathO.defineDefaultProperty(vm, "floor", newFunctionObject(math_floor, nil), 1)
-	mathO.defineDefaultProperty(vm, "log", newFunctionObject(math_log, nil), 1)
-	mathO.defineDefaultProperty(vm, "max", newFunctionObject(math_max, nil), 1)
-	mathO.defineDefaultProperty(vm, "min", newFunctionObject(math_min, nil), 1)
-	// pow
-	mathO.defineDefaultProperty(vm, "random", newFunctionObject(math_random, nil), 1)
-	mathO.defineDefaultProperty(vm, "round", newFunctionObject(math_round, nil), 1)
-	mathO.defineDefaultProperty(vm, "sin", newFunctionObject(math_sin, nil), 1)
-	mathO.defineDefaultProperty(vm, "sqrt", newFunctionObject(math_sqrt, nil), 1)
-	mathO.defineDefaultProperty(vm, "tan", newFunctionObject(math_tan, nil), 1)
+	mathO.defineDefaultProperty(vm, "abs", objectValue(newFunctionObject(math_abs, nil)), 1)
+	mathO.defineDefaultProperty(vm, "acos", objectValue(newFunctionObject(math_acos, nil)), 1)
+	mathO.defineDefaultProperty(vm, "acosh", objectValue(newFunctionObject(math_acosh, nil)), 1)
+	mathO.defineDefaultProperty(vm, "asin", objectValue(newFunctionObject(math_asin, nil)), 1)
+	mathO.defineDefaultProperty(vm, "asinh", objectValue(newFunctionObject(math_asinh, nil)), 1)
+	mathO.defineDefaultProperty(vm, "atan", objectValue(newFunctionObject(math_atan, nil)), 1)
+	mathO.defineDefaultProperty(vm, "atanh", objectValue(newFunctionObject(math_atanh, nil)), 1)
+	mathO.defineDefaultProperty(vm, "atan2", objectValue(newFunctionObject(math_atan2, nil)), 2)
+	mathO.defineDefaultProperty(vm, "cbrt", objectValue(newFunctionObject(math_cbrt, nil)), 1)
+	mathO.defineDefaultProperty(vm, "ceil", objectValue(newFunctionObject(math_ceil, nil)), 1)
+	mathO.defineDefaultProperty(vm, "clz32", objectValue(newFunctionObject(math_clz32, nil)), 1)
+	mathO.defineDefaultProperty(vm, "cos", objectValue(newFunctionObject(math_cos, nil)), 1)
+	mathO.defineDefaultProperty(vm, "cosh", objectValue(newFunctionObject(math_cosh, nil)), 1)
+	mathO.defineDefaultProperty(vm, "exp", objectValue(newFunctionObject(math_exp, nil)), 1)
+	mathO.defineDefaultProperty(vm, "expm1", objectValue(newFunctionObject(math_expm1, nil)), 1)
+	mathO.defineDefaultProperty(vm, "floor", objectValue(newFunctionObject(math_floor, nil)), 1)
+	mathO.defineDefaultProperty(vm, "fround", objectValue(newFunctionObject(math_fround, nil)), 1)
+	mathO.defineDefaultProperty(vm, "hypot", objectValue(newFunctionObject(math_hypot, nil)), 2)
+	mathO.defineDefaultProperty(vm, "imul", objectValue(newFunctionObject(math_imul, nil)), 2)
+	mathO.defineDefaultProperty(vm, "log", objectValue(newFunctionObject(math_log, nil)), 1)
+	mathO.defineDefaultProperty(vm, "log1p", objectValue(newFunctionObject(math_log1p, nil)), 1)
+	mathO.defineDefaultProperty(vm, "log10", objectValue(newFunctionObject(math_log10, nil)), 1)
+	mathO.defineDefaultProperty(vm, "log2", objectValue(newFunctionObject(math_log2, nil)), 1)
+	mathO.defineDefaultProperty(vm, "max", objectValue(newFunctionObject(math_max, nil)), 1)
+	mathO.defineDefaultProperty(vm, "min", objectValue(newFunctionObject(math_min, nil)), 1)
+	mathO.defineDefaultProperty(vm, "pow", objectValue(newFunctionObject(math_pow, nil)), 2)
+	mathO.defineDefaultProperty(vm, "random", objectValue(newFunctionObject(math_random, nil)), 1)
+	mathO.defineDefaultProperty(vm, "round", objectValue(newFunctionObject(math_round, nil)), 1)
+	mathO.defineDefaultProperty(vm, "sign", objectValue(newFunctionObject(math_sign, nil)), 1)
+	mathO.defineDefaultProperty(vm, "sin", objectValue(newFunctionObject(math_sin, nil)), 1)
+	mathO.defineDefaultProperty(vm, "sinh", objectValue(newFunctionObject(math_sinh, nil)), 1)
+	mathO.defineDefaultProperty(vm, "sqrt", objectValue(newFunctionObject(math_sqrt, nil)), 1)
+	mathO.defineDefaultProperty(vm, "tan", objectValue(newFunctionObject(math_tan, nil)), 1)
+	mathO.defineDefaultProperty(vm, "tanh", objectValue(newFunctionObject(math_tanh, nil)), 1)
+	mathO.defineDefaultProperty(vm, "trunc", objectValue(newFunctionObject(math_trunc, nil)), 1)
 	return mathO
 }
 
+// ES5 15.8.2.13, shared with the ** operator.
+// Go's math.Pow disagrees with the spec when the exponent is NaN, or when the
+// base is +/-1 and the exponent is infinite: both are NaN in JavaScript.
+func exponentiate(x, y float64) float64 {
+	if math.IsNaN(y) {
+		return math.NaN()
+	}
+	if math.IsInf(y, 0) && math.Abs(x) == 1 {
+		return math.NaN()
+	}
+	return math.Pow(x, y)
+}
+
 func math_abs(vm *vm, f value, args []value) value {
-	return newNumber(math.Abs(args[0].ToNumber()))
+	return newNumber(math.Abs(argument(args, 0).ToNumber()))
 }
 
 func math_acos(vm *vm, f value, args []value) value {
-	return newNumber(math.Acos(args[0].ToNumber()))
+	return newNumber(math.Acos(argument(args, 0).ToNumber()))
+}
+
+func math_acosh(vm *vm, f value, args []value) value {
+	return newNumber(math.Acosh(argument(args, 0).ToNumber()))
 }
 
 func math_asin(vm *vm, f value, args []value) value {
-	return newNumber(math.Asin(args[0].ToNumber()))
+	return newNumber(math.Asin(argument(args, 0).ToNumber()))
+}
+
+func math_asinh(vm *vm, f value, args []value) value {
+	return newNumber(math.Asinh(argument(args, 0).ToNumber()))
 }
 
 func math_atan(vm *vm, f value, args []value) value {
-	return newNumber(math.Atan(args[0].ToNumber()))
+	return newNumber(math.Atan(argument(args, 0).ToNumber()))
+}
+
+func math_atanh(vm *vm, f value, args []value) value {
+	return newNumber(math.Atanh(argument(args, 0).ToNumber()))
+}
+
+func math_atan2(vm *vm, f value, args []value) value {
+	y := argument(args, 0).ToNumber()
+	x := argument(args, 1).ToNumber()
+	return newNumber(math.Atan2(y, x))
+}
+
+func math_cbrt(vm *vm, f value, args []value) value {
+	return newNumber(math.Cbrt(argument(args, 0).ToNumber()))
 }
 
 func math_ceil(vm *vm, f value, args []value) value {
-	return newNumber(math.Ceil(args[0].ToNumber()))
+	return newNumber(math.Ceil(argument(args, 0).ToNumber()))
+}
+
+func math_clz32(vm *vm, f value, args []value) value {
+	return newNumber(float64(bits.LeadingZeros32(toUint32(argument(args, 0).ToNumber()))))
 }
 
 func math_cos(vm *vm, f value, args []value) value {
-	return newNumber(math.Cos(args[0].ToNumber()))
+	return newNumber(math.Cos(argument(args, 0).ToNumber()))
+}
+
+func math_cosh(vm *vm, f value, args []value) value {
+	return newNumber(math.Cosh(argument(args, 0).ToNumber()))
 }
 
 func math_exp(vm *vm, f value, args []value) value {
-	return newNumber(math.Exp(args[0].ToNumber()))
+	return newNumber(math.Exp(argument(args, 0).ToNumber()))
+}
+
+func math_expm1(vm *vm, f value, args []value) value {
+	return newNumber(math.Expm1(argument(args, 0).ToNumber()))
 }
 
 func math_floor(vm *vm, f value, args []value) value {
-	return newNumber(math.Floor(args[0].ToNumber()))
+	return newNumber(math.Floor(argument(args, 0).ToNumber()))
+}
+
+func math_fround(vm *vm, f value, args []value) value {
+	return newNumber(float64(float32(argument(args, 0).ToNumber())))
+}
+
+func math_hypot(vm *vm, f value, args []value) value {
+	// All arguments are converted before any of them are looked at, and an
+	// infinity wins over a NaN wherever the two appear.
+	nums := make([]float64, len(args))
+	for i, a := range args {
+		nums[i] = a.ToNumber()
+	}
+
+	largest := 0.0
+	sawNaN := false
+	for _, n := range nums {
+		if math.IsInf(n, 0) {
+			return newNumber(math.Inf(+1))
+		}
+		if math.IsNaN(n) {
+			sawNaN = true
+		}
+		largest = math.Max(largest, math.Abs(n))
+	}
+	if sawNaN {
+		return newNumber(math.NaN())
+	}
+	if largest == 0 {
+		return newNumber(+0)
+	}
+
+	// Scale by the largest argument so that squaring can't overflow.
+	sum := 0.0
+	for _, n := range nums {
+		r := n / largest
+		sum += r * r
+	}
+	return newNumber(largest * math.Sqrt(sum))
+}
+
+func math_imul(vm *vm, f value, args []value) value {
+	a := toUint32(argument(args, 0).ToNumber())
+	b := toUint32(argument(args, 1).ToNumber())
+	return newNumber(float64(int32(a * b)))
 }
 
 func math_log(vm *vm, f value, args []value) value {
-	return newNumber(math.Log(args[0].ToNumber()))
+	return newNumber(math.Log(argument(args, 0).ToNumber()))
+}
+
+func math_log1p(vm *vm, f value, args []value) value {
+	return newNumber(math.Log1p(argument(args, 0).ToNumber()))
+}
+
+func math_log10(vm *vm, f value, args []value) value {
+	return newNumber(math.Log10(argument(args, 0).ToNumber()))
+}
+
+func math_log2(vm *vm, f value, args []value) value {
+	return newNumber(math.Log2(argument(args, 0).ToNumber()))
 }
 
 func math_max(vm *vm, f value, args []value) value {
@@ -124,22 +265,63 @@ func math_min(vm *vm, f value, args []value) value {
 	return newNumber(ret)
 }
 
+func math_pow(vm *vm, f value, args []value) value {
+	x := argument(args, 0).ToNumber()
+	y := argument(args, 1).ToNumber()
+	return newNumber(exponentiate(x, y))
+}
+
 func math_random(vm *vm, f value, args []value) value {
-	return newNumber(rand.Float64())
+	return newNumber(vm.random.Float64())
 }
 
+// ES5 15.8.2.15
+// Halves round towards +Infinity, unlike math.Round, which rounds them away
+// from zero. The result keeps the sign of a negative argument that rounds to 0.
 func math_round(vm *vm, f value, args []value) value {
-	return newNumber(math.Round(args[0].ToNumber()))
+	x := argument(args, 0).ToNumber()
+	r := math.Floor(x)
+	if x-r >= 0.5 {
+		r += 1
+	}
+	if r == 0 && x < 0 {
+		return newNumber(math.Copysign(0, -1))
+	}
+	return newNumber(r)
+}
+
+func math_sign(vm *vm, f value, args []value) value {
+	x := argument(args, 0).ToNumber()
+	if math.IsNaN(x) || x == 0 {
+		// preserves -0
+		return newNumber(x)
+	}
+	if x < 0 {
+		return newNumber(-1)
+	}
+	return newNumber(1)
 }
 
 func math_sin(vm *vm, f value, args []value) value {
-	return newNumber(math.Sin(args[0].ToNumber()))
+	return newNumber(math.Sin(argument(args, 0).ToNumber()))
+}
+
+func math_sinh(vm *vm, f value, args []value) value {
+	return newNumber(math.Sinh(argument(args, 0).ToNumber()))
 }
 
 func math_sqrt(vm *vm, f value, args []value) value {
-	return newNumber(math.Sqrt(args[0].ToNumber()))
+	return newNumber(math.Sqrt(argument(args, 0).ToNumber()))
 }
 
 func math_tan(vm *vm, f value, args []value) value {
-	return newNumber(math.Tan(args[0].ToNumber()))
+	return newNumber(math.Tan(argument(args, 0).ToNumber()))
+}
+
+func math_tanh(vm *vm, f value, args []value) value {
+	return newNumber(math.Tanh(argument(args, 0).ToNumber()))
+}
+
+func math_trunc(vm *vm, f value, args []value) value {
+	return newNumber(math.Trunc(argument(args, 0).ToNumber()))
 }
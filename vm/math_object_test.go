@@ -27,6 +27,9 @@
 package vm
 
 import (
+	"github.com/stvp/assert"
+	"math"
+	"math/rand"
 	"testing"
 )
 
@@ -87,7 +90,233 @@ func TestMathObject(t *testing.T) {
 	}
 
 	runSimpleVMTestHelper(t, tests)
+}
+
+func TestMathConstants(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "return Math.LOG10E",
+			out: newNumber(math.Log10E),
+		},
+		simpleVMTest{
+			in:  "return Math.LOG2E",
+			out: newNumber(math.Log2E),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+// NaN and the sign of zero can't be told apart by comparing values, so these
+// are checked with x != x and 1/x instead.
+func TestMathEdgeCases(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var x = Math.abs(); return x != x",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "return Math.pow(2, 10)",
+			out: newNumber(1024),
+		},
+		simpleVMTest{
+			in:  "return Math.pow(4, 0.5)",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "return Math.pow(0/0, 0)",
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  "var x = Math.pow(1, 0/0); return x != x",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "var x = Math.pow(1, 1/0); return x != x",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "var x = Math.pow(-1, -1/0); return x != x",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "var x = 1 ** (1/0); return x != x",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "return 1 / Math.pow(-0, 3)",
+			out: newNumber(math.Inf(-1)),
+		},
+		simpleVMTest{
+			in:  "return Math.atan2(1, 1)",
+			out: newNumber(math.Pi / 4),
+		},
+		simpleVMTest{
+			in:  "return Math.atan2(0, -0)",
+			out: newNumber(math.Pi),
+		},
+		simpleVMTest{
+			in:  "return 1 / Math.atan2(-0, 0)",
+			out: newNumber(math.Inf(-1)),
+		},
+		simpleVMTest{
+			in:  "return Math.round(2.5)",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "return Math.round(-2.5)",
+			out: newNumber(-2),
+		},
+		simpleVMTest{
+			in:  "return Math.round(0.49999999999999994)",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "return 1 / Math.round(-0.2)",
+			out: newNumber(math.Inf(-1)),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestMathES2015(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "return Math.sign(-3)",
+			out: newNumber(-1),
+		},
+		simpleVMTest{
+			in:  "return Math.sign(3)",
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  "return 1 / Math.sign(-0)",
+			out: newNumber(math.Inf(-1)),
+		},
+		simpleVMTest{
+			in:  "var x = Math.sign(0/0); return x != x",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "return Math.trunc(-4.7)",
+			out: newNumber(-4),
+		},
+		simpleVMTest{
+			in:  "return 1 / Math.trunc(-0.5)",
+			out: newNumber(math.Inf(-1)),
+		},
+		simpleVMTest{
+			in:  "return Math.cbrt(-27)",
+			out: newNumber(-3),
+		},
+		simpleVMTest{
+			in:  "return Math.hypot(3, 4)",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "return Math.hypot(1, 2, 2)",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "return Math.hypot()",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "var a = 10**200; return Math.hypot(a, a)",
+			out: newNumber(math.Pow(10, 200) * math.Sqrt2),
+		},
+		simpleVMTest{
+			in:  "return Math.hypot(0/0, -1/0)",
+			out: newNumber(math.Inf(1)),
+		},
+		simpleVMTest{
+			in:  "var x = Math.hypot(0/0, 1); return x != x",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "return Math.log2(8)",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "return Math.log10(1000)",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "return Math.log1p(0)",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "return 1 / Math.expm1(-0)",
+			out: newNumber(math.Inf(-1)),
+		},
+		simpleVMTest{
+			in:  "return Math.sinh(0)",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "return Math.cosh(0)",
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  "return Math.tanh(1/0)",
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  "return Math.asinh(0)",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "return Math.acosh(1)",
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  "return Math.atanh(1)",
+			out: newNumber(math.Inf(1)),
+		},
+		simpleVMTest{
+			in:  "return Math.fround(5.5)",
+			out: newNumber(5.5),
+		},
+		simpleVMTest{
+			in:  "return Math.fround(5.05)",
+			out: newNumber(5.050000190734863),
+		},
+		simpleVMTest{
+			in:  "return Math.imul(3, 4)",
+			out: newNumber(12),
+		},
+		simpleVMTest{
+			in:  "return Math.imul(4294967295, 5)",
+			out: newNumber(-5),
+		},
+		simpleVMTest{
+			in:  "return Math.clz32(1)",
+			out: newNumber(31),
+		},
+		simpleVMTest{
+			in:  "return Math.clz32(0)",
+			out: newNumber(32),
+		},
+		simpleVMTest{
+			in:  "return Math.clz32(-1)",
+			out: newNumber(0),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestMathRandom(t *testing.T) {
+	want := rand.New(rand.NewSource(42)).Float64()
+
+	for i := 0; i < 2; i++ {
+		vm := New("return Math.random()")
+		vm.SetRandomSource(rand.New(rand.NewSource(42)))
+		assert.Equal(t, mustRun(t, vm), newNumber(want))
+	}
 
-	// untested: pow, atan2 (not implemented yet)
-	// random() (no reliable return value, but we should ensure it returns 0..1
+	vm := New("return Math.random()")
+	n := mustRun(t, vm).ToNumber()
+	assert.True(t, n >= 0 && n < 1)
 }
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+// What a script allocates (strings, array elements, objects and their
+// properties) is charged to its vm, so a host can see how much memory the
+// script is using, and stop a script that uses too much.
+//
+// Charging is only counting, so it's cheap, but nothing is ever given back as
+// things become garbage. So once the count goes over the limit, the vm
+// measures what the script can still reach, and only stops the script if that
+// and what it is allocating are over the limit too. The count then starts again
+// from that. Allocations are charged before they are made, so one that is too
+// big on its own is never made.
+//
+// Measuring walks everything the script can reach, so when that is close to
+// the limit, the vm lets the count go a good way past it (by an eighth of what
+// was measured) before measuring again, rather than walking it all on almost
+// every allocation. A script can so go over its limit by up to an eighth
+// before it is stopped.
+//
+// Sizes are estimates, of about what Go needs to hold each thing.
+
+const (
+	valueSize    = int64(unsafe.Sizeof(value{}))
+	propertySize = int64(unsafe.Sizeof(propertyDescriptor{}) + unsafe.Sizeof(&propertyDescriptor{}))
+	objectSize   = int64(unsafe.Sizeof(valueBasicObjectData{}))
+)
+
+type memory struct {
+	used     int64 // estimated, in bytes; read and written atomically
+	limit    int64 // or 0 for none
+	baseline int64 // what the builtins take, which isn't the script's
+	shapes   int64 // what the vm's shapes take, which are never freed
+
+	// the count to go over before measuring next; the limit, or more if the
+	// last measurement was close to it
+	nextMeasure int64
+
+	measurements int // how many times memory was measured, for tests
+}
+
+// SetMemoryLimit sets how many bytes a script can use before it is stopped,
+// or 0 for no limit.
+func (this *vm) SetMemoryLimit(bytes int64) {
+	this.memory.limit = bytes
+}
+
+// MemoryUsage returns about how many bytes the script is using. It is at
+// least what the script can reach, and can be more, as garbage is only noticed
+// when the limit is reached. It can be called from any goroutine.
+func (this *vm) MemoryUsage() int64 {
+	return atomic.LoadInt64(&this.memory.used)
+}
+
+// allocate charges the script for bytes of memory that it is about to
+// allocate, stopping it if that takes it over the limit.
+func (this *vm) allocate(bytes int64) {
+	used := atomic.AddInt64(&this.memory.used, bytes)
+	if this.memory.limit <= 0 || used <= this.memory.limit || used <= this.memory.nextMeasure {
+		return
+	}
+
+	// what is being allocated can't be reached yet, so isn't measured
+	live := this.measureMemory()
+	if live+bytes > this.memory.limit {
+		atomic.StoreInt64(&this.memory.used, live)
+		this.terminate(fmt.Sprintf("memory limit of %d bytes exceeded", this.memory.limit), nil)
+	}
+	used = live + bytes
+	atomic.StoreInt64(&this.memory.used, used)
+
+	headroom := this.memory.limit - used
+	if headroom < used/8 {
+		headroom = used / 8
+	}
+	this.memory.nextMeasure = used + headroom
+}
+
+// allocateString charges the script for a new string.
+func (this *vm) allocateString(length int) {
+	this.allocate(int64(length))
+}
+
+// setElement sets an element of an array, charging for whatever it takes.
+func (this *vm) setElement(ad *valueArrayData, idx uint32, v value) {
+	before := ad.size()
+	ad.Set(idx, v)
+	if after := ad.size(); after > before {
+		this.allocate(after - before)
+	}
+}
+
+// size returns about how much memory the elements of an array take.
+func (this *valueArrayData) size() int64 {
+	if this.sparse != nil {
+		return int64(len(this.sparse)) * (valueSize + int64(unsafe.Sizeof(uint32(0))))
+	}
+	return int64(len(this.dense)) * valueSize
+}
+
+// measureMemory returns about how much memory the script can still reach.
+func (this *vm) measureMemory() int64 {
+	this.memory.measurements++
+	m := memoryWalk{vm: this, seen: make(map[unsafe.Pointer]bool)}
+	m.object(this.globalObject)
+	for _, proto := range []*valueBasicObject{&this.objectProto, &this.arrayProto, &this.stringProto, &this.booleanProto, &this.numberProto, &this.dateProto} {
+		if proto.odata != nil {
+			m.object(*proto)
+		}
+	}
+	for _, fn := range this.functions {
+		m.value(fn)
+	}
+	for idx := 0; idx < this.stack.depth; idx++ {
+		f := this.stack.at(idx)
+		m.value(f.thisArg)
+		for _, v := range f.registers {
+			m.value(v)
+		}
+	}
+	for _, v := range this.args.values {
+		m.value(v)
+	}
+	m.value(this.returnValue)
+	m.size += this.memory.shapes
+
+	if m.size < this.memory.baseline {
+		return 0
+	}
+	return m.size - this.memory.baseline
+}
+
+// memoryWalk adds up the size of everything it is shown, and everything that
+// can be reached from it, counting each object and string once.
+type memoryWalk struct {
+	vm   *vm
+	seen map[unsafe.Pointer]bool
+	size int64
+}
+
+func (this *memoryWalk) value(v value) {
+	switch v.kind {
+	case kindString:
+		this.string(v.str)
+	case kindObject:
+		this.object(v.obj)
+	}
+}
+
+func (this *memoryWalk) string(s string) {
+	if len(s) == 0 {
+		return
+	}
+	p := unsafe.Pointer(unsafe.StringData(s))
+	if this.seen[p] {
+		return
+	}
+	this.seen[p] = true
+	this.size += int64(len(s))
+}
+
+func (this *memoryWalk) object(o valueObject) {
+	od := o.objectData()
+	storage := od.Storage()
+	if this.seen[unsafe.Pointer(storage)] {
+		return
+	}
+	this.seen[unsafe.Pointer(storage)] = true
+
+	this.size += objectSize
+	for _, pd := range od.Properties() {
+		this.size += propertySize + int64(len(pd.name))
+		this.value(pd.value)
+	}
+
+	switch t := o.(type) {
+	case arrayObject:
+		this.size += t.primitiveData.size()
+		for _, v := range t.primitiveData.dense {
+			this.value(v)
+		}
+		for _, v := range t.primitiveData.sparse {
+			this.value(v)
+		}
+	case stringObject:
+		this.string(t.primitiveData)
+	case functionObject:
+		if t.prototype != nil {
+			this.object(*t.prototype)
+		}
+	}
+
+	if proto := od.Prototype(this.vm); proto != nil && proto.odata != nil {
+		this.object(*proto)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/stvp/assert"
+	"testing"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	v := New("return 1")
+	mustRun(t, v)
+	assert.Equal(t, v.MemoryUsage(), int64(0))
+
+	v = New(`var s = "x".repeat(100000); var a = [s, s]; var o = {a: a}`)
+	mustRun(t, v)
+	assert.True(t, v.MemoryUsage() >= 100000)
+	assert.True(t, v.MemoryUsage() < 200000)
+
+	// what is measured counts each string once, however often it's used,
+	// plus a little for the objects and their shapes
+	live := v.measureMemory()
+	assert.True(t, live >= 100000)
+	assert.True(t, live < 105000)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []string{
+		`var s = "x"; while (true) s = s + s`,
+		`var s = "x"; while (true) s = s.repeat(2)`,
+		`var s = "x"; while (true) s = s.padEnd(s.length * 2, "y")`,
+		`var a = []; while (true) a.push(1)`,
+		`var a = []; var i = 0; while (true) { a[i] = i; i++ }`,
+		`var a = []; while (true) a = [a, a, a, a]`,
+		`var o = {}; var i = 0; while (true) { o["p" + i] = i; i++ }`,
+		`var a = [1, 2, 3]; while (true) a = a.concat(a)`,
+	}
+	for _, in := range tests {
+		t.Logf("Testing: %s", in)
+		v := New(in)
+		v.SetMemoryLimit(1 << 20)
+		te := runTerminated(t, v.Run)
+		assert.Equal(t, te.Reason, "memory limit of 1048576 bytes exceeded")
+	}
+}
+
+// An allocation that is too big on its own is never made.
+func TestMemoryLimitSingleAllocation(t *testing.T) {
+	tests := []string{
+		`"x".repeat(200000000)`,
+		`var a = []; a.length = 100000000; a.join("ab")`,
+		`var s = "x".repeat(500000); s.concat(s, s)`,
+	}
+	for _, in := range tests {
+		t.Logf("Testing: %s", in)
+		v := New(in)
+		v.SetMemoryLimit(1 << 20)
+		te := runTerminated(t, v.Run)
+		assert.Equal(t, te.Reason, "memory limit of 1048576 bytes exceeded")
+		assert.True(t, v.MemoryUsage() < 1<<21)
+	}
+}
+
+// Garbage isn't held against a script.
+func TestMemoryLimitGarbage(t *testing.T) {
+	v := New(`var i = 0; var s; while (i < 100) { s = "x".repeat(100000); i++ }; return s.length`)
+	v.SetMemoryLimit(1 << 20)
+	assert.Equal(t, mustRun(t, v), newNumber(100000))
+	assert.True(t, v.MemoryUsage() < 1<<20)
+}
+
+// Being close to the limit doesn't mean measuring on every allocation.
+func TestMemoryLimitMeasurements(t *testing.T) {
+	v := New(`var a = []; var i = 0; while (i < 20000) { a.push({}); i++ }`)
+	mustRun(t, v)
+	live := v.measureMemory()
+
+	v.SetMemoryLimit(live + 4096)
+	before := v.memory.measurements
+	_, err := v.Eval(`var j = 0; while (j < 20000) { var s = "y".repeat(8); j++ }`)
+	assert.Nil(t, err)
+	assert.True(t, v.memory.measurements-before <= 2)
+}
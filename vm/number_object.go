@@ -31,34 +31,32 @@ import (
 	"math"
 )
 
-var numberProto valueBasicObject
-
 type numberObjectData struct {
 	*valueBasicObjectData
 	primitiveData float64
 }
 
-func (this *numberObjectData) Prototype() *valueBasicObject {
-	return &numberProto
+func (this *numberObjectData) Prototype(vm *vm) *valueBasicObject {
+	return &vm.numberProto
 }
 
 func newNumberObject(f float64) valueBasicObject {
-	return valueBasicObject{&numberObjectData{&valueBasicObjectData{extensible: true}, f}}
+	return valueBasicObject{&numberObjectData{newValueBasicObjectData(), f}}
 }
 
 func defineNumberCtor(vm *vm) functionObject {
-	numberProto = valueBasicObject{&rootObjectData{&valueBasicObjectData{extensible: true}}}
-	numberProto.defineDefaultProperty(vm, "toString", newFunctionObject(number_prototype_toString, nil), 0)
+	vm.numberProto = valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	vm.numberProto.defineDefaultProperty(vm, "toString", objectValue(newFunctionObject(number_prototype_toString, nil)), 0)
 
 	numberO := newFunctionObject(number_call, number_ctor)
-	numberO.prototype = &numberProto
+	numberO.prototype = &vm.numberProto
 	numberO.defineDefaultProperty(vm, "MAX_VALUE", newNumber(math.MaxFloat64), 0)
 	numberO.defineDefaultProperty(vm, "MIN_VALUE", newNumber(math.SmallestNonzeroFloat64), 0)
 	numberO.defineDefaultProperty(vm, "NaN", newNumber(math.NaN()), 0)
 	numberO.defineDefaultProperty(vm, "NEGATIVE_INFINITY", newNumber(math.Inf(-1)), 0)
 	numberO.defineDefaultProperty(vm, "POSITIVE_INFINITY", newNumber(math.Inf(+1)), 0)
 
-	numberProto.defineDefaultProperty(vm, "constructor", numberO, 0)
+	vm.numberProto.defineDefaultProperty(vm, "constructor", objectValue(numberO), 0)
 	return numberO
 }
 
@@ -72,9 +70,9 @@ func number_call(vm *vm, f value, args []value) value {
 
 func number_ctor(vm *vm, f value, args []value) value {
 	if len(args) > 0 {
-		return newNumberObject(args[0].ToNumber())
+		return objectValue(newNumberObject(args[0].ToNumber()))
 	} else {
-		return newNumberObject(+0)
+		return objectValue(newNumberObject(+0))
 	}
 }
 
@@ -84,11 +82,11 @@ func number_prototype_toString(vm *vm, f value, args []value) value {
 	}
 
 	n := 0.0
-	switch o := f.(type) {
-	case valueNumber:
-		n = float64(o)
-	case valueBasicObject:
-		n = o.odata.(*numberObjectData).primitiveData
+	switch {
+	case f.isNumber():
+		n = f.num
+	case f.isObject():
+		n = f.obj.objectData().(*numberObjectData).primitiveData
 	default:
 		panic(fmt.Sprintf("Not a number! %s", f)) // ### throw
 	}
@@ -54,7 +54,7 @@ func (this valueBasicObject) canPut(vm *vm, prop value) bool {
 		}
 	}
 
-	proto := this.odata.Prototype()
+	proto := this.odata.Prototype(vm)
 	if proto == nil {
 		return this.odata.IsExtensible()
 	}
@@ -101,7 +101,8 @@ func (this valueBasicObject) defineOwnProperty(vm *vm, prop value, desc *propert
 		} else {
 			pd = &propertyDescriptor{name: prop.String(), get: desc.get, hasGet: true, set: desc.set, hasSet: true, enumerable: desc.enumerable, hasEnumerable: true, configurable: desc.configurable, hasConfigurable: true}
 		}
-		this.odata.(objectData).AppendProperty(pd)
+		vm.allocate(propertySize + int64(len(pd.name)))
+		this.odata.AppendProperty(vm, pd)
 		//log.Printf("Added new property %s %+v", prop, pd)
 		return true
 	}
@@ -225,7 +226,7 @@ func (this valueBasicObject) put(vm *vm, prop value, v value, throw bool) {
 
 	desc := this.getProperty(vm, prop)
 	if desc != nil && desc.isAccessorDescriptor() {
-		desc.set(vm, this, prop, desc, v)
+		desc.set(vm, objectValue(this), prop, desc, v)
 	} else {
 		newDesc := &propertyDescriptor{value: v, hasValue: true, writable: true, hasWritable: true, enumerable: true, hasEnumerable: true, configurable: true, hasConfigurable: true}
 		this.defineOwnProperty(vm, prop, newDesc, throw)
@@ -241,31 +242,23 @@ func (this valueBasicObject) get(vm *vm, prop value) value {
 	if desc.isDataDescriptor() {
 		return desc.value
 	} else if desc.isAccessorDescriptor() {
-		return desc.get(vm, this, prop, desc)
+		return desc.get(vm, objectValue(this), prop, desc)
 	}
 
 	panic("unreachable")
 }
 
 func (this valueBasicObject) getOwnProperty(vm *vm, prop value) *propertyDescriptor {
-	props := this.odata.(objectData).Properties()
 	if objectDebug {
-		log.Printf("GetOwnProperty %T.%s %d props", this, prop, len(props))
+		log.Printf("GetOwnProperty %T.%s %d props", this, prop, len(this.odata.Properties()))
 	}
-	for idx, _ := range props {
-		if objectDebug {
-			log.Printf("Looking for %s found %s", prop, props[idx].name)
-		}
-		if props[idx].name == prop.String() {
-			return props[idx]
-		}
-	}
-
-	return nil
+	return this.odata.FindProperty(prop.String())
 }
 
 func (this valueBasicObject) hasInstance(vm *vm, instance value) bool {
-	log.Printf("valueBasicObject hasInstance: %+v STUB", instance)
+	if objectDebug {
+		log.Printf("valueBasicObject hasInstance: %+v STUB", instance)
+	}
 	return false
 }
 
@@ -275,7 +268,7 @@ func (this valueBasicObject) getProperty(vm *vm, prop value) *propertyDescriptor
 		return pd
 	}
 
-	po := this.odata.Prototype()
+	po := this.odata.Prototype(vm)
 	if po == nil {
 		return nil
 	}
@@ -324,8 +317,17 @@ func (this *propertyDescriptor) isAccessorDescriptor() bool {
 	return this.get != nil || this.set != nil
 }
 
+// valueObject is an object that a value can hold. Objects convert themselves
+// to primitives, so they have the same conversions as a value does.
 type valueObject interface {
-	value
+	ToInteger() int
+	ToNumber() float64
+	ToBoolean() bool
+	ToString() value
+	ToObject() valueObject
+	hasPrimitiveBase() bool
+	String() string
+
 	objectData() objectData
 	defineOwnProperty(vm *vm, prop value, desc *propertyDescriptor, throw bool) bool
 	getOwnProperty(vm *vm, prop value) *propertyDescriptor
@@ -343,23 +345,67 @@ func (this valueBasicObject) objectData() objectData {
 }
 
 type objectData interface {
-	Prototype() *valueBasicObject
+	Prototype(vm *vm) *valueBasicObject
 	Properties() []*propertyDescriptor
-	AppendProperty(pd *propertyDescriptor)
+	FindProperty(name string) *propertyDescriptor
+	AppendProperty(vm *vm, pd *propertyDescriptor)
 	IsExtensible() bool
+	Storage() *valueBasicObjectData
 }
 
+// valueBasicObjectData holds an object's own properties, in the order they
+// were added. See shape for how they are found.
 type valueBasicObjectData struct {
-	properties []*propertyDescriptor
+	shape      *shape         // nil in dictionary mode
+	dictionary map[string]int // slots by name, in dictionary mode
+	slots      []*propertyDescriptor
 	extensible bool // ### is this needed?
 }
 
-func (this *valueBasicObjectData) AppendProperty(pd *propertyDescriptor) {
-	this.properties = append(this.properties, pd)
+func newValueBasicObjectData() *valueBasicObjectData {
+	return &valueBasicObjectData{shape: emptyShape, extensible: true}
+}
+
+func (this *valueBasicObjectData) AppendProperty(vm *vm, pd *propertyDescriptor) {
+	slot := len(this.slots)
+	this.slots = append(this.slots, pd)
+
+	if this.shape == nil {
+		this.dictionary[pd.name] = slot
+		return
+	}
+	if slot < maxShapeProperties {
+		this.shape = this.shape.with(vm, pd.name)
+		return
+	}
+
+	this.dictionary = make(map[string]int, len(this.slots))
+	for idx, pd := range this.slots {
+		this.dictionary[pd.name] = idx
+	}
+	this.shape = nil
+}
+
+func (this *valueBasicObjectData) FindProperty(name string) *propertyDescriptor {
+	var slot int
+	var ok bool
+	if this.shape == nil {
+		slot, ok = this.dictionary[name]
+	} else {
+		slot, ok = this.shape.lookup(name)
+	}
+	if !ok {
+		return nil
+	}
+	return this.slots[slot]
 }
 
 func (this *valueBasicObjectData) Properties() []*propertyDescriptor {
-	return this.properties
+	return this.slots
+}
+
+func (this *valueBasicObjectData) Storage() *valueBasicObjectData {
+	return this
 }
 
 func (this *valueBasicObjectData) IsExtensible() bool {
@@ -35,7 +35,7 @@ type rootObjectData struct {
 	*valueBasicObjectData
 }
 
-func (this *rootObjectData) Prototype() *valueBasicObject {
+func (this *rootObjectData) Prototype(vm *vm) *valueBasicObject {
 	return nil
 }
 
@@ -43,63 +43,59 @@ type basicObjectData struct {
 	*valueBasicObjectData
 }
 
-func (this *basicObjectData) Prototype() *valueBasicObject {
-	return &objectProto
+func (this *basicObjectData) Prototype(vm *vm) *valueBasicObject {
+	return &vm.objectProto
 }
 
-var objectProto valueBasicObject
-
 // Keep in mind that this is not just used by this file.
 func newBasicObject() valueBasicObject {
-	v := valueBasicObject{&basicObjectData{&valueBasicObjectData{extensible: true}}}
+	v := valueBasicObject{&basicObjectData{newValueBasicObjectData()}}
 	return v
 }
 
 func defineObjectCtor(vm *vm) value {
-	objectProto = valueBasicObject{&rootObjectData{&valueBasicObjectData{extensible: true}}}
-	objectProto.defineDefaultProperty(vm, "toString", newFunctionObject(object_prototype_toString, nil), 0)
-	objectProto.defineDefaultProperty(vm, "valueOf", newFunctionObject(object_prototype_valueOf, nil), 0)
-	objectProto.defineDefaultProperty(vm, "hasOwnProperty", newFunctionObject(object_prototype_hasOwnProperty, nil), 0)
+	vm.objectProto = valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	vm.objectProto.defineDefaultProperty(vm, "toString", objectValue(newFunctionObject(object_prototype_toString, nil)), 0)
+	vm.objectProto.defineDefaultProperty(vm, "valueOf", objectValue(newFunctionObject(object_prototype_valueOf, nil)), 0)
+	vm.objectProto.defineDefaultProperty(vm, "hasOwnProperty", objectValue(newFunctionObject(object_prototype_hasOwnProperty, nil)), 0)
 
 	objectCtor := newFunctionObject(object_call, object_ctor)
-	objectCtor.defineDefaultProperty(vm, "getPrototypeOf", newFunctionObject(object_ctor_getPrototypeOf, nil), 0)
-	objectCtor.prototype = &objectProto
+	objectCtor.defineDefaultProperty(vm, "getPrototypeOf", objectValue(newFunctionObject(object_ctor_getPrototypeOf, nil)), 0)
+	objectCtor.prototype = &vm.objectProto
 
-	return objectCtor
+	return objectValue(objectCtor)
 }
 
 func object_call(vm *vm, f value, args []value) value {
-	return args[0].ToObject()
+	return objectValue(args[0].ToObject())
 }
 
 func object_ctor(vm *vm, f value, args []value) value {
 	if len(args) > 0 {
 		v := args[0]
-		switch v.(type) {
-		case valueBasicObject:
-			return v
-		case valueString:
-			return v.ToObject()
-		case valueBool:
-			return v.ToObject()
-		case valueNumber:
-			return v.ToObject()
+		switch v.kind {
+		case kindObject:
+			if _, ok := v.obj.(valueBasicObject); ok {
+				return v
+			}
+		case kindString, kindBool, kindNumber:
+			return objectValue(v.ToObject())
 		}
 	}
 
 	o := newBasicObject()
-	return o
+	return objectValue(o)
 }
 
 func object_prototype_toString(vm *vm, f value, args []value) value {
-	switch f.(type) {
-	case valueUndefined:
+	switch f.kind {
+	case kindUndefined:
 		return newString("[object Undefined]")
-	case valueNull:
+	case kindNull:
 		return newString("[object Null]")
-	case valueString:
+	case kindString:
 		return newString("[object String]")
-	case valueNumber:
+	case kindNumber:
 		return newString("[object Number]")
 	}
 
@@ -117,13 +113,15 @@ func object_prototype_toString(vm *vm, f value, args []value) value {
 		return newString("[object Boolean]")
 	case *numberObjectData:
 		return newString("[object Number]")
+	case *dateObjectData:
+		return newString("[object Date]")
 	}
 	panic(fmt.Sprintf("%T is an unknown object type", o.objectData()))
 }
 
 func object_prototype_valueOf(vm *vm, f value, args []value) value {
 	o := f.ToObject()
-	return o
+	return objectValue(o)
 }
 
 func object_prototype_hasOwnProperty(vm *vm, f value, args []value) value {
@@ -139,9 +137,13 @@ func object_prototype_hasOwnProperty(vm *vm, f value, args []value) value {
 }
 
 func object_ctor_getPrototypeOf(vm *vm, f value, args []value) value {
-	switch o := f.(type) {
+	switch o := f.obj.(type) {
 	case valueBasicObject:
-		return o.odata.Prototype()
+		proto := o.odata.Prototype(vm)
+		if proto == nil {
+			return newNull()
+		}
+		return objectValue(*proto)
 	default:
 		return vm.ThrowTypeError("")
 	}
@@ -31,10 +31,17 @@ import (
 )
 
 // opcode instructions...
+//
+// Instructions operate on the registers of the current stack frame. A
+// function's locals (see scope) take the first registers, followed by its
+// temporaries, and then any scratch registers codegen needed (for instance to
+// hold a global while it is being stored).
+//
+// Operands that are only read may also name a constant; see operand.
 type opcode_type uint8
 
 const (
-	// Simple math operators
+	// Simple math operators: a = b op c
 	ADD opcode_type = iota
 	SUB
 	MULTIPLY
@@ -42,67 +49,74 @@ const (
 	LEFT_SHIFT
 	RIGHT_SHIFT
 	UNSIGNED_RIGHT_SHIFT
-	BITWISE_AND // a & b
-	BITWISE_XOR // a ^ b
-	BITWISE_OR  // a | b
+	BITWISE_AND // a = b & c
+	BITWISE_XOR // a = b ^ c
+	BITWISE_OR  // a = b | c
 
-	UPLUS       // +a
-	UMINUS      // -a
-	UNOT        // !a
-	TYPEOF      // typeof a
-	BITWISE_NOT // ~a
-
-	// a % b
+	// a = b % c
 	MODULUS
 
-	// These all push a given value to the stack.
-	LOAD_THIS      // 'this'
-	PUSH_UNDEFINED // undefined
-	PUSH_NULL      // null
-	PUSH_NUMBER    // 5
-	PUSH_ARRAY     // [a, b, c...]
-	PUSH_BOOL      // true
-	PUSH_STRING    // "hello" (note: the string index is given via the opdata)
-
-	// LOAD identifier
-	// Pushes a variable onto the stack.
-	// Note that this also sets the 'this' arg for calls.
-	LOAD
-
-	// Loads a member from the topmost stack item, and pushes it to the stack frame.
+	// a = b ** c
+	EXPONENT
+
+	UPLUS       // a = +b
+	UMINUS      // a = -b
+	UNOT        // a = !b
+	TYPEOF      // a = typeof b
+	TYPEOF_VAR  // a = typeof the global named b, which may be undeclared
+	BITWISE_NOT // a = ~b
+
+	// a = b
+	MOVE
+
+	// a = 'this'
+	LOAD_THIS
+
+	// a = the global named b (an index into the string table)
+	// b = the global named a
+	LOAD_GLOBAL
+	STORE_GLOBAL
+
+	// a = b.c, where c indexes the string table
+	// a.b = c, where b indexes the string table
+	// d indexes the vm's inline caches, for both
 	LOAD_MEMBER
 	STORE_MEMBER
+
+	// a = b[c]
+	// a[b] = c
 	LOAD_INDEXED
 	STORE_INDEXED
 
-	LOAD_TEMPORARY
-	STORE_TEMPORARY
+	// a = JavaScript function b
+	LOAD_FUNCTION
 
-	// Jump ip, relative to the current position.
+	// Jump to a
 	JMP
 
-	// call/new call the topmost function object on the stack.
-	// the opdata gives the argument count
-	// arguments are on the stack as well.
+	// Jump to b if a is false (misnamed ###)
+	JNE
+
+	// push a onto the argument stack
+	PUSH_ARG
+
+	// a = b(the last d arguments), with c as 'this'
 	CALL
 	NEW
 
 	// used to tell the VM which function it's inside, for debug printing
-	// purposes.
+	// purposes. a is the name, b the number of registers the function uses.
 	IN_FUNCTION
 
-	// jump if false (misnamed ###)
-	JNE
+	// the current function is strict code
+	USE_STRICT
 
-	// return from function
+	// return a from function
 	RETURN
 
-	// declare var
+	// declare the global named a
 	DECLARE
 
-	// store var
-	STORE
-
 	LESS_THAN
 	LESS_THAN_EQ
 	GREATER_THAN
@@ -116,170 +130,219 @@ const (
 	IN
 	INSTANCEOF
 
-	INCREMENT // a++
-	DECREMENT // a--
-
-	// discard item from the stack
-	POP
+	// Number-only versions of the above, see specialize.go.
+	ADD_NUMBER
+	SUB_NUMBER
+	INCREMENT // a = b + 1
+	DECREMENT // a = b - 1
+	LESS_THAN_NUMBER
+	LESS_THAN_EQ_NUMBER
+	GREATER_THAN_NUMBER
+	GREATER_THAN_EQ_NUMBER
 
-	// duplicate the top of the stack
-	DUP
+	// a = an array of the last b arguments
+	NEW_ARRAY
 
-	// Start an object definition. It will be followed by property definition,
-	// and an END_OBJECT.
+	// a = a new object
 	NEW_OBJECT
 
-	// Define property of the NEW_OBJECT on the stack, with the arg on the stack.
+	// Define property b of object a with the value c.
 	DEFINE_PROPERTY
 
-	// End object definition.
-	END_OBJECT
+	// not an opcode, but the number of them
+	opcodeCount
 )
 
-// 'opdata' is a piece of information attached to an opcode. It can be nothing,
-// like in the case of an instruction like ADD (as the operands are pushed onto
-// the stack earlier), or an actual value, like when pushing numbers onto the
-// stack.
-//
-// note that it is only ever valid in the context of the opcode_type for the
-// opcode -- e.g. PUSH_STRING uses it as an index into the string table, not as
-// a number
-type opdata float64
+// An operand is either a register, or (if it is negative) an index into the
+// constant table.
+type operand int32
+
+func registerOperand(reg int) operand {
+	return operand(reg)
+}
+
+func constantOperand(idx int) operand {
+	return operand(-idx - 1)
+}
+
+func (this operand) isConstant() bool {
+	return this < 0
+}
 
-func (this opdata) asFloat64() float64 {
-	return float64(this)
+func (this operand) constantIndex() int {
+	return int(-this - 1)
 }
 
-func (this opdata) asInt() int {
-	return int(this)
+func (this operand) String() string {
+	if this.isConstant() {
+		return fmt.Sprintf("k%d", this.constantIndex())
+	}
+	return fmt.Sprintf("r%d", int(this))
 }
 
 // an opcode for the VM to execute.
+//
+// What the operands mean depends on the opcode_type; not all of them are
+// operands in the sense of registers or constants: some are string table
+// indexes, counts, or code offsets.
 type opcode struct {
 	// what type of instruction?
 	otype opcode_type
 
-	// what data is attached to it?
-	opdata opdata
+	a operand
+	b operand
+	c operand
+	d operand
 }
 
 func (this opcode) String() string {
+	return this.format(nil)
+}
+
+// format describes an opcode, naming the strings it refers to from the string
+// table given, or by their index if they aren't in it.
+func (this opcode) format(strings []string) string {
 	switch this.otype {
 	case ADD:
-		return "ADD"
-	case UPLUS:
-		return "UPLUS"
-	case UMINUS:
-		return "UMINUS"
-	case UNOT:
-		return "UNOT"
-	case TYPEOF:
-		return "TYPEOF"
-	case BITWISE_NOT:
-		return "BITWISE_NOT"
+		return this.binaryString("+")
 	case SUB:
-		return "SUB"
+		return this.binaryString("-")
 	case MULTIPLY:
-		return "MUL"
+		return this.binaryString("*")
 	case DIVIDE:
-		return "DIV"
+		return this.binaryString("/")
 	case LEFT_SHIFT:
-		return "<<"
+		return this.binaryString("<<")
 	case RIGHT_SHIFT:
-		return ">>"
+		return this.binaryString(">>")
 	case UNSIGNED_RIGHT_SHIFT:
-		return ">>>"
+		return this.binaryString(">>>")
 	case BITWISE_AND:
-		return "&"
+		return this.binaryString("&")
 	case BITWISE_XOR:
-		return "^"
+		return this.binaryString("^")
 	case BITWISE_OR:
-		return "|"
+		return this.binaryString("|")
 	case MODULUS:
-		return "MOD"
-	case NEW_OBJECT:
-		return "NEW_OBJECT"
-	case DEFINE_PROPERTY:
-		return "DEFINE_PROPERTY"
-	case END_OBJECT:
-		return "END_OBJECT"
-	case DUP:
-		return "DUP"
-	case INCREMENT:
-		return "INCREMENT"
-	case DECREMENT:
-		return "DECREMENT"
-	case POP:
-		return "POP"
+		return this.binaryString("%")
+	case EXPONENT:
+		return this.binaryString("**")
 	case LESS_THAN:
-		return "<"
+		return this.binaryString("<")
 	case LESS_THAN_EQ:
-		return "<="
-	case LOGICAL_AND:
-		return "&&"
+		return this.binaryString("<=")
 	case GREATER_THAN:
-		return ">"
+		return this.binaryString(">")
 	case GREATER_THAN_EQ:
-		return ">="
+		return this.binaryString(">=")
 	case EQUALS:
-		return "=="
+		return this.binaryString("==")
 	case NOT_EQUALS:
-		return "!="
+		return this.binaryString("!=")
+	case STRICT_EQUALS:
+		return this.binaryString("===")
+	case STRICT_NOT_EQUALS:
+		return this.binaryString("!==")
+	case LOGICAL_AND:
+		return this.binaryString("&&")
+	case LOGICAL_OR:
+		return this.binaryString("||")
+	case IN:
+		return this.binaryString("in")
+	case INSTANCEOF:
+		return this.binaryString("instanceof")
+	case ADD_NUMBER:
+		return this.binaryString("+num")
+	case SUB_NUMBER:
+		return this.binaryString("-num")
+	case INCREMENT:
+		return fmt.Sprintf("%s = %s +num 1", this.a, this.b)
+	case DECREMENT:
+		return fmt.Sprintf("%s = %s -num 1", this.a, this.b)
+	case LESS_THAN_NUMBER:
+		return this.binaryString("<num")
+	case LESS_THAN_EQ_NUMBER:
+		return this.binaryString("<=num")
+	case GREATER_THAN_NUMBER:
+		return this.binaryString(">num")
+	case GREATER_THAN_EQ_NUMBER:
+		return this.binaryString(">=num")
+	case UPLUS:
+		return fmt.Sprintf("%s = +%s", this.a, this.b)
+	case UMINUS:
+		return fmt.Sprintf("%s = -%s", this.a, this.b)
+	case UNOT:
+		return fmt.Sprintf("%s = !%s", this.a, this.b)
+	case TYPEOF:
+		return fmt.Sprintf("%s = typeof %s", this.a, this.b)
+	case TYPEOF_VAR:
+		return fmt.Sprintf("%s = typeof %s", this.a, stringName(strings, this.b))
+	case BITWISE_NOT:
+		return fmt.Sprintf("%s = ~%s", this.a, this.b)
+	case MOVE:
+		return fmt.Sprintf("%s = %s", this.a, this.b)
 	case LOAD_THIS:
-		return "LOAD this"
-	case PUSH_UNDEFINED:
-		return "PUSH undefined"
-	case PUSH_NULL:
-		return "PUSH null"
-	case PUSH_NUMBER:
-		return fmt.Sprintf("PUSH number(%f)", this.opdata)
-	case PUSH_ARRAY:
-		return fmt.Sprintf("PUSH array(%f)", this.opdata)
-	case PUSH_STRING:
-		return fmt.Sprintf("PUSH string(%d, \"%s\")", int(this.opdata), stringtable[int(this.opdata)])
-	case PUSH_BOOL:
-		return fmt.Sprintf("PUSH bool(%f)", this.opdata)
+		return fmt.Sprintf("%s = this", this.a)
+	case LOAD_GLOBAL:
+		return fmt.Sprintf("%s = LOAD_GLOBAL %s", this.a, stringName(strings, this.b))
+	case STORE_GLOBAL:
+		return fmt.Sprintf("STORE_GLOBAL %s = %s", stringName(strings, this.a), this.b)
+	case LOAD_MEMBER:
+		return fmt.Sprintf("%s = %s.%s", this.a, this.b, stringName(strings, this.c))
+	case STORE_MEMBER:
+		return fmt.Sprintf("%s.%s = %s", this.a, stringName(strings, this.b), this.c)
+	case LOAD_INDEXED:
+		return fmt.Sprintf("%s = %s[%s]", this.a, this.b, this.c)
+	case STORE_INDEXED:
+		return fmt.Sprintf("%s[%s] = %s", this.a, this.b, this.c)
+	case LOAD_FUNCTION:
+		return fmt.Sprintf("%s = LOAD_FUNCTION %d", this.a, int(this.b))
 	case JMP:
-		return fmt.Sprintf("JMP %d", int(this.opdata))
+		return fmt.Sprintf("JMP %d", int(this.a))
+	case JNE:
+		return fmt.Sprintf("JNE %s %d", this.a, int(this.b))
+	case PUSH_ARG:
+		return fmt.Sprintf("PUSH_ARG %s", this.a)
 	case CALL:
-		return fmt.Sprintf("CALL(argc: %d)", int(this.opdata))
+		return fmt.Sprintf("%s = CALL %s(this: %s, argc: %d)", this.a, this.b, this.c, int(this.d))
 	case NEW:
-		return fmt.Sprintf("NEW(argc: %d)", int(this.opdata))
+		return fmt.Sprintf("%s = NEW %s(this: %s, argc: %d)", this.a, this.b, this.c, int(this.d))
 	case IN_FUNCTION:
-		return fmt.Sprintf("function %s:", stringtable[int(this.opdata)])
-	case JNE:
-		return fmt.Sprintf("JNE %d", int(this.opdata))
+		return fmt.Sprintf("function %s (registers: %d):", stringName(strings, this.a), int(this.b))
+	case USE_STRICT:
+		return "USE_STRICT"
 	case RETURN:
-		return "RETURN"
-	case STORE:
-		return fmt.Sprintf("STORE %s", stringtable[int(this.opdata)])
+		return fmt.Sprintf("RETURN %s", this.a)
 	case DECLARE:
-		return fmt.Sprintf("DECLARE %s", stringtable[int(this.opdata)])
-	case LOAD:
-		return fmt.Sprintf("LOAD %s", stringtable[int(this.opdata)])
-	case LOAD_MEMBER:
-		return fmt.Sprintf("LOAD_MEMBER %s", stringtable[int(this.opdata)])
-	case STORE_MEMBER:
-		return fmt.Sprintf("STORE_MEMBER %s", stringtable[int(this.opdata)])
-	case LOAD_TEMPORARY:
-		return fmt.Sprintf("LOAD_TEMPORARY %d", int(this.opdata))
-	case STORE_TEMPORARY:
-		return fmt.Sprintf("STORE_TEMPORARY %d", int(this.opdata))
-	case LOAD_INDEXED:
-		return fmt.Sprintf("LOAD_INDEXED")
-	case STORE_INDEXED:
-		return fmt.Sprintf("STORE_INDEXED")
+		return fmt.Sprintf("DECLARE %s", stringName(strings, this.a))
+	case NEW_ARRAY:
+		return fmt.Sprintf("%s = NEW_ARRAY(%d)", this.a, int(this.b))
+	case NEW_OBJECT:
+		return fmt.Sprintf("%s = NEW_OBJECT", this.a)
+	case DEFINE_PROPERTY:
+		return fmt.Sprintf("DEFINE_PROPERTY %s[%s] = %s", this.a, this.b, this.c)
 	default:
 		return fmt.Sprintf("unknown opcode %d", this.otype)
 	}
 }
 
-// create an opcode with no opdata
-func simpleOp(o opcode_type) opcode {
-	return opcode{o, 0}
+func stringName(strings []string, o operand) string {
+	if int(o) >= 0 && int(o) < len(strings) {
+		return strings[o]
+	}
+	return fmt.Sprintf("s%d", int(o))
 }
 
-// create an opcode with opdata 'i'
-func newOpcode(o opcode_type, i float64) opcode {
-	return opcode{o, opdata(i)}
+func (this opcode) binaryString(op string) string {
+	return fmt.Sprintf("%s = %s %s %s", this.a, this.b, op, this.c)
+}
+
+// create an opcode with the given operands
+func newOpcode(o opcode_type, operands ...operand) opcode {
+	op := opcode{otype: o}
+	dst := []*operand{&op.a, &op.b, &op.c, &op.d}
+	for idx, v := range operands {
+		*dst[idx] = v
+	}
+	return op
 }
@@ -0,0 +1,772 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"math"
+)
+
+// The optimizer rewrites the TAC of one function at a time, as control never
+// passes from one to another. Each pass builds a control-flow graph of the
+// function first, so that it only makes changes that hold on every path
+// through it.
+//
+// Only temporaries and function locals (see scope) are tracked. Anything else
+// (globals, members) may be changed behind our back by any call, so it is
+// left alone.
+
+// optimizeTAC optimizes each function in a program.
+func optimizeTAC(codebuf *[]tac) {
+	code := *codebuf
+	out := []tac{}
+	for start := 0; start < len(code); {
+		end := start + 1
+		for end < len(code) && code[end-1].op != TAC_END_FUNCTION {
+			end++
+		}
+		out = append(out, optimizeFunction(code[start:end])...)
+		start = end
+	}
+	*codebuf = out
+}
+
+// optimizeFunction runs the optimization passes over a function until they
+// have nothing left to do.
+func optimizeFunction(code []tac) []tac {
+	passes := []func([]tac) ([]tac, bool){
+		propagateConstants,
+		propagateCopies,
+		foldConstants,
+		removeUnreachableCode,
+		removeDeadCode,
+	}
+
+	for i := 0; i < 50; i++ {
+		changed := false
+		for _, pass := range passes {
+			c := false
+			code, c = pass(code)
+			changed = changed || c
+		}
+		if !changed {
+			break
+		}
+	}
+	return code
+}
+
+func isTracked(addr tac_address) bool {
+	return addr.isTemp() || (addr.isVar() && addr.slot != -1)
+}
+
+// trackedKey identifies a tracked address within its function. It's cheaper
+// to hash than the address itself.
+func trackedKey(addr tac_address) int {
+	if addr.isTemp() {
+		return addr.temporary * 2
+	}
+	return addr.slot*2 + 1
+}
+
+// constantValue returns the value of an address known at codegen time.
+func constantValue(addr tac_address) (value, bool) {
+	if addr.isConstant() && addr.valid {
+		return addr.constant, true
+	}
+	if addr.isVar() && addr.slot == -1 && addr.varname == "undefined" {
+		return newUndefined(), true
+	}
+	return newUndefined(), false
+}
+
+// sameConstant is like ==, but tells 0 and -0 apart.
+func sameConstant(a value, b value) bool {
+	if a != b {
+		return false
+	}
+	if a.isNumber() {
+		return math.Signbit(a.num) == math.Signbit(b.num)
+	}
+	return true
+}
+
+// definedAddress returns the tracked address an instruction writes, if any.
+func definedAddress(op tac) (tac_address, bool) {
+	// NEW_OBJECT only leaves the object on the stack for END_OBJECT to store.
+	if op.op == TAC_NEW_OBJECT || !isTracked(op.result) {
+		return tac_address{}, false
+	}
+	return op.result, true
+}
+
+// usedAddresses calls used for each tracked address an instruction reads,
+// including those making up member addresses.
+func usedAddresses(op tac, used func(addr tac_address)) {
+	var use func(addr tac_address)
+	use = func(addr tac_address) {
+		if addr.isMember() {
+			use(addr.base())
+			use(*addr.reference)
+		} else if isTracked(addr) {
+			used(addr)
+		}
+	}
+
+	switch op.op {
+	case TAC_LABEL, TAC_JMP:
+		// labels aren't values
+	case TAC_JNE:
+		use(op.arg1)
+	default:
+		use(op.arg1)
+		use(op.arg2)
+	}
+	if op.result.isMember() {
+		use(op.result)
+	}
+}
+
+// replaceableOperands returns the operands of an instruction that can be
+// replaced by another (equal) value. Parts of member addresses can't, as they
+// must stay a var or temporary. Neither can the callee of a call: the VM takes
+// 'this' from loading it.
+func replaceableOperands(op *tac) []*tac_address {
+	switch op.op {
+	case TAC_LABEL, TAC_JMP, TAC_CALL, TAC_NEW:
+		return nil
+	case TAC_JNE:
+		return []*tac_address{&op.arg1}
+	}
+	return []*tac_address{&op.arg1, &op.arg2}
+}
+
+// isPure reports whether an instruction can be removed if its result is
+// unused: it can't throw, call anything, or touch the stack.
+func isPure(op tac) bool {
+	safe := func(addr tac_address) bool {
+		if !addr.valid {
+			return true
+		}
+		_, ok := constantValue(addr)
+		return ok || isTracked(addr)
+	}
+
+	switch op.op {
+	case TAC_ASSIGN, TAC_TYPEOF:
+		return !op.result.isMember() && safe(op.arg1) && safe(op.arg2)
+	case TAC_LOAD_FUNCTION:
+		return !op.result.isMember()
+	}
+	return false
+}
+
+// A basicBlock is a run of instructions, code[start:end], that is only entered
+// at its start, and only left at its end.
+type basicBlock struct {
+	start int
+	end   int
+	preds []int
+	succs []int
+}
+
+// Besides the blocks, the CFG numbers the tracked addresses in the code (by
+// trackedKey), and notes which of them each instruction defines (or -1) and
+// uses.
+type controlFlowGraph struct {
+	code   []tac
+	blocks []*basicBlock
+	addrs  map[int]int
+	defs   []int
+	uses   [][]int
+}
+
+func (this *controlFlowGraph) addressIndex(addr tac_address) int {
+	return this.addrs[trackedKey(addr)]
+}
+
+// buildCFG splits the code of a function into basic blocks. Blocks start at
+// labels and the function's entry, and after anything that jumps or returns.
+func buildCFG(code []tac) *controlFlowGraph {
+	g := &controlFlowGraph{code: code, addrs: make(map[int]int)}
+	number := func(addr tac_address) int {
+		key := trackedKey(addr)
+		a, ok := g.addrs[key]
+		if !ok {
+			a = len(g.addrs)
+			g.addrs[key] = a
+		}
+		return a
+	}
+	g.defs = make([]int, len(code))
+	g.uses = make([][]int, len(code))
+	for idx, op := range code {
+		g.defs[idx] = -1
+		if addr, ok := definedAddress(op); ok {
+			g.defs[idx] = number(addr)
+		}
+		usedAddresses(op, func(addr tac_address) {
+			g.uses[idx] = append(g.uses[idx], number(addr))
+		})
+	}
+
+	leader := make([]bool, len(code)+1)
+	leader[0] = true
+	for idx, op := range code {
+		switch op.op {
+		case TAC_FUNCTION, TAC_LABEL:
+			leader[idx] = true
+		case TAC_JMP, TAC_JNE, TAC_RETURN, TAC_END_FUNCTION:
+			leader[idx+1] = true
+		}
+	}
+
+	labels := make(map[tac_address]int)
+	for idx := 0; idx < len(code); {
+		b := &basicBlock{start: idx}
+		for idx++; idx < len(code) && !leader[idx]; idx++ {
+		}
+		b.end = idx
+		if code[b.start].op == TAC_LABEL {
+			labels[code[b.start].arg1] = len(g.blocks)
+		}
+		g.blocks = append(g.blocks, b)
+	}
+
+	edge := func(from int, to int) {
+		g.blocks[from].succs = append(g.blocks[from].succs, to)
+		g.blocks[to].preds = append(g.blocks[to].preds, from)
+	}
+	jump := func(from int, label tac_address) {
+		to, ok := labels[label]
+		if !ok {
+			panic(fmt.Sprintf("jump to unknown label %s", label))
+		}
+		edge(from, to)
+	}
+	fallsInto := func(to int) bool {
+		return to < len(g.blocks) && code[g.blocks[to].start].op != TAC_FUNCTION
+	}
+
+	for idx, b := range g.blocks {
+		last := code[b.end-1]
+		switch last.op {
+		case TAC_JMP:
+			jump(idx, last.arg1)
+		case TAC_JNE:
+			jump(idx, last.arg2)
+			if fallsInto(idx + 1) {
+				edge(idx, idx+1)
+			}
+		case TAC_RETURN, TAC_END_FUNCTION:
+		default:
+			if fallsInto(idx + 1) {
+				edge(idx, idx+1)
+			}
+		}
+	}
+
+	return g
+}
+
+func (this *controlFlowGraph) isEntry(b int) bool {
+	return this.code[this.blocks[b].start].op == TAC_FUNCTION
+}
+
+// reachable returns which blocks can be reached from the entry of their
+// function.
+func (this *controlFlowGraph) reachable() []bool {
+	seen := make([]bool, len(this.blocks))
+	var visit func(b int)
+	visit = func(b int) {
+		if seen[b] {
+			return
+		}
+		seen[b] = true
+		for _, s := range this.blocks[b].succs {
+			visit(s)
+		}
+	}
+	for idx := range this.blocks {
+		if this.isEntry(idx) {
+			visit(idx)
+		}
+	}
+	return seen
+}
+
+// A bitset is a set of small integers: instructions, or tracked addresses.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (this bitset) add(i int) {
+	this[i/64] |= 1 << uint(i%64)
+}
+
+func (this bitset) remove(i int) {
+	this[i/64] &^= 1 << uint(i%64)
+}
+
+func (this bitset) has(i int) bool {
+	return this[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (this bitset) clear() {
+	for i := range this {
+		this[i] = 0
+	}
+}
+
+func (this bitset) fill() {
+	for i := range this {
+		this[i] = math.MaxUint64
+	}
+}
+
+func (this bitset) copyFrom(o bitset) {
+	copy(this, o)
+}
+
+func (this bitset) union(o bitset) {
+	for i := range this {
+		this[i] |= o[i]
+	}
+}
+
+func (this bitset) intersect(o bitset) {
+	for i := range this {
+		this[i] &= o[i]
+	}
+}
+
+func (this bitset) equals(o bitset) bool {
+	for i := range this {
+		if this[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// A forwardAnalysis is a dataflow problem solved over the CFG in the direction
+// of control flow. transfer updates a set as it flows through one instruction.
+// A may analysis merges sets at joins by union, a must analysis by
+// intersection. in[b] is the set at the start of block b.
+type forwardAnalysis struct {
+	size     int
+	must     bool
+	entry    func(set bitset)
+	transfer func(set bitset, idx int)
+	in       []bitset
+}
+
+func (this *forwardAnalysis) solve(g *controlFlowGraph) {
+	reachable := g.reachable()
+	this.in = make([]bitset, len(g.blocks))
+	out := make([]bitset, len(g.blocks))
+	for b := range g.blocks {
+		this.in[b] = newBitset(this.size)
+		out[b] = newBitset(this.size)
+		if this.must && reachable[b] && !g.isEntry(b) {
+			out[b].fill()
+		}
+	}
+
+	scratch := newBitset(this.size)
+	for changed := true; changed; {
+		changed = false
+		for b, block := range g.blocks {
+			if !reachable[b] {
+				continue
+			}
+			in := this.in[b]
+			if g.isEntry(b) {
+				in.clear()
+				this.entry(in)
+			} else if this.must {
+				in.fill()
+				for _, p := range block.preds {
+					if reachable[p] {
+						in.intersect(out[p])
+					}
+				}
+			} else {
+				in.clear()
+				for _, p := range block.preds {
+					in.union(out[p])
+				}
+			}
+
+			scratch.copyFrom(in)
+			for idx := block.start; idx < block.end; idx++ {
+				this.transfer(scratch, idx)
+			}
+			if !scratch.equals(out[b]) {
+				out[b].copyFrom(scratch)
+				changed = true
+			}
+		}
+	}
+}
+
+// reachingDefinitions finds, for each point in the program, which instructions
+// may have set the value each tracked address has there. A tracked address
+// also has a definition of its own (numbered after the instructions) standing
+// for whatever value it has when its function is entered: an argument, or
+// undefined.
+type reachingDefinitions struct {
+	forwardAnalysis
+	g      *controlFlowGraph
+	defsOf [][]int
+}
+
+func newReachingDefinitions(g *controlFlowGraph) *reachingDefinitions {
+	rd := &reachingDefinitions{g: g, defsOf: make([][]int, len(g.addrs))}
+	for idx, a := range g.defs {
+		if a != -1 {
+			rd.defsOf[a] = append(rd.defsOf[a], idx)
+		}
+	}
+
+	entryDefs := len(g.code)
+	rd.size = entryDefs + len(g.addrs)
+	rd.entry = func(set bitset) {
+		for a := 0; a < len(g.addrs); a++ {
+			set.add(entryDefs + a)
+		}
+	}
+	rd.transfer = func(set bitset, idx int) {
+		if a := g.defs[idx]; a != -1 {
+			for _, d := range rd.defsOf[a] {
+				set.remove(d)
+			}
+			set.remove(entryDefs + a)
+			set.add(idx)
+		}
+	}
+	rd.solve(g)
+	return rd
+}
+
+// constantAt returns the value of addr, if every definition of it that reaches
+// a point (with the given reaching set) assigns the same constant.
+func (this *reachingDefinitions) constantAt(set bitset, addr tac_address) (value, bool) {
+	a := this.g.addressIndex(addr)
+	if set.has(len(this.g.code) + a) {
+		return newUndefined(), false
+	}
+
+	var c value
+	found := false
+	for _, d := range this.defsOf[a] {
+		if !set.has(d) {
+			continue
+		}
+		op := this.g.code[d]
+		if op.op != TAC_ASSIGN {
+			return newUndefined(), false
+		}
+		v, ok := constantValue(op.arg1)
+		if !ok || (found && !sameConstant(c, v)) {
+			return newUndefined(), false
+		}
+		c, found = v, true
+	}
+	return c, found
+}
+
+// propagateConstants replaces uses of tracked addresses that can only hold one
+// constant value with that value.
+func propagateConstants(code []tac) ([]tac, bool) {
+	g := buildCFG(code)
+	rd := newReachingDefinitions(g)
+	changed := false
+
+	set := newBitset(rd.size)
+	for b, block := range g.blocks {
+		set.copyFrom(rd.in[b])
+		for idx := block.start; idx < block.end; idx++ {
+			for _, operand := range replaceableOperands(&code[idx]) {
+				if !isTracked(*operand) {
+					continue
+				}
+				if c, ok := rd.constantAt(set, *operand); ok {
+					*operand = newConstant(c)
+					changed = true
+				}
+			}
+			rd.transfer(set, idx)
+		}
+	}
+
+	return code, changed
+}
+
+// isCopy reports whether an instruction copies one tracked address to another.
+func isCopy(op tac) bool {
+	return op.op == TAC_ASSIGN && isTracked(op.result) && isTracked(op.arg1) && op.result != op.arg1
+}
+
+// propagateCopies replaces uses of an address that was copied from another with
+// the original, where neither has changed since the copy on any path. It works
+// out which copies are still available everywhere.
+func propagateCopies(code []tac) ([]tac, bool) {
+	g := buildCFG(code)
+	orig := append([]tac(nil), code...)
+	changed := false
+
+	// the copies each tracked address is involved in, and the copies to it
+	involved := make([][]int, len(g.addrs))
+	copiesTo := make([][]int, len(g.addrs))
+	for idx, op := range code {
+		if isCopy(op) {
+			to, from := g.addressIndex(op.result), g.addressIndex(op.arg1)
+			involved[to] = append(involved[to], idx)
+			involved[from] = append(involved[from], idx)
+			copiesTo[to] = append(copiesTo[to], idx)
+		}
+	}
+
+	ac := &forwardAnalysis{size: len(code), must: true}
+	ac.entry = func(set bitset) {}
+	ac.transfer = func(set bitset, idx int) {
+		a := g.defs[idx]
+		if a == -1 {
+			return
+		}
+		for _, c := range involved[a] {
+			set.remove(c)
+		}
+		if isCopy(orig[idx]) {
+			set.add(idx)
+		}
+	}
+	ac.solve(g)
+
+	set := newBitset(ac.size)
+	for b, block := range g.blocks {
+		set.copyFrom(ac.in[b])
+		for idx := block.start; idx < block.end; idx++ {
+			for _, operand := range replaceableOperands(&code[idx]) {
+				if !isTracked(*operand) {
+					continue
+				}
+				for _, c := range copiesTo[g.addressIndex(*operand)] {
+					if set.has(c) {
+						*operand = orig[c].arg1
+						changed = true
+						break
+					}
+				}
+			}
+			ac.transfer(set, idx)
+		}
+	}
+
+	return code, changed
+}
+
+// Operations that can be worked out at codegen time if their operands are
+// constant, and the operands they use.
+var foldableBinaryOps = map[tac_op_type]bool{
+	TAC_ADD: true, TAC_SUB: true, TAC_MULTIPLY: true, TAC_DIVIDE: true,
+	TAC_MODULUS: true, TAC_EXPONENT: true, TAC_LEFT_SHIFT: true,
+	TAC_RIGHT_SHIFT: true, TAC_UNSIGNED_RIGHT_SHIFT: true, TAC_BITWISE_AND: true,
+	TAC_BITWISE_XOR: true, TAC_BITWISE_OR: true, TAC_LESS_THAN: true,
+	TAC_LESS_THAN_EQ: true, TAC_GREATER_THAN: true, TAC_GREATER_THAN_EQ: true,
+	TAC_EQUALS: true, TAC_NOT_EQUALS: true, TAC_STRICT_EQUALS: true,
+	TAC_STRICT_NOT_EQUALS: true, TAC_LOGICAL_AND: true, TAC_LOGICAL_OR: true,
+	TAC_LOGICAL_NOT: true,
+}
+
+var foldableUnaryOps = map[tac_op_type]bool{
+	TAC_UMINUS: true, TAC_BITWISE_NOT: true, TAC_TYPEOF: true,
+}
+
+// evaluateConstant works out an operation on constants by running it, so that
+// folding always agrees with the VM.
+func evaluateConstant(op tac) (v value, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			v, ok = newUndefined(), false
+		}
+	}()
+
+	op.result = tac_address{true, newUndefined(), "", nil, 0, -1}
+	name := newConstant(newString("%fold"))
+	scratch := &vm{programScope: &scope{}, constantIndexes: make(map[interface{}]int)}
+	scratch.code = scratch.generateBytecode([]tac{
+		tac{arg1: name, op: TAC_FUNCTION, arg2: newConstant(newNumber(-1))},
+		op,
+		tac{arg1: op.result, op: TAC_RETURN},
+		tac{arg1: name, op: TAC_END_FUNCTION},
+	})
+	scratch.currentFrame = scratch.stack.push(makeStackFrame(newUndefined(), 0, nil))
+	scratch.run(0)
+	return scratch.returnValue, true
+}
+
+// foldConstants works out operations on constants, and branches on them.
+func foldConstants(code []tac) ([]tac, bool) {
+	out := make([]tac, 0, len(code))
+	changed := false
+
+	for _, op := range code {
+		c1, ok1 := constantValue(op.arg1)
+		c2, ok2 := constantValue(op.arg2)
+
+		if (foldableBinaryOps[op.op] && ok1 && ok2) || (foldableUnaryOps[op.op] && ok1) {
+			eop := op
+			eop.arg1 = newConstant(c1)
+			if ok2 {
+				eop.arg2 = newConstant(c2)
+			}
+			if v, ok := evaluateConstant(eop); ok {
+				op = tac{result: op.result, arg1: newConstant(v), op: TAC_ASSIGN}
+				changed = true
+			}
+		} else if op.op == TAC_JNE && ok1 {
+			changed = true
+			if c1.ToBoolean() {
+				// never taken
+				continue
+			}
+			op = tac{arg1: op.arg2, op: TAC_JMP}
+		}
+		out = append(out, op)
+	}
+
+	return out, changed
+}
+
+// removeUnreachableCode removes blocks no path through their function reaches.
+func removeUnreachableCode(code []tac) ([]tac, bool) {
+	g := buildCFG(code)
+	reachable := g.reachable()
+	all := true
+	for _, r := range reachable {
+		all = all && r
+	}
+	if all {
+		return code, false
+	}
+
+	out := make([]tac, 0, len(code))
+
+	for b, block := range g.blocks {
+		for _, op := range code[block.start:block.end] {
+			if reachable[b] || op.op == TAC_FUNCTION || op.op == TAC_END_FUNCTION {
+				out = append(out, op)
+			}
+		}
+	}
+
+	return out, len(out) != len(code)
+}
+
+// liveness finds which tracked addresses may still be read after each block,
+// working backwards from the end of each function, where nothing is live.
+type liveness struct {
+	g   *controlFlowGraph
+	out []bitset
+}
+
+func (this *liveness) transfer(set bitset, idx int) {
+	if a := this.g.defs[idx]; a != -1 {
+		set.remove(a)
+	}
+	for _, a := range this.g.uses[idx] {
+		set.add(a)
+	}
+}
+
+func newLiveness(g *controlFlowGraph) *liveness {
+	lv := &liveness{g: g}
+	in := make([]bitset, len(g.blocks))
+	lv.out = make([]bitset, len(g.blocks))
+	for b := range g.blocks {
+		in[b] = newBitset(len(g.addrs))
+		lv.out[b] = newBitset(len(g.addrs))
+	}
+
+	set := newBitset(len(g.addrs))
+	for changed := true; changed; {
+		changed = false
+		for b := len(g.blocks) - 1; b >= 0; b-- {
+			lv.out[b].clear()
+			for _, s := range g.blocks[b].succs {
+				lv.out[b].union(in[s])
+			}
+
+			set.copyFrom(lv.out[b])
+			for idx := g.blocks[b].end - 1; idx >= g.blocks[b].start; idx-- {
+				lv.transfer(set, idx)
+			}
+			if !set.equals(in[b]) {
+				in[b].copyFrom(set)
+				changed = true
+			}
+		}
+	}
+	return lv
+}
+
+// removeDeadCode removes pure instructions whose results are never read.
+func removeDeadCode(code []tac) ([]tac, bool) {
+	g := buildCFG(code)
+	lv := newLiveness(g)
+	dead := make([]bool, len(code))
+	changed := false
+
+	set := newBitset(len(g.addrs))
+	for b, block := range g.blocks {
+		set.copyFrom(lv.out[b])
+		for idx := block.end - 1; idx >= block.start; idx-- {
+			if a := g.defs[idx]; a != -1 && isPure(code[idx]) && !set.has(a) {
+				// skipping the transfer, as if it was already gone
+				dead[idx] = true
+				changed = true
+				continue
+			}
+			lv.transfer(set, idx)
+		}
+	}
+
+	if !changed {
+		return code, false
+	}
+	out := make([]tac, 0, len(code))
+	for idx, op := range code {
+		if !dead[idx] {
+			out = append(out, op)
+		}
+	}
+	return out, changed
+}
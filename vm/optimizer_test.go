@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/CrimsonAS/v2/parser"
+	"github.com/stvp/assert"
+	"testing"
+)
+
+// optimizedTAC compiles code the way newVM does, and returns the optimized
+// body of the last function in it.
+func optimizedTAC(code string) []string {
+	vm := &vm{temporaryIndex: -1}
+	ast := parser.Parse(code, true)
+	vm.programScope = vm.analyzeScopes(ast.(*parser.Program))
+	vm.functions = make([]value, len(vm.funcsToDefine))
+
+	il := []tac{}
+	vm.generateCodeTAC(ast, &il)
+	optimizeTAC(&il)
+
+	start := 0
+	for idx, op := range il {
+		if op.op == TAC_FUNCTION {
+			start = idx + 1
+		}
+	}
+
+	ret := []string{}
+	for _, op := range il[start : len(il)-1] {
+		ret = append(ret, op.String())
+	}
+	return ret
+}
+
+func TestControlFlowGraph(t *testing.T) {
+	vm := &vm{temporaryIndex: -1}
+	c := vm.newTemporary()
+	l1 := vm.newTemporary()
+	l2 := vm.newTemporary()
+	code := []tac{
+		tac{op: TAC_FUNCTION, arg1: newConstant(newString("f"))},
+		tac{op: TAC_JNE, arg1: c, arg2: l1},
+		tac{op: TAC_JMP, arg1: l2},
+		tac{op: TAC_LABEL, arg1: l1},
+		tac{op: TAC_RETURN, arg1: c},
+		tac{op: TAC_LABEL, arg1: l2},
+		tac{op: TAC_RETURN, arg1: newConstant(newNumber(1))},
+		tac{op: TAC_END_FUNCTION, arg1: newConstant(newString("f"))},
+	}
+
+	g := buildCFG(code)
+	assert.Equal(t, len(g.blocks), 5)
+
+	// the entry branches to @t_1, or falls through to the JMP
+	assert.Equal(t, g.blocks[0].succs, []int{2, 1})
+	assert.Equal(t, g.blocks[1].succs, []int{3})
+	assert.Equal(t, g.blocks[2].preds, []int{0})
+
+	// nothing reaches END_FUNCTION, as both paths return
+	assert.Equal(t, len(g.blocks[4].preds), 0)
+	assert.Equal(t, g.reachable(), []bool{true, true, true, true, false})
+}
+
+func TestOptimizeConstants(t *testing.T) {
+	assert.Equal(t, optimizedTAC("function f() { var x = 2 * 3; var y = x; return y + 1 }"), []string{
+		"return(7)",
+	})
+
+	// x is only 2 on one of the paths into the return
+	assert.Equal(t, optimizedTAC("function f(c) { var x = 1; if (c) x = 2; return x }"), []string{
+		"x = 1",
+		"JNE c @t_1",
+		"x = 2",
+		"JMP @t_2",
+		"@t_1:",
+		"@t_2:",
+		"return(x)",
+	})
+
+	// i changes in the loop, so it can't be treated as 0
+	assert.Equal(t, optimizedTAC("function f() { var i = 0; while (i < 10) i = i + 1; return i }"), []string{
+		"i = 0",
+		"@t_1:",
+		"t_4 = i TAC_LESS_THAN 10",
+		"JNE t_4 @t_2",
+		"t_7 = i TAC_ADD 1",
+		"i = t_7",
+		"JMP @t_1",
+		"@t_2:",
+		"return(i)",
+	})
+}
+
+func TestOptimizeDeadCode(t *testing.T) {
+	// the call may have side effects, so it stays even if its result is unused
+	assert.Equal(t, optimizedTAC("function f(g) { var x = g(); var y = 1; return 2 }"), []string{
+		"t_0 = CALL(g)",
+		"return(2)",
+	})
+
+	assert.Equal(t, optimizedTAC("function f() { if (false) { return 1 } return 2 }"), []string{
+		"JMP @t_1",
+		"@t_1:",
+		"@t_2:",
+		"return(2)",
+	})
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+// DefineProcess gives scripts what a command line tool would: a process
+// object, whose argv holds argv, and an exit function (also process.exit)
+// that ends the run with an ExitError. Nothing else defines these, as a
+// script embedded in something else has no business with them.
+func (this *vm) DefineProcess(argv []string) {
+	args := make([]value, len(argv))
+	for idx, arg := range argv {
+		args[idx] = newString(arg)
+	}
+	exit := objectValue(newFunctionObject(process_exit, nil))
+
+	processO := valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	processO.defineDefaultProperty(this, "argv", objectValue(newArrayObject(args)), 0)
+	processO.defineDefaultProperty(this, "exit", exit, 1)
+	this.defineBuiltinGlobal("process", objectValue(processO))
+	this.defineBuiltinGlobal("exit", exit)
+}
+
+func process_exit(vm *vm, f value, args []value) value {
+	code := 0
+	if c := argument(args, 0); c.kind != kindUndefined {
+		code = c.ToInteger()
+	}
+	panic(&ExitError{Code: code})
+}
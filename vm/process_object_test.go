@@ -0,0 +1,58 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/stvp/assert"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	v := New("return process.argv.join(' ')")
+	v.DefineProcess([]string{"v2", "script.js", "--flag"})
+	assert.Equal(t, mustRun(t, v), newString("v2 script.js --flag"))
+
+	tests := []struct {
+		code string
+		exit int
+	}{
+		{"exit()", 0},
+		{"exit(3); return 1", 3},
+		{"process.exit(4)", 4},
+		{`function f() { exit(5) }; "a".replace("a", f)`, 5},
+	}
+	for _, test := range tests {
+		v := New(test.code)
+		v.DefineProcess(nil)
+		_, err := v.Run()
+		assert.Equal(t, err, &ExitError{Code: test.exit})
+	}
+
+	// without DefineProcess, there's no exit
+	_, err := New("exit(1)").Run()
+	assert.Equal(t, err.Error(), "ReferenceError: exit is not defined")
+}
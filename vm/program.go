@@ -0,0 +1,287 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/CrimsonAS/v2/parser"
+)
+
+// A Program is compiled code, which can be run any number of times, each time
+// in a vm of its own, without compiling it again. Nothing changes a Program
+// once it is compiled, so one can be shared between goroutines.
+type Program struct {
+	name      string
+	code      []opcode
+	constants []value
+	strings   []string
+	lines     []lineEntry
+	functions []compiledFunction
+	caches    int // how many inline caches the code uses
+}
+
+// compiledFunction is where a JavaScript function's code is, and what it
+// needs to be called.
+type compiledFunction struct {
+	entry     int // where its IN_FUNCTION is
+	frameSize int
+	params    int
+}
+
+// Compile compiles code, with name used to describe it in errors.
+func Compile(code string, name string) (p *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, err = nil, fmt.Errorf("%s: %v", name, r)
+		}
+	}()
+	return compile(code, name, true), nil
+}
+
+// CompileScript compiles code like Compile, for a program that returns the
+// value of its last statement, if that is an expression and it doesn't return
+// anything else, as Eval does.
+func CompileScript(code string, name string) (p *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, err = nil, fmt.Errorf("%s: %v", name, r)
+		}
+	}()
+	return compileAST(parser.Parse(code, true /* ignore comments */), name, true, true), nil
+}
+
+// CompileAST compiles a tree that didn't come from parsing code, like one
+// from parser.UnmarshalESTree, with name used to describe it in errors.
+func CompileAST(ast parser.Node, name string) (p *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, err = nil, fmt.Errorf("%s: %v", name, r)
+		}
+	}()
+	if _, ok := ast.(*parser.Program); !ok {
+		return nil, fmt.Errorf("%s: expected a program, got %T", name, ast)
+	}
+	return compileAST(ast, name, true, false), nil
+}
+
+func compile(code string, name string, optimize bool) *Program {
+	return compileAST(parser.Parse(code, true /* ignore comments */), name, optimize, false)
+}
+
+func compileAST(ast parser.Node, name string, optimize bool, completion bool) *Program {
+	c, il := generateTAC(ast, optimize, completion)
+
+	if execDebug {
+		for idx, op := range il {
+			log.Printf("%d: %s", idx, op)
+		}
+	}
+
+	c.code = c.generateBytecode(il)
+
+	if execDebug {
+		c.DumpCode()
+	}
+
+	return &Program{
+		name:      name,
+		code:      c.code,
+		constants: c.constants,
+		strings:   c.strings,
+		lines:     c.lines,
+		functions: c.compiled,
+		caches:    len(c.caches),
+	}
+}
+
+// generateTAC generates the three address code for ast, in a vm to generate
+// the bytecode in. With completion set, the program returns the value of its
+// last statement.
+func generateTAC(ast parser.Node, optimize bool, completion bool) (*vm, []tac) {
+	c := &vm{temporaryIndex: -1, constantIndexes: make(map[interface{}]int), returnCompletion: completion}
+	c.programScope = c.analyzeScopes(ast.(*parser.Program))
+	c.compiled = make([]compiledFunction, len(c.funcsToDefine))
+
+	il := []tac{}
+	c.generateCodeTAC(ast, &il)
+	if optimize {
+		optimizeTAC(&il)
+	}
+	return c, il
+}
+
+// DumpTAC returns the three address code that code compiles to on its way to
+// bytecode, optimized or not, an instruction a line, for debugging codegen.
+func DumpTAC(code string, optimize bool) (dump string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			dump, err = "", fmt.Errorf("%v", r)
+		}
+	}()
+	_, il := generateTAC(parser.Parse(code, true /* ignore comments */), optimize, false)
+	var sb strings.Builder
+	for idx, op := range il {
+		fmt.Fprintf(&sb, "%d: %s\n", idx, op)
+	}
+	return sb.String(), nil
+}
+
+// Name returns the name the program was compiled with.
+func (this *Program) Name() string {
+	return this.name
+}
+
+// Disassemble writes out the program's bytecode, as DumpCode does.
+func (this *Program) Disassemble(w io.Writer) {
+	printf := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+	dumpCode(printf, this.code, this.constants, this.strings, this.lines)
+}
+
+// NewFromProgram returns a new vm, with globals of its own, to run a program.
+func NewFromProgram(p *Program) *vm {
+	vm := vm{clock: hostClock{}, random: hostRandomSource{}, console: newConsoleState(), globalObject: newBasicObject()}
+	vm.currentFrame = vm.stack.push(makeStackFrame(objectValue(vm.globalObject), 0, nil))
+	vm.maxCallDepth = defaultMaxCallDepth
+
+	vm.name = p.name
+	vm.code = p.code
+	vm.constants = p.constants
+	// so that anything added to this vm's table doesn't end up in the program's
+	vm.strings = p.strings[:len(p.strings):len(p.strings)]
+	vm.lines = p.lines
+	vm.caches = make([]inlineCache, p.caches)
+	vm.functions = make([]value, len(p.functions))
+	for idx, fn := range p.functions {
+		runBuiltin := callJsFunction(fn.entry-1, fn.frameSize, fn.params)
+		vm.functions[idx] = objectValue(newFunctionObject(runBuiltin, runBuiltin))
+	}
+
+	vm.defineBuiltinGlobal("globalThis", objectValue(vm.globalObject))
+	vm.defineBuiltinGlobal("Object", defineObjectCtor(&vm))
+	vm.defineBuiltinGlobal("console", defineConsoleObject(&vm))
+	vm.defineBuiltinGlobal("Math", objectValue(defineMathObject(&vm)))
+	vm.defineBuiltinGlobal("Boolean", objectValue(defineBooleanCtor(&vm)))
+	vm.defineBuiltinGlobal("Number", objectValue(defineNumberCtor(&vm)))
+	vm.defineBuiltinGlobal("Array", defineArrayCtor(&vm))
+	vm.defineBuiltinGlobal("String", defineStringCtor(&vm))
+	vm.defineBuiltinGlobal("Date", objectValue(defineDateCtor(&vm)))
+
+	vm.memory.baseline = vm.measureMemory()
+	vm.memory.used = 0
+
+	return &vm
+}
+
+// Eval compiles code and runs it in this vm, the way a REPL does: it sees the
+// globals left by whatever ran before it, and returns the value of its last
+// statement, if that is an expression. Errors compiling or running it (see
+// Run) are returned, and leave the vm able to Eval more.
+func (this *vm) Eval(code string) (rval value, err error) {
+	entry, err := this.compileMore(code)
+	if err != nil {
+		return newUndefined(), err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			rval, err = newUndefined(), this.catch(r)
+		}
+	}()
+
+	this.resetStack()
+	this.currentFrame = this.stack.push(makeStackFrame(objectValue(this.globalObject), 0, nil))
+	this.ip = entry
+	this.returnValue = newUndefined()
+	this.startLimits(context.Background())
+	this.run(0)
+	return this.returnValue, nil
+}
+
+// compileMore compiles code onto the end of the vm's own, for Eval, and
+// returns where it starts.
+func (this *vm) compileMore(code string) (entry int, err error) {
+	// so that nothing added here ends up in the Program the vm came from
+	this.code = this.code[:len(this.code):len(this.code)]
+	this.constants = this.constants[:len(this.constants):len(this.constants)]
+	this.lines = this.lines[:len(this.lines):len(this.lines)]
+	if this.constantIndexes == nil {
+		this.constantIndexes = make(map[interface{}]int)
+		for idx, v := range this.constants {
+			this.constantIndexes[constantKey(v)] = idx
+		}
+	}
+
+	lines := len(this.lines)
+	parsed := false
+	defer func() {
+		if r := recover(); r != nil {
+			this.lines = this.lines[:lines]
+			if parsed {
+				err = fmt.Errorf("%v", r)
+			} else {
+				err = fmt.Errorf("SyntaxError: %v", r)
+			}
+		}
+	}()
+
+	ast := parser.Parse(code, true /* ignore comments */)
+	parsed = true
+	this.temporaryIndex = -1
+	this.programScope = this.analyzeScopes(ast.(*parser.Program))
+	this.compiled = make([]compiledFunction, len(this.funcsToDefine))
+
+	il := []tac{}
+	this.returnCompletion = true
+	this.generateCodeTAC(ast, &il)
+	optimizeTAC(&il)
+
+	entry = len(this.code)
+	this.code = this.generateBytecode(il)
+	for idx := len(this.functions); idx < len(this.compiled); idx++ {
+		fn := this.compiled[idx]
+		runBuiltin := callJsFunction(fn.entry-1, fn.frameSize, fn.params)
+		this.functions = append(this.functions, objectValue(newFunctionObject(runBuiltin, runBuiltin)))
+	}
+	return entry, nil
+}
+
+// resetStack empties the call stack and the argument stack, of anything a run
+// that was stopped left on them.
+func (this *vm) resetStack() {
+	for this.stack.depth > 0 {
+		this.stack.pop()
+	}
+	this.currentFrame = nil
+	this.args.values = this.args.values[:0]
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/CrimsonAS/v2/parser"
+	"github.com/stvp/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCompileError(t *testing.T) {
+	p, err := Compile("var = ;", "broken.js")
+	assert.Nil(t, p)
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "broken.js: "))
+}
+
+// A tree from other tooling, like acorn, runs like the code it came from.
+func TestCompileAST(t *testing.T) {
+	ast, err := parser.UnmarshalESTree([]byte(`{"type": "Program", "body": [
+		{"type": "FunctionDeclaration", "id": {"type": "Identifier", "name": "twice"},
+		 "params": [{"type": "Identifier", "name": "x"}],
+		 "body": {"type": "BlockStatement", "body": [{"type": "ReturnStatement", "argument":
+			{"type": "BinaryExpression", "operator": "*", "left": {"type": "Identifier", "name": "x"},
+			 "right": {"type": "Literal", "value": 2, "raw": "2"}}}]}},
+		{"type": "ReturnStatement", "argument": {"type": "CallExpression",
+		 "callee": {"type": "Identifier", "name": "twice"}, "arguments": [{"type": "Literal", "value": 21}]}}
+	]}`))
+	assert.Nil(t, err)
+	p, err := CompileAST(ast, "tree.json")
+	assert.Nil(t, err)
+	ret, err := NewFromProgram(p).Run()
+	assert.Nil(t, err)
+	assert.Equal(t, ret, newNumber(42))
+
+	_, err = CompileAST(&parser.IdentifierLiteral{}, "tree.json")
+	assert.Equal(t, err.Error(), "tree.json: expected a program, got *parser.IdentifierLiteral")
+}
+
+func TestProgramName(t *testing.T) {
+	p, err := Compile("", "rules.js")
+	assert.Nil(t, err)
+	assert.Equal(t, p.Name(), "rules.js")
+}
+
+// Each run starts from scratch, without any of the globals or builtins the
+// last one changed.
+func TestProgramRunsIndependently(t *testing.T) {
+	p, err := Compile(`
+	var first = typeof seen == "undefined";
+	seen = true;
+	var o = {count: 0};
+	o.count++;
+	Math.marked = first;
+	function f(a) { return a.marked }
+	return first && o.count == 1 && f(Math)
+	`, "test.js")
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, mustRun(t, NewFromProgram(p)), newBool(true))
+	}
+}
+
+func TestProgramUnchangedByRuns(t *testing.T) {
+	p, err := Compile(`var o = {a: 1}; o.b = 2; return o.a + o.b`, "test.js")
+	assert.Nil(t, err)
+	count := len(p.strings)
+
+	v := NewFromProgram(p)
+	v.defineVar(v.appendStringtable("extra"), newNumber(1))
+	assert.Equal(t, mustRun(t, v), newNumber(3))
+	assert.Equal(t, len(p.strings), count)
+	assert.Equal(t, mustRun(t, NewFromProgram(p)), newNumber(3))
+}
+
+func TestProgramConcurrentRuns(t *testing.T) {
+	p, err := Compile(`
+	function sum(n) {
+		var o = {total: 0}
+		var i = 0
+		while (i < n) {
+			o.total += i
+			i++
+		}
+		return o.total
+	}
+	return sum(100)
+	`, "test.js")
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	results := make([]value, 16)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = NewFromProgram(p).Run()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, r := range results {
+		assert.Nil(t, errs[i])
+		assert.Equal(t, r, newNumber(4950))
+	}
+}
+
+func TestEval(t *testing.T) {
+	v := New("")
+	mustRun(t, v)
+
+	eval := func(code string) string {
+		ret, err := v.Eval(code)
+		assert.Nil(t, err)
+		return v.Inspect(ret)
+	}
+	assert.Equal(t, eval("var x = 20"), "undefined")
+	assert.Equal(t, eval("function twice(n) { return n * 2 }"), "undefined")
+	assert.Equal(t, eval("twice(x) + 2"), "42")
+	assert.Equal(t, eval("x = 'changed'; x"), "'changed'")
+	assert.Equal(t, eval("function thrice(n) { return n * 3 }; thrice(twice(1))"), "6")
+	assert.Equal(t, eval("[twice, thrice].length"), "2")
+	assert.Equal(t, eval("({a: x})"), "{ a: 'changed' }")
+}
+
+// A failed Eval leaves the vm as it was, able to carry on.
+func TestEvalErrors(t *testing.T) {
+	v := New("var kept = 1")
+	mustRun(t, v)
+
+	_, err := v.Eval("function f() { return missing }; f()")
+	assert.Equal(t, err.Error(), "ReferenceError: missing is not defined")
+
+	_, err = v.Eval("1 }")
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "SyntaxError: "))
+
+	// what parses but doesn't compile isn't a syntax error
+	_, err = v.Eval("throw 1")
+	assert.Equal(t, err.Error(), "unknown node *parser.ThrowStatement")
+
+	v.SetStepLimit(1000)
+	_, err = v.Eval("while (true) {}")
+	_, terminated := err.(*TerminationError)
+	assert.True(t, terminated)
+	v.SetStepLimit(0)
+
+	ret, err := v.Eval("kept + 1")
+	assert.Nil(t, err)
+	assert.Equal(t, ret, newNumber(2))
+}
+
+// Evaluating more code in a vm doesn't change the Program it came from.
+func TestEvalProgramUnchanged(t *testing.T) {
+	p, err := Compile("var a = 1", "test.js")
+	assert.Nil(t, err)
+	code := len(p.code)
+	constants := len(p.constants)
+
+	v := NewFromProgram(p)
+	mustRun(t, v)
+	ret, err := v.Eval("a + 41")
+	assert.Nil(t, err)
+	assert.Equal(t, ret, newNumber(42))
+	assert.Equal(t, len(p.code), code)
+	assert.Equal(t, len(p.constants), constants)
+
+	mustRun(t, NewFromProgram(p))
+}
+
+func TestDumpTAC(t *testing.T) {
+	tac, err := DumpTAC("var a = 1 + 2", false)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(tac, "TAC_ADD"))
+
+	tac, err = DumpTAC("var a = 1 + 2", true)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(tac, "TAC_ADD"))
+	assert.True(t, strings.Contains(tac, "a = 3"))
+
+	_, err = DumpTAC("1 }", false)
+	assert.NotNil(t, err)
+}
+
+func TestCompileScript(t *testing.T) {
+	p, err := CompileScript("var a = 1; a + 1", "test.js")
+	assert.Nil(t, err)
+	assert.Equal(t, mustRun(t, NewFromProgram(p)), newNumber(2))
+
+	// as Compile doesn't
+	p, err = Compile("var a = 1; a + 1", "test.js")
+	assert.Nil(t, err)
+	assert.Equal(t, mustRun(t, NewFromProgram(p)), newUndefined())
+
+	p, err = CompileScript("if (true) { return 3 }; 4", "test.js")
+	assert.Nil(t, err)
+	assert.Equal(t, mustRun(t, NewFromProgram(p)), newNumber(3))
+
+	_, err = CompileScript("throw 1", "test.js")
+	assert.Equal(t, err.Error(), "test.js: unknown node *parser.ThrowStatement")
+}
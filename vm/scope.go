@@ -0,0 +1,261 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"github.com/CrimsonAS/v2/parser"
+)
+
+// A scope holds what is known about a function's variables before any code
+// is generated for it. Declarations are hoisted per ES5 10.5: parameters
+// first, then function declarations, then vars, each name declared once.
+//
+// A function's variables live in numbered slots in its stack frame, so they
+// are accessed by index rather than looked up by name. The program scope has
+// no slots: its variables are properties of the global object, and any name
+// not declared in a function's own scope is looked up there.
+//
+// ### there are no closures yet, so a nested function can't see the variables
+// of the function containing it. Code that uses one fails to compile, rather
+// than using a global of the same name.
+type scope struct {
+	fn        *parser.FunctionExpression // nil for the program
+	outer     *scope                     // the scope the function is in
+	index     int                        // into vm.functions; -1 for the program
+	params    []string
+	functions []*parser.FunctionExpression // hoisted function declarations
+	self      string                       // a function expression's own name, if it can see it
+	vars      []string
+	slots     map[string]int
+	strict    bool
+}
+
+func (this *scope) isProgram() bool {
+	return this.fn == nil
+}
+
+func (this *scope) name() string {
+	if this.isProgram() {
+		return "%main"
+	}
+	if this.fn.Identifier == nil {
+		return "%anonymous"
+	}
+	return this.fn.Identifier.String()
+}
+
+func (this *scope) declare(name string) {
+	for _, v := range this.vars {
+		if v == name {
+			return
+		}
+	}
+	this.vars = append(this.vars, name)
+	if !this.isProgram() {
+		this.slots[name] = len(this.slots)
+	}
+}
+
+// slot returns the slot index of a name declared in this scope, or -1 if the
+// name must be looked up at runtime.
+func (this *scope) slot(name string) int {
+	if idx, ok := this.slots[name]; ok {
+		return idx
+	}
+	return -1
+}
+
+// analyzeScopes builds the scope of the program, and of every function in it.
+// Functions are numbered (and put in funcsToDefine) in the order they appear.
+func (this *vm) analyzeScopes(program *parser.Program) *scope {
+	this.scopes = make(map[*parser.FunctionExpression]*scope)
+	// functions compiled before (see Eval) keep their numbers
+	this.funcsToDefine = make([]*parser.FunctionExpression, len(this.functions))
+
+	s := &scope{index: -1, strict: isStrictCode(program.Body())}
+	for _, stmt := range program.Body() {
+		this.hoistDeclarations(s, stmt)
+	}
+	return s
+}
+
+// analyzeFunction builds the scope of fn. A function expression (rather than
+// a declaration) sees its own name, unless a parameter, var or function in it
+// has the same one.
+func (this *vm) analyzeFunction(fn *parser.FunctionExpression, outer *scope, expression bool) {
+	s := &scope{fn: fn, outer: outer, index: len(this.funcsToDefine), slots: make(map[string]int)}
+	s.strict = outer.strict || isStrictCode(fn.Body.Body)
+	this.scopes[fn] = s
+	this.funcsToDefine = append(this.funcsToDefine, fn)
+
+	for _, p := range fn.Parameters {
+		s.params = append(s.params, p.String())
+		s.declare(p.String())
+	}
+	for _, stmt := range fn.Body.Body {
+		this.hoistDeclarations(s, stmt)
+	}
+	if expression && fn.Identifier != nil && s.slot(fn.Identifier.String()) == -1 {
+		s.self = fn.Identifier.String()
+		s.declare(s.self)
+	}
+}
+
+// isFunctionDeclaration reports whether a statement declares a function, as
+// opposed to evaluating a function expression.
+func isFunctionDeclaration(stmt parser.Node) (*parser.FunctionExpression, bool) {
+	es, ok := stmt.(*parser.ExpressionStatement)
+	if !ok {
+		return nil, false
+	}
+	fn, ok := es.X.(*parser.FunctionExpression)
+	if !ok || fn.Identifier == nil {
+		return nil, false
+	}
+	return fn, true
+}
+
+// hoistDeclarations declares the vars and functions found in a statement, and
+// analyzes any function (declared or not) found in it.
+func (this *vm) hoistDeclarations(s *scope, node parser.Node) {
+	if fn, ok := isFunctionDeclaration(node); ok {
+		s.functions = append(s.functions, fn)
+		s.declare(fn.Identifier.String())
+		this.analyzeFunction(fn, s, false)
+		return
+	}
+
+	switch n := node.(type) {
+	case nil:
+	case *parser.VariableStatement:
+		for idx, v := range n.Vars {
+			s.declare(v.String())
+			this.hoistDeclarations(s, n.Initializers[idx])
+		}
+	case *parser.FunctionExpression:
+		this.analyzeFunction(n, s, true)
+	case *parser.BlockStatement:
+		for _, stmt := range n.Body {
+			this.hoistDeclarations(s, stmt)
+		}
+	case *parser.ExpressionStatement:
+		this.hoistDeclarations(s, n.X)
+	case *parser.IfStatement:
+		this.hoistDeclarations(s, n.ConditionExpr)
+		this.hoistDeclarations(s, n.ThenStmt)
+		this.hoistDeclarations(s, n.ElseStmt)
+	case *parser.ReturnStatement:
+		this.hoistDeclarations(s, n.X)
+	case *parser.ThrowStatement:
+		this.hoistDeclarations(s, n.X)
+	case *parser.SwitchStatement:
+		this.hoistDeclarations(s, n.X)
+		for _, c := range n.Cases {
+			this.hoistDeclarations(s, c.X)
+			for _, stmt := range c.Body {
+				this.hoistDeclarations(s, stmt)
+			}
+		}
+	case *parser.DoWhileStatement:
+		this.hoistDeclarations(s, n.X)
+		this.hoistDeclarations(s, n.Body)
+	case *parser.WhileStatement:
+		this.hoistDeclarations(s, n.X)
+		this.hoistDeclarations(s, n.Body)
+	case *parser.ForStatement:
+		this.hoistDeclarations(s, n.Initializer)
+		this.hoistDeclarations(s, n.Test)
+		this.hoistDeclarations(s, n.Update)
+		this.hoistDeclarations(s, n.Body)
+	case *parser.ForInStatement:
+		this.hoistDeclarations(s, n.X)
+		this.hoistDeclarations(s, n.Y)
+		this.hoistDeclarations(s, n.Body)
+	case *parser.TryStatement:
+		this.hoistDeclarations(s, n.Body)
+		if n.Catch != nil {
+			this.hoistDeclarations(s, n.Catch.Body)
+		}
+		if n.Finally != nil {
+			this.hoistDeclarations(s, n.Finally.Body)
+		}
+
+	// Expressions can't declare anything, but may contain functions.
+	case *parser.NewExpression:
+		this.hoistDeclarations(s, n.X)
+	case *parser.CallExpression:
+		this.hoistDeclarations(s, n.X)
+		for _, arg := range n.Arguments {
+			this.hoistDeclarations(s, arg)
+		}
+	case *parser.DotMemberExpression:
+		this.hoistDeclarations(s, n.X)
+	case *parser.BracketMemberExpression:
+		this.hoistDeclarations(s, n.X)
+		this.hoistDeclarations(s, n.Y)
+	case *parser.UnaryExpression:
+		this.hoistDeclarations(s, n.X)
+	case *parser.AssignmentExpression:
+		this.hoistDeclarations(s, n.Left)
+		this.hoistDeclarations(s, n.Right)
+	case *parser.BinaryExpression:
+		this.hoistDeclarations(s, n.Left)
+		this.hoistDeclarations(s, n.Right)
+	case *parser.ConditionalExpression:
+		this.hoistDeclarations(s, n.X)
+		this.hoistDeclarations(s, n.Then)
+		this.hoistDeclarations(s, n.Else)
+	case *parser.SequenceExpression:
+		this.hoistDeclarations(s, n.X)
+		this.hoistDeclarations(s, n.Y)
+	case *parser.ArrayLiteral:
+		for _, e := range n.Elements {
+			this.hoistDeclarations(s, e)
+		}
+	case *parser.ObjectLiteral:
+		for _, p := range n.Properties {
+			this.hoistDeclarations(s, p.X)
+		}
+	}
+}
+
+// resolveVar returns the address of a variable, as seen from the scope code is
+// currently being generated for.
+func (this *vm) resolveVar(name string) tac_address {
+	if this.currentScope != nil {
+		if slot := this.currentScope.slot(name); slot != -1 {
+			return newLocal(name, slot)
+		}
+		for s := this.currentScope.outer; s != nil; s = s.outer {
+			if s.slot(name) != -1 {
+				panic(fmt.Sprintf("closures are not supported: %s uses %s, a variable of %s", this.currentScope.name(), name, s.name()))
+			}
+		}
+	}
+	return newVar(name)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/CrimsonAS/v2/parser"
+	"github.com/stvp/assert"
+	"testing"
+)
+
+func TestScopeSlots(t *testing.T) {
+	vm := &vm{}
+	code := "var g; function f(a, b) { var c, a; if (a) { var d } function e() { var x } return function() {} }"
+	ps := vm.analyzeScopes(parser.Parse(code, true).(*parser.Program))
+
+	assert.Equal(t, ps.vars, []string{"g", "f"})
+	assert.Equal(t, ps.slot("g"), -1)
+
+	// functions are numbered in the order they appear
+	assert.Equal(t, len(vm.funcsToDefine), 3)
+	fs := vm.scopes[vm.funcsToDefine[0]]
+	assert.Equal(t, fs.index, 0)
+	assert.Equal(t, fs.name(), "f")
+	assert.Equal(t, fs.params, []string{"a", "b"})
+	assert.Equal(t, fs.vars, []string{"a", "b", "c", "d", "e"})
+	assert.Equal(t, fs.slot("a"), 0)
+	assert.Equal(t, fs.slot("d"), 3)
+	assert.Equal(t, fs.slot("x"), -1)
+	assert.Equal(t, fs.slot("g"), -1)
+
+	es := vm.scopes[vm.funcsToDefine[1]]
+	assert.Equal(t, es.name(), "e")
+	assert.Equal(t, es.vars, []string{"x"})
+
+	as := vm.scopes[vm.funcsToDefine[2]]
+	assert.Equal(t, as.name(), "%anonymous")
+	assert.Equal(t, len(as.vars), 0)
+}
+
+func TestScopeStrictness(t *testing.T) {
+	vm := &vm{}
+	code := "function f() { \"use strict\"; function g() {} } function h() {}"
+	ps := vm.analyzeScopes(parser.Parse(code, true).(*parser.Program))
+
+	assert.Equal(t, ps.strict, false)
+	assert.Equal(t, vm.scopes[vm.funcsToDefine[0]].strict, true)
+	assert.Equal(t, vm.scopes[vm.funcsToDefine[1]].strict, true)
+	assert.Equal(t, vm.scopes[vm.funcsToDefine[2]].strict, false)
+}
+
+// Until there are closures, using a variable of an enclosing function doesn't
+// compile, rather than using a global.
+func TestScopeEnclosingVariables(t *testing.T) {
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{"function f() { var x = 1; function g() { x = 5 } g(); return x }", "test.js: closures are not supported: g uses x, a variable of f"},
+		{"function f(x) { return function() { return x } }", "test.js: closures are not supported: %anonymous uses x, a variable of f"},
+		{"function f() { var x; function g() { function h() { return typeof x } } }", "test.js: closures are not supported: h uses x, a variable of f"},
+		// its own variables, and globals, are fine
+		{"var x; function f() { var x = 1; function g(x) { var y = x; return y } return x }", ""},
+		{"var x; function f() { function g() { return x } }", ""},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.code, "test.js")
+		if test.err == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.NotNil(t, err)
+			assert.Equal(t, err.Error(), test.err)
+		}
+		t.Logf("Passed: %s", test.code)
+	}
+}
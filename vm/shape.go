@@ -0,0 +1,207 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import "unsafe"
+
+// Objects keep their own properties in slots, and a shape that says which
+// property is in which slot. Adding a property moves an object on to the next
+// shape, and objects that had the same properties added in the same order end
+// up sharing a shape, so a member access can remember where it found a
+// property on an object, and go straight to that slot on the next object it
+// sees with the same shape.
+//
+// Each vm has shapes of its own, which last as long as it does, and are
+// charged to it like anything else the script allocates.
+//
+// An object with a lot of properties goes into dictionary mode instead, where
+// it has no shape, and looks its properties up in a map of its own.
+
+// how many properties an object can have before it goes into dictionary mode.
+const maxShapeProperties = 64
+
+// below this, a shape looks names up by scanning them, rather than in a map.
+const shapeIndexThreshold = 8
+
+type shape struct {
+	names       []string
+	index       map[string]int    // built once there are enough names
+	transitions map[string]*shape // of names to the next shape
+}
+
+// emptyShape is the shape of objects with no properties. Objects are made
+// without a vm, so this one is shared, and has no transitions: adding the
+// first property goes on from the rootShape of the vm it is added in.
+var emptyShape = &shape{}
+
+// shapeSize returns about how much memory a shape of n names takes.
+func shapeSize(n int) int64 {
+	size := int64(unsafe.Sizeof(shape{})) + int64(n)*int64(unsafe.Sizeof(""))
+	if n >= shapeIndexThreshold {
+		size += int64(n) * int64(unsafe.Sizeof("")+unsafe.Sizeof(0))
+	}
+	return size
+}
+
+// lookup returns the slot of a property, if the shape has it.
+func (this *shape) lookup(name string) (int, bool) {
+	if this.index != nil {
+		slot, ok := this.index[name]
+		return slot, ok
+	}
+	for slot, n := range this.names {
+		if n == name {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+// with returns the shape that adding a property to this one gives in vm.
+func (this *shape) with(vm *vm, name string) *shape {
+	if this == emptyShape {
+		if vm.rootShape == nil {
+			vm.rootShape = &shape{}
+		}
+		return vm.rootShape.with(vm, name)
+	}
+	if next, ok := this.transitions[name]; ok {
+		return next
+	}
+
+	size := shapeSize(len(this.names) + 1)
+	vm.allocate(size)
+	vm.memory.shapes += size
+	names := make([]string, len(this.names)+1)
+	copy(names, this.names)
+	names[len(this.names)] = name
+	next := &shape{names: names}
+	if len(names) >= shapeIndexThreshold {
+		next.index = make(map[string]int, len(names))
+		for slot, n := range names {
+			next.index[n] = slot
+		}
+	}
+
+	if this.transitions == nil {
+		this.transitions = make(map[string]*shape)
+	}
+	this.transitions[name] = next
+	return next
+}
+
+// how many shapes an inline cache remembers before it gives up on new ones.
+const inlineCacheSize = 4
+
+// An inlineCache belongs to a LOAD_MEMBER or STORE_MEMBER, and remembers the
+// slot its property was found in for the last few shapes it saw.
+type inlineCache struct {
+	shapes [inlineCacheSize]*shape
+	slots  [inlineCacheSize]int
+	count  int
+}
+
+// lookup returns the property descriptor the cache knows of on o, if any.
+func (this *inlineCache) lookup(o *valueBasicObjectData) *propertyDescriptor {
+	for i := 0; i < this.count; i++ {
+		if this.shapes[i] == o.shape {
+			return o.slots[this.slots[i]]
+		}
+	}
+	return nil
+}
+
+// update remembers where o keeps the property name, if it has it.
+func (this *inlineCache) update(o *valueBasicObjectData, name string) {
+	if o.shape == nil || this.count == inlineCacheSize {
+		return
+	}
+	for i := 0; i < this.count; i++ {
+		if this.shapes[i] == o.shape {
+			return
+		}
+	}
+	if slot, ok := o.shape.lookup(name); ok {
+		this.shapes[this.count] = o.shape
+		this.slots[this.count] = slot
+		this.count++
+	}
+}
+
+// cacheableData returns the property storage of v, if member accesses on it
+// can go through an inline cache: that is, if it is an object in shape mode
+// that has no special properties of its own.
+func cacheableData(v value) *valueBasicObjectData {
+	var data objectData
+	switch o := v.obj.(type) {
+	case valueBasicObject:
+		data = o.odata
+	case functionObject:
+		data = o.odata
+	default:
+		return nil
+	}
+	storage := data.Storage()
+	if storage.shape == nil {
+		return nil
+	}
+	return storage
+}
+
+// loadMember is LOAD_MEMBER: it gets the property name of v.
+func (this *vm) loadMember(v value, name string, cache *inlineCache) value {
+	o := cacheableData(v)
+	if o != nil {
+		if pd := cache.lookup(o); pd != nil && pd.isDataDescriptor() {
+			return pd.value
+		}
+	}
+
+	key := newString(name)
+	rv := this.objectFor(v, key, false).get(this, key)
+	if o != nil {
+		cache.update(o, name)
+	}
+	return rv
+}
+
+// storeMember is STORE_MEMBER: it sets the property name of v.
+func (this *vm) storeMember(v value, name string, nv value, cache *inlineCache) {
+	o := cacheableData(v)
+	if o != nil {
+		if pd := cache.lookup(o); pd != nil && pd.isDataDescriptor() && pd.writable {
+			pd.value = nv
+			return
+		}
+	}
+
+	key := newString(name)
+	this.objectFor(v, key, true).put(this, key, nv, true)
+	if o != nil {
+		cache.update(o, name)
+	}
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"fmt"
+	"github.com/stvp/assert"
+	"testing"
+)
+
+// the vm that addProperty adds properties in, so its shapes are shared
+var shapeTestVM = &vm{}
+
+func addProperty(o *valueBasicObjectData, name string, v value) {
+	o.AppendProperty(shapeTestVM, &propertyDescriptor{name: name, value: v, writable: true})
+}
+
+func TestShapeTransitions(t *testing.T) {
+	a := newValueBasicObjectData()
+	b := newValueBasicObjectData()
+	c := newValueBasicObjectData()
+	assert.Equal(t, a.shape, emptyShape)
+
+	addProperty(a, "x", newNumber(1))
+	addProperty(a, "y", newNumber(2))
+	addProperty(b, "x", newNumber(3))
+	addProperty(b, "y", newNumber(4))
+	addProperty(c, "y", newNumber(5))
+	addProperty(c, "x", newNumber(6))
+
+	// the same properties in the same order give the same shape
+	assert.True(t, a.shape == b.shape)
+	assert.False(t, a.shape == c.shape)
+
+	assert.Equal(t, a.FindProperty("y").value, newNumber(2))
+	assert.Equal(t, b.FindProperty("x").value, newNumber(3))
+	assert.Equal(t, c.FindProperty("x").value, newNumber(6))
+	assert.Nil(t, a.FindProperty("z"))
+}
+
+// Each vm has shapes of its own, so one can't fill up another's.
+func TestShapesPerVM(t *testing.T) {
+	a := newValueBasicObjectData()
+	b := newValueBasicObjectData()
+	a.AppendProperty(&vm{}, &propertyDescriptor{name: "x"})
+	b.AppendProperty(&vm{}, &propertyDescriptor{name: "x"})
+	assert.False(t, a.shape == b.shape)
+	assert.Equal(t, emptyShape.transitions, map[string]*shape(nil))
+}
+
+// Shapes are charged to the vm, and aren't garbage, as they are never freed.
+func TestShapesMemoryLimit(t *testing.T) {
+	v := New(`var i = 0; while (true) { var o = {}; o["k" + i] = 1; i++ }`)
+	v.SetMemoryLimit(1 << 20)
+	v.SetStepLimit(5000000)
+	te := runTerminated(t, v.Run)
+	assert.Equal(t, te.Reason, "memory limit of 1048576 bytes exceeded")
+}
+
+func TestShapeIndex(t *testing.T) {
+	o := newValueBasicObjectData()
+	for i := 0; i < shapeIndexThreshold*2; i++ {
+		addProperty(o, fmt.Sprintf("p%d", i), newNumber(float64(i)))
+	}
+	assert.NotNil(t, o.shape.index)
+	for i := 0; i < shapeIndexThreshold*2; i++ {
+		assert.Equal(t, o.FindProperty(fmt.Sprintf("p%d", i)).value, newNumber(float64(i)))
+	}
+	assert.Nil(t, o.FindProperty("p100"))
+}
+
+func TestDictionaryMode(t *testing.T) {
+	o := newValueBasicObjectData()
+	for i := 0; i < maxShapeProperties; i++ {
+		addProperty(o, fmt.Sprintf("p%d", i), newNumber(float64(i)))
+	}
+	assert.NotNil(t, o.shape)
+
+	addProperty(o, "last", newNumber(-1))
+	assert.Nil(t, o.shape)
+	addProperty(o, "after", newNumber(-2))
+
+	for i := 0; i < maxShapeProperties; i++ {
+		assert.Equal(t, o.FindProperty(fmt.Sprintf("p%d", i)).value, newNumber(float64(i)))
+	}
+	assert.Equal(t, o.FindProperty("last").value, newNumber(-1))
+	assert.Equal(t, o.FindProperty("after").value, newNumber(-2))
+	assert.Nil(t, o.FindProperty("missing"))
+
+	// properties stay in the order they were added
+	props := o.Properties()
+	assert.Equal(t, len(props), maxShapeProperties+2)
+	assert.Equal(t, props[0].name, "p0")
+	assert.Equal(t, props[len(props)-1].name, "after")
+}
+
+func TestInlineCache(t *testing.T) {
+	var cache inlineCache
+
+	objects := []*valueBasicObjectData{}
+	for i := 0; i < inlineCacheSize+1; i++ {
+		o := newValueBasicObjectData()
+		// a different property before x gives each object a shape of its own
+		addProperty(o, fmt.Sprintf("p%d", i), newUndefined())
+		addProperty(o, "x", newNumber(float64(i)))
+		objects = append(objects, o)
+	}
+
+	o := objects[0]
+	assert.Nil(t, cache.lookup(o))
+	cache.update(o, "x")
+	assert.Equal(t, cache.lookup(o).value, newNumber(0))
+
+	// another object of the same shape hits as well
+	same := newValueBasicObjectData()
+	addProperty(same, "p0", newUndefined())
+	addProperty(same, "x", newNumber(10))
+	assert.Equal(t, cache.lookup(same).value, newNumber(10))
+
+	// seeing a shape again doesn't use up another entry
+	cache.update(same, "x")
+	assert.Equal(t, cache.count, 1)
+
+	for _, o := range objects[1:] {
+		cache.update(o, "x")
+	}
+	for i, o := range objects[:inlineCacheSize] {
+		assert.Equal(t, cache.lookup(o).value, newNumber(float64(i)))
+	}
+	// the cache is full, so the last shape is never remembered
+	assert.Nil(t, cache.lookup(objects[inlineCacheSize]))
+
+	// nor are properties an object doesn't have
+	var missing inlineCache
+	missing.update(o, "y")
+	assert.Equal(t, missing.count, 0)
+}
+
+func TestMemberCaches(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			// one shape, then more than the cache holds
+			in: `function read(o) { return o.x }
+				var a = {x: 1}
+				var r = read(a) + read(a) + read({x: 2})
+				r = r + read({y: 0, x: 3}) + read({z: 0, x: 4}) + read({w: 0, x: 5}) + read({v: 0, x: 6})
+				return r + read(a)`,
+			out: newNumber(23),
+		},
+		simpleVMTest{
+			// a property that isn't own isn't cached
+			in: `function read(o) { return o.hasOwnProperty }
+				read({hasOwnProperty: 1})
+				return typeof read({})`,
+			out: newString("function"),
+		},
+		simpleVMTest{
+			in: `function write(o, v) { o.x = v }
+				var a = {x: 1}, b = {y: 1, x: 2}, c = {}
+				write(a, 3); write(a, 4); write(b, 5); write(c, 6)
+				return a.x + b.x + c.x`,
+			out: newNumber(15),
+		},
+	}
+	runSimpleVMTestHelper(t, tests)
+}
+
+// Properties have the same shape whether they are writable or not, so a store
+// that hits the cache still has to check.
+func TestReadOnlyMemberCache(t *testing.T) {
+	vm := New("")
+	writable := newBasicObject()
+	writable.odata.AppendProperty(vm, &propertyDescriptor{name: "x", value: newNumber(1), writable: true})
+	readOnly := newBasicObject()
+	readOnly.odata.AppendProperty(vm, &propertyDescriptor{name: "x", value: newNumber(1)})
+	assert.True(t, writable.odata.Storage().shape == readOnly.odata.Storage().shape)
+
+	var cache inlineCache
+	vm.storeMember(objectValue(writable), "x", newNumber(2), &cache)
+	assert.Equal(t, cache.count, 1)
+	assert.Equal(t, writable.get(vm, newString("x")), newNumber(2))
+
+	defer func() {
+		assert.Equal(t, recover(), "TypeError: Cannot write to property")
+		assert.Equal(t, readOnly.get(vm, newString("x")), newNumber(1))
+	}()
+	vm.storeMember(objectValue(readOnly), "x", newNumber(2), &cache)
+}
+
+func TestDictionaryModeFromVM(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in: `var o = {}
+				var i = 0
+				while (i < 100) { o["p" + i] = i; i = i + 1 }
+				o.x = 5
+				o.x = o.x + 1
+				return o.x + o["p" + 99] + o["p" + 0]`,
+			out: newNumber(105),
+		},
+	}
+	runSimpleVMTestHelper(t, tests)
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+// Arithmetic and comparisons on numbers get opcodes of their own, that skip
+// converting their operands if they are numbers already. Which operands are
+// numbers is only guessed at codegen time, so each of these opcodes checks
+// that it guessed right, and does what the generic opcode would otherwise.
+
+// numberOpcodes maps opcodes to their number-only versions.
+var numberOpcodes = map[opcode_type]opcode_type{
+	ADD:             ADD_NUMBER,
+	SUB:             SUB_NUMBER,
+	LESS_THAN:       LESS_THAN_NUMBER,
+	LESS_THAN_EQ:    LESS_THAN_EQ_NUMBER,
+	GREATER_THAN:    GREATER_THAN_NUMBER,
+	GREATER_THAN_EQ: GREATER_THAN_EQ_NUMBER,
+}
+
+// producesNumber reports whether an instruction always gives a number, if the
+// addresses isNumber says are numbers are.
+func producesNumber(op tac, isNumber func(addr tac_address) bool) bool {
+	switch op.op {
+	case TAC_ASSIGN, TAC_LOAD:
+		return isNumber(op.arg1)
+	case TAC_ADD:
+		return isNumber(op.arg1) && isNumber(op.arg2)
+	case TAC_SUB, TAC_MULTIPLY, TAC_DIVIDE, TAC_MODULUS, TAC_EXPONENT,
+		TAC_LEFT_SHIFT, TAC_RIGHT_SHIFT, TAC_UNSIGNED_RIGHT_SHIFT,
+		TAC_BITWISE_AND, TAC_BITWISE_XOR, TAC_BITWISE_OR,
+		TAC_UMINUS, TAC_BITWISE_NOT:
+		return true
+	}
+	return false
+}
+
+// inferNumbers guesses which tracked addresses (by trackedKey) of a function
+// only ever hold numbers: those that are only ever set to numbers.
+//
+// It is only a guess, as it ignores the value an address has when the
+// function is entered (an argument, or undefined).
+func inferNumbers(code []tac) map[int]bool {
+	numbers := make(map[int]bool)
+	for _, op := range code {
+		if addr, ok := definedAddress(op); ok {
+			numbers[trackedKey(addr)] = true
+		}
+	}
+
+	isNumber := func(addr tac_address) bool {
+		if c, ok := constantValue(addr); ok {
+			return c.isNumber()
+		}
+		return isTracked(addr) && numbers[trackedKey(addr)]
+	}
+
+	// rule out anything set to something that isn't a number, until that
+	// rules out nothing else
+	for changed := true; changed; {
+		changed = false
+		for _, op := range code {
+			addr, ok := definedAddress(op)
+			if !ok || !numbers[trackedKey(addr)] {
+				continue
+			}
+			if !producesNumber(op, isNumber) {
+				numbers[trackedKey(addr)] = false
+				changed = true
+			}
+		}
+	}
+	return numbers
+}
+
+// isNumber reports whether addr is likely to be a number.
+func (this *bytecodeGenerator) isNumber(addr tac_address) bool {
+	if c, ok := constantValue(addr); ok {
+		return c.isNumber()
+	}
+	return isTracked(addr) && this.numbers[trackedKey(addr)]
+}
+
+// specialize returns the number-only version of the opcode o for op, if its
+// operands are likely to be numbers.
+func (this *bytecodeGenerator) specialize(o opcode_type, op tac) opcode_type {
+	fast, ok := numberOpcodes[o]
+	if !ok {
+		return o
+	}
+
+	// one number operand is enough to go on, as long as the other isn't
+	// known to be something else.
+	for _, addr := range []tac_address{op.arg1, op.arg2} {
+		if c, ok := constantValue(addr); ok {
+			if !c.isNumber() {
+				return o
+			}
+		}
+	}
+	if !this.isNumber(op.arg1) && !this.isNumber(op.arg2) {
+		return o
+	}
+
+	if c, ok := constantValue(op.arg2); ok && c == newNumber(1) {
+		switch o {
+		case ADD:
+			return INCREMENT
+		case SUB:
+			return DECREMENT
+		}
+	}
+	return fast
+}
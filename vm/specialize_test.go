@@ -0,0 +1,125 @@
+/*
+ * Copyright 2018 Crimson AS <info@crimson.no>
+ * Author: Robin Burchell <robin.burchell@crimson.no>
+ *
+ * Redistribution and use in source and binary forms, with or without modification,
+ * are permitted provided that the following conditions are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ * 2. Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ * ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED.  IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package vm
+
+import (
+	"github.com/stvp/assert"
+	"testing"
+)
+
+// opcodeTypes returns the opcodes compiled for code, optimized or not.
+func opcodeTypes(code string, optimize bool) map[opcode_type]bool {
+	ret := make(map[opcode_type]bool)
+	for _, op := range newVM(code, optimize).code {
+		ret[op.otype] = true
+	}
+	return ret
+}
+
+func TestSpecializedOpcodes(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		ops := opcodeTypes("function f() { var a = 0, b = 1; for (var i = 2; i <= 10; i++) { b = a + b; a = b - a } return b } return f()", optimize)
+		assert.Equal(t, ops[LESS_THAN_EQ_NUMBER], true)
+		assert.Equal(t, ops[INCREMENT], true)
+		assert.Equal(t, ops[ADD_NUMBER], true)
+		assert.Equal(t, ops[SUB_NUMBER], true)
+		assert.Equal(t, ops[ADD], false)
+		assert.Equal(t, ops[SUB], false)
+	}
+
+	// nothing suggests these are numbers
+	ops := opcodeTypes("function f(a, b) { return a + b } return f(1, 2)", false)
+	assert.Equal(t, ops[ADD], true)
+	assert.Equal(t, ops[ADD_NUMBER], false)
+	ops = opcodeTypes("function f(a) { return a + 'x' } return f(1)", false)
+	assert.Equal(t, ops[ADD], true)
+}
+
+func TestInferNumbers(t *testing.T) {
+	vm := &vm{temporaryIndex: -1}
+	a := newLocal("a", 0)
+	b := newLocal("b", 1)
+	c := newLocal("c", 2)
+	tmp := vm.newTemporary()
+	code := []tac{
+		tac{result: a, arg1: newConstant(newNumber(1)), op: TAC_ASSIGN},
+		tac{result: b, arg1: a, op: TAC_ASSIGN},
+		tac{result: tmp, arg1: a, op: TAC_ADD, arg2: b},
+		tac{result: c, arg1: tmp, op: TAC_ASSIGN},
+		tac{result: c, arg1: newConstant(newString("x")), op: TAC_ASSIGN},
+	}
+	numbers := inferNumbers(code)
+	assert.Equal(t, numbers[trackedKey(a)], true)
+	assert.Equal(t, numbers[trackedKey(b)], true)
+	assert.Equal(t, numbers[trackedKey(tmp)], true)
+	assert.Equal(t, numbers[trackedKey(c)], false)
+}
+
+// The number-only opcodes must do what the generic ones do when they guessed
+// wrong.
+func TestSpecializedGuards(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "function f(a) { return a + 1 } return typeof f('x')",
+			out: newString("string"),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a - 1 } return f('3')",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a - 2 } return f('3')",
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a + 2 } return f(true)",
+			out: newNumber(3),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a < 2 } return f('1')",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a <= 2 } return f(null)",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a > 2 } return f('3')",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a >= 2 } return f(undefined)",
+			out: newBool(false),
+		},
+		simpleVMTest{
+			in:  "function f() { var i = 0; i = 'x'; return i + 1 } return typeof f()",
+			out: newString("string"),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
@@ -57,3 +57,46 @@ func (this *stack) pop() value {
 	//log.Printf("Popped %s len %d", v, len(this.values))
 	return v
 }
+
+// how many frames the call stack allocates at a time.
+const frameChunkSize = 64
+
+// frameStack is the call stack. Frames are kept in chunks that never move,
+// rather than in one slice that is copied as it grows, so that pointers to
+// frames (the vm's currentFrame, and each frame's outer) stay good for as long
+// as the frame is on the stack.
+type frameStack struct {
+	chunks [][]stackFrame
+	depth  int
+}
+
+// push puts sf on top of the stack, and returns where it went.
+func (this *frameStack) push(sf stackFrame) *stackFrame {
+	if this.depth == len(this.chunks)*frameChunkSize {
+		this.chunks = append(this.chunks, make([]stackFrame, frameChunkSize))
+	}
+	f := &this.chunks[this.depth/frameChunkSize][this.depth%frameChunkSize]
+	*f = sf
+	this.depth++
+	return f
+}
+
+// pop takes the top frame off the stack.
+func (this *frameStack) pop() {
+	this.depth--
+	// so that its registers can be collected
+	this.chunks[this.depth/frameChunkSize][this.depth%frameChunkSize] = stackFrame{}
+}
+
+// top returns the frame on top of the stack, or nil if it's empty.
+func (this *frameStack) top() *stackFrame {
+	if this.depth == 0 {
+		return nil
+	}
+	return this.at(this.depth - 1)
+}
+
+// at returns the frame idx places from the bottom of the stack.
+func (this *frameStack) at(idx int) *stackFrame {
+	return &this.chunks[idx/frameChunkSize][idx%frameChunkSize]
+}
@@ -30,12 +30,16 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type stringObject struct {
 	valueBasicObject
-	primitiveData valueString
+	primitiveData string
 }
 
 //////////////////////////////////////
@@ -54,8 +58,8 @@ func (this stringObject) ToBoolean() bool {
 	return true
 }
 
-func (this stringObject) ToString() valueString {
-	return this.primitiveData
+func (this stringObject) ToString() value {
+	return newString(this.primitiveData)
 }
 
 func (this stringObject) ToObject() valueObject {
@@ -67,7 +71,7 @@ func (this stringObject) hasPrimitiveBase() bool {
 }
 
 func (this stringObject) String() string {
-	return string(this.primitiveData)
+	return this.primitiveData
 }
 
 //////////////////////////////////////
@@ -83,7 +87,9 @@ func (this stringObject) getOwnProperty(vm *vm, prop value) *propertyDescriptor
 }
 
 func (this stringObject) hasInstance(vm *vm, instance value) bool {
-	log.Printf("stringObject hasInstance: %+v STUB", instance)
+	if objectDebug {
+		log.Printf("stringObject hasInstance: %+v STUB", instance)
+	}
 	return false
 }
 
@@ -93,51 +99,142 @@ func (this stringObject) put(vm *vm, prop value, v value, throw bool) {
 
 func (this stringObject) get(vm *vm, prop value) value {
 	// ### belongs in getOwnProperty perhaps?
-	if numIdx, ok := prop.(valueNumber); ok {
-		idx := numIdx.ToInteger()
-		if float64(idx) == float64(numIdx) && idx >= 0 && idx < len(this.primitiveData) {
-			return newString(string(this.primitiveData[idx]))
-		}
+	// ES5 15.5.5.2
+	if idx, ok := stringPropertyIndex(prop); ok && idx < stringLength(this.primitiveData) {
+		return newString(substring(this.primitiveData, idx, idx+1))
+	}
+	// ES5 15.5.5.1
+	if prop == newString("length") {
+		return newNumber(float64(stringLength(this.primitiveData)))
 	}
 
 	if this.valueBasicObject.getOwnProperty(vm, prop) != nil {
 		return this.valueBasicObject.get(vm, prop)
 	} else {
-		return stringProto.get(vm, prop)
+		return vm.stringProto.get(vm, prop)
+	}
+}
+
+// stringPropertyIndex returns the character index a property name refers to, if
+// it is one: a non-negative integer, or the canonical string form of one.
+func stringPropertyIndex(prop value) (int, bool) {
+	switch prop.kind {
+	case kindNumber:
+		idx := prop.ToInteger()
+		if float64(idx) == prop.num && idx >= 0 {
+			return idx, true
+		}
+	case kindString:
+		idx, err := strconv.Atoi(prop.str)
+		if err == nil && idx >= 0 && strconv.Itoa(idx) == prop.str {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// Strings are kept as UTF-8, but are indexed and measured in UTF-16 code
+// units (ES5 8.4). For ASCII the two are the same, so those strings are used
+// as they are, and others are converted. UTF-8 can't hold a lone surrogate,
+// so half of a surrogate pair becomes U+FFFD.
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// stringLength returns the length of s in code units.
+func stringLength(s string) int {
+	if isASCII(s) {
+		return len(s)
+	}
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// stringUnits returns the code units of s.
+func stringUnits(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+// substring returns the code units of s from from up to to, which must be in
+// bounds.
+func substring(s string, from int, to int) string {
+	if isASCII(s) {
+		return s[from:to]
+	}
+	return string(utf16.Decode(stringUnits(s)[from:to]))
+}
+
+// unitsAt reports whether search is in units at idx.
+func unitsAt(units []uint16, search []uint16, idx int) bool {
+	if idx < 0 || idx+len(search) > len(units) {
+		return false
 	}
+	for i, u := range search {
+		if units[idx+i] != u {
+			return false
+		}
+	}
+	return true
 }
 
 //////////////////////////////////////
 
-func (this *stringObject) Prototype() *valueBasicObject {
-	return &stringProto
+func (this *stringObject) Prototype(vm *vm) *valueBasicObject {
+	return &vm.stringProto
 }
 
 func newStringObject(s string) valueObject {
-	return stringObject{valueBasicObject: newBasicObject(), primitiveData: newString(s)}
+	return stringObject{valueBasicObject: newBasicObject(), primitiveData: s}
 }
 
-var stringProto valueBasicObject
-
 func defineStringCtor(vm *vm) value {
-	stringProto = valueBasicObject{&rootObjectData{&valueBasicObjectData{extensible: true}}}
-	stringProto.defineDefaultProperty(vm, "toString", newFunctionObject(string_prototype_toString, nil), 0)
-	stringProto.defineDefaultProperty(vm, "valueOf", newFunctionObject(string_prototype_valueOf, nil), 0)
-	stringProto.defineDefaultProperty(vm, "charAt", newFunctionObject(string_prototype_charAt, nil), 1)
-	stringProto.defineDefaultProperty(vm, "charCodeAt", newFunctionObject(string_prototype_charCodeAt, nil), 1)
-	stringProto.defineDefaultProperty(vm, "concat", newFunctionObject(string_prototype_concat, nil), 1)
-	stringProto.defineDefaultProperty(vm, "indexOf", newFunctionObject(string_prototype_indexOf, nil), 1)
-	stringProto.defineDefaultProperty(vm, "lastIndexOf", newFunctionObject(string_prototype_lastIndexOf, nil), 1)
-	stringProto.defineDefaultProperty(vm, "toLowerCase", newFunctionObject(string_prototype_toLowerCase, nil), 0)
-	stringProto.defineDefaultProperty(vm, "toUpperCase", newFunctionObject(string_prototype_toUpperCase, nil), 0)
-	stringProto.defineDefaultProperty(vm, "trim", newFunctionObject(string_prototype_trim, nil), 0)
+	vm.stringProto = valueBasicObject{&rootObjectData{newValueBasicObjectData()}}
+	vm.stringProto.defineDefaultProperty(vm, "toString", objectValue(newFunctionObject(string_prototype_toString, nil)), 0)
+	vm.stringProto.defineDefaultProperty(vm, "valueOf", objectValue(newFunctionObject(string_prototype_valueOf, nil)), 0)
+	vm.stringProto.defineDefaultProperty(vm, "charAt", objectValue(newFunctionObject(string_prototype_charAt, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "charCodeAt", objectValue(newFunctionObject(string_prototype_charCodeAt, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "concat", objectValue(newFunctionObject(string_prototype_concat, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "indexOf", objectValue(newFunctionObject(string_prototype_indexOf, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "lastIndexOf", objectValue(newFunctionObject(string_prototype_lastIndexOf, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "localeCompare", objectValue(newFunctionObject(string_prototype_localeCompare, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "replace", objectValue(newFunctionObject(string_prototype_replace, nil)), 2)
+	vm.stringProto.defineDefaultProperty(vm, "slice", objectValue(newFunctionObject(string_prototype_slice, nil)), 2)
+	vm.stringProto.defineDefaultProperty(vm, "split", objectValue(newFunctionObject(string_prototype_split, nil)), 2)
+	vm.stringProto.defineDefaultProperty(vm, "substr", objectValue(newFunctionObject(string_prototype_substr, nil)), 2)
+	vm.stringProto.defineDefaultProperty(vm, "substring", objectValue(newFunctionObject(string_prototype_substring, nil)), 2)
+	vm.stringProto.defineDefaultProperty(vm, "toLowerCase", objectValue(newFunctionObject(string_prototype_toLowerCase, nil)), 0)
+	vm.stringProto.defineDefaultProperty(vm, "toUpperCase", objectValue(newFunctionObject(string_prototype_toUpperCase, nil)), 0)
+	vm.stringProto.defineDefaultProperty(vm, "trim", objectValue(newFunctionObject(string_prototype_trim, nil)), 0)
+	vm.stringProto.defineDefaultProperty(vm, "trimStart", objectValue(newFunctionObject(string_prototype_trimStart, nil)), 0)
+	vm.stringProto.defineDefaultProperty(vm, "trimEnd", objectValue(newFunctionObject(string_prototype_trimEnd, nil)), 0)
+	vm.stringProto.defineDefaultProperty(vm, "padStart", objectValue(newFunctionObject(string_prototype_padStart, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "padEnd", objectValue(newFunctionObject(string_prototype_padEnd, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "repeat", objectValue(newFunctionObject(string_prototype_repeat, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "startsWith", objectValue(newFunctionObject(string_prototype_startsWith, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "endsWith", objectValue(newFunctionObject(string_prototype_endsWith, nil)), 1)
+	vm.stringProto.defineDefaultProperty(vm, "includes", objectValue(newFunctionObject(string_prototype_includes, nil)), 1)
 
 	stringO := newFunctionObject(string_call, string_ctor)
-	stringO.prototype = &stringProto
+	stringO.defineDefaultProperty(vm, "fromCharCode", objectValue(newFunctionObject(string_fromCharCode, nil)), 1)
+	stringO.defineDefaultProperty(vm, "raw", objectValue(newFunctionObject(string_raw, nil)), 1)
+	stringO.prototype = &vm.stringProto
 
-	stringProto.defineDefaultProperty(vm, "constructor", stringO, 0)
+	vm.stringProto.defineDefaultProperty(vm, "constructor", objectValue(stringO), 0)
 
-	return stringO
+	return objectValue(stringO)
 }
 
 func string_call(vm *vm, f value, args []value) value {
@@ -150,18 +247,55 @@ func string_call(vm *vm, f value, args []value) value {
 
 func string_ctor(vm *vm, f value, args []value) value {
 	if len(args) > 0 {
-		return newStringObject(args[0].ToString().String())
+		return objectValue(newStringObject(args[0].ToString().String()))
 	} else {
-		return newStringObject("")
+		return objectValue(newStringObject(""))
 	}
 }
 
+// ES5 15.5.3.2
+func string_fromCharCode(vm *vm, f value, args []value) value {
+	units := make([]uint16, len(args))
+	for idx, arg := range args {
+		units[idx] = uint16(toUint32(arg.ToNumber()))
+	}
+
+	// Surrogate pairs are combined; a lone surrogate can't be represented in
+	// our strings, and becomes U+FFFD.
+	S := string(utf16.Decode(units))
+	vm.allocateString(len(S))
+	return newString(S)
+}
+
+// ES2015 21.1.2.4
+func string_raw(vm *vm, f value, args []value) value {
+	cooked := argument(args, 0).ToObject()
+	raw := cooked.get(vm, newString("raw")).ToObject()
+	literalSegments := int(toLength(raw.get(vm, newString("length")).ToNumber()))
+	substitutions := []value{}
+	if len(args) > 1 {
+		substitutions = args[1:]
+	}
+
+	S := ""
+	for nextIndex := 0; nextIndex < literalSegments; nextIndex++ {
+		S += raw.get(vm, newNumber(float64(nextIndex))).ToString().String()
+		if nextIndex+1 < literalSegments && nextIndex < len(substitutions) {
+			S += substitutions[nextIndex].ToString().String()
+		}
+	}
+
+	vm.allocateString(len(S))
+	return newString(S)
+}
+
 func string_prototype_toString(vm *vm, f value, args []value) value {
-	switch o := f.(type) {
-	case valueString:
-		return newString(f.ToString().String())
+	if f.isString() {
+		return f
+	}
+	switch o := f.obj.(type) {
 	case stringObject:
-		return newString(o.primitiveData.ToString().String())
+		return newString(o.primitiveData)
 	default:
 		panic(fmt.Sprintf("Not a string! %s", f)) // ### throw
 	}
@@ -169,39 +303,43 @@ func string_prototype_toString(vm *vm, f value, args []value) value {
 }
 
 func string_prototype_valueOf(vm *vm, f value, args []value) value {
-	switch o := f.(type) {
-	case valueString:
-		return newString(f.ToString().String())
+	if f.isString() {
+		return f
+	}
+	switch o := f.obj.(type) {
 	case stringObject:
-		return newString(o.primitiveData.ToString().String())
+		return newString(o.primitiveData)
 	default:
 		panic(fmt.Sprintf("Not a string! %s", f)) // ### throw
 	}
 	panic("unreachable")
 }
 
+// ES5 15.5.4.4
 func string_prototype_charAt(vm *vm, f value, args []value) value {
 	checkObjectCoercible(vm, f)
 	S := f.ToString().String()
-	pos := args[0].ToInteger()
-	size := len(S)
-	if pos < 0 || pos >= size {
+	pos := toInteger(argument(args, 0).ToNumber())
+	if pos < 0 || pos >= float64(stringLength(S)) {
 		return newString("")
 	}
 
-	return newString(string(S[pos]))
+	return newString(substring(S, int(pos), int(pos)+1))
 }
 
+// ES5 15.5.4.5
 func string_prototype_charCodeAt(vm *vm, f value, args []value) value {
 	checkObjectCoercible(vm, f)
 	S := f.ToString().String()
-	pos := args[0].ToInteger()
-	size := len(S)
-	if pos < 0 || pos >= size {
+	pos := toInteger(argument(args, 0).ToNumber())
+	if pos < 0 || pos >= float64(stringLength(S)) {
 		return newNumber(math.NaN())
 	}
 
-	return newNumber(float64(S[pos]))
+	if isASCII(S) {
+		return newNumber(float64(S[int(pos)]))
+	}
+	return newNumber(float64(stringUnits(S)[int(pos)]))
 }
 
 func string_prototype_concat(vm *vm, f value, args []value) value {
@@ -212,53 +350,251 @@ func string_prototype_concat(vm *vm, f value, args []value) value {
 		S += arg.ToString().String()
 	}
 
+	vm.allocateString(len(S))
 	return newString(S)
 }
 
+// ES5 15.5.4.7
 func string_prototype_indexOf(vm *vm, f value, args []value) value {
 	checkObjectCoercible(vm, f)
 	S := f.ToString().String()
-	searchStr := args[0].ToString().String()
-	pos := 0
-	if len(args) > 1 {
-		pos = args[1].ToInteger()
-	}
+	searchStr := argument(args, 0).ToString().String()
+	start := clampStringIndex(toInteger(argument(args, 1).ToNumber()), float64(stringLength(S)))
 
-	if pos > len(S) {
+	if isASCII(S) && isASCII(searchStr) {
+		if idx := strings.Index(S[start:], searchStr); idx >= 0 {
+			return newNumber(float64(idx + start))
+		}
 		return newNumber(-1)
 	}
-
-	return newNumber(float64(strings.Index(S[pos:], searchStr) + pos))
+	units, search := stringUnits(S), stringUnits(searchStr)
+	for k := start; k+len(search) <= len(units); k++ {
+		if unitsAt(units, search, k) {
+			return newNumber(float64(k))
+		}
+	}
+	return newNumber(-1)
 }
 
+// ES5 15.5.4.8
 func string_prototype_lastIndexOf(vm *vm, f value, args []value) value {
 	checkObjectCoercible(vm, f)
 	S := f.ToString().String()
-	searchStr := args[0].ToString().String()
-	pos := len(S)
-	if len(args) > 1 {
-		pos = args[1].ToInteger()
+	searchStr := argument(args, 0).ToString().String()
+	pos := math.Inf(+1)
+	if numPos := argument(args, 1).ToNumber(); !math.IsNaN(numPos) {
+		pos = toInteger(numPos)
 	}
 
-	if pos > len(S) {
-		return newNumber(-1)
+	units, search := stringUnits(S), stringUnits(searchStr)
+	start := clampStringIndex(pos, float64(len(units)))
+	for k := start; k >= 0; k-- {
+		if unitsAt(units, search, k) {
+			return newNumber(float64(k))
+		}
 	}
+	return newNumber(-1)
+}
+
+// ES5 15.5.4.9
+// ### no locale support, so this compares strings by their bytes.
+func string_prototype_localeCompare(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	That := argument(args, 0).ToString().String()
 
-	return newNumber(float64(strings.LastIndex(S[0:pos], searchStr)))
+	return newNumber(float64(strings.Compare(S, That)))
 }
 
-// ### localeCompare
 // ### match
-// ### replace
+
+// ES2015 21.1.3.14, for string patterns (as there is no RegExp).
+func string_prototype_replace(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	searchString := argument(args, 0).ToString().String()
+	replaceValue := argument(args, 1)
+	replaceFn, functionalReplace := replaceValue.obj.(functionObject)
+	replaceStr := ""
+	if !functionalReplace {
+		replaceStr = replaceValue.ToString().String()
+	}
+
+	pos := strings.Index(S, searchString)
+	if pos == -1 {
+		return newString(S)
+	}
+	tailPos := pos + len(searchString)
+
+	var replacement string
+	if functionalReplace {
+		replArgs := []value{newString(searchString), newNumber(float64(stringLength(S[:pos]))), newString(S)}
+		replacement = vm.callFunction(replaceFn, newUndefined(), replArgs).ToString().String()
+	} else {
+		replacement = getSubstitution(searchString, S, pos, tailPos, replaceStr)
+	}
+
+	vm.allocateString(len(S) - len(searchString) + len(replacement))
+	return newString(S[:pos] + replacement + S[tailPos:])
+}
+
+// ES2015 21.1.3.14.1
+// String patterns have no captures, so $n is left alone.
+func getSubstitution(matched string, str string, position int, tailPos int, replacement string) string {
+	result := ""
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		if c != '$' || i+1 == len(replacement) {
+			result += string(c)
+			continue
+		}
+
+		switch replacement[i+1] {
+		case '$':
+			result += "$"
+		case '&':
+			result += matched
+		case '`':
+			result += str[:position]
+		case '\'':
+			result += str[tailPos:]
+		default:
+			result += string(c)
+			continue
+		}
+		i++
+	}
+	return result
+}
+
 // ### search
-// ### slice
-// ### split
-// ### substring
+
+// relativeStringIndex resolves a start or end argument that counts from the
+// end of the string when negative, as slice and substr do.
+func relativeStringIndex(pos float64, size float64) int {
+	if pos < 0 {
+		return int(math.Max(size+pos, 0))
+	}
+	return int(math.Min(pos, size))
+}
+
+// clampStringIndex clamps a position to the bounds of the string.
+func clampStringIndex(pos float64, size float64) int {
+	return int(math.Min(math.Max(pos, 0), size))
+}
+
+// ES5 15.5.4.13
+func string_prototype_slice(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	size := float64(stringLength(S))
+	intStart := toInteger(argument(args, 0).ToNumber())
+	intEnd := size
+	if end := argument(args, 1); end != newUndefined() {
+		intEnd = toInteger(end.ToNumber())
+	}
+
+	from := relativeStringIndex(intStart, size)
+	to := relativeStringIndex(intEnd, size)
+	if from >= to {
+		return newString("")
+	}
+
+	return newString(substring(S, from, to))
+}
+
+// ES5 15.5.4.14
+func string_prototype_split(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	separator := argument(args, 0)
+	lim := uint32(math.MaxUint32)
+	if limit := argument(args, 1); limit != newUndefined() {
+		lim = toUint32(limit.ToNumber())
+	}
+	R := separator.ToString().String()
+
+	if lim == 0 {
+		return objectValue(newArrayObject([]value{}))
+	}
+	if separator == newUndefined() {
+		return objectValue(newArrayObject([]value{newString(S)}))
+	}
+	if len(S) == 0 {
+		if len(R) == 0 {
+			return objectValue(newArrayObject([]value{}))
+		}
+		return objectValue(newArrayObject([]value{newString(S)}))
+	}
+
+	var parts []string
+	if R == "" {
+		// into code units, rather than characters
+		units := stringUnits(S)
+		parts = make([]string, len(units))
+		for idx, u := range units {
+			parts[idx] = string(utf16.Decode([]uint16{u}))
+		}
+	} else {
+		parts = strings.Split(S, R)
+	}
+	if uint32(len(parts)) > lim {
+		parts = parts[:lim]
+	}
+
+	// the parts share S's memory, so only the array is new
+	vm.allocate(objectSize + int64(len(parts))*valueSize)
+	A := make([]value, len(parts))
+	for idx, part := range parts {
+		A[idx] = newString(part)
+	}
+	return objectValue(newArrayObject(A))
+}
+
+// ES5 B.2.3
+func string_prototype_substr(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	size := float64(stringLength(S))
+	start := relativeStringIndex(toInteger(argument(args, 0).ToNumber()), size)
+	length := math.Inf(+1)
+	if l := argument(args, 1); l != newUndefined() {
+		length = toInteger(l.ToNumber())
+	}
+
+	resultLength := math.Min(math.Max(length, 0), size-float64(start))
+	if resultLength <= 0 {
+		return newString("")
+	}
+
+	return newString(substring(S, start, start+int(resultLength)))
+}
+
+// ES5 15.5.4.15
+func string_prototype_substring(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	size := float64(stringLength(S))
+	intStart := toInteger(argument(args, 0).ToNumber())
+	intEnd := size
+	if end := argument(args, 1); end != newUndefined() {
+		intEnd = toInteger(end.ToNumber())
+	}
+
+	finalStart := clampStringIndex(intStart, size)
+	finalEnd := clampStringIndex(intEnd, size)
+	if finalStart > finalEnd {
+		finalStart, finalEnd = finalEnd, finalStart
+	}
+
+	return newString(substring(S, finalStart, finalEnd))
+}
 
 func string_prototype_toLowerCase(vm *vm, f value, args []value) value {
 	checkObjectCoercible(vm, f)
 	S := f.ToString().String()
 
+	vm.allocateString(len(S))
 	return newString(strings.ToLower(S))
 }
 
@@ -268,14 +604,138 @@ func string_prototype_toUpperCase(vm *vm, f value, args []value) value {
 	checkObjectCoercible(vm, f)
 	S := f.ToString().String()
 
+	vm.allocateString(len(S))
 	return newString(strings.ToUpper(S))
 }
 
 // ### toLocaleUpperCase
 
+// ES5 7.2 and 7.3: WhiteSpace and LineTerminator, which trimming removes.
+func isStringWhiteSpace(r rune) bool {
+	switch r {
+	case '\t', '\v', '\f', ' ', '\u00A0', '\uFEFF':
+		return true
+	case '\n', '\r', '\u2028', '\u2029':
+		return true
+	}
+	return unicode.Is(unicode.Zs, r)
+}
+
 func string_prototype_trim(vm *vm, f value, args []value) value {
 	checkObjectCoercible(vm, f)
 	S := f.ToString().String()
 
-	return newString(strings.Trim(S, "\n "))
+	return newString(strings.TrimFunc(S, isStringWhiteSpace))
+}
+
+func string_prototype_trimStart(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+
+	return newString(strings.TrimLeftFunc(S, isStringWhiteSpace))
+}
+
+func string_prototype_trimEnd(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+
+	return newString(strings.TrimRightFunc(S, isStringWhiteSpace))
+}
+
+// Strings longer than this throw a RangeError rather than being built.
+const maxStringLength = 1<<30 - 1
+
+// ES2017 21.1.3.13.1
+func stringPad(vm *vm, f value, args []value, atStart bool) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	intMaxLength := toLength(argument(args, 0).ToNumber())
+	length := float64(stringLength(S))
+	if intMaxLength <= length {
+		return newString(S)
+	}
+
+	filler := " "
+	if fillString := argument(args, 1); fillString != newUndefined() {
+		filler = fillString.ToString().String()
+	}
+	if filler == "" {
+		return newString(S)
+	}
+	if intMaxLength > maxStringLength {
+		return vm.ThrowRangeError("Invalid string length")
+	}
+
+	vm.allocateString(int(intMaxLength))
+	fillLen := int(intMaxLength - length)
+	fillerLen := stringLength(filler)
+	truncatedStringFiller := substring(strings.Repeat(filler, fillLen/fillerLen+1), 0, fillLen)
+	if atStart {
+		return newString(truncatedStringFiller + S)
+	}
+	return newString(S + truncatedStringFiller)
+}
+
+func string_prototype_padStart(vm *vm, f value, args []value) value {
+	return stringPad(vm, f, args, true)
+}
+
+func string_prototype_padEnd(vm *vm, f value, args []value) value {
+	return stringPad(vm, f, args, false)
+}
+
+// ES2015 21.1.3.13
+func string_prototype_repeat(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	n := toInteger(argument(args, 0).ToNumber())
+	if n < 0 || math.IsInf(n, +1) {
+		return vm.ThrowRangeError("Invalid count value")
+	}
+	if n == 0 || len(S) == 0 {
+		return newString("")
+	}
+	if n*float64(len(S)) > maxStringLength {
+		return vm.ThrowRangeError("Invalid string length")
+	}
+
+	vm.allocateString(int(n) * len(S))
+	return newString(strings.Repeat(S, int(n)))
+}
+
+// ES2015 21.1.3.18
+func string_prototype_startsWith(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	searchStr := argument(args, 0).ToString().String()
+	size := stringLength(S)
+	start := clampStringIndex(toInteger(argument(args, 1).ToNumber()), float64(size))
+
+	return newBool(strings.HasPrefix(substring(S, start, size), searchStr))
+}
+
+// ES2015 21.1.3.6
+func string_prototype_endsWith(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	searchStr := argument(args, 0).ToString().String()
+	size := float64(stringLength(S))
+	pos := size
+	if endPosition := argument(args, 1); endPosition != newUndefined() {
+		pos = toInteger(endPosition.ToNumber())
+	}
+	end := clampStringIndex(pos, size)
+
+	return newBool(strings.HasSuffix(substring(S, 0, end), searchStr))
+}
+
+// ES2015 21.1.3.7
+func string_prototype_includes(vm *vm, f value, args []value) value {
+	checkObjectCoercible(vm, f)
+	S := f.ToString().String()
+	searchStr := argument(args, 0).ToString().String()
+	size := stringLength(S)
+	start := clampStringIndex(toInteger(argument(args, 1).ToNumber()), float64(size))
+
+	return newBool(strings.Contains(substring(S, start, size), searchStr))
 }
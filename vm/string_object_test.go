@@ -27,6 +27,7 @@
 package vm
 
 import (
+	"github.com/stvp/assert"
 	"testing"
 )
 
@@ -164,3 +165,386 @@ func TestStringObject(t *testing.T) {
 
 	runSimpleVMTestHelper(t, tests)
 }
+
+func TestStringIndexAndLength(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return "hello"[1]`,
+			out: newString("e"),
+		},
+		simpleVMTest{
+			in:  `return "hello"["1"]`,
+			out: newString("e"),
+		},
+		simpleVMTest{
+			in:  `return "hello"["01"]`,
+			out: newUndefined(),
+		},
+		simpleVMTest{
+			in:  `var s = "hello"; var i = 4; return s[i]`,
+			out: newString("o"),
+		},
+		simpleVMTest{
+			in:  `return "hello".length`,
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  `return "".length`,
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  `var s = new String("hello"); return s.length`,
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  `var s = "hello"; return s["length"]`,
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  `var s = "hello"; return s.toUpperCase().length`,
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  `var s = "hello"; return s.toUpperCase().charAt(1)`,
+			out: newString("E"),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestStringSlicing(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return "hello".slice(1, 3)`,
+			out: newString("el"),
+		},
+		simpleVMTest{
+			in:  `return "hello".slice(-3, -1)`,
+			out: newString("ll"),
+		},
+		simpleVMTest{
+			in:  `return "hello".slice(2)`,
+			out: newString("llo"),
+		},
+		simpleVMTest{
+			in:  `return "hello".slice(3, 1)`,
+			out: newString(""),
+		},
+		simpleVMTest{
+			in:  `return "hello".substring(1, 3)`,
+			out: newString("el"),
+		},
+		simpleVMTest{
+			in:  `return "hello".substring(4, 1)`,
+			out: newString("ell"),
+		},
+		simpleVMTest{
+			in:  `return "hello".substring(-5, 99)`,
+			out: newString("hello"),
+		},
+		simpleVMTest{
+			in:  `return "hello".substring("1", "2")`,
+			out: newString("e"),
+		},
+		simpleVMTest{
+			in:  `return "hello".substr(1, 3)`,
+			out: newString("ell"),
+		},
+		simpleVMTest{
+			in:  `return "hello".substr(-3, 2)`,
+			out: newString("ll"),
+		},
+		simpleVMTest{
+			in:  `return "hello".substr(1)`,
+			out: newString("ello"),
+		},
+		simpleVMTest{
+			in:  `return "hello".substr(1, -1)`,
+			out: newString(""),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestStringSplit(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return "a,b,,c".split(",").join("|")`,
+			out: newString("a|b||c"),
+		},
+		simpleVMTest{
+			in:  `return "a,b,c".split(",", 2).join("|")`,
+			out: newString("a|b"),
+		},
+		simpleVMTest{
+			in:  `return "a,b,c".split(",", 0).length`,
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  `return "abc".split("").join("|")`,
+			out: newString("a|b|c"),
+		},
+		simpleVMTest{
+			in:  `return "a,b".split()[0]`,
+			out: newString("a,b"),
+		},
+		simpleVMTest{
+			in:  `return "".split("").length`,
+			out: newNumber(0),
+		},
+		simpleVMTest{
+			in:  `return "".split(",").length`,
+			out: newNumber(1),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestStringReplace(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return "abab".replace("b", "x")`,
+			out: newString("axab"),
+		},
+		simpleVMTest{
+			in:  `return "abc".replace("z", "x")`,
+			out: newString("abc"),
+		},
+		simpleVMTest{
+			in:  `return "abc".replace("b")`,
+			out: newString("aundefinedc"),
+		},
+		simpleVMTest{
+			in:  "return \"aXbX\".replace(\"X\", \"[$&|$`|$'|$$|$1|$]\")",
+			out: newString("a[X|a|bX|$|$1|$]bX"),
+		},
+		simpleVMTest{
+			in:  `function up(m) { return m.toUpperCase() }; return "abcb".replace("b", up)`,
+			out: newString("aBcb"),
+		},
+		simpleVMTest{
+			in:  `function rest(m, p, s) { return s.substring(p) }; return "abc".replace("b", rest)`,
+			out: newString("abcc"),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestStringPaddingAndTrimming(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return "  hi  ".trim()`,
+			out: newString("hi"),
+		},
+		simpleVMTest{
+			in:  `return "  hi  ".trimStart()`,
+			out: newString("hi  "),
+		},
+		simpleVMTest{
+			in:  `return "  hi  ".trimEnd()`,
+			out: newString("  hi"),
+		},
+		simpleVMTest{
+			in:  `return "5".padStart(3, "0")`,
+			out: newString("005"),
+		},
+		simpleVMTest{
+			in:  `return "abc".padStart(6)`,
+			out: newString("   abc"),
+		},
+		simpleVMTest{
+			in:  `return "abc".padEnd(8, "12")`,
+			out: newString("abc12121"),
+		},
+		simpleVMTest{
+			in:  `return "abc".padEnd(2, "12")`,
+			out: newString("abc"),
+		},
+		simpleVMTest{
+			in:  `return "abc".padEnd(6, "")`,
+			out: newString("abc"),
+		},
+		simpleVMTest{
+			in:  `return "ab".repeat(3)`,
+			out: newString("ababab"),
+		},
+		simpleVMTest{
+			in:  `return "ab".repeat(0)`,
+			out: newString(""),
+		},
+		simpleVMTest{
+			in:  `return "ab".repeat(2.9)`,
+			out: newString("abab"),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+
+	// The lexer has no escapes, so check the less common whitespace here.
+	vm := New("")
+	ws := newString("\u00a0\t\u2028x\u3000\ufeff\n")
+	assert.Equal(t, string_prototype_trim(vm, ws, nil), newString("x"))
+	assert.Equal(t, string_prototype_trimStart(vm, ws, nil), newString("x\u3000\ufeff\n"))
+	assert.Equal(t, string_prototype_trimEnd(vm, ws, nil), newString("\u00a0\t\u2028x"))
+}
+
+func TestStringSearching(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return "hello".startsWith("he")`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "hello".startsWith("ell", 1)`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "hello".startsWith("he", 1)`,
+			out: newBool(false),
+		},
+		simpleVMTest{
+			in:  `return "hello".endsWith("lo")`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "hello".endsWith("hel", 3)`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "hello".endsWith("lo", 99)`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "hello".includes("ll")`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "hello".includes("h", 1)`,
+			out: newBool(false),
+		},
+		simpleVMTest{
+			in:  `return "hello".includes("")`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "a".localeCompare("b")`,
+			out: newNumber(-1),
+		},
+		simpleVMTest{
+			in:  `return "b".localeCompare("a")`,
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  `return "a".localeCompare("a")`,
+			out: newNumber(0),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+// Numbers given where strings are wanted are converted as ToString does.
+func TestStringNumberArguments(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return "a".padStart(5, 0)`,
+			out: newString("0000a"),
+		},
+		simpleVMTest{
+			in:  `return "a".padEnd(4, 1.5)`,
+			out: newString("a1.5"),
+		},
+		simpleVMTest{
+			in:  `return "a1b".split(1).join("|")`,
+			out: newString("a|b"),
+		},
+		simpleVMTest{
+			in:  `return "x0.25y".indexOf(0.25)`,
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  `return "a".concat(1, -2.5, Math.pow(10, 21), 1 / 10000000)`,
+			out: newString("a1-2.51e+211e-7"),
+		},
+		simpleVMTest{
+			in:  `return "10".includes(10) && "-0".indexOf(-0) == 1 && "NaN".startsWith(0/0)`,
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  `return "a-b".replace("-", 3)`,
+			out: newString("a3b"),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+// Strings are indexed in UTF-16 code units, whatever they hold.
+func TestStringCodeUnits(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{in: `return "é"[0]`, out: newString("é")},
+		simpleVMTest{in: `return "é".length`, out: newNumber(1)},
+		simpleVMTest{in: `return "😀".length`, out: newNumber(2)},
+		simpleVMTest{in: `return String.fromCharCode(0xe9).charCodeAt(0)`, out: newNumber(0xe9)},
+		simpleVMTest{in: `return "😀".charCodeAt(0) + "," + "😀".charCodeAt(1)`, out: newString("55357,56832")},
+		simpleVMTest{in: `return "héllo".charAt(1)`, out: newString("é")},
+		simpleVMTest{in: `return "héllo".substring(2)`, out: newString("llo")},
+		simpleVMTest{in: `return "héllo".slice(1, 3)`, out: newString("él")},
+		simpleVMTest{in: `return "héllo".substr(-3, 2)`, out: newString("ll")},
+		simpleVMTest{in: `return "aé".padStart(4, "é")`, out: newString("ééaé")},
+		simpleVMTest{in: `return "aé".padEnd(3, "😀")`, out: newString("aé\uFFFD")},
+		simpleVMTest{in: `return "😀".slice(0, 1)`, out: newString("\uFFFD")},
+		simpleVMTest{in: `return "héllo".indexOf("l") + "," + "héllo".lastIndexOf("l") + "," + "😀x".indexOf("x")`, out: newString("2,3,2")},
+		simpleVMTest{in: `return "é😀".split("").length`, out: newNumber(3)},
+		simpleVMTest{in: `return "héllo".startsWith("llo", 2) && "héllo".endsWith("hé", 2) && "héllo".includes("é", 1)`, out: newBool(true)},
+		simpleVMTest{in: `return "héllo".replace("l", function(m, pos) { return pos })`, out: newString("hé2lo")},
+
+		// and searching clamps positions as the spec says
+		simpleVMTest{in: `return "abc".indexOf("", 5) + "," + "abc".indexOf("a", -1)`, out: newString("3,0")},
+		simpleVMTest{in: `return "canal".lastIndexOf("a", 0) + "," + "canal".lastIndexOf("a", 2) + "," + "canal".lastIndexOf("")`, out: newString("-1,1,5")},
+	}
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestStringConstructorFunctions(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  `return String.fromCharCode(72, 105)`,
+			out: newString("Hi"),
+		},
+		simpleVMTest{
+			in:  `return String.fromCharCode(65601)`,
+			out: newString("A"),
+		},
+		simpleVMTest{
+			in:  `return String.fromCharCode(233)`,
+			out: newString("\u00e9"),
+		},
+		simpleVMTest{
+			in:  `return String.fromCharCode(55357, 56832)`,
+			out: newString("\U0001F600"),
+		},
+		simpleVMTest{
+			in:  `return String.fromCharCode()`,
+			out: newString(""),
+		},
+		simpleVMTest{
+			in:  `return String.raw({raw: ["a", "b", "c"]}, "1", "2", "3")`,
+			out: newString("a1b2c"),
+		},
+		simpleVMTest{
+			in:  `return String.raw({raw: "xyz"}, "-")`,
+			out: newString("x-yz"),
+		},
+		simpleVMTest{
+			in:  `return String.raw({raw: []})`,
+			out: newString(""),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
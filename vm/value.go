@@ -27,160 +27,128 @@
 package vm
 
 import (
-	"fmt"
 	"math"
-	"reflect"
 	"strconv"
+	"strings"
 )
 
 /////////////////////////////////
 // constructors
 /////////////////////////////////
 
-func newUndefined() valueUndefined {
-	return valueUndefined{}
+func newUndefined() value {
+	return value{}
 }
 
-func newNull() valueNull {
-	return valueNull{}
+func newNull() value {
+	return value{kind: kindNull}
 }
 
 func newBool(val bool) value {
 	if val {
-		return valueBool(true)
+		return value{kind: kindBool, num: 1}
 	} else {
-		return valueBool(false)
+		return value{kind: kindBool}
 	}
 }
 
-func newNumber(val float64) valueNumber {
-	return valueNumber(val)
+func newNumber(val float64) value {
+	return value{kind: kindNumber, num: val}
 }
 
-func newString(val string) valueString {
-	return valueString(val)
+func newString(val string) value {
+	return value{kind: kindString, str: val}
+}
+
+func objectValue(o valueObject) value {
+	return value{kind: kindObject, obj: o}
 }
 
 /////////////////////////////////
 // type definitions
 /////////////////////////////////
 
-// value represents an abstraction of a JavaScript value.
-type value interface {
-	ToInteger() int
-	ToNumber() float64
-	ToBoolean() bool
-	ToString() valueString
-	ToObject() valueObject
-	hasPrimitiveBase() bool
-	String() string
+type valueKind uint8
+
+const (
+	kindUndefined valueKind = iota
+	kindNull
+	kindBool
+	kindNumber
+	kindString
+	kindObject
+
+	// a missing element, only ever found inside an array, see valueArrayData
+	kindHole
+)
+
+// value represents a JavaScript value.
+//
+// Only objects live on the heap; everything else is kept in the value itself,
+// so that working with them doesn't allocate. The zero value is undefined.
+type value struct {
+	kind valueKind
+	num  float64 // numbers, and bools (as 0 or 1)
+	str  string
+	obj  valueObject
+}
+
+func (this value) isUndefined() bool {
+	return this.kind == kindUndefined
+}
+
+func (this value) isNull() bool {
+	return this.kind == kindNull
+}
+
+func (this value) isBool() bool {
+	return this.kind == kindBool
+}
+
+func (this value) isNumber() bool {
+	return this.kind == kindNumber
+}
+
+func (this value) isString() bool {
+	return this.kind == kindString
+}
+
+func (this value) isObject() bool {
+	return this.kind == kindObject
 }
 
 // ES5 11.9.3
 func abstractEqualityComparison(x, y value) bool {
-	xt := reflect.TypeOf(x)
-	yt := reflect.TypeOf(y)
-
-	if xt == yt {
-		switch x.(type) {
-		case valueUndefined:
-			return true
-		case valueNull:
-			return true
-		case valueNumber:
-			xn := x.ToNumber()
-			yn := y.ToNumber()
-			if math.IsNaN(xn) {
-				return false
-			}
-			if math.IsNaN(yn) {
-				return false
-			}
-			if xn == yn {
-				return true
-			}
-			if xn == +0 && yn == -0 {
-				return true
-			} else if xn == -0 && yn == +0 {
-				return true
-			}
-			return false
-		case valueString:
-			return x.ToString() == y.ToString()
-		case valueBool:
-			xb := x.ToBoolean()
-			yb := y.ToBoolean()
-			if xb && yb || !xb && !yb {
-				return true
-			} else {
-				return false
-			}
-		case valueBasicObject:
-			if x.(valueBasicObject).odata == y.(valueBasicObject).odata {
-				return true
-			} else {
-				return false
-			}
-		}
-		return false
+	if x.kind == y.kind {
+		return strictEqualityComparison(x, y)
 	}
 
-	switch x.(type) {
-	case valueNull:
-		switch y.(type) {
-		case valueUndefined:
-			return true
-		}
-	case valueUndefined:
-		switch y.(type) {
-		case valueNull:
-			return true
-		}
+	if x.isNull() && y.isUndefined() {
+		return true
+	}
+	if x.isUndefined() && y.isNull() {
+		return true
 	}
 
-	switch x.(type) {
-	case valueNumber:
-		switch y.(type) {
-		case valueString:
-			return x.ToNumber() == y.ToNumber() // ### probably need to handle +/- 0 as above
-		}
-	case valueString:
-		switch y.(type) {
-		case valueNumber:
-			return x.ToNumber() == y.ToNumber() // ### probably need to handle +/- 0 as above
-		}
+	if x.isNumber() && y.isString() {
+		return x.ToNumber() == y.ToNumber() // ### probably need to handle +/- 0 as above
+	}
+	if x.isString() && y.isNumber() {
+		return x.ToNumber() == y.ToNumber() // ### probably need to handle +/- 0 as above
 	}
 
-	switch x.(type) {
-	case valueBool:
+	if x.isBool() {
 		return x.ToNumber() == y.ToNumber()
 	}
-
-	switch y.(type) {
-	case valueBool:
+	if y.isBool() {
 		return x.ToNumber() == y.ToNumber()
 	}
 
-	switch x.(type) {
-	case valueString:
-		switch y.(type) {
-		case valueBasicObject:
-			return valueToPrimitive(x) == valueToPrimitive(y)
-		}
-	case valueNumber:
-		switch y.(type) {
-		case valueBasicObject:
-			return valueToPrimitive(x) == valueToPrimitive(y)
-		}
+	if (x.isString() || x.isNumber()) && y.isObject() {
+		return valueToPrimitive(x) == valueToPrimitive(y)
 	}
-	switch x.(type) {
-	case valueBasicObject:
-		switch y.(type) {
-		case valueString:
-			return valueToPrimitive(x) == valueToPrimitive(y)
-		case valueNumber:
-			return valueToPrimitive(x) == valueToPrimitive(y)
-		}
+	if x.isObject() && (y.isString() || y.isNumber()) {
+		return valueToPrimitive(x) == valueToPrimitive(y)
 	}
 
 	return false
@@ -188,53 +156,25 @@ func abstractEqualityComparison(x, y value) bool {
 
 // ES5 11.9.6
 func strictEqualityComparison(x, y value) bool {
-	xt := reflect.TypeOf(x)
-	yt := reflect.TypeOf(y)
-
-	if xt != yt {
+	if x.kind != y.kind {
 		return false
 	}
 
-	switch x.(type) {
-	case valueUndefined:
+	switch x.kind {
+	case kindUndefined:
 		return true
-	case valueNull:
+	case kindNull:
 		return true
-	case valueNumber:
-		xn := x.ToNumber()
-		yn := y.ToNumber()
-		if math.IsNaN(xn) {
-			return false
-		}
-		if math.IsNaN(yn) {
-			return false
-		}
-		if xn == yn {
-			return true
-		}
-		if xn == +0 && yn == -0 {
-			return true
-		} else if xn == -0 && yn == +0 {
-			return true
-		}
-
-		return false
-	case valueString:
-		return x.ToString() == y.ToString()
-	case valueBool:
-		xb := x.ToBoolean()
-		yb := y.ToBoolean()
-		if xb && yb || !xb && !yb {
-			return true
-		} else {
-			return false
-		}
-	case valueBasicObject:
-		if x.(valueBasicObject).odata == y.(valueBasicObject).odata {
-			return true
-		} else {
-			return false
-		}
+	case kindNumber:
+		// NaN is unequal to everything, and +0 and -0 are equal, just as
+		// they are for Go.
+		return x.num == y.num
+	case kindString:
+		return x.str == y.str
+	case kindBool:
+		return x.num == y.num
+	case kindObject:
+		return x.obj.objectData() == y.obj.objectData()
 	}
 
 	return false
@@ -242,182 +182,141 @@ func strictEqualityComparison(x, y value) bool {
 
 /////////////////////////////////
 
-type valueUndefined struct {
-}
-
-func (this valueUndefined) ToInteger() int {
-	return 0
-}
-func (this valueUndefined) ToNumber() float64 {
-	return math.NaN()
-}
-func (this valueUndefined) ToBoolean() bool {
-	return false
-}
-func (this valueUndefined) ToString() valueString {
-	return "undefined"
-}
-func (this valueUndefined) ToObject() valueObject {
-	panic("TypeError") // ### can't ThrowTypeError as we have no vm
-}
-func (this valueUndefined) hasPrimitiveBase() bool {
-	return false
-}
-func (this valueUndefined) String() string {
-	return this.ToString().String()
-}
-
-/////////////////////////////////
-
-type valueNull struct {
-}
-
-func (this valueNull) ToInteger() int {
-	return 0
-}
-func (this valueNull) ToNumber() float64 {
-	return +0
-}
-func (this valueNull) ToBoolean() bool {
-	return false
-}
-func (this valueNull) ToString() valueString {
-	return "null"
-}
-func (this valueNull) ToObject() valueObject {
-	panic("TypeError") // ### can't ThrowTypeError as we have no vm
-}
-func (this valueNull) hasPrimitiveBase() bool {
-	return false
-}
-func (this valueNull) String() string {
-	return this.ToString().String()
-}
-
-/////////////////////////////////
-
-type valueBool bool
-
-func (this valueBool) ToInteger() int {
-	if this {
-		return 1
-	} else {
+func (this value) ToInteger() int {
+	switch this.kind {
+	case kindUndefined, kindNull:
 		return 0
+	case kindBool:
+		return int(this.num)
+	case kindNumber:
+		tf := this.num
+		if math.IsNaN(tf) {
+			return +0
+		}
+		if int(tf) == 0 || math.IsInf(tf, 0) {
+			return int(tf)
+		}
+
+		if tf > 0 {
+			return int(1 * math.Floor(math.Abs(tf)))
+		} else {
+			return int(-1 * math.Floor(math.Abs(tf)))
+		}
+	case kindString:
+		panic("not implemented")
 	}
+	return this.obj.ToInteger()
 }
 
-func (this valueBool) ToNumber() float64 {
-	if this {
-		return 1
-	} else {
+func (this value) ToNumber() float64 {
+	switch this.kind {
+	case kindUndefined:
+		return math.NaN()
+	case kindNull:
 		return +0
+	case kindBool, kindNumber:
+		return this.num
+	case kindString:
+		// ### toNumber(string) not implemented (es5 9.3.1)
+		v, _ := strconv.ParseFloat(this.str, 64)
+		return v
 	}
+	return this.obj.ToNumber()
 }
 
-func (this valueBool) ToBoolean() bool {
-	return bool(this)
-}
-
-func (this valueBool) ToString() valueString {
-	if this {
-		return "true"
-	} else {
-		return "false"
+func (this value) ToBoolean() bool {
+	switch this.kind {
+	case kindUndefined, kindNull:
+		return false
+	case kindBool:
+		return this.num != 0
+	case kindNumber:
+		if int(this.num) == 0 || math.IsNaN(this.num) {
+			return false
+		} else {
+			return true
+		}
+	case kindString:
+		return len(this.str) > 0
 	}
-}
-
-func (this valueBool) ToObject() valueObject {
-	return newBooleanObject(bool(this))
-}
-
-func (this valueBool) hasPrimitiveBase() bool {
-	return true
-}
-
-func (this valueBool) String() string {
-	return this.ToString().String()
-}
-
-/////////////////////////////////
-
-type valueString string
-
-func (this valueString) ToInteger() int {
-	panic("not implemented")
-}
-
-func (this valueString) ToNumber() float64 {
-	// ### toNumber(string) not implemented (es5 9.3.1)
-	v, _ := strconv.ParseFloat(this.String(), 64)
-	return v
-}
-
-func (this valueString) ToBoolean() bool {
-	return len(this) > 0
-}
-
-func (this valueString) ToString() valueString {
-	return this
-}
-
-func (this valueString) ToObject() valueObject {
-	return newStringObject(this.String())
-}
-
-func (this valueString) hasPrimitiveBase() bool {
-	return true
-}
-
-func (this valueString) String() string {
-	return string(this)
-}
-
-/////////////////////////////////
-
-type valueNumber float64
-
-func (this valueNumber) ToInteger() int {
-	tf := float64(this)
-	if math.IsNaN(tf) {
-		return +0
+	return this.obj.ToBoolean()
+}
+
+func (this value) ToString() value {
+	switch this.kind {
+	case kindUndefined:
+		return newString("undefined")
+	case kindNull:
+		return newString("null")
+	case kindBool:
+		if this.num != 0 {
+			return newString("true")
+		} else {
+			return newString("false")
+		}
+	case kindNumber:
+		return newString(numberToString(this.num))
+	case kindString:
+		return this
 	}
-	if int(tf) == 0 || math.IsInf(tf, 0) {
-		return int(tf)
+	return this.obj.ToString()
+}
+
+// numberToString is ToString for numbers (ES5 9.8.1): as short as it can be
+// while reading back as the same number, in exponent notation if it's very
+// big or small.
+func numberToString(n float64) string {
+	switch {
+	case math.IsNaN(n):
+		return "NaN"
+	case math.IsInf(n, 1):
+		return "Infinity"
+	case math.IsInf(n, -1):
+		return "-Infinity"
+	case n == 0:
+		return "0" // -0 too
 	}
-
-	if tf > 0 {
-		return int(1 * math.Floor(math.Abs(tf)))
-	} else {
-		return int(-1 * math.Floor(math.Abs(tf)))
+	if abs := math.Abs(n); abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(n, 'f', -1, 64)
 	}
+	s := strconv.FormatFloat(n, 'g', -1, 64)
+	// Go writes 1e-07 where JavaScript writes 1e-7
+	if idx := strings.IndexByte(s, 'e'); idx >= 0 {
+		exp := strings.TrimLeft(s[idx+2:], "0")
+		s = s[:idx+2] + exp
+	}
+	return s
+}
+
+func (this value) ToObject() valueObject {
+	switch this.kind {
+	case kindUndefined, kindNull:
+		panic("TypeError") // ### can't ThrowTypeError as we have no vm
+	case kindBool:
+		return newBooleanObject(this.num != 0)
+	case kindNumber:
+		return newNumberObject(this.num)
+	case kindString:
+		return newStringObject(this.str)
+	}
+	return this.obj
 }
 
-func (this valueNumber) ToNumber() float64 {
-	return float64(this)
-}
-
-func (this valueNumber) ToBoolean() bool {
-	if int(this) == 0 || math.IsNaN(float64(this)) {
+func (this value) hasPrimitiveBase() bool {
+	switch this.kind {
+	case kindUndefined, kindNull:
 		return false
-	} else {
+	case kindBool, kindNumber, kindString:
 		return true
 	}
+	return this.obj.hasPrimitiveBase()
 }
 
-func (this valueNumber) ToString() valueString {
-	return newString(fmt.Sprintf("%f", float64(this)))
-}
-
-func (this valueNumber) ToObject() valueObject {
-	return newNumberObject(float64(this))
-}
-
-func (this valueNumber) hasPrimitiveBase() bool {
-	return true
-}
-
-func (this valueNumber) String() string {
-	return this.ToString().String()
+func (this value) String() string {
+	if this.kind == kindObject {
+		return this.obj.String()
+	}
+	return this.ToString().str
 }
 
 /////////////////////////////////
@@ -434,8 +333,8 @@ func (this valueBasicObject) ToBoolean() bool {
 	return true
 }
 
-func (this valueBasicObject) ToString() valueString {
-	return "[object]"
+func (this valueBasicObject) ToString() value {
+	return newString("[object]")
 }
 
 func (this valueBasicObject) ToObject() valueObject {
@@ -454,32 +353,39 @@ func (this valueBasicObject) String() string {
 //////////////////////////////////////
 
 func checkObjectCoercible(vm *vm, v value) {
-	switch v.(type) {
-	case valueUndefined:
+	if v.isUndefined() || v.isNull() {
 		vm.ThrowTypeError("")
-	case valueNull:
-		vm.ThrowTypeError("")
-	case valueBool:
-	case valueNumber:
-	case valueString:
-	case valueObject:
 	}
 }
 
+// ES5 9.4, without the truncation to a Go int that value.ToInteger does.
+func toInteger(n float64) float64 {
+	if math.IsNaN(n) {
+		return +0
+	}
+	return math.Trunc(n)
+}
+
+// ES2015 7.1.15
+func toLength(n float64) float64 {
+	n = toInteger(n)
+	if n <= 0 {
+		return +0
+	}
+	return math.Min(n, 1<<53-1)
+}
+
+// ES5 9.6
+func toUint32(n float64) uint32 {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0
+	}
+	return uint32(int64(math.Mod(math.Trunc(n), 4294967296)))
+}
+
 func valueToPrimitive(v value) value {
-	switch v.(type) {
-	case valueUndefined:
-		return v
-	case valueNull:
-		return v
-	case valueBool:
-		return v
-	case valueNumber:
-		return v
-	case valueString:
-		return v
-	case valueObject:
+	if v.isObject() {
 		panic("object conversion not implemented")
 	}
-	panic("unreachable")
+	return v
 }
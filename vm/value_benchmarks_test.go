@@ -30,40 +30,97 @@ import (
 	"testing"
 )
 
+// sink keeps the compiler from optimizing away the values being benchmarked.
+var sink value
+
 func BenchmarkSimpleTypes(b *testing.B) {
 	b.Run("undefined", func(b *testing.B) {
+		b.ReportAllocs()
 		for i := 0; i < b.N; i++ {
-			newUndefined()
+			sink = newUndefined()
 		}
 	})
 	b.Run("null", func(b *testing.B) {
+		b.ReportAllocs()
 		for i := 0; i < b.N; i++ {
-			newNull()
+			sink = newNull()
 		}
 	})
 	b.Run("bool", func(b *testing.B) {
+		b.ReportAllocs()
 		for i := 0; i < b.N; i++ {
-			newBool(true)
+			sink = newBool(true)
 		}
 	})
 	b.Run("number", func(b *testing.B) {
+		b.ReportAllocs()
 		for i := 0; i < b.N; i++ {
-			newNumber(0)
+			sink = newNumber(float64(i))
 		}
 	})
 	b.Run("string_empty", func(b *testing.B) {
+		b.ReportAllocs()
 		for i := 0; i < b.N; i++ {
-			newString("")
+			sink = newString("")
 		}
 	})
 	b.Run("string_1c", func(b *testing.B) {
+		b.ReportAllocs()
 		for i := 0; i < b.N; i++ {
-			newString("a")
+			sink = newString("a")
 		}
 	})
 	b.Run("string_5c", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sink = newString("hello")
+		}
+	})
+}
+
+// Operations on primitives shouldn't need the heap at all.
+func BenchmarkPrimitiveOperations(b *testing.B) {
+	b.Run("add_numbers", func(b *testing.B) {
+		b.ReportAllocs()
+		v := newNumber(0)
+		for i := 0; i < b.N; i++ {
+			v = addValues(v, newNumber(1))
+		}
+		sink = v
+	})
+	b.Run("to_number", func(b *testing.B) {
+		b.ReportAllocs()
+		vals := []value{newUndefined(), newNull(), newBool(true), newNumber(1)}
+		for i := 0; i < b.N; i++ {
+			sink = newNumber(vals[i%len(vals)].ToNumber())
+		}
+	})
+	b.Run("to_boolean", func(b *testing.B) {
+		b.ReportAllocs()
+		vals := []value{newUndefined(), newNull(), newNumber(1), newString("a")}
+		for i := 0; i < b.N; i++ {
+			sink = newBool(vals[i%len(vals)].ToBoolean())
+		}
+	})
+	b.Run("strict_equality", func(b *testing.B) {
+		b.ReportAllocs()
+		lhs, rhs := newNumber(1), newNumber(2)
+		for i := 0; i < b.N; i++ {
+			sink = newBool(strictEqualityComparison(lhs, rhs))
+		}
+	})
+	b.Run("abstract_equality", func(b *testing.B) {
+		b.ReportAllocs()
+		lhs, rhs := newNull(), newUndefined()
+		for i := 0; i < b.N; i++ {
+			sink = newBool(abstractEqualityComparison(lhs, rhs))
+		}
+	})
+	b.Run("typeof", func(b *testing.B) {
+		b.ReportAllocs()
+		v := newNumber(1)
 		for i := 0; i < b.N; i++ {
-			newString("hello")
+			sink = typeOf(v)
 		}
 	})
 }
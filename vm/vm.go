@@ -27,37 +27,42 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 	"github.com/CrimsonAS/v2/parser"
 	"log"
 	"math"
+	"strconv"
 )
 
 type stackFrame struct {
-	retAddr int
-	vars    []int
-	// ### ideally we would reserve space for these inside data_stack
-	varValues   []value
-	temporaries []value
-	outer       *stackFrame
-	thisArg     value
+	retAddr   int
+	registers []value // see opcode
+	outer     *stackFrame
+	thisArg   value
+	strict    bool
+
+	// the register of the calling frame that the return value goes to, or
+	// -1 if the caller takes it from vm.returnValue
+	returnRegister operand
 }
 
-var stringtable []string
-
-func appendStringtable(name string) int {
-	for idx, str := range stringtable {
+// appendStringtable returns the index of name in the string table, adding it
+// if it isn't there yet.
+func (this *vm) appendStringtable(name string) int {
+	for idx, str := range this.strings {
 		if name == str {
 			return idx
 		}
 	}
-	stringtable = append(stringtable, name)
-	return len(stringtable) - 1
+	this.strings = append(this.strings, name)
+	return len(this.strings) - 1
 }
 
 type vm struct {
-	data_stack    stack
-	stack         []stackFrame
+	args          stack // arguments for the next CALL, NEW or NEW_ARRAY
+	stack         frameStack
+	maxCallDepth  int
 	currentFrame  *stackFrame
 	code          []opcode
 	ip            int
@@ -66,131 +71,151 @@ type vm struct {
 	ignoreReturn  bool
 	isNew         int
 	canConsume    int
-	lastLoadedVar value
+	clock         Clock
+	random        RandomSource
+	console       consoleState
+	globalObject  valueBasicObject
+	functions     []value       // JavaScript functions, see scope
+	constants     []value       // see operand
+	strings       []string      // names of globals and members
+	lines         []lineEntry   // where in the source the code came from
+	caches        []inlineCache // of LOAD_MEMBER and STORE_MEMBER, see shape
+	rootShape     *shape        // that this vm's shapes grow from, see emptyShape
+	name          string        // of the program
+	limits        limits
+	memory        memory
+
+	objectProto  valueBasicObject
+	arrayProto   valueBasicObject
+	stringProto  valueBasicObject
+	booleanProto valueBasicObject
+	numberProto  valueBasicObject
+	dateProto    valueBasicObject
 
 	// from codegen
-	temporaryIndex int
+	temporaryIndex  int
+	scopes          map[*parser.FunctionExpression]*scope
+	programScope    *scope
+	currentScope    *scope
+	constantIndexes map[interface{}]int
+	compiled        []compiledFunction
+	// whether the program returns the value of its last statement, if that
+	// is an expression, see Eval
+	returnCompletion bool
 }
 
 const lookupDebug = false
 
+// Variables that aren't local to a function (see scope) are properties of the
+// global object, and are looked up by name.
+func (this *vm) globalKey(name int) value {
+	return newString(this.strings[name])
+}
+
 func (this *vm) setVar(name int, nv value) bool {
 	if execDebug {
-		log.Printf("Storing %s in %s", nv, stringtable[name])
-	}
-	sf := this.currentFrame
-	for sf != nil {
-		for idx, sfvar := range sf.vars {
-			if sfvar == name {
-				//log.Printf("Set var %d to %+v", name, nv)
-				sf.varValues[idx] = nv
-				return true
-			}
-		}
-		sf = sf.outer
+		log.Printf("Storing %s in %s", nv, this.strings[name])
 	}
-	return false
+	key := this.globalKey(name)
+	if this.globalObject.getProperty(this, key) == nil {
+		return false
+	}
+	this.globalObject.put(this, key, nv, this.currentFrame.strict)
+	return true
 }
 
 func (this *vm) findVar(name int) (value, bool) {
-	sf := this.currentFrame
-	for sf != nil {
-		for idx, sfvar := range sf.vars {
-			if sfvar == name {
-				if execDebug {
-					log.Printf("Loading %s gave %s", stringtable[name], sf.varValues[idx])
-				}
-				return sf.varValues[idx], true
-			}
+	key := this.globalKey(name)
+	pd := this.globalObject.getProperty(this, key)
+	if pd == nil {
+		if execDebug {
+			log.Printf("Loading %s was not found", this.strings[name])
 		}
-		sf = sf.outer
+		return newUndefined(), false
+	}
+
+	v := pd.value
+	if pd.isAccessorDescriptor() {
+		v = pd.get(this, objectValue(this.globalObject), key, pd)
 	}
 	if execDebug {
-		log.Printf("Loading %s was not found", stringtable[name])
+		log.Printf("Loading %s gave %s", this.strings[name], v)
 	}
-	return nil, false
+	return v, true
 }
 
+// Declared globals are enumerable, but can't be deleted (ES5 10.5).
 func (this *vm) defineVar(name int, v value) {
-	for _, sfvar := range this.currentFrame.vars {
-		if sfvar == name {
-			//panic(fmt.Sprintf("Var %s already defined", stringtable[name]))
-			return
-		}
+	key := this.globalKey(name)
+	if this.globalObject.getOwnProperty(this, key) != nil {
+		return
 	}
 
 	if execDebug {
-		log.Printf("Var %s declared", stringtable[name])
+		log.Printf("Var %s declared", this.strings[name])
 	}
-	this.currentFrame.vars = append(this.currentFrame.vars, name)
-	this.currentFrame.varValues = append(this.currentFrame.varValues, v)
+	pd := &propertyDescriptor{name: key.String(), value: v, hasValue: true, writable: true, hasWritable: true, enumerable: true, hasEnumerable: true, configurable: false, hasConfigurable: true}
+	this.globalObject.defineOwnProperty(this, key, pd, true)
 }
 
-func makeStackFrame(thisArg value, returnAddr int, outer *stackFrame) stackFrame {
-	return stackFrame{retAddr: returnAddr, outer: outer, thisArg: thisArg}
+// Built-in globals are writable and configurable, but not enumerable (ES5 15.1).
+func (this *vm) defineBuiltinGlobal(name string, v value) {
+	pd := &propertyDescriptor{name: name, value: v, hasValue: true, writable: true, hasWritable: true, enumerable: false, hasEnumerable: true, configurable: true, hasConfigurable: true}
+	this.globalObject.defineOwnProperty(this, newString(name), pd, true)
 }
 
-func New(code string) *vm {
-	ast := parser.Parse(code, true /* ignore comments */)
-
-	vm := vm{stack{}, []stackFrame{}, nil, []opcode{}, 0, nil, nil, false, 0, 0, nil, -1}
-	vm.stack = []stackFrame{makeStackFrame(newUndefined(), 0, nil)}
-	vm.currentFrame = &vm.stack[0]
-
-	il := []tac{}
-	vm.generateCodeTAC(ast, &il)
-	optimizeTAC(&il)
-
-	if execDebug {
-		for idx, op := range il {
-			log.Printf("%d: %s", idx, op)
-		}
-	}
+// how deep calls can nest, unless SetMaxCallDepth says otherwise.
+const defaultMaxCallDepth = 10000
 
-	vm.code = vm.generateBytecode(il)
-
-	if execDebug {
-		vm.DumpCode()
-	}
+func makeStackFrame(thisArg value, returnAddr int, outer *stackFrame) stackFrame {
+	return stackFrame{retAddr: returnAddr, outer: outer, thisArg: thisArg, returnRegister: -1}
+}
 
-	vm.defineVar(appendStringtable("Object"), defineObjectCtor(&vm))
-	vm.defineVar(appendStringtable("console"), defineConsoleObject(&vm))
-	vm.defineVar(appendStringtable("Math"), defineMathObject(&vm))
-	vm.defineVar(appendStringtable("Boolean"), defineBooleanCtor(&vm))
-	vm.defineVar(appendStringtable("Number"), defineNumberCtor(&vm))
-	vm.defineVar(appendStringtable("Array"), defineArrayCtor(&vm))
-	vm.defineVar(appendStringtable("String"), defineStringCtor(&vm))
+// New compiles code, and returns a vm to run it. It panics if code doesn't compile.
+func New(code string) *vm {
+	return newVM(code, true)
+}
 
-	return &vm
+func newVM(code string, optimize bool) *vm {
+	return NewFromProgram(compile(code, "", optimize))
 }
 
 const execDebug = false
 
+// SetMaxCallDepth sets how deep calls can nest before a RangeError is thrown.
+func (this *vm) SetMaxCallDepth(n int) {
+	this.maxCallDepth = n
+}
+
 func (this *vm) pushStack(sf stackFrame) {
-	this.stack = append(this.stack, sf)
-	this.currentFrame = &this.stack[len(this.stack)-1]
+	// the program's own frame doesn't count as a call
+	if this.stack.depth > this.maxCallDepth {
+		this.ThrowRangeError("Maximum call stack size exceeded")
+	}
+	this.currentFrame = this.stack.push(sf)
 
 	if execDebug {
-		log.Printf("Pushed stack. Stack now: %+v", this.stack)
+		log.Printf("Pushed stack. Depth now: %d", this.stack.depth)
 	}
 }
 
 func (this *vm) popStack(rval value) {
-	this.stack = this.stack[:len(this.stack)-1]
-
-	if len(this.stack) > 0 {
-		this.ip = this.currentFrame.retAddr
-		this.currentFrame = &this.stack[len(this.stack)-1]
-		this.data_stack.push(rval)
+	this.returnValue = rval
+	returnRegister := this.currentFrame.returnRegister
+	retAddr := this.currentFrame.retAddr
+	this.stack.pop()
+
+	if this.stack.depth > 0 {
+		this.ip = retAddr
+		this.currentFrame = this.stack.top()
+		if returnRegister != -1 {
+			this.currentFrame.registers[returnRegister] = rval
+		}
 		if execDebug {
 			log.Printf("Returning %s up the stack", rval)
-			log.Printf("Stack now: %+v", this.stack)
+			log.Printf("Depth now: %d", this.stack.depth)
 		}
 	} else {
-		if rval == nil {
-			rval = newUndefined()
-		}
-		this.returnValue = rval
 		if execDebug {
 			log.Printf("Returning %s from Run()", rval)
 		}
@@ -198,13 +223,27 @@ func (this *vm) popStack(rval value) {
 }
 
 func (this *vm) DumpCode() {
-	log.Printf("String table:")
-	for i := 0; i < len(stringtable); i++ {
-		log.Printf("%d: %s", i, stringtable[i])
+	dumpCode(log.Printf, this.code, this.constants, this.strings, this.lines)
+}
+
+// dumpCode prints code, and the tables it refers to, with printf.
+func dumpCode(printf func(format string, args ...interface{}), code []opcode, constants []value, strings []string, lines []lineEntry) {
+	printf("String table:")
+	for i := 0; i < len(strings); i++ {
+		printf("%d: %s", i, strings[i])
 	}
-	log.Printf("Program:")
-	for i := 0; i < len(this.code); i++ {
-		log.Printf("%d: %s", i, this.code[i])
+	printf("Constants:")
+	for i := 0; i < len(constants); i++ {
+		printf("k%d: %s", i, constants[i])
+	}
+	printf("Program:")
+	line := 0
+	for i := 0; i < len(code); i++ {
+		if l := findLine(lines, i); l != line {
+			line = l
+			printf("line %d:", line)
+		}
+		printf("%d: %s", i, code[i].format(strings))
 	}
 }
 
@@ -215,332 +254,442 @@ func (this *vm) ThrowTypeError(msg string) value {
 	panic("TypeError")
 }
 
-func (this *vm) Run() value {
-	for ; len(this.stack) > 0 && this.ip < len(this.code); this.ip++ {
-		op := this.code[this.ip]
+func (this *vm) ThrowReferenceError(msg string) value {
+	if msg != "" {
+		panic(fmt.Sprintf("ReferenceError: %s", msg))
+	}
+	panic("ReferenceError")
+}
+
+func (this *vm) ThrowRangeError(msg string) value {
+	if msg != "" {
+		panic(fmt.Sprintf("RangeError: %s", msg))
+	}
+	panic("RangeError")
+}
+
+// Run runs the program, and returns what it returned. If the run didn't
+// finish, the error says why: an Exception the script threw, an ExitError if
+// it called exit (see DefineProcess), or a TerminationError if it was stopped
+// (see limits).
+func (this *vm) Run() (value, error) {
+	return this.RunContext(context.Background())
+}
+
+// RunContext is Run, stopping the run if ctx is done first.
+func (this *vm) RunContext(ctx context.Context) (rval value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rval, err = newUndefined(), this.catch(r)
+		}
+	}()
+
+	this.startLimits(ctx)
+	this.run(0)
+	return this.returnValue, nil
+}
+
+// get returns the value of an operand in the current frame.
+func (this *vm) get(o operand) value {
+	if o < 0 {
+		return this.constants[-o-1]
+	}
+	return this.currentFrame.registers[o]
+}
+
+// set stores a value to a register of the current frame.
+func (this *vm) set(o operand, v value) {
+	this.currentFrame.registers[o] = v
+}
+
+// objectFor returns the object to get or set the property key of v on,
+// throwing a TypeError if v is undefined or null, which have no properties
+// (ES5 8.7.1, 8.7.2).
+func (this *vm) objectFor(v value, key value, set bool) valueObject {
+	if v.kind == kindUndefined || v.kind == kindNull {
+		verb := "read"
+		if set {
+			verb = "set"
+		}
+		this.ThrowTypeError(fmt.Sprintf("Cannot %s property '%s' of %s", verb, key, v))
+	}
+	if v.hasPrimitiveBase() {
+		// Would be nice if we could do this at codegen time...
+		return v.ToObject()
+	}
+	return v.obj
+}
+
+// run executes code until the stack is unwound to the given depth.
+func (this *vm) run(depth int) {
+	for ; this.stack.depth > depth && this.ip < len(this.code); this.ip++ {
+		this.limits.steps++
+		if this.limits.steps >= this.limits.nextCheck {
+			this.checkLimits()
+		}
+		op := &this.code[this.ip]
 		if execDebug {
-			log.Printf("Op %d: %s (stack: %+v §§ temporaries %+v)", this.ip, op, this.data_stack, this.currentFrame.temporaries)
+			log.Printf("Op %d: %s (registers: %+v §§ args %+v)", this.ip, op, this.currentFrame.registers, this.args.values)
 		}
 		switch op.otype {
-		case PUSH_BOOL:
-			b := false
-			if op.opdata != 0 {
-				b = true
-			}
-			this.data_stack.push(newBool(b))
 		case NEW_OBJECT:
-			o := newBasicObject()
-			this.data_stack.push(o)
+			this.allocate(objectSize)
+			this.set(op.a, objectValue(newBasicObject()))
 		case DEFINE_PROPERTY:
-			val := this.data_stack.pop()
-			key := this.data_stack.pop()
-			obj := this.data_stack.peek().(valueObject)
-			pn := key.ToString()
-			pd := &propertyDescriptor{name: pn.String(), value: val, hasValue: true, writable: true, hasWritable: true, configurable: true, hasConfigurable: true}
+			pn := this.get(op.b).ToString()
+			obj := this.objectFor(this.get(op.a), pn, true)
+			pd := &propertyDescriptor{name: pn.String(), value: this.get(op.c), hasValue: true, writable: true, hasWritable: true, enumerable: true, hasEnumerable: true, configurable: true, hasConfigurable: true}
 			obj.defineOwnProperty(this, pn, pd, false)
-		case END_OBJECT:
-			this.data_stack.pop()
-		case PUSH_UNDEFINED:
-			this.data_stack.push(newUndefined())
-		case PUSH_NULL:
-			this.data_stack.push(newNull())
-		case PUSH_ARRAY:
-			vals := this.data_stack.popSlice(op.opdata.asInt())
-			this.data_stack.push(newArrayObject(vals))
-		case PUSH_NUMBER:
-			this.data_stack.push(newNumber(op.opdata.asFloat64()))
-		case PUSH_STRING:
-			this.data_stack.push(newString(stringtable[op.opdata.asInt()]))
+		case NEW_ARRAY:
+			// the array keeps its values, so they can't stay on the argument stack
+			this.allocate(objectSize + int64(op.b)*valueSize)
+			this.set(op.a, objectValue(newArrayObject(this.args.popSlice(int(op.b)))))
+		case PUSH_ARG:
+			this.args.push(this.get(op.a))
+		case MOVE:
+			this.set(op.a, this.get(op.b))
 		case UPLUS:
-			val := this.data_stack.pop()
-			this.data_stack.push(newNumber(val.ToNumber()))
+			this.set(op.a, newNumber(this.get(op.b).ToNumber()))
 		case UMINUS:
-			expr := this.data_stack.pop()
-			oldVal := expr.ToNumber()
+			oldVal := this.get(op.b).ToNumber()
 			if math.IsNaN(oldVal) {
-				this.data_stack.push(newNumber(math.NaN()))
+				this.set(op.a, newNumber(math.NaN()))
 			} else {
-				this.data_stack.push(newNumber(oldVal * -1))
+				this.set(op.a, newNumber(oldVal*-1))
 			}
 		case UNOT:
-			expr := this.data_stack.pop()
-			oldVal := expr.ToBoolean()
-			if oldVal {
-				this.data_stack.push(newBool(false))
+			this.set(op.a, newBool(!this.get(op.b).ToBoolean()))
+		case ADD:
+			this.set(op.a, this.add(this.get(op.b), this.get(op.c)))
+		case ADD_NUMBER:
+			lhs, rhs := this.get(op.b), this.get(op.c)
+			if lhs.isNumber() && rhs.isNumber() {
+				this.set(op.a, newNumber(lhs.num+rhs.num))
 			} else {
-				this.data_stack.push(newBool(true))
+				this.set(op.a, this.add(lhs, rhs))
 			}
 		case INCREMENT:
-			v := this.data_stack.pop()
-			this.data_stack.push(newNumber(v.ToNumber() + 1))
+			if v := this.get(op.b); v.isNumber() {
+				this.set(op.a, newNumber(v.num+1))
+			} else {
+				this.set(op.a, this.add(v, newNumber(1)))
+			}
+		case SUB_NUMBER:
+			lhs, rhs := this.get(op.b), this.get(op.c)
+			if lhs.isNumber() && rhs.isNumber() {
+				this.set(op.a, newNumber(lhs.num-rhs.num))
+			} else {
+				this.set(op.a, newNumber(lhs.ToNumber()-rhs.ToNumber()))
+			}
 		case DECREMENT:
-			v := this.data_stack.pop()
-			this.data_stack.push(newNumber(v.ToNumber() - 1))
-		case ADD:
-			// ### could (should) specialize this in codegen for numeric types
-			vals := this.data_stack.popSlice(2)
-			vals[0] = valueToPrimitive(vals[0])
-			vals[1] = valueToPrimitive(vals[1])
-
-			oneIsString := false
-			switch vals[0].(type) {
-			case valueString:
-				oneIsString = true
+			if v := this.get(op.b); v.isNumber() {
+				this.set(op.a, newNumber(v.num-1))
+			} else {
+				this.set(op.a, newNumber(v.ToNumber()-1))
 			}
-			switch vals[1].(type) {
-			case valueString:
-				oneIsString = true
+		case LESS_THAN_NUMBER:
+			lhs, rhs := this.get(op.b), this.get(op.c)
+			if lhs.isNumber() && rhs.isNumber() {
+				this.set(op.a, newBool(lhs.num < rhs.num))
+			} else {
+				this.set(op.a, newBool(lhs.ToNumber() < rhs.ToNumber()))
 			}
-			if oneIsString {
-				this.data_stack.push(newString(vals[1].String() + vals[0].String()))
+		case LESS_THAN_EQ_NUMBER:
+			lhs, rhs := this.get(op.b), this.get(op.c)
+			if lhs.isNumber() && rhs.isNumber() {
+				this.set(op.a, newBool(lhs.num <= rhs.num))
 			} else {
-				this.data_stack.push(newNumber(vals[1].ToNumber() + vals[0].ToNumber()))
+				this.set(op.a, newBool(lhs.ToNumber() <= rhs.ToNumber()))
+			}
+		case GREATER_THAN_NUMBER:
+			lhs, rhs := this.get(op.b), this.get(op.c)
+			if lhs.isNumber() && rhs.isNumber() {
+				this.set(op.a, newBool(lhs.num > rhs.num))
+			} else {
+				this.set(op.a, newBool(lhs.ToNumber() > rhs.ToNumber()))
+			}
+		case GREATER_THAN_EQ_NUMBER:
+			lhs, rhs := this.get(op.b), this.get(op.c)
+			if lhs.isNumber() && rhs.isNumber() {
+				this.set(op.a, newBool(lhs.num >= rhs.num))
+			} else {
+				this.set(op.a, newBool(lhs.ToNumber() >= rhs.ToNumber()))
 			}
 		case SUB:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(vals[1].ToNumber() - vals[0].ToNumber()))
+			this.set(op.a, newNumber(this.get(op.b).ToNumber()-this.get(op.c).ToNumber()))
 		case MULTIPLY:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(vals[1].ToNumber() * vals[0].ToNumber()))
+			this.set(op.a, newNumber(this.get(op.b).ToNumber()*this.get(op.c).ToNumber()))
 		case DIVIDE:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(vals[1].ToNumber() / vals[0].ToNumber()))
+			this.set(op.a, newNumber(this.get(op.b).ToNumber()/this.get(op.c).ToNumber()))
 		case MODULUS:
-			vals := this.data_stack.popSlice(2)
 			// ### using math is probably going to hurt performance?
-			this.data_stack.push(newNumber(math.Mod(vals[1].ToNumber(), vals[0].ToNumber())))
+			this.set(op.a, newNumber(math.Mod(this.get(op.b).ToNumber(), this.get(op.c).ToNumber())))
+		case EXPONENT:
+			this.set(op.a, newNumber(exponentiate(this.get(op.b).ToNumber(), this.get(op.c).ToNumber())))
 		case LEFT_SHIFT:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(float64(vals[1].ToInteger() << uint(vals[0].ToInteger()))))
+			this.set(op.a, newNumber(float64(this.get(op.b).ToInteger()<<uint(this.get(op.c).ToInteger()))))
 		case RIGHT_SHIFT:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(float64(vals[1].ToInteger() >> uint(vals[0].ToInteger()))))
+			this.set(op.a, newNumber(float64(this.get(op.b).ToInteger()>>uint(this.get(op.c).ToInteger()))))
 		case UNSIGNED_RIGHT_SHIFT:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(float64(uint32(vals[1].ToInteger()) >> uint(vals[0].ToInteger()))))
+			this.set(op.a, newNumber(float64(uint32(this.get(op.b).ToInteger())>>uint(this.get(op.c).ToInteger()))))
 		case BITWISE_AND:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(float64(vals[1].ToInteger() & vals[0].ToInteger())))
+			this.set(op.a, newNumber(float64(this.get(op.b).ToInteger()&this.get(op.c).ToInteger())))
 		case BITWISE_XOR:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(float64(vals[1].ToInteger() ^ vals[0].ToInteger())))
+			this.set(op.a, newNumber(float64(this.get(op.b).ToInteger()^this.get(op.c).ToInteger())))
 		case BITWISE_OR:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newNumber(float64(vals[1].ToInteger() | vals[0].ToInteger())))
+			this.set(op.a, newNumber(float64(this.get(op.b).ToInteger()|this.get(op.c).ToInteger())))
 		case BITWISE_NOT:
-			v := this.data_stack.pop()
-			this.data_stack.push(newNumber(float64(^v.ToInteger())))
+			this.set(op.a, newNumber(float64(^this.get(op.b).ToInteger())))
 		case LESS_THAN:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(vals[1].ToNumber() < vals[0].ToNumber()))
+			this.set(op.a, newBool(this.get(op.b).ToNumber() < this.get(op.c).ToNumber()))
 		case GREATER_THAN:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(vals[1].ToNumber() > vals[0].ToNumber()))
+			this.set(op.a, newBool(this.get(op.b).ToNumber() > this.get(op.c).ToNumber()))
 		case GREATER_THAN_EQ:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(vals[1].ToNumber() >= vals[0].ToNumber()))
+			this.set(op.a, newBool(this.get(op.b).ToNumber() >= this.get(op.c).ToNumber()))
+		case LESS_THAN_EQ:
+			this.set(op.a, newBool(this.get(op.b).ToNumber() <= this.get(op.c).ToNumber()))
 		case EQUALS:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(abstractEqualityComparison(vals[1], vals[0])))
+			this.set(op.a, newBool(abstractEqualityComparison(this.get(op.b), this.get(op.c))))
 		case NOT_EQUALS:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(!abstractEqualityComparison(vals[1], vals[0])))
+			this.set(op.a, newBool(!abstractEqualityComparison(this.get(op.b), this.get(op.c))))
 		case STRICT_EQUALS:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(strictEqualityComparison(vals[1], vals[0])))
+			this.set(op.a, newBool(strictEqualityComparison(this.get(op.b), this.get(op.c))))
 		case STRICT_NOT_EQUALS:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(!strictEqualityComparison(vals[1], vals[0])))
-		case LESS_THAN_EQ:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(vals[1].ToNumber() <= vals[0].ToNumber()))
+			this.set(op.a, newBool(!strictEqualityComparison(this.get(op.b), this.get(op.c))))
 		case LOGICAL_AND:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(vals[1].ToBoolean() && vals[0].ToBoolean()))
+			this.set(op.a, newBool(this.get(op.b).ToBoolean() && this.get(op.c).ToBoolean()))
 		case LOGICAL_OR:
-			vals := this.data_stack.popSlice(2)
-			this.data_stack.push(newBool(vals[1].ToBoolean() || vals[0].ToBoolean()))
+			this.set(op.a, newBool(this.get(op.b).ToBoolean() || this.get(op.c).ToBoolean()))
 		case IN:
-			vals := this.data_stack.popSlice(2)
-			rval := vals[0].ToObject()
-			this.data_stack.push(newBool(rval.getOwnProperty(this, vals[1].ToString()) != nil))
+			rval := this.get(op.c).ToObject()
+			this.set(op.a, newBool(rval.getOwnProperty(this, this.get(op.b).ToString()) != nil))
 		case INSTANCEOF:
-			vals := this.data_stack.popSlice(2)
-			ctor := vals[1].ToObject()
-			log.Printf("%+v instanceof %+v", vals[1], vals[0])
-			this.data_stack.push(newBool(ctor.hasInstance(this, vals[0])))
-		case POP:
-			this.data_stack.pop()
+			lhs := this.get(op.b)
+			rhs := this.get(op.c)
+			ctor := lhs.ToObject()
+			if execDebug {
+				log.Printf("%+v instanceof %+v", lhs, rhs)
+			}
+			this.set(op.a, newBool(ctor.hasInstance(this, rhs)))
 		case JMP:
-			this.ip += op.opdata.asInt()
+			this.ip = int(op.a) - 1
 		case JNE:
-			test := this.data_stack.pop()
-
-			if op.opdata.asInt() == 0 {
-				panic("JNE 0 is an infinite loop")
-			}
-			if !test.ToBoolean() {
-				if execDebug {
-					log.Printf("IP is at %d jump rel %d code length %d", this.ip, op.opdata.asInt(), len(this.code))
-				}
-				this.ip += op.opdata.asInt()
-
-				if this.ip >= len(this.code) {
-					panic("JNE blew over opcode length")
-				}
+			if !this.get(op.a).ToBoolean() {
+				this.ip = int(op.b) - 1
 			}
 		case CALL:
 			this.handleCall(op, false)
 		case NEW:
 			this.handleCall(op, true)
 		case RETURN:
-			// can't inline this to popStack, because the builtin case doesn't
-			// have a value pushed onto the data_stack.
-			var rval value
-			if len(this.data_stack.values) > 0 {
-				rval = this.data_stack.pop()
-			}
-
-			this.popStack(rval)
-		case DUP:
-			cv := this.data_stack.peek()
-			this.data_stack.push(cv)
+			this.popStack(this.get(op.a))
 		case IN_FUNCTION:
-			// no-op, just for informative/debug purposes
+			// the program's frame gets its registers here; functions get
+			// theirs when called, see callJsFunction.
+			if this.currentFrame.registers == nil {
+				registers := make([]value, int(op.b))
+				for idx := range registers {
+					registers[idx] = newUndefined()
+				}
+				this.currentFrame.registers = registers
+			}
+		case USE_STRICT:
+			this.currentFrame.strict = true
 		case DECLARE:
-			this.defineVar(op.opdata.asInt(), nil)
-		case STORE:
-			v := this.data_stack.pop()
-			ok := this.setVar(op.opdata.asInt(), v)
+			this.defineVar(int(op.a), newUndefined())
+		case LOAD_FUNCTION:
+			this.set(op.a, this.functions[int(op.b)])
+		case LOAD_GLOBAL:
+			sv, ok := this.findVar(int(op.b))
 			if !ok {
-				panic(fmt.Sprintf("var %s not found", stringtable[op.opdata.asInt()]))
+				this.ThrowReferenceError(fmt.Sprintf("%s is not defined", this.strings[int(op.b)]))
 			}
-		case STORE_MEMBER:
-			v := this.data_stack.pop()
-			nv := this.data_stack.pop()
-			var vo valueObject
-			if v.hasPrimitiveBase() {
-				// Would be nice if we could do this at codegen time...
-				vo = v.ToObject()
-			} else {
-				vo = v.(valueObject)
+			this.set(op.a, sv)
+		case STORE_GLOBAL:
+			v := this.get(op.b)
+			ok := this.setVar(int(op.a), v)
+			if !ok {
+				// Assigning an undeclared name creates a global, except in
+				// strict code (ES5 8.7.2).
+				if this.currentFrame.strict {
+					this.ThrowReferenceError(fmt.Sprintf("%s is not defined", this.strings[int(op.a)]))
+				}
+				this.globalObject.put(this, this.globalKey(int(op.a)), v, false)
 			}
-			vo.put(this, newString(stringtable[op.opdata.asInt()]), nv, true)
 		case LOAD_MEMBER:
-			v := this.data_stack.pop()
-			var vo valueObject
-			if v.hasPrimitiveBase() {
-				// Would be nice if we could do this at codegen time...
-				vo = v.ToObject()
-			} else {
-				vo = v.(valueObject)
-			}
-			this.data_stack.push(vo.get(this, newString(stringtable[op.opdata.asInt()])))
+			this.set(op.a, this.loadMember(this.get(op.b), this.strings[int(op.c)], &this.caches[op.d]))
+		case STORE_MEMBER:
+			this.storeMember(this.get(op.a), this.strings[int(op.b)], this.get(op.c), &this.caches[op.d])
 		case LOAD_INDEXED:
-			v := this.data_stack.pop()
-			idx := this.data_stack.pop().ToInteger()
-			var vo valueObject
-			if v.hasPrimitiveBase() {
-				// Would be nice if we could do this at codegen time...
-				vo = v.ToObject()
-			} else {
-				vo = v.(valueObject)
-			}
-
-			this.data_stack.push(vo.get(this, newNumber(float64(idx))))
+			this.set(op.a, this.loadIndexed(this.get(op.b), this.get(op.c)))
 		case STORE_INDEXED:
-			v := this.data_stack.pop()
-			idx := this.data_stack.pop().ToInteger()
-			var vo valueObject
-			if v.hasPrimitiveBase() {
-				// Would be nice if we could do this at codegen time...
-				vo = v.ToObject()
-			} else {
-				vo = v.(valueObject)
-			}
-
-			nv := this.data_stack.pop()
-			vo.put(this, newNumber(float64(idx)), nv, true)
-		case LOAD:
-			sv, ok := this.findVar(op.opdata.asInt())
-			if !ok {
-				panic("var not found")
-			}
-			this.lastLoadedVar = sv
-			this.data_stack.push(sv)
+			this.storeIndexed(this.get(op.a), this.get(op.b), this.get(op.c))
 		case LOAD_THIS:
-			// ### 'this' should be valid in global contexts too, but isn't currently.
-			if this.currentFrame.thisArg == nil {
-				panic("'this' in global context not yet supported...")
-			}
-			this.data_stack.push(this.currentFrame.thisArg)
-		case LOAD_TEMPORARY:
-			idx := op.opdata.asInt()
-			if idx >= len(this.currentFrame.temporaries) {
-				this.data_stack.push(newUndefined())
-			} else {
-				this.data_stack.push(this.currentFrame.temporaries[idx])
-			}
-		case STORE_TEMPORARY:
-			idx := op.opdata.asInt()
-			for len(this.currentFrame.temporaries) <= idx {
-				this.currentFrame.temporaries = append(this.currentFrame.temporaries, newUndefined())
-			}
-			this.currentFrame.temporaries[idx] = this.data_stack.pop()
+			this.set(op.a, this.currentFrame.thisArg)
 		case TYPEOF:
-			v := this.data_stack.pop()
-
-			// ### does the value interface need another member?
-			switch v.(type) {
-			case valueUndefined:
-				this.data_stack.push(newString("undefined"))
-			case valueNull:
-				this.data_stack.push(newString("object"))
-			case valueBool:
-				this.data_stack.push(newString("boolean"))
-			case valueNumber:
-				this.data_stack.push(newString("number"))
-			case valueString:
-				this.data_stack.push(newString("string"))
-			case functionObject:
-				this.data_stack.push(newString("function"))
-			case valueBasicObject:
-				this.data_stack.push(newString("object"))
-			case arrayObject:
-				this.data_stack.push(newString("object"))
-			default:
-				panic("Unknown type")
+			this.set(op.a, typeOf(this.get(op.b)))
+		case TYPEOF_VAR:
+			// typeof doesn't throw for undeclared names (ES5 11.4.3)
+			sv, ok := this.findVar(int(op.b))
+			if !ok {
+				sv = newUndefined()
 			}
+			this.set(op.a, typeOf(sv))
 		default:
 			panic(fmt.Sprintf("unhandled opcode %+v", op))
 		}
 	}
+}
 
-	return this.returnValue
+// add is addValues, charging for the string it makes, if it makes one.
+func (this *vm) add(lhs value, rhs value) value {
+	v := addValues(lhs, rhs)
+	if v.isString() {
+		this.allocateString(len(v.str))
+	}
+	return v
 }
 
-func (this *vm) handleCall(op opcode, isNew bool) {
-	// my, this is inefficient
-	builtinArgs := this.data_stack.popSlice(op.opdata.asInt() + 1)
+// addValues is the + operator (ES5 11.6.1).
+func addValues(lhs value, rhs value) value {
+	lhs = valueToPrimitive(lhs)
+	rhs = valueToPrimitive(rhs)
+
+	if lhs.isString() || rhs.isString() {
+		return newString(lhs.String() + rhs.String())
+	}
+	return newNumber(lhs.ToNumber() + rhs.ToNumber())
+}
+
+func typeOf(v value) value {
+	switch v.kind {
+	case kindUndefined:
+		return newString("undefined")
+	case kindNull:
+		return newString("object")
+	case kindBool:
+		return newString("boolean")
+	case kindNumber:
+		return newString("number")
+	case kindString:
+		return newString("string")
+	}
+	if _, ok := v.obj.(functionObject); ok {
+		return newString("function")
+	}
+	return newString("object")
+}
 
-	fn := builtinArgs[len(builtinArgs)-1]
-	if len(builtinArgs) > 1 {
-		builtinArgs = builtinArgs[0 : len(builtinArgs)-1]
+// callFunction calls fn from inside a builtin and returns its result.
+//
+// A CALL leaves a JavaScript function's body to be run by the main loop once
+// the builtin returns, which is too late for a builtin that needs the result,
+// so the body is instead run here until its frame is popped.
+func (this *vm) callFunction(fn functionObject, thisArg value, args []value) value {
+	ip := this.ip
+	depth := this.stack.depth
+	this.pushStack(makeStackFrame(thisArg, ip, this.currentFrame))
+
+	rval := fn.call(this, thisArg, args)
+	if this.ignoreReturn {
+		this.ignoreReturn = false
+		// the main loop would step past the function's entry point; do the same
+		this.ip++
+		this.run(depth)
 	} else {
-		builtinArgs = builtinArgs[:0]
+		this.popStack(rval)
 	}
 
-	fo := fn.(functionObject)
+	// the frame has no register to return to, so the result is left in
+	// returnValue
+	this.ip = ip
+	return this.returnValue
+}
+
+// indexedPropertyKey returns the property name v is as a key in a[v] (ES5
+// 11.2.1). Numbers that are array indices are kept as numbers, for arrays not
+// to have to parse them back; anything else is its ToString.
+func indexedPropertyKey(v value) value {
+	if v.isString() {
+		return v
+	}
+	if _, ok := arrayIndex(v); ok {
+		return v
+	}
+	return v.ToString()
+}
+
+// loadIndexed is LOAD_INDEXED: it gets the property key of v.
+func (this *vm) loadIndexed(v value, key value) value {
+	// elements of dense arrays can be read straight from their storage
+	if a, ok := v.obj.(arrayObject); ok && key.isNumber() {
+		dense := a.primitiveData.dense
+		if idx := int(key.num); float64(idx) == key.num && idx >= 0 && idx < len(dense) && dense[idx].kind != kindHole {
+			return dense[idx]
+		}
+	}
+	key = indexedPropertyKey(key)
+	return this.objectFor(v, key, false).get(this, key)
+}
+
+// storeIndexed is STORE_INDEXED: it sets the property key of v.
+func (this *vm) storeIndexed(v value, key value, nv value) {
+	// as can elements of dense arrays that are there, or are just past the end
+	if a, ok := v.obj.(arrayObject); ok && key.isNumber() {
+		ad := a.primitiveData
+		if idx := int(key.num); float64(idx) == key.num && idx >= 0 && idx < len(ad.dense) {
+			ad.dense[idx] = nv
+			return
+		} else if idx == len(ad.dense) && ad.sparse == nil && uint32(idx) == ad.length {
+			this.allocate(valueSize)
+			ad.dense = append(ad.dense, nv)
+			ad.length++
+			return
+		}
+	}
+	key = indexedPropertyKey(key)
+	this.objectFor(v, key, true).put(this, key, nv, true)
+}
+
+// describe returns how an error names v: objects by their type, as what they
+// print as can be long, or even never end, and anything else as it is.
+func describe(v value) string {
+	switch v.kind {
+	case kindObject:
+		return typeOf(v).str
+	case kindString:
+		return strconv.Quote(v.str)
+	}
+	return v.String()
+}
+
+func (this *vm) handleCall(op *opcode, isNew bool) {
+	// The arguments stay on the argument stack until the call returns, as a
+	// builtin may still be using them while it calls other functions.
+	base := len(this.args.values) - int(op.d)
+	args := this.args.values[base:len(this.args.values):len(this.args.values)]
+
+	callee := this.get(op.b)
+	fo, ok := callee.obj.(functionObject)
+	if !ok {
+		if isNew {
+			this.ThrowTypeError(fmt.Sprintf("%s is not a constructor", describe(callee)))
+		}
+		this.ThrowTypeError(fmt.Sprintf("%s is not a function", describe(callee)))
+	}
+	thisArg := this.get(op.c)
 
-	sf := makeStackFrame(this.lastLoadedVar, this.ip, this.currentFrame)
+	sf := makeStackFrame(thisArg, this.ip, this.currentFrame)
+	sf.returnRegister = op.a
 	this.pushStack(sf)
 
 	var rval value
 	if isNew {
-		rval = fo.construct(this, this.lastLoadedVar, builtinArgs)
+		rval = fo.construct(this, thisArg, args)
 	} else {
-		rval = fo.call(this, this.lastLoadedVar, builtinArgs)
+		rval = fo.call(this, thisArg, args)
 	}
+	this.args.values = this.args.values[:base]
 
 	if this.ignoreReturn {
 		this.ignoreReturn = false
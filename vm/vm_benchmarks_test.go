@@ -87,6 +87,16 @@ func vmBenchmarks() []benchmark {
 				}
 				sum(10000)`,
 		},
+		benchmark{
+			name: "members",
+			code: `function members(n) {
+					var o = {a: 1, b: 2, c: 3, d: 4, x: 0};
+					for (var i=0; i<n; ++i)
+						o.x = o.x + o.d;
+					return o.x;
+				}
+				members(10000)`,
+		},
 	}
 }
 
@@ -103,6 +113,25 @@ func BenchmarkVM(b *testing.B) {
 	}
 }
 
+// BenchmarkVM_Program runs the benchmarks compiled once, as a Program.
+func BenchmarkVM_Program(b *testing.B) {
+	tests := vmBenchmarks()
+	for _, test := range tests {
+		b.Run(test.name,
+			func(b *testing.B) {
+				p, err := Compile(test.code, test.name)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					vm := NewFromProgram(p)
+					vm.Run()
+				}
+			})
+	}
+}
+
 func BenchmarkVM_Otto(b *testing.B) {
 	tests := vmBenchmarks()
 	for _, test := range tests {
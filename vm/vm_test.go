@@ -27,7 +27,11 @@
 package vm
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/stvp/assert"
+	"log"
+	"os"
 	"testing"
 )
 
@@ -36,11 +40,24 @@ type simpleVMTest struct {
 	out value
 }
 
+// mustRun runs vm, failing the test if the run was stopped.
+func mustRun(t testing.TB, vm *vm) value {
+	v, err := vm.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// Every test is run both with and without optimizing the code first, as the
+// optimizer must not change what a program does.
 func runSimpleVMTestHelper(t *testing.T, tests []simpleVMTest) {
 	for _, test := range tests {
 		t.Logf("Testing: %s", test.in)
-		vm := New(test.in)
-		assert.Equal(t, vm.Run(), test.out)
+		vm := newVM(test.in, false)
+		assert.Equal(t, mustRun(t, vm), test.out)
+		vm = newVM(test.in, true)
+		assert.Equal(t, mustRun(t, vm), test.out)
 		t.Logf("** Passed %s == %s", test.in, test.out)
 	}
 }
@@ -109,6 +126,10 @@ func TestAssignmentOperators(t *testing.T) {
 			in:  "var a = 5; a %= 2; return a",
 			out: newNumber(1),
 		},
+		simpleVMTest{
+			in:  "var a = 3; a **= 2; return a",
+			out: newNumber(9),
+		},
 		simpleVMTest{
 			in:  "var a = 5; a <<= 2; return a",
 			out: newNumber(20),
@@ -227,6 +248,22 @@ func TestSimple(t *testing.T) {
 			in:  "return 2+2*2+2",
 			out: newNumber(8),
 		},
+		simpleVMTest{
+			in:  "return 2**10",
+			out: newNumber(1024),
+		},
+		simpleVMTest{
+			in:  "return 2**3**2",
+			out: newNumber(512),
+		},
+		simpleVMTest{
+			in:  "return 2*3**2",
+			out: newNumber(18),
+		},
+		simpleVMTest{
+			in:  "return (-2)**2",
+			out: newNumber(4),
+		},
 		simpleVMTest{
 			in:  "return 1<2",
 			out: newBool(true),
@@ -365,12 +402,37 @@ func TestInstanceOfOperator(t *testing.T) {
 	*/
 }
 
+// Nothing is logged for instanceof, unless debugging.
+func TestInstanceOfQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	mustRun(t, New("var d = new Date(0); d instanceof Date; [] instanceof Array; 'a' instanceof String"))
+	assert.Equal(t, buf.String(), "")
+}
+
 func TestCall(t *testing.T) {
 	tests := []simpleVMTest{
 		simpleVMTest{
 			in:  "return f() function f() { return 5 }",
 			out: newNumber(5),
 		},
+		simpleVMTest{
+			in:  "function f(a, b) { return b } function g() { return 5 } return f(1, g())",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "function f(a, b) { return a - b } function g(a) { return a } return f(g(3), g(1))",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "function f() { return {g: g} } function g() { return 6 } return f().g()",
+			out: newNumber(6),
+		},
+		simpleVMTest{
+			in:  "function f(a) { return a } var a = [1, f(2), [3, f(4)]]; return a[1] + a[2][1]",
+			out: newNumber(6),
+		},
 	}
 
 	runSimpleVMTestHelper(t, tests)
@@ -384,6 +446,10 @@ func TestThis(t *testing.T) {
 			in:  "function f() { return this.a }; f.a = 42; return f();",
 			out: newNumber(42),
 		},
+		simpleVMTest{
+			in:  "var a = 42; return this.a",
+			out: newNumber(42),
+		},
 	}
 
 	runSimpleVMTestHelper(t, tests)
@@ -421,6 +487,14 @@ func TestTypeof(t *testing.T) {
 			in:  "function v() {}; return typeof v",
 			out: newString("function"),
 		},
+		simpleVMTest{
+			in:  "return typeof undeclared",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "\"use strict\"; return typeof undeclared",
+			out: newString("undefined"),
+		},
 	}
 
 	runSimpleVMTestHelper(t, tests)
@@ -445,6 +519,139 @@ func TestVar(t *testing.T) {
 	runSimpleVMTestHelper(t, tests)
 }
 
+func TestGlobalObject(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "return this === globalThis",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "return globalThis.globalThis === globalThis",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "return globalThis.Math === Math",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "var a = 5; return globalThis.a",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "globalThis.a = 5; return a",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "function f() {}; return typeof globalThis.f",
+			out: newString("function"),
+		},
+		simpleVMTest{
+			in:  "a = 5; return globalThis.a",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "function f() { a = 5 }; f(); return a",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "function f() { var a = 5 }; f(); return typeof a",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "var a = 1; function f() { a = 5 }; f(); return a",
+			out: newNumber(5),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestHoisting(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "return typeof a; var a = 5",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "return f(); function f() { return 5 }",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "function f() { a = 5; return a; var a } var r = f(); return r == 5 && typeof a == \"undefined\"",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "function f() { return g(); function g() { return 5 } } return f()",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "function f(n) { if (n > 0) { var j = n } return j } return f(4)",
+			out: newNumber(4),
+		},
+		simpleVMTest{
+			// function declarations replace parameters, vars don't
+			in:  "function f(a) { var a; return a } return f(5)",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "function f(a) { function a() {} return typeof a } return f(5)",
+			out: newString("function"),
+		},
+		simpleVMTest{
+			in:  "function f(a, b) { return typeof b } return f(5)",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			// function expressions aren't hoisted
+			in:  "var r = typeof f; var f = function() { return 5 }; return r == \"undefined\" && f() == 5",
+			out: newBool(true),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestScope(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			// locals don't leak into the functions they call
+			in:  "function f() { return typeof a } function g() { var a = 5; return f() } return g()",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "var a = 1; function f() { var a = 5; return a } var r = f(); return r + a",
+			out: newNumber(6),
+		},
+		simpleVMTest{
+			in:  "var a = 1; function f(a) { a = 5 } f(2); return a",
+			out: newNumber(1),
+		},
+		simpleVMTest{
+			in:  "function f(n) { var r = n; if (n > 0) { f(n - 1) } return r } return f(3)",
+			out: newNumber(3),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
+func TestReferenceError(t *testing.T) {
+	tests := []string{
+		"return undeclared",
+		"function f() { return undeclared } function g() { var undeclared = 5; return f() } return g()",
+		"return undeclared + 1",
+		"\"use strict\"; undeclared = 5",
+		"function f() { \"use strict\"; undeclared = 5 }; f()",
+		"\"use strict\"; function f() { undeclared = 5 }; f()",
+	}
+
+	for _, in := range tests {
+		t.Logf("Testing: %s", in)
+		_, err := New(in).Run()
+		assert.Equal(t, err.Error(), "ReferenceError: undeclared is not defined")
+	}
+}
+
 func TestWhile(t *testing.T) {
 	tests := []simpleVMTest{
 		simpleVMTest{
@@ -512,6 +719,10 @@ func TestReturnStatement(t *testing.T) {
 			in:  "function a() { return 10; } var b = new a(); return b;",
 			out: newNumber(10),
 		},
+		simpleVMTest{
+			in:  "function a() { return {b: 7}; } return new a().b;",
+			out: newNumber(7),
+		},
 	}
 
 	runSimpleVMTestHelper(t, tests)
@@ -526,8 +737,8 @@ func TestBuiltinFunction(t *testing.T) {
 
 		vm := New("return testFunc()")
 		pf := newFunctionObject(testFunc, nil)
-		vm.defineVar(appendStringtable("testFunc"), pf)
-		assert.Equal(t, vm.Run(), newString("Hello world"))
+		vm.defineVar(vm.appendStringtable("testFunc"), objectValue(pf))
+		assert.Equal(t, mustRun(t, vm), newString("Hello world"))
 	}
 	t.Logf("Test one passed")
 
@@ -539,8 +750,8 @@ func TestBuiltinFunction(t *testing.T) {
 
 		vm := New("return testFunc(\"Hello\", \"World\")")
 		pf := newFunctionObject(testFunc, nil)
-		vm.defineVar(appendStringtable("testFunc"), pf)
-		assert.Equal(t, vm.Run(), newString("HelloWorld"))
+		vm.defineVar(vm.appendStringtable("testFunc"), objectValue(pf))
+		assert.Equal(t, mustRun(t, vm), newString("HelloWorld"))
 	}
 	t.Logf("Test two passed")
 
@@ -556,19 +767,32 @@ func TestBuiltinFunction(t *testing.T) {
 		{
 			vm := New("return testFunc()")
 			pf := newFunctionObject(testCall, testConstruct)
-			vm.defineVar(appendStringtable("testFunc"), pf)
-			assert.Equal(t, vm.Run(), newNumber(10))
+			vm.defineVar(vm.appendStringtable("testFunc"), objectValue(pf))
+			assert.Equal(t, mustRun(t, vm), newNumber(10))
 		}
 		t.Logf("Call passed")
 		{
 			vm := New("return new testFunc()")
 			pf := newFunctionObject(testCall, testConstruct)
-			vm.defineVar(appendStringtable("testFunc"), pf)
-			assert.Equal(t, vm.Run(), newNumber(20))
+			vm.defineVar(vm.appendStringtable("testFunc"), objectValue(pf))
+			assert.Equal(t, mustRun(t, vm), newNumber(20))
 		}
 		t.Logf("New passed")
 	}
 	t.Logf("Test call/construct passed")
+
+	// a builtin's arguments must survive it calling back into JavaScript
+	{
+		testFunc := func(vm *vm, f value, args []value) value {
+			vm.callFunction(args[0].obj.(functionObject), newUndefined(), nil)
+			return args[1]
+		}
+
+		vm := New("function g(a, b) { return a + b } return testFunc(function() { return g(3, 4) }, 5)")
+		pf := newFunctionObject(testFunc, nil)
+		vm.defineVar(vm.appendStringtable("testFunc"), objectValue(pf))
+		assert.Equal(t, mustRun(t, vm), newNumber(5))
+	}
 }
 
 func TestJSFunction(t *testing.T) {
@@ -600,10 +824,38 @@ func TestJSFunction(t *testing.T) {
 
 func TestRecursiveLookups(t *testing.T) {
 	vm := New("function f(a) { if (a > 3) return a; a = a + 1; return f(a); } var n = f(0); return n")
-	ret := vm.Run()
+	ret := mustRun(t, vm)
 	assert.Equal(t, ret, newNumber(4))
 }
 
+// A function expression's name is bound inside it, and only there.
+func TestNamedFunctionExpression(t *testing.T) {
+	tests := []simpleVMTest{
+		simpleVMTest{
+			in:  "var f = function h(n) { return n ? h(n - 1) : \"done\" }; return f(3)",
+			out: newString("done"),
+		},
+		simpleVMTest{
+			in:  "var f = function h() { return h === f }; return f()",
+			out: newBool(true),
+		},
+		simpleVMTest{
+			in:  "var f = function h() {}; return typeof h",
+			out: newString("undefined"),
+		},
+		simpleVMTest{
+			in:  "var f = function h(h) { return h }; return f(2)",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "var f = function h() { var h = 5; return h }; return f()",
+			out: newNumber(5),
+		},
+	}
+
+	runSimpleVMTestHelper(t, tests)
+}
+
 func TestFibonnaci(t *testing.T) {
 	f := "function fibonacci(n) {\n"
 	f += "	var a = 0, b = 1, f = 1;\n"
@@ -619,7 +871,7 @@ func TestFibonnaci(t *testing.T) {
 	var iterative value
 	{
 		vm := New(f)
-		iterative = vm.Run()
+		iterative = mustRun(t, vm)
 	}
 
 	f = ""
@@ -637,7 +889,7 @@ func TestFibonnaci(t *testing.T) {
 	var recursive value
 	{
 		vm := New(f)
-		recursive = vm.Run()
+		recursive = mustRun(t, vm)
 	}
 
 	assert.Equal(t, iterative, recursive)
@@ -674,6 +926,34 @@ func TestObjectProperties(t *testing.T) {
 			in:  "var a = {b: 5}; a.b = 6; return a.b;",
 			out: newNumber(6),
 		},
+		simpleVMTest{
+			in:  "var a = {b: {c: 5}}; return a.b.c;",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "var a = {b: {c: 5}}; a.b.c = 6; return a.b.c;",
+			out: newNumber(6),
+		},
+		simpleVMTest{
+			in:  "var a = {b: 5}; return a[\"b\"];",
+			out: newNumber(5),
+		},
+		simpleVMTest{
+			in:  "var a = {b: 5}; var k = \"b\"; a[k] = 6; return a.b;",
+			out: newNumber(6),
+		},
+		simpleVMTest{
+			in:  "var a = [1, 2]; var i = 1; return a[i];",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "return [1, 2][1];",
+			out: newNumber(2),
+		},
+		simpleVMTest{
+			in:  "function f() { var o = {b: 5}; o.b = 6; return o.b } return f();",
+			out: newNumber(6),
+		},
 	}
 
 	runSimpleVMTestHelper(t, tests)
@@ -771,3 +1051,50 @@ func TestValueStrictEquality(t *testing.T) {
 
 	runSimpleVMTestHelper(t, tests)
 }
+
+func TestCallDepthLimit(t *testing.T) {
+	depth := "function d(n) { if (n == 0) return 0; return d(n - 1) + 1 }; return d(%d)"
+
+	// deeper than the call stack's first chunk of frames
+	assert.Equal(t, mustRun(t, New(fmt.Sprintf(depth, 5000))), newNumber(5000))
+
+	// d(n) nests n + 1 calls
+	vm := New(fmt.Sprintf(depth, 99))
+	vm.SetMaxCallDepth(100)
+	assert.Equal(t, mustRun(t, vm), newNumber(99))
+
+	tests := []string{
+		fmt.Sprintf(depth, 100),
+		"function f() { return f() }; f()",
+		`function f() { return "a".replace("a", f) }; f()`,
+	}
+	for _, in := range tests {
+		t.Logf("Testing: %s", in)
+		vm := New(in)
+		vm.SetMaxCallDepth(100)
+		_, err := vm.Run()
+		assert.Equal(t, err.Error(), "RangeError: Maximum call stack size exceeded")
+	}
+}
+
+// Frames don't move as the stack grows, so pointers to them stay good.
+func TestFrameStack(t *testing.T) {
+	var s frameStack
+	frames := []*stackFrame{}
+	for i := 0; i < frameChunkSize*3; i++ {
+		f := s.push(makeStackFrame(newNumber(float64(i)), i, s.top()))
+		frames = append(frames, f)
+	}
+	for i, f := range frames {
+		assert.Equal(t, f.thisArg, newNumber(float64(i)))
+		if i > 0 {
+			assert.True(t, f.outer == frames[i-1])
+		}
+	}
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		assert.True(t, s.top() == frames[i])
+		s.pop()
+	}
+	assert.Nil(t, s.top())
+}